@@ -0,0 +1,469 @@
+// Copyright ©2025 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"unicode"
+)
+
+// analyze reports typing statistics for stored revisions against a
+// text corpus.
+func analyze(args []string) {
+	fs := flag.NewFlagSet("fkm analyze", flag.ExitOnError)
+	var common commonFlags
+	common.register(fs)
+	corpus := fs.String("corpus", "", "path to a text corpus to type (required)")
+	var revIDs stringList
+	fs.Var(&revIDs, "revision", "ID of a stored revision to analyze (required, may be repeated to compare revisions)")
+	format := fs.String("format", "text", "output format (text or json)")
+	fs.Parse(args)
+	common.setup(fs)
+	if common.json {
+		*format = "json"
+	}
+	if *corpus == "" || len(revIDs) == 0 || fs.NArg() != 0 || (*format != "text" && *format != "json") {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+
+	text, err := os.ReadFile(*corpus)
+	if err != nil {
+		fatal("failed to read corpus", err, "path", *corpus)
+	}
+	db := openExisting(common.dbPath, true, common.db)
+	defer db.Close()
+	var reports []typingReport
+	for _, id := range revIDs {
+		l, err := loadLayout(db, id)
+		if err != nil {
+			fatal("failed to load revision", err, "revision", id)
+		}
+		t, err := newTypist(l)
+		if err != nil {
+			fatal("failed to analyze revision", err, "revision", id)
+		}
+		reports = append(reports, t.typeText(id, string(text)))
+	}
+	err = writeTypingReports(os.Stdout, reports, *format)
+	if err != nil {
+		fatal("failed to write report", err)
+	}
+}
+
+// finger is a finger of a typist.
+type finger int
+
+const (
+	leftPinky finger = iota
+	leftRing
+	leftMiddle
+	leftIndex
+	leftThumb
+	rightThumb
+	rightIndex
+	rightMiddle
+	rightRing
+	rightPinky
+	numFingers
+)
+
+var fingerNames = [numFingers]string{
+	"left pinky", "left ring", "left middle", "left index", "left thumb",
+	"right thumb", "right index", "right middle", "right ring", "right pinky",
+}
+
+func (f finger) String() string { return fingerNames[f] }
+
+// leftHand returns whether the finger is on the left hand.
+func (f finger) leftHand() bool { return f <= leftThumb }
+
+// fingerGeometries describes how ZSA keyboards are typed on for
+// analysis. Home row is the index of the row of the home keys in the
+// built-in geometry. On split keyboards the bottom row is typed with
+// the thumbs; otherwise only its inner keys are.
+var fingerGeometries = map[string]struct {
+	homeRow int
+	split   bool
+}{
+	"voyager":    {homeRow: 2, split: true},
+	"moonlander": {homeRow: 2, split: true},
+	"ergodox-ez": {homeRow: 2, split: true},
+	"planck-ez":  {homeRow: 1, split: false},
+}
+
+// stroke is the key presses that type a character.
+type stroke struct {
+	layer int
+	key   int
+	shift bool
+}
+
+// typist types text on a layout.
+type typist struct {
+	layout  *layout
+	fingers [][]finger // finger of each key of each layer
+	home    [][]bool   // whether each key of each layer is on the home row
+
+	strokes  map[rune]stroke
+	layerKey map[int]int // base layer key activating each layer
+	shiftKey []int       // base layer shift keys
+}
+
+// newTypist returns a typist for the layout. Characters are typed on
+// the lowest layer that has them, without shift if possible. Layers are
+// only used if a base layer key activates them.
+func newTypist(l *layout) (*typist, error) {
+	g, ok := fingerGeometries[l.Geometry]
+	if !ok {
+		return nil, fmt.Errorf("no finger assignment for geometry %q", l.Geometry)
+	}
+	if len(l.Revision.Layers) == 0 {
+		return nil, fmt.Errorf("revision has no layers")
+	}
+	t := &typist{
+		layout:   l,
+		strokes:  make(map[rune]stroke),
+		layerKey: make(map[int]int),
+	}
+	for _, ly := range l.Revision.Layers {
+		f, h := assignFingers(keyPlacements(nil, l.Geometry, len(ly.Keys)), g.homeRow, g.split)
+		t.fingers = append(t.fingers, f[:min(len(f), len(ly.Keys))])
+		t.home = append(t.home, h[:min(len(h), len(ly.Keys))])
+	}
+	for _, s := range layerSwitches(l) {
+		if s.from != 0 || s.to == 0 || s.key >= len(t.fingers[0]) {
+			continue
+		}
+		// Prefer momentary activation.
+		if _, ok := t.layerKey[s.to]; !ok || !s.sticky {
+			t.layerKey[s.to] = s.key
+		}
+	}
+	for j, k := range l.Revision.Layers[0].Keys[:len(t.fingers[0])] {
+		for _, a := range k.Actions() {
+			if a.Kind == "tap" || a.Kind == "hold" {
+				if m := qmkModifiers[a.Action.Code]; m == "LSFT" || m == "RSFT" {
+					t.shiftKey = append(t.shiftKey, j)
+				}
+			}
+		}
+	}
+	for i, ly := range l.Revision.Layers {
+		if _, ok := t.layerKey[i]; i != 0 && !ok {
+			continue
+		}
+		var shifted []stroke
+		var shiftedChars []rune
+		for j, k := range ly.Keys {
+			if j >= len(t.fingers[i]) {
+				break
+			}
+			c, shift, ok := tapChar(k.Tap)
+			if !ok {
+				continue
+			}
+			if _, ok := t.strokes[c]; !ok {
+				t.strokes[c] = stroke{layer: i, key: j, shift: shift}
+			}
+			if s, ok := shiftedRune(c); ok && !shift {
+				shifted = append(shifted, stroke{layer: i, key: j, shift: true})
+				shiftedChars = append(shiftedChars, s)
+			}
+		}
+		// Shifted characters are only used if the layer has no
+		// direct key for them.
+		for n, s := range shifted {
+			if _, ok := t.strokes[shiftedChars[n]]; !ok {
+				t.strokes[shiftedChars[n]] = s
+			}
+		}
+	}
+	return t, nil
+}
+
+// assignFingers returns the finger and home row membership of each of
+// the placed keys. Keys are assigned to hands by which half of the
+// keyboard they are in and to fingers by their distance from the outer
+// edge of the keyboard: the outer two columns are typed with the
+// pinky, then one column each for the ring and middle fingers, and the
+// remaining inner columns with the index finger.
+func assignFingers(keys []keyPlacement, homeRow int, split bool) ([]finger, []bool) {
+	var width, bottom float64
+	for _, k := range keys {
+		width = max(width, k.X+k.W)
+		bottom = max(bottom, k.Y)
+	}
+	fingers := make([]finger, len(keys))
+	home := make([]bool, len(keys))
+	for i, k := range keys {
+		left := k.X+k.W/2 < width/2
+		d := k.X
+		if !left {
+			d = width - (k.X + k.W)
+		}
+		var f finger
+		switch {
+		case k.Y == bottom && (split || d >= 4):
+			f = leftThumb
+		case d < 2:
+			f = leftPinky
+		case d < 3:
+			f = leftRing
+		case d < 4:
+			f = leftMiddle
+		default:
+			f = leftIndex
+		}
+		if !left {
+			f = numFingers - 1 - f
+		}
+		fingers[i] = f
+		home[i] = k.Y == float64(homeRow) && f != leftThumb && f != rightThumb
+	}
+	return fingers, home
+}
+
+// tapChar returns the character typed by the tap action and whether it
+// is typed with shift.
+func tapChar(a *keyAction) (c rune, shift bool, ok bool) {
+	if a == nil || a.Layer != nil {
+		return 0, false, false
+	}
+	name, ok := remapKeyName(a.Code)
+	if !ok {
+		return 0, false, false
+	}
+	name, shift = strings.CutPrefix(name, "S-")
+	for _, m := range append(modifiers{a.Modifier}, a.Modifiers...) {
+		switch qmkModifiers[m] {
+		case "":
+		case "LSFT", "RSFT":
+			shift = true
+		default:
+			// Other modifiers do not type characters.
+			return 0, false, false
+		}
+	}
+	switch name {
+	case "spc":
+		c = ' '
+	case "ret":
+		c = '\n'
+	case "tab":
+		c = '\t'
+	case "grv":
+		c = '`'
+	default:
+		r := []rune(name)
+		if len(r) != 1 {
+			return 0, false, false
+		}
+		c = r[0]
+	}
+	if shift {
+		s, ok := shiftedRune(c)
+		if !ok {
+			return 0, false, false
+		}
+		c = s
+	}
+	return c, shift, true
+}
+
+// shiftedRune returns the character typed by shifting c on a US
+// keyboard.
+func shiftedRune(c rune) (rune, bool) {
+	if 'a' <= c && c <= 'z' {
+		return unicode.ToUpper(c), true
+	}
+	const unshifted, shifted = "`1234567890-=[]\\;',./", "~!@#$%^&*()_+{}|:\"<>?"
+	i := strings.IndexRune(unshifted, c)
+	if i < 0 {
+		return 0, false
+	}
+	return rune(shifted[i]), true
+}
+
+// typingReport holds the statistics of typing a corpus on a revision.
+type typingReport struct {
+	Revision string `json:"revision"`
+	Geometry string `json:"geometry"`
+
+	// Characters is the number of characters in the corpus that
+	// could be typed and Unmapped the number that could not.
+	Characters int            `json:"characters"`
+	Unmapped   map[string]int `json:"unmapped,omitempty"`
+
+	// Presses is the number of key presses, including the shift
+	// and layer keys needed to type characters.
+	Presses int `json:"presses"`
+	// FingerLoad is the number of presses by each finger.
+	FingerLoad map[string]int `json:"finger_load"`
+	// SameFingerBigrams is the number of consecutive presses of
+	// different keys by the same finger.
+	SameFingerBigrams int `json:"same_finger_bigrams"`
+	// HomeRow is the number of presses of home row keys.
+	HomeRow int `json:"home_row"`
+	// LayerUse is the number of characters typed on each layer,
+	// and LayerSwitches the number of times consecutive characters
+	// are typed on different layers.
+	LayerUse      map[int]int `json:"layer_use"`
+	LayerSwitches int         `json:"layer_switches"`
+}
+
+// typeText returns the statistics of typing the text.
+func (t *typist) typeText(id, text string) typingReport {
+	r := typingReport{
+		Revision:   id,
+		Geometry:   t.layout.Geometry,
+		FingerLoad: make(map[string]int),
+		LayerUse:   make(map[int]int),
+	}
+	type press struct {
+		key    int
+		finger finger
+	}
+	var (
+		last      *press
+		lastLayer = -1
+	)
+	pressKey := func(layer, key int) {
+		p := press{key: key, finger: t.fingers[layer][key]}
+		r.Presses++
+		r.FingerLoad[p.finger.String()]++
+		if t.home[layer][key] {
+			r.HomeRow++
+		}
+		if last != nil && last.finger == p.finger && last.key != p.key {
+			r.SameFingerBigrams++
+		}
+		last = &p
+	}
+	for _, c := range text {
+		if c == '\r' {
+			continue
+		}
+		s, ok := t.strokes[c]
+		if !ok {
+			if r.Unmapped == nil {
+				r.Unmapped = make(map[string]int)
+			}
+			r.Unmapped[string(c)]++
+			continue
+		}
+		r.Characters++
+		r.LayerUse[s.layer]++
+		if lastLayer >= 0 && s.layer != lastLayer {
+			r.LayerSwitches++
+		}
+		lastLayer = s.layer
+		if s.layer != 0 {
+			pressKey(0, t.layerKey[s.layer])
+		}
+		if s.shift {
+			if k, ok := t.shiftFor(s); ok {
+				pressKey(0, k)
+			}
+		}
+		pressKey(s.layer, s.key)
+	}
+	return r
+}
+
+// shiftFor returns the shift key to press with the stroke, preferring
+// one on the other hand.
+func (t *typist) shiftFor(s stroke) (int, bool) {
+	if len(t.shiftKey) == 0 {
+		return 0, false
+	}
+	left := t.fingers[s.layer][s.key].leftHand()
+	for _, k := range t.shiftKey {
+		if t.fingers[0][k].leftHand() != left {
+			return k, true
+		}
+	}
+	return t.shiftKey[0], true
+}
+
+// writeTypingReports writes the reports to w in the given format. Text
+// output has a column for each report.
+func writeTypingReports(w io.Writer, reports []typingReport, format string) error {
+	if format == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "\t")
+		return enc.Encode(reports)
+	}
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	row := func(name string, val func(r typingReport) string) {
+		fmt.Fprint(tw, name)
+		for _, r := range reports {
+			fmt.Fprintf(tw, "\t%s", val(r))
+		}
+		fmt.Fprintln(tw)
+	}
+	pct := func(n, of int) string {
+		if of == 0 {
+			return "-"
+		}
+		return fmt.Sprintf("%d (%.1f%%)", n, 100*float64(n)/float64(of))
+	}
+	row("REVISION", func(r typingReport) string { return r.Revision })
+	row("characters", func(r typingReport) string { return fmt.Sprint(r.Characters) })
+	row("unmapped", func(r typingReport) string { return unmappedSummary(r.Unmapped) })
+	row("presses", func(r typingReport) string { return fmt.Sprint(r.Presses) })
+	for _, f := range fingerNames {
+		row(f, func(r typingReport) string { return pct(r.FingerLoad[f], r.Presses) })
+	}
+	row("same-finger bigrams", func(r typingReport) string { return pct(r.SameFingerBigrams, max(r.Presses-1, 0)) })
+	row("home row", func(r typingReport) string { return pct(r.HomeRow, r.Presses) })
+	row("layer switches", func(r typingReport) string { return pct(r.LayerSwitches, max(r.Characters-1, 0)) })
+	var layers []int
+	for _, r := range reports {
+		for l := range r.LayerUse {
+			if !slices.Contains(layers, l) {
+				layers = append(layers, l)
+			}
+		}
+	}
+	slices.Sort(layers)
+	for _, l := range layers {
+		row(fmt.Sprintf("layer %d", l), func(r typingReport) string { return pct(r.LayerUse[l], r.Characters) })
+	}
+	return tw.Flush()
+}
+
+// unmappedSummary returns a summary of the most frequent characters
+// that could not be typed.
+func unmappedSummary(unmapped map[string]int) string {
+	var total int
+	for _, n := range unmapped {
+		total += n
+	}
+	if total == 0 {
+		return "0"
+	}
+	chars := slices.SortedFunc(maps.Keys(unmapped), func(a, b string) int {
+		if c := unmapped[b] - unmapped[a]; c != 0 {
+			return c
+		}
+		return strings.Compare(a, b)
+	})
+	if len(chars) > 5 {
+		chars = chars[:5]
+	}
+	for i, c := range chars {
+		chars[i] = fmt.Sprintf("%q", c)
+	}
+	return fmt.Sprintf("%d (%s)", total, strings.Join(chars, " "))
+}
@@ -0,0 +1,119 @@
+// Copyright ©2025 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// apiCmd enables or disables keymapp's gRPC API.
+func apiCmd(args []string) {
+	usage := func() {
+		fmt.Fprintln(os.Stderr, `usage: fkm api enable [-port N] [flags]
+       fkm api disable [flags]`)
+		os.Exit(exitUsage)
+	}
+	if len(args) == 0 {
+		usage()
+	}
+	cmd, args := args[0], args[1:]
+	if cmd != "enable" && cmd != "disable" {
+		usage()
+	}
+
+	fs := flag.NewFlagSet("fkm api "+cmd, flag.ExitOnError)
+	var (
+		common commonFlags
+		guard  writeGuard
+	)
+	common.register(fs)
+	guard.register(fs)
+	port := 0
+	if cmd == "enable" {
+		fs.IntVar(&port, "port", 50051, "port for the keymapp API to listen on")
+	}
+	dryRun := fs.Bool("dry-run", false, "print the changes that would be made to the database without making them")
+	backupDir := fs.String("backup-dir", "", "directory to back up the database to before changing it")
+	timeout := fs.Duration("timeout", 5*time.Second, "time to wait for keymapp to respond after enabling the API")
+	fs.Parse(args)
+	common.setup(fs)
+	if fs.NArg() != 0 || cmd == "enable" && (port < 1 || port > 65535) {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+	if port != 0 && port < 1024 {
+		slog.Warn("api port is privileged", "port", port)
+	}
+
+	addr := net.JoinHostPort("localhost", strconv.Itoa(port))
+	if cmd == "enable" {
+		err := checkAPIPort(addr, *timeout)
+		if err != nil {
+			fatal("api port is not available", err, "port", port)
+		}
+	}
+
+	db := openExisting(common.dbPath, *dryRun, common.db)
+	defer db.Close()
+
+	var changes []change
+	if cmd == "enable" {
+		changes = append(setConfig("api_enabled", "1"), setConfig("api_port", strconv.Itoa(port))...)
+	} else {
+		changes = setConfig("api_enabled", "0")
+	}
+	if *dryRun {
+		printChanges(common.dbPath, changes)
+		return
+	}
+	err := guard.check(context.Background(), db)
+	if err != nil {
+		fatal("refusing to update db", err, "path", common.dbPath)
+	}
+	if *backupDir != "" {
+		err = autoBackup(db, *backupDir)
+		if err != nil {
+			fatal("failed to back up db", err, "path", common.dbPath)
+		}
+	}
+	err = apply(db, changes)
+	if err != nil {
+		fatal("failed to update db", err, "path", common.dbPath)
+	}
+	if cmd == "disable" {
+		return
+	}
+
+	s, err := keymappAPI{newGRPCClient(addr, *timeout)}.status()
+	if err != nil {
+		slog.Warn("keymapp API is not reachable: start or restart keymapp to apply the change", "addr", addr, "error", err)
+		return
+	}
+	fmt.Printf("keymapp %s API is reachable on %s\n", s.version, addr)
+}
+
+// checkAPIPort returns an error if the address is in use by something
+// other than the keymapp API.
+func checkAPIPort(addr string, timeout time.Duration) error {
+	l, err := net.Listen("tcp", addr)
+	if err == nil {
+		return l.Close()
+	}
+	// The port may be in use because keymapp already serves
+	// its API on it.
+	_, serr := keymappAPI{newGRPCClient(addr, timeout)}.status()
+	if serr == nil {
+		return nil
+	}
+	return errors.Join(err, fmt.Errorf("not a keymapp API: %w", serr))
+}
@@ -0,0 +1,146 @@
+// Copyright ©2025 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// auditEntry is a line of the network audit log.
+type auditEntry struct {
+	Start          time.Time `json:"start"`
+	End            time.Time `json:"end"`
+	Method         string    `json:"method"`
+	URL            string    `json:"url"`
+	Status         int       `json:"status,omitempty"`
+	RequestBytes   int       `json:"request_bytes"`
+	RequestSHA256  string    `json:"request_sha256"`
+	ResponseBytes  int       `json:"response_bytes"`
+	ResponseSHA256 string    `json:"response_sha256,omitempty"`
+	Error          string    `json:"error,omitempty"`
+}
+
+// auditor is an http.RoundTripper that appends a record of each
+// request sent through it to a JSON lines log.
+type auditor struct {
+	next http.RoundTripper
+
+	mu  sync.Mutex
+	log io.Writer
+}
+
+// newAuditor returns an auditor sending requests to next and logging
+// them to the file at path, which is appended to if it exists.
+func newAuditor(next http.RoundTripper, path string) (*auditor, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	return &auditor{next: next, log: f}, nil
+}
+
+// RoundTrip sends req to the next transport. The response body is
+// passed through as it is read, and the request is logged when the body
+// has been read or closed.
+func (a *auditor) RoundTrip(req *http.Request) (*http.Response, error) {
+	reqBody, err := readRequestBody(req)
+	if err != nil {
+		return nil, err
+	}
+	e := auditEntry{
+		Start:         time.Now().UTC(),
+		Method:        req.Method,
+		URL:           req.URL.String(),
+		RequestBytes:  len(reqBody),
+		RequestSHA256: fmt.Sprintf("%x", sha256.Sum256(reqBody)),
+	}
+	resp, err := a.next.RoundTrip(req)
+	if err != nil {
+		e.End = time.Now().UTC()
+		e.Error = err.Error()
+		lerr := a.write(e)
+		if lerr != nil {
+			return nil, permanentError{fmt.Errorf("failed to write audit log: %w", lerr)}
+		}
+		return nil, err
+	}
+	e.Status = resp.StatusCode
+	resp.Body = &auditedBody{ReadCloser: resp.Body, log: a, entry: e, hash: sha256.New()}
+	return resp, nil
+}
+
+// auditedBody is a response body that logs its request to the audit log
+// once it has been completely read or closed.
+type auditedBody struct {
+	io.ReadCloser
+	log    *auditor
+	entry  auditEntry
+	hash   hash.Hash
+	logged bool
+}
+
+func (b *auditedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.hash.Write(p[:n])
+	b.entry.ResponseBytes += n
+	switch {
+	case err == io.EOF:
+		lerr := b.finish(nil)
+		if lerr != nil {
+			return n, lerr
+		}
+	case err != nil:
+		b.finish(err)
+	}
+	return n, err
+}
+
+func (b *auditedBody) Close() error {
+	err := b.ReadCloser.Close()
+	lerr := b.finish(nil)
+	if lerr != nil {
+		return lerr
+	}
+	return err
+}
+
+// finish logs the request with the response bytes read so far and any
+// error reading the body, if it has not already been logged.
+func (b *auditedBody) finish(err error) error {
+	if b.logged {
+		return nil
+	}
+	b.logged = true
+	b.entry.End = time.Now().UTC()
+	b.entry.ResponseSHA256 = fmt.Sprintf("%x", b.hash.Sum(nil))
+	if err != nil {
+		b.entry.Error = err.Error()
+	}
+	lerr := b.log.write(b.entry)
+	if lerr != nil {
+		return permanentError{fmt.Errorf("failed to write audit log: %w", lerr)}
+	}
+	return nil
+}
+
+// write appends e to the log.
+func (a *auditor) write(e auditEntry) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	_, err = a.log.Write(append(b, '\n'))
+	return err
+}
@@ -0,0 +1,254 @@
+// Copyright ©2025 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+)
+
+// authCmd manages the keymapp login held in the database.
+func authCmd(args []string) {
+	usage := func() {
+		fmt.Fprintln(os.Stderr, `usage: fkm auth import -from <path> [flags]
+       fkm auth to-keyring [flags]
+       fkm auth from-keyring [flags]
+       fkm auth status [flags]
+
+Import the keymapp login from another keymapp database, for example a
+copy of the database of a machine that has network access, so that
+keymapp is authorised to fetch private layouts without logging in
+again.
+
+The to-keyring subcommand moves the login from the database to the OS
+keyring (Secret Service, macOS Keychain or Windows Credential Manager)
+so that the token is not held in plain text, and from-keyring moves it
+back for keymapp to use. Commands given the -keyring-auth flag read the
+token from the keyring and send it with their Oryx requests, holding it
+only in memory.
+
+The status subcommand reports the user that is logged in. Tokens are
+never printed.`)
+		os.Exit(exitUsage)
+	}
+	if len(args) == 0 {
+		usage()
+	}
+	cmd, args := args[0], args[1:]
+	switch cmd {
+	case "import", "to-keyring", "from-keyring", "status":
+	default:
+		usage()
+	}
+
+	fs := flag.NewFlagSet("fkm auth "+cmd, flag.ExitOnError)
+	var (
+		common commonFlags
+		guard  writeGuard
+	)
+	common.register(fs)
+	var from, backupDir *string
+	dryRun := new(bool)
+	if cmd != "status" {
+		guard.register(fs)
+		if cmd == "import" {
+			from = fs.String("from", "", "path to the keymapp database to import the login from (required)")
+		}
+		dryRun = fs.Bool("dry-run", false, "print the changes that would be made to the database without making them")
+		backupDir = fs.String("backup-dir", "", "directory to back up the database to before changing it")
+	}
+	fs.Parse(args)
+	common.setup(fs)
+	if fs.NArg() != 0 || (cmd == "import" && *from == "") {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+
+	db := openExisting(common.dbPath, cmd == "status" || *dryRun, common.db)
+	defer db.Close()
+
+	var (
+		logins  []authLogin
+		changes []change
+	)
+	switch cmd {
+	case "status":
+		logins, err := authLogins(db)
+		if err != nil {
+			fatal("failed to read auth", err, "path", common.dbPath)
+		}
+		kl, err := keyringLogin()
+		if err != nil && !errors.Is(err, errNoKeyringSecret) {
+			slog.Debug("failed to read keyring", "error", err)
+		}
+		if common.json {
+			status := struct {
+				Users   []string `json:"users"`
+				Keyring string   `json:"keyring,omitempty"`
+			}{Users: []string{}, Keyring: kl.username}
+			for _, l := range logins {
+				status.Users = append(status.Users, l.username)
+			}
+			printJSON(status)
+			return
+		}
+		if len(logins) == 0 {
+			fmt.Println("not logged in")
+		}
+		for _, l := range logins {
+			fmt.Printf("logged in as %s\n", l.username)
+		}
+		if kl.token != "" {
+			fmt.Printf("OS keyring holds login for %s\n", kl.username)
+		}
+		return
+
+	case "import":
+		src, err := filepath.Abs(*from)
+		if err == nil {
+			var dst string
+			dst, err = filepath.Abs(common.dbPath)
+			if err == nil && src == dst {
+				fatal("invalid source", usageError("-from is the database being updated"), "path", *from)
+			}
+		}
+		srcDB := openExisting(*from, true, common.db)
+		defer srcDB.Close()
+		logins, err = authLogins(srcDB)
+		if err != nil {
+			fatal("failed to read auth", err, "path", *from)
+		}
+		if len(logins) == 0 {
+			fatal("failed to import auth", errors.New("source database has no login"), "path", *from)
+		}
+		changes = importAuth(logins)
+
+	case "to-keyring":
+		var err error
+		logins, err = authLogins(db)
+		if err != nil {
+			fatal("failed to read auth", err, "path", common.dbPath)
+		}
+		switch len(logins) {
+		case 0:
+			fatal("failed to move login", errors.New("database has no login"), "path", common.dbPath)
+		case 1:
+		default:
+			fatal("failed to move login", fmt.Errorf("database has %d logins", len(logins)), "path", common.dbPath)
+		}
+		changes = importAuth(nil)
+
+	case "from-keyring":
+		l, err := keyringLogin()
+		if err != nil {
+			fatal("failed to read keyring", err)
+		}
+		logins = []authLogin{l}
+		changes = importAuth(logins)
+	}
+	if *dryRun {
+		printChanges(common.dbPath, changes)
+		return
+	}
+	err := guard.check(context.Background(), db)
+	if err != nil {
+		fatal("refusing to update db", err, "path", common.dbPath)
+	}
+	if *backupDir != "" {
+		err = autoBackup(db, *backupDir)
+		if err != nil {
+			fatal("failed to back up db", err, "path", common.dbPath)
+		}
+	}
+	if cmd == "to-keyring" {
+		// Store the login before removing it from the database
+		// so that it cannot be lost.
+		err = setKeyringLogin(logins[0])
+		if err != nil {
+			fatal("failed to write keyring", err)
+		}
+	}
+	if cmd == "to-keyring" {
+		// Do not leave the removed token readable in the
+		// database file or its WAL.
+		err = applySecure(db, changes)
+	} else {
+		err = apply(db, changes)
+	}
+	if err != nil {
+		fatal("failed to update db", err, "path", common.dbPath)
+	}
+	switch cmd {
+	case "import":
+		for _, l := range logins {
+			fmt.Printf("imported login for %s: restart keymapp to use it\n", l.username)
+		}
+	case "to-keyring":
+		fmt.Printf("moved login for %s to the OS keyring: keymapp will not be able to fetch private layouts until it is moved back\n", logins[0].username)
+	case "from-keyring":
+		err = keyringDelete()
+		if err != nil {
+			slog.Warn("failed to remove login from the OS keyring", "error", err)
+		}
+		fmt.Printf("restored login for %s: restart keymapp to use it\n", logins[0].username)
+	}
+}
+
+// authLogin is a row of the keymapp auth table.
+type authLogin struct {
+	token    string
+	username string
+}
+
+// authLogins returns the logins held in db.
+func authLogins(db *sql.DB) ([]authLogin, error) {
+	ok, err := hasTable(db, "auth")
+	if err != nil || !ok {
+		return nil, err
+	}
+	rows, err := db.Query(`SELECT token, username FROM auth ORDER BY rowid`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var logins []authLogin
+	for rows.Next() {
+		var l authLogin
+		err = rows.Scan(&l.token, &l.username)
+		if err != nil {
+			return nil, err
+		}
+		logins = append(logins, l)
+	}
+	return logins, rows.Err()
+}
+
+// importAuth returns the changes replacing the logins in the database
+// with logins, or removing them if logins is empty. Tokens are not
+// included in change descriptions.
+func importAuth(logins []authLogin) []change {
+	changes := []change{{
+		table: "auth",
+		op:    "delete",
+		desc:  "all rows",
+		query: `DELETE FROM auth`,
+	}}
+	for _, l := range logins {
+		changes = append(changes, change{
+			table: "auth",
+			op:    "insert",
+			desc:  fmt.Sprintf("username=%s token=<redacted>", l.username),
+			query: `INSERT INTO auth (token, username) VALUES (?, ?)`,
+			args:  []any{l.token, l.username},
+		})
+	}
+	return changes
+}
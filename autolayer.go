@@ -0,0 +1,302 @@
+// Copyright ©2025 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// autolayer switches keyboard layers to follow the focused window
+// according to the smart layer rules in the database.
+func autolayer(args []string) {
+	fs := flag.NewFlagSet("fkm autolayer", flag.ExitOnError)
+	var (
+		common commonFlags
+		dmn    daemonFlags
+	)
+	common.register(fs)
+	dmn.register(fs)
+	addr := fs.String("addr", "", "address of the keymapp API (default localhost and the api_port in the database)")
+	timeout := fs.Duration("timeout", 5*time.Second, "timeout for each API request")
+	interval := fs.Duration("interval", 250*time.Millisecond, "time between checks of the focused window")
+	layoutID := fs.String("layout", "", "hash ID of the layout whose smart layer rules are used (required if rules exist for more than one layout)")
+	keyboard := fs.String("keyboard", "", "geometry of the keyboard whose layouts' smart layer rules are used")
+	fs.Parse(args)
+	common.setup(fs)
+	if fs.NArg() != 0 || *interval <= 0 {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+
+	db := openExisting(common.dbPath, true, common.db)
+	defer db.Close()
+	if *addr == "" {
+		var err error
+		*addr, err = keymappAPIAddr(db)
+		if err != nil {
+			fatal("failed to read api config", err, "path", common.dbPath)
+		}
+	}
+	// Check the rules before starting.
+	_, err := smartLayerRules(db, *layoutID, *keyboard)
+	if err != nil {
+		fatal("failed to read smart layer rules", err, "path", common.dbPath)
+	}
+	mismatched, err := smartLayerMismatches(db)
+	if err != nil {
+		fatal("failed to check smart layer rules", err, "path", common.dbPath)
+	}
+	for _, m := range mismatched {
+		if m.geometry {
+			slog.Warn("ignoring smart layer rule", "id", m.id, "app", m.app, "reason", m.reason)
+		}
+	}
+	api := keymappAPI{newGRPCClient(*addr, *timeout)}
+	err = api.connect()
+	if err != nil {
+		fatal("failed to connect to keymapp", err, "addr", *addr)
+	}
+
+	ctx, stop := shutdownContext()
+	defer stop()
+	d, err := dmn.start(ctx, "autolayer")
+	if err != nil {
+		fatal("failed to serve status", err, "addr", dmn.statusAddr)
+	}
+	s := layerSwitcher{db: db, api: api, layout: *layoutID, keyboard: *keyboard, locked: -1, status: d}
+	s.run(ctx, *interval)
+}
+
+// layerSwitcher locks the layer of the keyboard to the smart layer of
+// the focused application.
+type layerSwitcher struct {
+	db       *sql.DB
+	api      keymappAPI
+	layout   string
+	keyboard string
+	status   *daemon
+
+	app      string // focused application
+	locked   int    // locked layer, or -1
+	focusErr bool   // whether a focus error has been reported
+}
+
+// run checks the focused window every interval until ctx is done,
+// releasing any locked layer before returning.
+func (s *layerSwitcher) run(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	s.status.setState("running")
+	for {
+		s.check()
+		s.status.set("app", s.app)
+		if s.locked >= 0 {
+			s.status.set("locked_layer", s.locked)
+		} else {
+			s.status.set("locked_layer", nil)
+		}
+		select {
+		case <-ctx.Done():
+			s.status.setState("stopping")
+			if s.locked >= 0 {
+				err := s.api.setLayer(s.locked, false)
+				if err != nil {
+					slog.Warn("failed to release layer", "layer", s.locked, "error", err)
+				}
+			}
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// check switches layers if the focused application has changed.
+func (s *layerSwitcher) check() {
+	app, err := focusedApp()
+	if err != nil {
+		if !s.focusErr {
+			slog.Warn("failed to get focused window", "error", err)
+			s.focusErr = true
+		}
+		return
+	}
+	s.focusErr = false
+	if app == s.app {
+		return
+	}
+	rules, err := smartLayerRules(s.db, s.layout, s.keyboard)
+	if err != nil {
+		slog.Warn("failed to read smart layer rules", "error", err)
+		return
+	}
+	target, ok := rules[strings.ToLower(app)]
+	if !ok {
+		target = -1
+	}
+	slog.Info("focus changed", "app", app, "layer", target)
+	if target != s.locked {
+		if s.locked >= 0 {
+			err = s.api.setLayer(s.locked, false)
+			if err != nil {
+				slog.Warn("failed to release layer", "layer", s.locked, "error", err)
+				return
+			}
+			s.locked = -1
+		}
+		if target >= 0 {
+			err = s.api.setLayer(target, true)
+			if err != nil {
+				slog.Warn("failed to set layer", "layer", target, "app", app, "error", err)
+				return
+			}
+			s.locked = target
+		}
+	}
+	s.app = app
+}
+
+// smartLayerRules returns the smart layer of each application, keyed by
+// lower case application name, for the layout with the given hash ID.
+// If keyboard is not empty, only the rules of layouts with that geometry
+// are considered. If layoutID is empty, the remaining rules must all be
+// for a single layout. Rules referencing a revision of a different
+// geometry to their layout are ignored.
+func smartLayerRules(db *sql.DB, layoutID, keyboard string) (map[string]int, error) {
+	geoms, err := storedGeometries(db)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := db.Query(`SELECT app, layer, layoutId, revisionId FROM smart_layer ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	rules := make(map[string]map[string]int)
+	for rows.Next() {
+		var (
+			app, layout, rev string
+			layer            int
+		)
+		err = rows.Scan(&app, &layer, &layout, &rev)
+		if err != nil {
+			return nil, err
+		}
+		g, ok := geoms.layouts[layout]
+		if keyboard != "" && g != keyboard {
+			continue
+		}
+		if r, ok2 := geoms.revisions[rev]; ok && ok2 && g != r.Geometry {
+			continue
+		}
+		if rules[layout] == nil {
+			rules[layout] = make(map[string]int)
+		}
+		rules[layout][strings.ToLower(app)] = layer
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	if layoutID != "" {
+		return rules[layoutID], nil
+	}
+	switch len(rules) {
+	case 0:
+		return nil, nil
+	case 1:
+		for _, r := range rules {
+			return r, nil
+		}
+	}
+	return nil, fmt.Errorf("smart layer rules exist for layouts %s: use -layout or -keyboard to select one", strings.Join(slices.Sorted(maps.Keys(rules)), ", "))
+}
+
+// geometryIndex holds the geometries of the stored revisions.
+type geometryIndex struct {
+	revisions map[string]*layout // parsed revisions keyed by revision ID
+	layouts   map[string]string  // geometry of the newest revision keyed by layout hash ID
+}
+
+// storedGeometries returns the geometries of the revisions and layouts
+// stored in the database.
+func storedGeometries(db *sql.DB) (geometryIndex, error) {
+	revs, err := storedRevisions(db)
+	if err != nil {
+		return geometryIndex{}, err
+	}
+	idx := geometryIndex{
+		revisions: make(map[string]*layout),
+		layouts:   make(map[string]string),
+	}
+	for _, r := range revs {
+		l := r.layout
+		if l == nil {
+			continue
+		}
+		idx.revisions[r.id] = l
+		// Revisions are ordered newest first within each layout.
+		if _, ok := idx.layouts[l.HashID]; !ok {
+			idx.layouts[l.HashID] = l.Geometry
+		}
+	}
+	return idx, nil
+}
+
+// smartLayerMismatch is a smart layer rule that references a revision
+// of a layout other than the rule's layout.
+type smartLayerMismatch struct {
+	id       int
+	app      string
+	reason   string
+	geometry bool // the revision is for a different keyboard geometry
+}
+
+// smartLayerMismatches returns the smart layer rules whose revision is
+// not a revision of the rule's layout. Rules referencing revisions that
+// are not stored are not included.
+func smartLayerMismatches(db *sql.DB) ([]smartLayerMismatch, error) {
+	geoms, err := storedGeometries(db)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := db.Query(`SELECT id, app, layoutId, revisionId FROM smart_layer ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var mismatched []smartLayerMismatch
+	for rows.Next() {
+		var (
+			id               int
+			app, layout, rev string
+		)
+		err = rows.Scan(&id, &app, &layout, &rev)
+		if err != nil {
+			return nil, err
+		}
+		r, ok := geoms.revisions[rev]
+		if !ok || r.HashID == layout {
+			continue
+		}
+		m := smartLayerMismatch{id: id, app: app}
+		if g, ok := geoms.layouts[layout]; ok && g != r.Geometry {
+			m.geometry = true
+			m.reason = fmt.Sprintf("smart layer %d for %s on %s layout %s references %s revision %s", id, app, g, layout, r.Geometry, rev)
+		} else {
+			m.reason = fmt.Sprintf("smart layer %d for %s on layout %s references revision %s of layout %s", id, app, layout, rev, r.HashID)
+		}
+		mismatched = append(mismatched, m)
+	}
+	return mismatched, rows.Err()
+}
@@ -0,0 +1,239 @@
+// Copyright ©2025 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// backupCmd writes a copy of the database to a file.
+func backupCmd(args []string) {
+	fs := flag.NewFlagSet("fkm backup", flag.ExitOnError)
+	var common commonFlags
+	common.register(fs)
+	out := fs.String("out", "keymapp-"+time.Now().Format("20060102")+".sqlite3", "path to write the backup to")
+	force := fs.Bool("force", false, "overwrite an existing backup file")
+	signKey := fs.String("sign", "", "minisign secret key file to sign the backup with, writing the signature next to it with a "+sigSuffix+" suffix")
+	fs.Parse(args)
+	common.setup(fs)
+
+	var key signingKey
+	if *signKey != "" {
+		var err error
+		key, err = loadSigningKey(*signKey)
+		if err != nil {
+			fatal("failed to load signing key", err, "path", *signKey)
+		}
+	}
+
+	db := openExisting(common.dbPath, true, common.db)
+	defer db.Close()
+
+	err := backupTo(db, *out, *force)
+	if err != nil {
+		fatal("failed to back up db", err, "path", common.dbPath, "out", *out)
+	}
+	if *signKey != "" {
+		err = signFile(*out, key)
+		if err != nil {
+			fatal("failed to sign backup", err, "out", *out)
+		}
+	}
+	slog.Info("backed up db", "path", common.dbPath, "out", *out)
+}
+
+// restoreCmd replaces the contents of the database with a backup.
+func restoreCmd(args []string) {
+	fs := flag.NewFlagSet("fkm restore", flag.ExitOnError)
+	var (
+		common commonFlags
+		guard  writeGuard
+	)
+	common.register(fs)
+	guard.register(fs)
+	from := fs.String("from", "", "path to the backup to restore (required)")
+	backupDir := fs.String("backup-dir", "", "directory to back up the current database to before restoring")
+	verifyKeyPath := fs.String("verify-key", "", "minisign public key file that the backup must be signed by")
+	fs.Parse(args)
+	common.setup(fs)
+	if *from == "" {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+
+	if *verifyKeyPath != "" {
+		key, err := loadVerifyKey(*verifyKeyPath)
+		if err != nil {
+			fatal("failed to load verification key", err, "path", *verifyKeyPath)
+		}
+		err = verifyFile(*from, key)
+		if err != nil {
+			fatal("invalid backup", err, "from", *from)
+		}
+	}
+	err := checkBackup(*from)
+	if err != nil {
+		fatal("invalid backup", err, "from", *from)
+	}
+	if *backupDir != "" {
+		if _, err := os.Stat(common.dbPath); err == nil {
+			db := openExisting(common.dbPath, true, common.db)
+			err = autoBackup(db, *backupDir)
+			db.Close()
+			if err != nil {
+				fatal("failed to back up db", err, "path", common.dbPath)
+			}
+		}
+	}
+	db, err := openDB(common.dbPath, common.db)
+	if err != nil {
+		fatal("failed to open db", err, "path", common.dbPath)
+	}
+	defer db.Close()
+	err = guard.check(context.Background(), db)
+	if err != nil {
+		fatal("refusing to restore db", err, "path", common.dbPath)
+	}
+	err = sqliteBackup(db, *from, true)
+	if err != nil {
+		fatal("failed to restore db", err, "path", common.dbPath, "from", *from)
+	}
+	slog.Info("restored db", "path", common.dbPath, "from", *from)
+}
+
+// checkBackup checks that the file at path is a sound sqlite database.
+func checkBackup(path string) error {
+	db, err := openDBReadOnly(path, dbOptions{})
+	if err != nil {
+		return err
+	}
+	if db == nil {
+		return fs.ErrNotExist
+	}
+	defer db.Close()
+	var result string
+	err = db.QueryRow(`PRAGMA quick_check`).Scan(&result)
+	if err != nil {
+		return err
+	}
+	if result != "ok" {
+		return fmt.Errorf("integrity check failed: %s", result)
+	}
+	ok, err := hasTable(db, "revision")
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.New("not a keymapp database")
+	}
+	return nil
+}
+
+// autoBackup writes a timestamped backup of db into dir. Commands take
+// the backup after the write guard has passed and before calling apply,
+// which is where the schema of db is migrated, so the backup is a copy
+// of the database as it was before fkm changed it.
+func autoBackup(db *sql.DB, dir string) error {
+	err := os.MkdirAll(dir, 0o750)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(dir, "keymapp-"+time.Now().Format("20060102T150405")+".sqlite3")
+	err = backupTo(db, path, false)
+	if err != nil {
+		return err
+	}
+	slog.Info("backed up db", "out", path)
+	return nil
+}
+
+// backupTo writes a backup of db to path. If force is false, an
+// existing file at path is not overwritten.
+func backupTo(db *sql.DB, path string, force bool) error {
+	if !force {
+		_, err := os.Stat(path)
+		if err == nil {
+			return fmt.Errorf("%s: %w", path, fs.ErrExist)
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	return sqliteBackup(db, path, false)
+}
+
+// sqliteBackup uses the sqlite online backup API to copy db to the
+// database at path, or if restore is true, to copy the database at
+// path into db. The copy is made incrementally so that other
+// connections may continue to use the source database.
+func sqliteBackup(db *sql.DB, path string, restore bool) error {
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return conn.Raw(func(dc any) error {
+		c, ok := dc.(interface {
+			NewBackup(string) (*sqlite.Backup, error)
+			NewRestore(string) (*sqlite.Backup, error)
+		})
+		if !ok {
+			return fmt.Errorf("backup not supported by %T", dc)
+		}
+		var (
+			b   *sqlite.Backup
+			err error
+		)
+		if restore {
+			b, err = c.NewRestore(path)
+		} else {
+			b, err = c.NewBackup(path)
+		}
+		if err != nil {
+			return err
+		}
+		for {
+			more, err := b.Step(64)
+			if err != nil {
+				if isBusy(err) {
+					time.Sleep(50 * time.Millisecond)
+					continue
+				}
+				b.Finish()
+				return err
+			}
+			if !more {
+				break
+			}
+		}
+		return b.Finish()
+	})
+}
+
+// isBusy returns whether err is an sqlite busy or locked error.
+func isBusy(err error) bool {
+	var e *sqlite.Error
+	if !errors.As(err, &e) {
+		return false
+	}
+	switch e.Code() & 0xff {
+	case sqlite3.SQLITE_BUSY, sqlite3.SQLITE_LOCKED:
+		return true
+	}
+	return false
+}
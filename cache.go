@@ -0,0 +1,246 @@
+// Copyright ©2025 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+//go:generate curl -sSfL -o snapshot/metadata.json https://configure.zsa.io/metadata.json
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// defaultCacheDir returns the default fkm cache directory.
+func defaultCacheDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "fkm")
+}
+
+// metadataCache is a local cache of metadata.json.
+type metadataCache struct {
+	// dir is the cache directory. If dir is empty,
+	// no caching is performed.
+	dir string
+	// maxAge is the age below which the cached metadata
+	// is used without checking with the server.
+	maxAge time.Duration
+	// refresh forces an unconditional fetch.
+	refresh bool
+	// offline prevents fetching from the server. The
+	// cached metadata is used if it is available,
+	// otherwise the embedded snapshot is used.
+	offline bool
+}
+
+// metadataCacheInfo holds the validators for a cached metadata.json.
+type metadataCacheInfo struct {
+	Endpoint     string    `json:"endpoint"`
+	ETag         string    `json:"etag,omitempty"`
+	LastModified string    `json:"last_modified,omitempty"`
+	Fetched      time.Time `json:"fetched"`
+}
+
+func (m metadataCache) dataPath() string { return filepath.Join(m.dir, "metadata.json") }
+func (m metadataCache) infoPath() string { return filepath.Join(m.dir, "metadata.json.info") }
+
+// fetchedMetadata is metadata.json data obtained by a metadataCache.
+type fetchedMetadata struct {
+	data []byte
+	// embedded is whether data is the snapshot embedded in the
+	// binary rather than metadata from the server. Embedded data
+	// may be older than metadata already stored in a database.
+	embedded bool
+}
+
+// get returns the metadata from endpoint, using the cache when it is
+// fresh or the server reports it has not been modified. If the server
+// cannot be reached, stale cached metadata is returned.
+func (m metadataCache) get(ctx context.Context, cli *client, endpoint string) (fetchedMetadata, error) {
+	if m.dir == "" {
+		if m.offline {
+			return offlineMetadata(), nil
+		}
+		b, err := metadata(ctx, cli, endpoint)
+		if err != nil {
+			return fallbackMetadata(err)
+		}
+		return fetchedMetadata{data: b}, nil
+	}
+	cached, info, err := m.load(endpoint)
+	if err != nil {
+		slog.Warn("ignoring metadata cache", "error", err, "path", m.dir)
+	}
+	if m.offline {
+		if cached != nil {
+			slog.Info("using cached metadata", "path", m.dataPath(), "age", time.Since(info.Fetched))
+			return fetchedMetadata{data: cached}, nil
+		}
+		return offlineMetadata(), nil
+	}
+	if cached != nil && !m.refresh && time.Since(info.Fetched) < m.maxAge {
+		slog.Info("using cached metadata", "path", m.dataPath(), "age", time.Since(info.Fetched))
+		return fetchedMetadata{data: cached}, nil
+	}
+
+	header := make(http.Header)
+	if cached != nil && !m.refresh {
+		if info.ETag != "" {
+			header.Set("If-None-Match", info.ETag)
+		}
+		if info.LastModified != "" {
+			header.Set("If-Modified-Since", info.LastModified)
+		}
+	}
+	resp, err := cli.do(ctx, http.MethodGet, endpoint, header, nil)
+	if err != nil {
+		if cached == nil {
+			return fallbackMetadata(fmt.Errorf("failed to get metadata: %w", err))
+		}
+		slog.Warn("using stale cached metadata", "error", err, "fetched", info.Fetched)
+		return fetchedMetadata{data: cached}, nil
+	}
+	data, err := resp.data()
+	if err != nil {
+		return fetchedMetadata{}, fmt.Errorf("failed to get metadata: %w", err)
+	}
+	if resp.status == http.StatusNotModified {
+		if cached == nil {
+			return fetchedMetadata{}, errors.New("failed to get metadata: not modified response without cached data")
+		}
+		slog.Info("cached metadata not modified", "path", m.dataPath())
+		info.Fetched = time.Now()
+		err = m.store(nil, info)
+		if err != nil {
+			slog.Warn("failed to update metadata cache", "error", err)
+		}
+		return fetchedMetadata{data: cached}, nil
+	}
+	info = metadataCacheInfo{
+		Endpoint:     endpoint,
+		ETag:         resp.header.Get("ETag"),
+		LastModified: resp.header.Get("Last-Modified"),
+		Fetched:      time.Now(),
+	}
+	err = m.store(data, info)
+	if err != nil {
+		slog.Warn("failed to update metadata cache", "error", err)
+	}
+	return fetchedMetadata{data: data}, nil
+}
+
+// fallbackMetadata returns the embedded metadata snapshot after
+// failing to get metadata from the server with err.
+func fallbackMetadata(err error) (fetchedMetadata, error) {
+	meta, embErr := snapshotFallback()
+	if embErr != nil {
+		return fetchedMetadata{}, err
+	}
+	slog.Warn("using embedded metadata", "error", err)
+	return meta, nil
+}
+
+// snapshotFallback returns the embedded metadata snapshot, marked as
+// embedded.
+func snapshotFallback() (fetchedMetadata, error) {
+	b, err := embeddedMetadata()
+	if err != nil {
+		return fetchedMetadata{}, err
+	}
+	return fetchedMetadata{data: b, embedded: true}, nil
+}
+
+// offlineMetadata returns the embedded metadata snapshot for use
+// without a cache or server. If the binary has no snapshot, the
+// returned metadata is marked as embedded but has no data, so that
+// databases that already hold metadata can still be updated.
+func offlineMetadata() fetchedMetadata {
+	meta, err := snapshotFallback()
+	if err != nil {
+		slog.Warn("no metadata available", "error", err)
+		return fetchedMetadata{embedded: true}
+	}
+	slog.Info("using embedded metadata")
+	return meta
+}
+
+// load returns the cached metadata for endpoint and its validators.
+// If there is no cached data for endpoint, nil data is returned.
+func (m metadataCache) load(endpoint string) ([]byte, metadataCacheInfo, error) {
+	var info metadataCacheInfo
+	b, err := os.ReadFile(m.infoPath())
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, info, nil
+	}
+	if err != nil {
+		return nil, info, err
+	}
+	err = json.Unmarshal(b, &info)
+	if err != nil {
+		return nil, info, err
+	}
+	if info.Endpoint != endpoint {
+		return nil, metadataCacheInfo{}, nil
+	}
+	data, err := os.ReadFile(m.dataPath())
+	if err != nil {
+		return nil, metadataCacheInfo{}, err
+	}
+	return data, info, nil
+}
+
+// store writes data and info to the cache. If data is nil, only info
+// is written.
+func (m metadataCache) store(data []byte, info metadataCacheInfo) error {
+	err := os.MkdirAll(m.dir, 0o750)
+	if err != nil {
+		return err
+	}
+	if data != nil {
+		err = writeFileAtomic(m.dataPath(), data, 0o640)
+		if err != nil {
+			return err
+		}
+	}
+	b, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(m.infoPath(), b, 0o640)
+}
+
+// writeFileAtomic writes data to a temporary file in the same
+// directory as path and renames it to path.
+func writeFileAtomic(path string, data []byte, perm fs.FileMode) error {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(data)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	err = f.Chmod(perm)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
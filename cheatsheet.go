@@ -0,0 +1,167 @@
+// Copyright ©2025 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"html/template"
+	"os"
+	"strings"
+)
+
+// cheatsheet writes a printable HTML page describing a stored revision.
+func cheatsheet(args []string) {
+	fs := flag.NewFlagSet("fkm cheatsheet", flag.ExitOnError)
+	var (
+		common commonFlags
+		legend legendFlags
+	)
+	common.register(fs)
+	legend.register(fs)
+	revID := fs.String("revision", "", "ID of the stored revision to describe (required)")
+	out := fs.String("out", "", "path of the HTML file to write (required)")
+	overlay := fs.Bool("heatmap", false, "shade keys by their press counts in the revision's stored heatmap data")
+	fs.Parse(args)
+	common.setup(fs)
+	if *revID == "" || *out == "" || fs.NArg() != 0 {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+	legends := legend.legends()
+
+	db := openExisting(common.dbPath, true, common.db)
+	defer db.Close()
+	l, err := loadLayout(db, *revID)
+	if err != nil {
+		fatal("failed to read revision", err, "revision", *revID)
+	}
+	meta, err := loadMetadata(db)
+	if err != nil {
+		fatal("failed to read metadata", err)
+	}
+	var h heatmap
+	if *overlay {
+		h, err = loadHeatmap(db, *revID)
+		if err != nil {
+			fatal("failed to read heatmap", err, "revision", *revID)
+		}
+		if h == nil {
+			fatal("failed to read heatmap", errors.New("no heatmap data stored"), "revision", *revID)
+		}
+	}
+
+	var buf bytes.Buffer
+	err = writeCheatsheet(&buf, l, meta, h, legends)
+	if err != nil {
+		fatal("failed to generate cheatsheet", err)
+	}
+	err = os.WriteFile(*out, buf.Bytes(), 0o644)
+	if err != nil {
+		fatal("failed to write cheatsheet", err, "path", *out)
+	}
+}
+
+// cheatsheetLayer is a layer in the cheatsheet template.
+type cheatsheetLayer struct {
+	Position int
+	Name     string
+	Image    template.HTML
+}
+
+// cheatsheetCombo is a combo in the cheatsheet template.
+type cheatsheetCombo struct {
+	Name    string
+	Layer   int
+	Keys    string
+	Trigger string
+}
+
+// cheatsheetPage is the data of the cheatsheet template.
+type cheatsheetPage struct {
+	Layout *layout
+	Layers []cheatsheetLayer
+	Combos []cheatsheetCombo
+	Tour   *tour
+}
+
+// newCheatsheetPage returns the template data describing the layout,
+// with images of each layer drawn using the geometry in meta and shaded
+// by the press counts in h if it is not nil. Key actions are shown with
+// the given legends.
+func newCheatsheetPage(l *layout, meta []byte, h heatmap, legends *keyLegends) cheatsheetPage {
+	data := cheatsheetPage{
+		Layout: l,
+		Tour:   l.Revision.Tour,
+	}
+	for i, ly := range l.Revision.Layers {
+		var img strings.Builder
+		renderSVG(&img, l, i, keyPlacements(meta, l.Geometry, len(ly.Keys)), svgOptions{heat: h.layer(i), legends: legends})
+		data.Layers = append(data.Layers, cheatsheetLayer{
+			Position: ly.Position,
+			Name:     ly.Name(),
+			// The SVG is generated with all text escaped.
+			Image: template.HTML(img.String()),
+		})
+	}
+	for _, c := range l.Revision.Combos {
+		data.Combos = append(data.Combos, cheatsheetCombo{
+			Name:    c.Name,
+			Layer:   c.LayerIdx,
+			Keys:    comboKeys(l, c, legends),
+			Trigger: legends.trigger(c.Trigger),
+		})
+	}
+	return data
+}
+
+// writeCheatsheet writes a self-contained HTML page with images of each
+// layer of the layout, its combos and its tour. If h is not nil, the
+// images are overlaid with its press counts.
+func writeCheatsheet(buf *bytes.Buffer, l *layout, meta []byte, h heatmap, legends *keyLegends) error {
+	return cheatsheetTemplate.Execute(buf, newCheatsheetPage(l, meta, h, legends))
+}
+
+var cheatsheetTemplate = template.Must(template.New("cheatsheet").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{.Layout.Title}} — {{.Layout.Revision.Title}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+section.layer { page-break-inside: avoid; margin-bottom: 2em; }
+svg { max-width: 100%; height: auto; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #999; padding: 0.25em 0.75em; text-align: left; }
+@media print {
+	body { margin: 0; }
+	section.layer { page-break-after: always; }
+}
+</style>
+</head>
+<body>
+<h1>{{.Layout.Title}}</h1>
+<p>{{.Layout.Geometry}} revision {{.Layout.Revision.HashID}}{{with .Layout.Revision.Title}} “{{.}}”{{end}}{{with .Layout.Revision.CreatedAt}}, created {{.}}{{end}}</p>
+{{range .Layers}}<section class="layer">
+<h2>{{.Position}}: {{.Name}}</h2>
+{{.Image}}</section>
+{{end}}{{with .Combos}}<section>
+<h2>Combos</h2>
+<table>
+<tr><th>Name</th><th>Layer</th><th>Keys</th><th>Trigger</th></tr>
+{{range .}}<tr><td>{{.Name}}</td><td>{{.Layer}}</td><td>{{.Keys}}</td><td>{{.Trigger}}</td></tr>
+{{end}}</table>
+</section>
+{{end}}{{with .Tour}}{{if .Steps}}<section>
+<h2>Tour</h2>
+<ol>
+{{range .Steps}}<li>{{with .Layer}}<em>Layer {{.Position}}</em>{{end}}{{with .KeyIndex}} <em>key {{.}}</em>{{end}}
+{{with .Intro}}<p>{{.}}</p>{{end}}{{with .Content}}<p>{{.}}</p>{{end}}{{with .Outro}}<p>{{.}}</p>{{end}}</li>
+{{end}}</ol>
+</section>
+{{end}}{{end}}</body>
+</html>
+`))
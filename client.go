@@ -0,0 +1,491 @@
+// Copyright ©2025 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+)
+
+// netFlags are the flags shared by commands that make network requests.
+type netFlags struct {
+	graphqlURL  string
+	metadataURL string
+	timeout     time.Duration
+	retries     int
+	rate        float64
+	maxResponse int64
+	proxy       string
+	pinFile     string
+	record      string
+	replay      string
+	signKey     string
+	verifyKey   string
+	auditLog    string
+	queryFile   string
+	offline     bool
+	keyringAuth bool
+}
+
+// register adds the network flags to fs.
+func (n *netFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&n.graphqlURL, "graphql-url", defaultGraphqlURL, "Oryx GraphQL endpoint")
+	fs.StringVar(&n.metadataURL, "metadata-url", defaultMetadataURL, "keyboard metadata endpoint")
+	fs.DurationVar(&n.timeout, "timeout", 30*time.Second, "timeout for each HTTP request")
+	fs.IntVar(&n.retries, "retries", 3, "maximum number of retries for transient HTTP failures")
+	fs.Float64Var(&n.rate, "rate", 2, "maximum number of HTTP requests per second to make to the network (0 for no limit)")
+	fs.Int64Var(&n.maxResponse, "max-response-size", defaultMaxResponse, "maximum size in bytes of a response body (0 for no limit)")
+	fs.StringVar(&n.proxy, "proxy", "", "HTTP proxy URL (default from HTTPS_PROXY and HTTP_PROXY)")
+	fs.StringVar(&n.pinFile, "pin-certs", "", "file of host certificate SHA-256 fingerprints to pin TLS connections to")
+	fs.StringVar(&n.record, "record", "", "directory to record each HTTP exchange to")
+	fs.StringVar(&n.replay, "replay", "", "directory of recorded HTTP exchanges to respond to requests with instead of using the network")
+	fs.StringVar(&n.signKey, "record-sign-key", "", "minisign secret key file to sign recorded exchanges with")
+	fs.StringVar(&n.verifyKey, "replay-verify-key", "", "minisign public key file that replayed exchanges must be signed by")
+	fs.StringVar(&n.auditLog, "audit-log", "", "JSON lines file to append a record of each outbound HTTP request to")
+	fs.StringVar(&n.queryFile, "query-file", "", "GraphQL file of fragments replacing or adding to those of the layout query")
+	fs.BoolVar(&n.keyringAuth, "keyring-auth", false, "send the Oryx token held in the OS keyring with GraphQL requests")
+	fs.BoolVar(&n.offline, "offline", false, "make no network requests; any attempt to use the network fails")
+}
+
+// cannotFetch returns whether the flags prevent responses from being
+// obtained, either from the network or from a replayed recording.
+func (n *netFlags) cannotFetch() bool {
+	return n.offline && n.replay == ""
+}
+
+// client returns a client configured by the flags, exiting if the
+// flags are invalid.
+func (n *netFlags) client() *client {
+	c := newClient(n.timeout, n.retries)
+	switch {
+	case n.rate < 0:
+		fatal("invalid network flags", usageError("-rate must not be negative"))
+	case n.rate > 0 && !n.offline && n.replay == "":
+		c.limit = newRateLimiter(n.rate)
+	}
+	if n.maxResponse < 0 {
+		fatal("invalid network flags", usageError("-max-response-size must not be negative"))
+	}
+	c.maxResponse = n.maxResponse
+	if n.queryFile != "" {
+		var err error
+		c.layoutQuery, err = loadLayoutQuery(n.queryFile)
+		if err != nil {
+			fatal("failed to load query file", err, "path", n.queryFile)
+		}
+	}
+	if n.keyringAuth {
+		l, err := keyringLogin()
+		if err != nil {
+			fatal("failed to read Oryx token", err)
+		}
+		c.token = l.token
+	}
+	if n.proxy != "" {
+		u, err := url.Parse(n.proxy)
+		if err != nil {
+			fatal("invalid proxy", exitError{status: exitUsage, err: err}, "proxy", n.proxy)
+		}
+		c.proxy(u)
+	}
+	if n.pinFile != "" {
+		p, err := loadPins(n.pinFile)
+		if err != nil {
+			fatal("failed to load certificate pins", err, "path", n.pinFile)
+		}
+		c.pin(p)
+	}
+	var rt http.RoundTripper = c.transport
+	if n.offline {
+		if n.record != "" {
+			fatal("invalid network flags", usageError("-record cannot be used with -offline"))
+		}
+		rt = offlineTransport{}
+		c.http.Transport = rt
+	}
+	if n.auditLog != "" {
+		a, err := newAuditor(rt, n.auditLog)
+		if err != nil {
+			fatal("failed to open audit log", err, "path", n.auditLog)
+		}
+		rt = a
+		c.http.Transport = rt
+	}
+	switch {
+	case n.record != "" && n.replay != "":
+		fatal("invalid network flags", usageError("-record and -replay are mutually exclusive"))
+	case n.signKey != "" && n.record == "":
+		fatal("invalid network flags", usageError("-record-sign-key requires -record"))
+	case n.verifyKey != "" && n.replay == "":
+		fatal("invalid network flags", usageError("-replay-verify-key requires -replay"))
+	case n.record != "":
+		err := os.MkdirAll(n.record, 0o750)
+		if err != nil {
+			fatal("unable to create record directory", err, "path", n.record)
+		}
+		r := recorder{next: rt, dir: n.record, max: n.maxResponse}
+		if n.signKey != "" {
+			key, err := loadSigningKey(n.signKey)
+			if err != nil {
+				fatal("failed to load signing key", err, "path", n.signKey)
+			}
+			r.key = &key
+		}
+		c.http.Transport = r
+	case n.replay != "":
+		r := replayer{dir: n.replay}
+		if n.verifyKey != "" {
+			key, err := loadVerifyKey(n.verifyKey)
+			if err != nil {
+				fatal("failed to load verification key", err, "path", n.verifyKey)
+			}
+			r.key = &key
+		}
+		c.http.Transport = r
+	}
+	return c
+}
+
+// client is an HTTP client that retries transient failures with
+// exponential backoff and jitter.
+type client struct {
+	http      *http.Client
+	transport *http.Transport
+
+	// retries is the maximum number of retries after the
+	// initial attempt.
+	retries int
+	// backoff is the initial delay between attempts. The delay
+	// is doubled after each failed attempt up to maxBackoff.
+	backoff    time.Duration
+	maxBackoff time.Duration
+
+	// received, if not nil, is called with the size of
+	// each response body received.
+	received func(n int)
+
+	// maxResponse, if positive, is the maximum size of a
+	// response body. Larger responses fail without being
+	// retried.
+	maxResponse int64
+
+	// limit, if not nil, limits the rate of requests made,
+	// including retries, across all users of the client.
+	limit *rateLimiter
+
+	// token, if not empty, is the Oryx token sent with GraphQL
+	// requests. It is only held in memory.
+	token string
+
+	// layoutQuery, if not empty, is used in place of the
+	// built-in getLayout query.
+	layoutQuery string
+}
+
+// newClient returns a client with the given per-request timeout and
+// retry limit.
+func newClient(timeout time.Duration, retries int) *client {
+	transport := &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		TLSHandshakeTimeout:   timeout,
+		ResponseHeaderTimeout: timeout,
+	}
+	return &client{
+		http: &http.Client{
+			Timeout:   timeout,
+			Transport: transport,
+		},
+		transport:  transport,
+		retries:    retries,
+		backoff:    500 * time.Millisecond,
+		maxBackoff: 30 * time.Second,
+	}
+}
+
+// pin restricts TLS connections made by the client to servers
+// presenting a certificate chain that matches p.
+func (c *client) pin(p pins) {
+	c.transport.TLSClientConfig = &tls.Config{
+		VerifyConnection: p.verify,
+	}
+}
+
+// proxy directs requests made by the client through the proxy at u.
+func (c *client) proxy(u *url.URL) {
+	c.transport.Proxy = http.ProxyURL(u)
+}
+
+// errOffline is returned for all requests made in offline mode.
+var errOffline = errors.New("network access is disabled by -offline")
+
+// offlineTransport is an http.RoundTripper that fails all requests.
+type offlineTransport struct{}
+
+func (offlineTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+	return nil, permanentError{fmt.Errorf("%w: %s %s", errOffline, req.Method, req.URL)}
+}
+
+// permanentError is an error that will not be resolved by retrying
+// the request.
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+// statusError is returned when a server responds with a non-2xx status.
+type statusError struct {
+	code int
+	body string
+}
+
+func (e statusError) Error() string {
+	if e.body == "" {
+		return fmt.Sprintf("unexpected status: %s", http.StatusText(e.code))
+	}
+	return fmt.Sprintf("unexpected status: %s: %s", http.StatusText(e.code), e.body)
+}
+
+// response is a completed HTTP response.
+type response struct {
+	url    string // URL of the final request after redirects
+	status int
+	header http.Header
+	body   *spool
+}
+
+// data returns the body of the response, releasing its spool.
+func (r *response) data() ([]byte, error) {
+	defer r.body.close()
+	return r.body.bytes()
+}
+
+// get performs a GET request to addr.
+func (c *client) get(ctx context.Context, addr string) ([]byte, error) {
+	resp, err := c.do(ctx, http.MethodGet, addr, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.data()
+}
+
+// post performs a POST request to addr with the given body.
+func (c *client) post(ctx context.Context, addr, contentType string, body []byte) ([]byte, error) {
+	resp, err := c.do(ctx, http.MethodPost, addr, http.Header{"Content-Type": {contentType}}, body)
+	if err != nil {
+		return nil, err
+	}
+	return resp.data()
+}
+
+// do performs the request with the provided headers, retrying on
+// transient failures. A response is returned for 2xx and 304 statuses.
+// Waiting between attempts, and the attempts themselves, stop when ctx
+// is done.
+func (c *client) do(ctx context.Context, method, addr string, header http.Header, body []byte) (*response, error) {
+	delay := c.backoff
+	for attempt := 0; ; attempt++ {
+		err := c.limit.wait(ctx)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := c.once(ctx, method, addr, header, body)
+		if err == nil {
+			return resp, nil
+		}
+		var perm permanentError
+		if errors.As(err, &perm) || ctx.Err() != nil {
+			return nil, err
+		}
+		slog.Debug("request failed", "method", method, "url", addr, "attempt", attempt+1, "error", err)
+		if attempt >= c.retries {
+			if c.retries != 0 {
+				err = fmt.Errorf("giving up after %d attempts: %w", attempt+1, err)
+			}
+			return nil, err
+		}
+		wait := delay/2 + rand.N(delay/2+1)
+		slog.Info("retrying request", "method", method, "url", addr, "after", wait)
+		err = sleep(ctx, wait)
+		if err != nil {
+			return nil, err
+		}
+		delay = min(2*delay, c.maxBackoff)
+	}
+}
+
+// rateLimiter spaces calls to wait so that they proceed at no more
+// than a fixed rate.
+type rateLimiter struct {
+	interval time.Duration
+
+	mu   sync.Mutex
+	next time.Time // earliest time the next call may proceed
+}
+
+// newRateLimiter returns a rateLimiter allowing perSecond calls each
+// second.
+func newRateLimiter(perSecond float64) *rateLimiter {
+	return &rateLimiter{interval: time.Duration(float64(time.Second) / perSecond)}
+}
+
+// wait blocks until the next call is permitted or ctx is done.
+// Waiting on a nil rateLimiter does not block.
+func (l *rateLimiter) wait(ctx context.Context) error {
+	if l == nil {
+		return ctx.Err()
+	}
+	l.mu.Lock()
+	now := time.Now()
+	at := l.next
+	if at.Before(now) {
+		at = now
+	}
+	l.next = at.Add(l.interval)
+	l.mu.Unlock()
+	if d := at.Sub(now); d > 0 {
+		slog.Debug("rate limiting request", "delay", d)
+		return sleep(ctx, d)
+	}
+	return ctx.Err()
+}
+
+// sleep pauses for d, returning early with the context's error if ctx
+// is done first.
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// once performs a single attempt at the request. Errors that should
+// not be retried are returned as permanentError.
+func (c *client) once(ctx context.Context, method, addr string, header http.Header, body []byte) (*response, error) {
+	var r io.Reader
+	if body != nil {
+		r = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, addr, r)
+	if err != nil {
+		return nil, permanentError{err}
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	slog.Debug("sending request", "method", method, "url", addr, "request_bytes", len(body))
+	start := time.Now()
+	resp, err := c.http.Do(req)
+	if err != nil {
+		if isPermanent(err) {
+			return nil, permanentError{err}
+		}
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 && resp.StatusCode != http.StatusNotModified {
+		// Only the start of an error body is kept for the message.
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+		slog.Info("received response", "method", method, "url", addr, "status", resp.StatusCode, "duration", time.Since(start))
+		err = statusError{code: resp.StatusCode, body: string(bytes.TrimSpace(msg))}
+		if !retryableStatus(resp.StatusCode) {
+			return nil, permanentError{err}
+		}
+		return nil, err
+	}
+	b, err := spoolBody(resp, c.maxResponse)
+	if err != nil {
+		return nil, err
+	}
+	slog.Info("received response", "method", method, "url", addr, "status", resp.StatusCode, "response_bytes", b.Len(), "duration", time.Since(start))
+	if c.received != nil {
+		c.received(b.Len())
+	}
+	return &response{url: resp.Request.URL.String(), status: resp.StatusCode, header: resp.Header, body: b}, nil
+}
+
+const (
+	// defaultMaxResponse is the default limit on the size of
+	// response bodies. Oryx layouts and metadata are a few
+	// megabytes at most.
+	defaultMaxResponse = 64 << 20
+
+	// maxErrorBody is the number of bytes of an error response
+	// body included in a statusError.
+	maxErrorBody = 1 << 10
+)
+
+// errResponseTooLarge is returned when a response body is larger than
+// the client's limit.
+var errResponseTooLarge = errors.New("response too large")
+
+// spoolBody copies the body of resp to a spool, failing without
+// reading the rest of the body if it is larger than max bytes and max
+// is positive.
+func spoolBody(resp *http.Response, max int64) (*spool, error) {
+	if max > 0 && resp.ContentLength > max {
+		return nil, permanentError{fmt.Errorf("%w: %d bytes is more than the limit of %d", errResponseTooLarge, resp.ContentLength, max)}
+	}
+	return newSpool(resp.Body, max)
+}
+
+// retryableStatus returns whether a request that resulted in the given
+// HTTP status code may succeed if retried.
+func retryableStatus(code int) bool {
+	switch code {
+	case http.StatusRequestTimeout, http.StatusTooManyRequests:
+		return true
+	}
+	return code/100 == 5
+}
+
+// isPermanent returns whether err from an http.Client is known to not
+// be resolved by retrying.
+func isPermanent(err error) bool {
+	var (
+		certErr  *tls.CertificateVerificationError
+		unknown  x509.UnknownAuthorityError
+		hostErr  x509.HostnameError
+		invalid  x509.CertificateInvalidError
+		dnsErr   *net.DNSError
+		urlError *url.Error
+		pinErr   pinError
+	)
+	switch {
+	case errors.As(err, &pinErr),
+		errors.As(err, &certErr),
+		errors.As(err, &unknown),
+		errors.As(err, &hostErr),
+		errors.As(err, &invalid):
+		return true
+	case errors.As(err, &dnsErr):
+		return dnsErr.IsNotFound
+	case errors.As(err, &urlError):
+		return urlError.Op == "parse"
+	}
+	return false
+}
@@ -0,0 +1,75 @@
+// Copyright ©2025 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestClientCancel(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		limit   *rateLimiter
+		backoff time.Duration
+	}{
+		{
+			name: "retry",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			},
+			backoff: time.Hour,
+		},
+		{
+			name: "rate limit",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			},
+			limit:   &rateLimiter{interval: time.Hour},
+			backoff: time.Millisecond,
+		},
+		{
+			name: "request",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				<-r.Context().Done()
+			},
+			backoff: time.Millisecond,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var requests atomic.Int64
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests.Add(1)
+				test.handler(w, r)
+			}))
+			defer srv.Close()
+
+			c := newClient(time.Hour, 10)
+			c.backoff, c.maxBackoff = test.backoff, test.backoff
+			c.limit = test.limit
+
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+			start := time.Now()
+			_, err := c.get(ctx, srv.URL)
+			if !errors.Is(err, context.DeadlineExceeded) {
+				t.Errorf("unexpected error: got:%v want:%v", err, context.DeadlineExceeded)
+			}
+			if d := time.Since(start); d > 10*time.Second {
+				t.Errorf("request did not stop promptly: took %v", d)
+			}
+			if n := requests.Load(); n > 2 {
+				t.Errorf("unexpected number of requests after cancellation: %d", n)
+			}
+		})
+	}
+}
@@ -0,0 +1,120 @@
+// Copyright ©2025 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// combos lists the combos of a stored revision.
+func combos(args []string) {
+	fs := flag.NewFlagSet("fkm combos", flag.ExitOnError)
+	var common commonFlags
+	common.register(fs)
+	revID := fs.String("revision", "", "ID of the stored revision to list combos from (required)")
+	format := fs.String("format", "text", "output format (text, json or csv)")
+	fs.Parse(args)
+	common.setup(fs)
+	if common.json {
+		*format = "json"
+	}
+	if *revID == "" || fs.NArg() != 0 {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+	switch *format {
+	case "text", "json", "csv":
+	default:
+		fmt.Fprintf(fs.Output(), "unknown format %q\n", *format)
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+
+	db := openExisting(common.dbPath, true, common.db)
+	defer db.Close()
+	l, err := loadLayout(db, *revID)
+	if err != nil {
+		fatal("failed to read revision", err, "revision", *revID)
+	}
+
+	list := comboList(l)
+	switch *format {
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "\t")
+		err = enc.Encode(list)
+	case "csv":
+		w := csv.NewWriter(os.Stdout)
+		w.Write([]string{"name", "layer", "keys", "legends", "trigger"})
+		for _, c := range list {
+			w.Write([]string{c.Name, strconv.Itoa(c.Layer), joinInts(c.Keys, " "), strings.Join(c.Legends, " "), c.Trigger})
+		}
+		w.Flush()
+		err = w.Error()
+	default:
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "NAME\tLAYER\tKEYS\tTRIGGER")
+		for _, c := range l.Revision.Combos {
+			fmt.Fprintf(w, "%s\t%d\t%s\t%s\n", c.Name, c.LayerIdx, comboKeys(l, c, nil), strings.TrimPrefix(c.Trigger, "KC_"))
+		}
+		err = w.Flush()
+	}
+	if err != nil {
+		fatal("failed to write combos", err)
+	}
+}
+
+// comboEntry is a combo in exported form.
+type comboEntry struct {
+	Name    string   `json:"name"`
+	Layer   int      `json:"layer"`
+	Keys    []int    `json:"keys"`
+	Legends []string `json:"legends"`
+	Trigger string   `json:"trigger"`
+}
+
+// comboList returns the layout's combos with the legends of their keys.
+func comboList(l *layout) []comboEntry {
+	list := make([]comboEntry, 0, len(l.Revision.Combos))
+	for _, c := range l.Revision.Combos {
+		e := comboEntry{
+			Name:    c.Name,
+			Layer:   c.LayerIdx,
+			Keys:    c.KeyIndices,
+			Legends: make([]string, 0, len(c.KeyIndices)),
+			Trigger: c.Trigger,
+		}
+		if e.Keys == nil {
+			e.Keys = []int{}
+		}
+		for _, k := range c.KeyIndices {
+			var legend string
+			if c.LayerIdx >= 0 && c.LayerIdx < len(l.Revision.Layers) {
+				if ks := l.Revision.Layers[c.LayerIdx].Keys; k >= 0 && k < len(ks) {
+					legend = ks[k].Label()
+				}
+			}
+			e.Legends = append(e.Legends, legend)
+		}
+		list = append(list, e)
+	}
+	return list
+}
+
+// joinInts returns the decimal forms of v joined by sep.
+func joinInts(v []int, sep string) string {
+	s := make([]string, len(v))
+	for i, n := range v {
+		s[i] = strconv.Itoa(n)
+	}
+	return strings.Join(s, sep)
+}
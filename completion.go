@@ -0,0 +1,324 @@
+// Copyright ©2025 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// completion writes shell completion scripts, and answers the
+// completion requests the scripts make.
+func completion(args []string) {
+	usage := func() {
+		fmt.Fprintln(os.Stderr, `usage: fkm completion bash|zsh|fish
+
+Write a completion script for the shell to standard output. For example
+	source <(fkm completion bash)
+	fkm completion zsh > "${fpath[1]}/_fkm"
+	fkm completion fish > ~/.config/fish/completions/fkm.fish`)
+		os.Exit(exitUsage)
+	}
+	if len(args) == 0 {
+		usage()
+	}
+	switch args[0] {
+	case "bash", "zsh", "fish":
+		if len(args) != 1 {
+			usage()
+		}
+		fmt.Print(completionScripts[args[0]])
+	case "complete":
+		// Called by the scripts with the words of the command
+		// line up to the cursor.
+		words := args[1:]
+		if len(words) != 0 && words[0] == "--" {
+			words = words[1:]
+		}
+		for _, c := range complete(words) {
+			fmt.Println(c)
+		}
+	default:
+		usage()
+	}
+}
+
+// complete returns the completions of the last of words, the command
+// line arguments up to the cursor. Subcommands and flags are completed
+// from fkm's own usage messages, and flag values from the flag's usage
+// text, the cached metadata and the database. When no completions are
+// returned, the shell completes file names.
+func complete(words []string) []string {
+	if len(words) == 0 {
+		words = []string{""}
+	}
+	cur, words := words[len(words)-1], words[:len(words)-1]
+
+	// Descend through the commands and subcommands in words.
+	var path []string
+	flags, subs := commandHelp(path)
+	for len(path) < 2 && len(path) < len(words) && slices.Contains(subs, words[len(path)]) {
+		path = words[:len(path)+1]
+		flags, subs = commandHelp(path)
+	}
+
+	if n := len(words); n != 0 {
+		_, name, ok := cutDashes(words[n-1])
+		if f := flags[name]; ok && f.value && !strings.Contains(name, "=") {
+			return withPrefix(f.values(words), cur, "")
+		}
+	}
+	if dashes, name, ok := cutDashes(cur); ok {
+		if name, val, ok := strings.Cut(name, "="); ok {
+			f, ok := flags[name]
+			if !ok || !f.value {
+				return nil
+			}
+			return withPrefix(f.values(words), val, dashes+name+"=")
+		}
+		var names []string
+		for n, f := range flags {
+			if f.value {
+				names = append(names, dashes+n+"=")
+			}
+			names = append(names, dashes+n)
+		}
+		slices.Sort(names)
+		return withPrefix(names, cur, "")
+	}
+
+	switch {
+	case len(words) == len(path) && len(subs) != 0:
+		return withPrefix(subs, cur, "")
+	case len(path) == 2 && path[0] == "profile" && path[1] != "list" && len(words) == 2:
+		names, _ := profileNames()
+		return withPrefix(names, cur, "")
+	case len(path) == 1 && path[0] == "diff":
+		return withPrefix(storedNames(words).revisions, cur, "")
+	}
+	return nil
+}
+
+// cutDashes returns arg without its leading one or two dashes, and
+// whether arg is a flag.
+func cutDashes(arg string) (dashes, name string, ok bool) {
+	for _, d := range []string{"--", "-"} {
+		if name, ok := strings.CutPrefix(arg, d); ok {
+			return d, name, true
+		}
+	}
+	return "", arg, false
+}
+
+// withPrefix returns the candidates starting with partial, each
+// preceded by prefix.
+func withPrefix(candidates []string, partial, prefix string) []string {
+	var matches []string
+	for _, c := range candidates {
+		if strings.HasPrefix(c, partial) {
+			matches = append(matches, prefix+c)
+		}
+	}
+	return matches
+}
+
+// completionFlag is a flag described in a usage message.
+type completionFlag struct {
+	name  string
+	value bool // the flag takes a value
+	usage string
+}
+
+// choices matches a list of permitted values in flag usage text, as in
+// "(text or json)" or "(text, json or csv)".
+var choices = regexp.MustCompile(`\(([\w-]+(?:, [\w-]+)* or [\w-]+)\)`)
+
+// values returns the candidate values of the flag.
+func (f completionFlag) values(words []string) []string {
+	switch {
+	case f.name == "geometry", f.name == "keyboard":
+		geoms := storedNames(words).geometries
+		meta, err := metadataCache{dir: defaultCacheDir(), offline: true}.get(context.Background(), nil, defaultMetadataURL)
+		if err == nil {
+			known, _ := geometries(meta.data)
+			geoms = append(geoms, known...)
+		}
+		slices.Sort(geoms)
+		return slices.Compact(geoms)
+	case f.name == "profile":
+		names, _ := profileNames()
+		return names
+	case strings.Contains(f.usage, "stored revision"):
+		return storedNames(words).revisions
+	case strings.Contains(f.usage, "hash ID"):
+		return storedNames(words).layouts
+	}
+	m := choices.FindStringSubmatch(f.usage)
+	if m == nil {
+		return nil
+	}
+	return strings.FieldsFunc(strings.ReplaceAll(m[1], " or ", ", "), func(r rune) bool {
+		return r == ',' || r == ' '
+	})
+}
+
+// commandHelp returns the flags and subcommands of the fkm command
+// path, parsed from the command's usage message. The subcommands of
+// the empty path are the fkm commands.
+func commandHelp(path []string) (map[string]completionFlag, []string) {
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, nil
+	}
+	out, _ := exec.Command(exe, append(slices.Clone(path), "-h")...).CombinedOutput()
+
+	flags := make(map[string]completionFlag)
+	lines := strings.Split(string(out), "\n")
+	for i := 0; i < len(lines); i++ {
+		def, ok := strings.CutPrefix(lines[i], "  -")
+		if !ok {
+			continue
+		}
+		head, usage, _ := strings.Cut(def, "\t")
+		for i+1 < len(lines) && strings.HasPrefix(lines[i+1], "    \t") {
+			i++
+			usage += " " + strings.TrimSpace(lines[i])
+		}
+		f := strings.Fields(head)
+		if len(f) == 0 {
+			continue
+		}
+		flags[f[0]] = completionFlag{name: f[0], value: len(f) > 1, usage: usage}
+	}
+
+	var subs []string
+	switch len(path) {
+	case 0:
+		for i, line := range lines[:max(len(lines)-1, 0)] {
+			name, ok := strings.CutPrefix(line, "  ")
+			if ok && isCommandName(name) && strings.HasPrefix(lines[i+1], "    \t") {
+				subs = append(subs, name)
+			}
+		}
+	case 1:
+		re := regexp.MustCompile(`(?m)^\s*(?:usage: )?fkm ` + regexp.QuoteMeta(path[0]) + ` ([a-z][a-z-]*)`)
+		for _, m := range re.FindAllStringSubmatch(string(out), -1) {
+			if !slices.Contains(subs, m[1]) {
+				subs = append(subs, m[1])
+			}
+		}
+	}
+	return flags, subs
+}
+
+// isCommandName returns whether s is a valid command name.
+func isCommandName(s string) bool {
+	return s != "" && s[0] != '-' && strings.Trim(s, "abcdefghijklmnopqrstuvwxyz-") == ""
+}
+
+// storedNames returns the names of the revisions, layouts and
+// geometries in the database selected by the -path flag in words, or
+// the default database.
+func storedNames(words []string) (names struct{ revisions, layouts, geometries []string }) {
+	path := defaultDBPath()
+	if p, ok := os.LookupEnv("FKM_DB_PATH"); ok {
+		path = p
+	}
+	for i, w := range words {
+		_, name, ok := cutDashes(w)
+		if !ok {
+			continue
+		}
+		if p, ok := strings.CutPrefix(name, "path="); ok {
+			path = p
+		} else if name == "path" && i+1 < len(words) {
+			path = words[i+1]
+		}
+	}
+	if rest, ok := strings.CutPrefix(path, "~/"); ok {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return names
+		}
+		path = filepath.Join(home, rest)
+	}
+	db, err := openDBReadOnly(path, dbOptions{})
+	if err != nil || db == nil {
+		return names
+	}
+	defer db.Close()
+	revs, err := storedRevisions(db)
+	if err != nil {
+		return names
+	}
+	for _, r := range revs {
+		names.revisions = append(names.revisions, r.id)
+		if l := r.layout; l != nil {
+			if !slices.Contains(names.layouts, l.HashID) {
+				names.layouts = append(names.layouts, l.HashID)
+			}
+			if l.Geometry != "" && !slices.Contains(names.geometries, l.Geometry) {
+				names.geometries = append(names.geometries, l.Geometry)
+			}
+		}
+	}
+	return names
+}
+
+// completionScripts are the shell completion scripts. Each passes the
+// command line up to the cursor to fkm completion complete and falls
+// back to file name completion when there are no candidates.
+var completionScripts = map[string]string{
+	"bash": `# bash completion for fkm
+
+_fkm() {
+	local line="${COMP_LINE:0:COMP_POINT}"
+	local -a words
+	read -r -a words <<<"$line"
+	[[ $line == *[[:space:]] ]] && words+=("")
+	local cur="${words[${#words[@]}-1]}"
+	local IFS=$'\n'
+	COMPREPLY=($(fkm completion complete -- "${words[@]:1}" 2>/dev/null))
+	# Bash completes the text after '=' in flag=value.
+	if [[ $cur == -*=* ]]; then
+		COMPREPLY=("${COMPREPLY[@]#*=}")
+	fi
+}
+complete -o default -F _fkm fkm
+`,
+	"zsh": `#compdef fkm
+
+_fkm() {
+	local -a candidates
+	candidates=("${(@f)$(fkm completion complete -- "${(@)words[2,CURRENT]}" 2>/dev/null)}")
+	if [[ -n ${candidates[1]} ]]; then
+		compadd -Q -- "${candidates[@]}"
+	else
+		_files
+	fi
+}
+
+if [[ $zsh_eval_context[-1] == loadautofunc ]]; then
+	_fkm "$@"
+else
+	compdef _fkm fkm
+fi
+`,
+	"fish": `# fish completion for fkm
+
+function __fkm_complete
+	set -l words (commandline -opc) (commandline -ct)
+	fkm completion complete -- $words[2..-1] 2>/dev/null
+end
+
+complete -c fkm -a '(__fkm_complete)'
+`,
+}
@@ -0,0 +1,241 @@
+// Copyright ©2025 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// compose stores a new revision made by overlaying layers of one
+// stored revision onto another.
+func compose(args []string) {
+	fs := flag.NewFlagSet("fkm compose", flag.ExitOnError)
+	var (
+		common commonFlags
+		guard  writeGuard
+		prov   provenanceFlags
+	)
+	common.register(fs)
+	guard.register(fs)
+	prov.register(fs)
+	baseID := fs.String("base", "", "stored revision ID to start from (required)")
+	fromID := fs.String("from", "", "stored revision ID to take layers from (required)")
+	var specs stringList
+	fs.Var(&specs, "layer", "layer to take from the -from revision as SRC or SRC:DST, replacing the base layer at DST or appending it if DST is the number of base layers (may be repeated)")
+	title := fs.String("title", "", "title of the new revision (default describes the composition)")
+	newID := fs.String("revision-id", "", "ID of the new revision (default derived from the composition)")
+	dryRun := fs.Bool("dry-run", false, "print the changes that would be made to the database without making them")
+	backupDir := fs.String("backup-dir", "", "directory to back up the database to before changing it")
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), `usage: fkm compose -base <id> -from <id> -layer SRC[:DST]... [flags]
+
+Store a new revision of the base revision's layout with layers taken
+from another stored revision of the same geometry. The new revision is
+not tracked, so update and watch continue to follow the layout in Oryx.
+Layer keys in the taken layers refer to layers by position, so check the
+result with fkm lint.
+
+Flags:
+`)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	common.setup(fs)
+	if fs.NArg() != 0 || *baseID == "" || *fromID == "" || len(specs) == 0 {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+	moves := make([]layerMove, len(specs))
+	for i, s := range specs {
+		var err error
+		moves[i], err = parseLayerMove(s)
+		if err != nil {
+			fatal("invalid layer", exitError{status: exitUsage, err: err}, "layer", s)
+		}
+	}
+
+	db := openExisting(common.dbPath, *dryRun, common.db)
+	defer db.Close()
+	base, err := loadRevision(db, *baseID)
+	if err != nil {
+		fatal("failed to load revision", err, "revision", *baseID)
+	}
+	from, err := loadRevision(db, *fromID)
+	if err != nil {
+		fatal("failed to load revision", err, "revision", *fromID)
+	}
+
+	id := *newID
+	if id == "" {
+		h := sha256.Sum256([]byte(strings.Join(append([]string{*baseID, *fromID}, specs...), "\x00")))
+		id = fmt.Sprintf("fkm%x", h[:6])
+	}
+	name := *title
+	if name == "" {
+		name = fmt.Sprintf("%s with layers %s of %s", *baseID, strings.Join(specs, ","), *fromID)
+	}
+	data, err := composeLayers(base, from, moves, id, name, time.Now())
+	if err != nil {
+		fatal("failed to compose revision", err, "base", *baseID, "from", *fromID)
+	}
+	md5sum, err := checkMD5(data)
+	if err != nil {
+		fatal("failed to compose revision", err, "base", *baseID)
+	}
+
+	n, err := rowCount(db, "revision", "revisionId=?", id)
+	if err != nil {
+		fatal("failed to check revision", err, "revision", id)
+	}
+	if n != 0 {
+		fatal("failed to store revision", fmt.Errorf("revision %s is already stored", id), "hint", "use -revision-id to choose another ID")
+	}
+	changes := []change{
+		storeRevision(id, data, "insert"),
+		upsertChecksum(id, md5sum, data),
+		recordProvenance(id, prov.provenance(fmt.Sprintf("revision:%s revision:%s layers=%s", *baseID, *fromID, strings.Join(specs, ",")))),
+	}
+	if *dryRun {
+		printChanges(common.dbPath, changes)
+		return
+	}
+	err = guard.check(context.Background(), db)
+	if err != nil {
+		fatal("refusing to update db", err, "path", common.dbPath)
+	}
+	if *backupDir != "" {
+		err = autoBackup(db, *backupDir)
+		if err != nil {
+			fatal("failed to back up db", err, "path", common.dbPath)
+		}
+	}
+	err = apply(db, changes)
+	if err != nil {
+		fatal("failed to update db", err, "path", common.dbPath)
+	}
+	fmt.Println(id)
+}
+
+// layerMove is a layer taken from one revision and placed in another.
+type layerMove struct {
+	src, dst int
+}
+
+// parseLayerMove parses a layer specification of the form SRC or
+// SRC:DST.
+func parseLayerMove(s string) (layerMove, error) {
+	src, dst, ok := strings.Cut(s, ":")
+	if !ok {
+		dst = src
+	}
+	var (
+		m   layerMove
+		err error
+	)
+	m.src, err = strconv.Atoi(src)
+	if err != nil || m.src < 0 {
+		return m, fmt.Errorf("invalid source layer %q", src)
+	}
+	m.dst, err = strconv.Atoi(dst)
+	if err != nil || m.dst < 0 {
+		return m, fmt.Errorf("invalid destination layer %q", dst)
+	}
+	return m, nil
+}
+
+// composeLayers returns base with the layers of from placed according
+// to moves, as a new revision with the given ID, title and creation
+// time. The revisions must have the same geometry, and replaced layers
+// must have the same number of keys.
+func composeLayers(base, from []byte, moves []layerMove, id, title string, created time.Time) ([]byte, error) {
+	bl, err := parseLayout(base)
+	if err != nil {
+		return nil, err
+	}
+	fl, err := parseLayout(from)
+	if err != nil {
+		return nil, err
+	}
+	if bl.Geometry != fl.Geometry {
+		return nil, fmt.Errorf("cannot take layers from %s revision for %s layout", fl.Geometry, bl.Geometry)
+	}
+	var src struct {
+		Layout struct {
+			Revision struct {
+				Layers []json.RawMessage `json:"layers"`
+			} `json:"revision"`
+		} `json:"layout"`
+	}
+	err = json.Unmarshal(from, &src)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse layers: %w", err)
+	}
+	if len(src.Layout.Revision.Layers) != len(fl.Revision.Layers) {
+		return nil, errors.New("inconsistent layers")
+	}
+	srcLayers := make(map[int]int) // indexes keyed by position
+	for i, l := range fl.Revision.Layers {
+		srcLayers[l.Position] = i
+	}
+
+	return editLayout(base, func(_, revision map[string]json.RawMessage) error {
+		var layers []json.RawMessage
+		err := json.Unmarshal(revision["layers"], &layers)
+		if err != nil {
+			return fmt.Errorf("failed to parse layers: %w", err)
+		}
+		if len(layers) != len(bl.Revision.Layers) {
+			return errors.New("inconsistent layers")
+		}
+		for _, m := range moves {
+			k, ok := srcLayers[m.src]
+			if !ok {
+				return fmt.Errorf("no layer at position %d in source revision", m.src)
+			}
+			var l map[string]json.RawMessage
+			err = json.Unmarshal(src.Layout.Revision.Layers[k], &l)
+			if err != nil {
+				return fmt.Errorf("failed to parse layer: %w", err)
+			}
+			l["position"], _ = marshalRaw(m.dst)
+			i := -1
+			for j, b := range bl.Revision.Layers {
+				if b.Position == m.dst {
+					i = j
+					break
+				}
+			}
+			switch {
+			case i >= 0:
+				got, want := len(fl.Revision.Layers[k].Keys), len(bl.Revision.Layers[i].Keys)
+				if got != want {
+					return fmt.Errorf("layer %d has %d keys but base layer %d has %d", m.src, got, m.dst, want)
+				}
+				layers[i] = encodeObject(l)
+			case m.dst == len(layers):
+				layers = append(layers, encodeObject(l))
+				added := fl.Revision.Layers[k]
+				added.Position = m.dst
+				bl.Revision.Layers = append(bl.Revision.Layers, added)
+			default:
+				return fmt.Errorf("no layer at position %d in base revision", m.dst)
+			}
+		}
+		revision["layers"] = encodeArray(layers)
+		revision["hashId"], _ = marshalRaw(id)
+		revision["title"], _ = marshalRaw(title)
+		revision["createdAt"], _ = marshalRaw(created.UTC().Format(time.RFC3339))
+		return nil
+	})
+}
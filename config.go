@@ -0,0 +1,108 @@
+// Copyright ©2025 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// defaultConfigPath returns the default path of the fkm configuration
+// file.
+func defaultConfigPath() string {
+	dir := defaultStateDir()
+	if dir == "" {
+		return ""
+	}
+	return filepath.Join(dir, "config.toml")
+}
+
+// fileConfig is the content of an fkm configuration file.
+//
+// Top-level keys are flag names and their values are used as defaults
+// for flags of the same name that have not been set on the command
+// line or by a profile. Array values set repeatable flags once per
+// element. The optional config table holds default keymapp
+// configuration values. For example
+//
+//	path = "~/.config/.keymapp/keymapp.sqlite3"
+//	proxy = "http://proxy.internal:3128"
+//	graphql-url = "https://oryx.mirror.internal/graphql"
+//
+//	[config]
+//	api_enabled = "1"
+type fileConfig struct {
+	flags  map[string][]string
+	config map[string]string
+}
+
+// loadConfig reads the fkm configuration file at path. If required
+// is false, a missing file is not an error.
+func loadConfig(path string, required bool) (*fileConfig, error) {
+	var raw map[string]any
+	_, err := toml.DecodeFile(path, &raw)
+	if err != nil {
+		if !required && errors.Is(err, fs.ErrNotExist) {
+			return &fileConfig{}, nil
+		}
+		return nil, err
+	}
+	cfg := fileConfig{flags: make(map[string][]string)}
+	for k, v := range raw {
+		switch v := v.(type) {
+		case map[string]any:
+			if k != "config" {
+				return nil, fmt.Errorf("%s: unknown table %q", path, k)
+			}
+			cfg.config = make(map[string]string)
+			for ck, cv := range v {
+				s, err := scalarString(cv)
+				if err != nil {
+					return nil, fmt.Errorf("%s: config.%s: %w", path, ck, err)
+				}
+				cfg.config[ck] = s
+			}
+		case []any:
+			for _, e := range v {
+				s, err := scalarString(e)
+				if err != nil {
+					return nil, fmt.Errorf("%s: %s: %w", path, k, err)
+				}
+				cfg.flags[k] = append(cfg.flags[k], s)
+			}
+		default:
+			s, err := scalarString(v)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s: %w", path, k, err)
+			}
+			cfg.flags[k] = []string{s}
+		}
+	}
+	return &cfg, nil
+}
+
+// scalarString returns the flag value representation of a TOML
+// scalar.
+func scalarString(v any) (string, error) {
+	switch v := v.(type) {
+	case string:
+		return v, nil
+	case bool, int64, float64:
+		return fmt.Sprint(v), nil
+	case toml.Key, []any, map[string]any:
+		return "", fmt.Errorf("unsupported value type %T", v)
+	default:
+		// Dates and times.
+		if s, ok := v.(fmt.Stringer); ok {
+			return strings.TrimSpace(s.String()), nil
+		}
+		return "", fmt.Errorf("unsupported value type %T", v)
+	}
+}
@@ -0,0 +1,294 @@
+// Copyright ©2025 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// daemonFlags are the flags of the commands that run as long-lived
+// services: watch, autolayer, serve and web.
+type daemonFlags struct {
+	statusAddr string
+}
+
+// register adds the daemon flags to fs.
+func (d *daemonFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&d.statusAddr, "status-addr", "", "address to serve the daemon's status on at /status, as host:port or unix:<path> (default the socket named status when socket activated)")
+}
+
+// shutdownContext returns a context that is cancelled when the process
+// is interrupted or asked to terminate, so that services can finish
+// the work in progress and close the database before exiting.
+func shutdownContext() (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+}
+
+// daemon is the state of a running service, reported by its status
+// endpoint and to systemd.
+type daemon struct {
+	mu      sync.Mutex
+	command string
+	started time.Time
+	state   string
+	details map[string]any
+}
+
+// daemonStatus is the status reported by a daemon's status endpoint.
+type daemonStatus struct {
+	Command string         `json:"command"`
+	PID     int            `json:"pid"`
+	Started time.Time      `json:"started"`
+	State   string         `json:"state"`
+	Details map[string]any `json:"details,omitempty"`
+}
+
+// start returns the state of the named service, serving its status
+// until ctx is done if a status address is given or a socket named
+// status was passed by socket activation.
+func (d *daemonFlags) start(ctx context.Context, command string) (*daemon, error) {
+	s := &daemon{
+		command: command,
+		started: time.Now(),
+		state:   "starting",
+		details: make(map[string]any),
+	}
+	var (
+		ln  net.Listener
+		err error
+	)
+	if d.statusAddr != "" {
+		ln, err = listenAddr(d.statusAddr)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		ln = activatedListeners()["status"]
+	}
+	if ln == nil {
+		return s, nil
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /status", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(s.status())
+	})
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+	go func() {
+		err := srv.Serve(ln)
+		if !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("status server failed", "error", err)
+		}
+	}()
+	slog.Info("serving status", "addr", ln.Addr())
+	return s, nil
+}
+
+// setState records the state of the service, reporting it to systemd.
+func (s *daemon) setState(state string) {
+	s.mu.Lock()
+	s.state = state
+	s.mu.Unlock()
+	switch state {
+	case "running":
+		notifySystemd("READY=1\nSTATUS=running")
+	case "stopping":
+		notifySystemd("STOPPING=1\nSTATUS=stopping")
+	}
+}
+
+// set records a detail of the service's status. A nil value removes
+// the detail.
+func (s *daemon) set(key string, val any) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if val == nil {
+		delete(s.details, key)
+		return
+	}
+	s.details[key] = val
+}
+
+// status returns the current status of the service.
+func (s *daemon) status() daemonStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return daemonStatus{
+		Command: s.command,
+		PID:     os.Getpid(),
+		Started: s.started,
+		State:   s.state,
+		Details: maps.Clone(s.details),
+	}
+}
+
+// listenAddr listens on addr, which is a TCP host:port or a Unix socket
+// path prefixed with "unix:".
+func listenAddr(addr string) (net.Listener, error) {
+	if path, ok := strings.CutPrefix(addr, "unix:"); ok {
+		return net.Listen("unix", path)
+	}
+	return net.Listen("tcp", addr)
+}
+
+// serviceListener returns the listener for a service's requests: the
+// first socket passed by socket activation that is not named status, or
+// a new listener on addr.
+func serviceListener(addr string) (net.Listener, error) {
+	ls := activatedListeners()
+	for _, name := range slices.Sorted(maps.Keys(ls)) {
+		if name != "status" {
+			slog.Info("using socket activated listener", "name", name, "addr", ls[name].Addr())
+			return ls[name], nil
+		}
+	}
+	return listenAddr(addr)
+}
+
+// activatedListeners returns the sockets passed by systemd socket
+// activation keyed by their names, taken from LISTEN_FDNAMES. Unnamed
+// sockets are keyed by their position. The activation environment is
+// removed so that it is not inherited by child processes.
+var activatedListeners = sync.OnceValue(func() map[string]net.Listener {
+	defer func() {
+		os.Unsetenv("LISTEN_PID")
+		os.Unsetenv("LISTEN_FDS")
+		os.Unsetenv("LISTEN_FDNAMES")
+	}()
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n <= 0 {
+		return nil
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	ls := make(map[string]net.Listener)
+	const firstFD = 3 // SD_LISTEN_FDS_START
+	for i := range n {
+		name := strconv.Itoa(i)
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+		f := os.NewFile(uintptr(firstFD+i), name)
+		ln, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			slog.Warn("ignoring socket activated file", "name", name, "error", err)
+			continue
+		}
+		ls[name] = ln
+	}
+	return ls
+})
+
+// notifySystemd sends the state to the systemd service manager if the
+// process was started by systemd with a notification socket. Failures
+// are logged and otherwise ignored.
+func notifySystemd(state string) {
+	addr := os.Getenv("NOTIFY_SOCKET")
+	if addr == "" {
+		return
+	}
+	if strings.HasPrefix(addr, "@") {
+		addr = "\x00" + addr[1:] // abstract socket
+	}
+	conn, err := net.Dial("unixgram", addr)
+	if err != nil {
+		slog.Debug("failed to notify systemd", "error", err)
+		return
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	if err != nil {
+		slog.Debug("failed to notify systemd", "error", err)
+	}
+}
+
+// status prints the status reported by a running daemon's status
+// endpoint.
+func status(args []string) {
+	fs := flag.NewFlagSet("fkm status", flag.ExitOnError)
+	var common commonFlags
+	common.register(fs)
+	addr := fs.String("addr", "", "status address of the daemon, as given by its -status-addr flag (required)")
+	timeout := fs.Duration("timeout", 5*time.Second, "timeout for the status request")
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), `usage: fkm status -addr <addr> [flags]
+
+Print the status of a watch, autolayer, serve or web daemon started with
+-status-addr, or socket activated with a socket named status.
+
+Flags:
+`)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	common.setup(fs)
+	if fs.NArg() != 0 || *addr == "" {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+
+	cli := &http.Client{Timeout: *timeout}
+	url := "http://" + *addr + "/status"
+	if path, ok := strings.CutPrefix(*addr, "unix:"); ok {
+		cli.Transport = &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", path)
+			},
+		}
+		url = "http://daemon/status"
+	}
+	resp, err := cli.Get(url)
+	if err != nil {
+		fatal("failed to get status", exitError{status: exitNetwork, err: err}, "addr", *addr)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		fatal("failed to get status", exitError{status: exitNetwork, err: err}, "addr", *addr)
+	}
+	if resp.StatusCode != http.StatusOK {
+		fatal("failed to get status", fmt.Errorf("unexpected status: %s", resp.Status), "addr", *addr)
+	}
+	var s daemonStatus
+	err = json.Unmarshal(body, &s)
+	if err != nil {
+		fatal("failed to parse status", err, "addr", *addr)
+	}
+	if common.json {
+		printJSON(s)
+		return
+	}
+	fmt.Printf("%s (pid %d) %s since %s\n", s.Command, s.PID, s.State, s.Started.Format(time.RFC3339))
+	for _, k := range slices.Sorted(maps.Keys(s.Details)) {
+		fmt.Printf("\t%s: %v\n", k, s.Details[k])
+	}
+}
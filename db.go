@@ -0,0 +1,853 @@
+// Copyright ©2025 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"maps"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// dbOptions are the connection options for a keymapp database.
+type dbOptions struct {
+	// busyTimeout is how long to wait for a lock held by
+	// another connection, such as keymapp, before failing
+	// with SQLITE_BUSY.
+	busyTimeout time.Duration
+	// wal is whether to switch the database to WAL journaling.
+	wal bool
+}
+
+// dsn returns the data source name for the database at path.
+func (o dbOptions) dsn(path string, readOnly bool) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	q := url.Values{}
+	if readOnly {
+		q.Set("mode", "ro")
+	}
+	if o.busyTimeout > 0 {
+		q.Add("_pragma", fmt.Sprintf("busy_timeout(%d)", o.busyTimeout.Milliseconds()))
+	}
+	if o.wal && !readOnly {
+		q.Add("_pragma", "journal_mode(WAL)")
+	}
+	return (&url.URL{Scheme: "file", Path: abs, RawQuery: q.Encode()}).String(), nil
+}
+
+// openDB opens the keymapp database at path for writing, creating it
+// if it does not exist. The schema of the database is not changed
+// until changes are applied to it, when missing tables and columns
+// are added by apply.
+func openDB(path string, opts dbOptions) (*sql.DB, error) {
+	dsn, err := opts.dsn(path, false)
+	if err != nil {
+		return nil, dbError(err)
+	}
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, dbError(err)
+	}
+	err = db.Ping()
+	if err != nil {
+		db.Close()
+		return nil, dbError(err)
+	}
+	return db, nil
+}
+
+// openDBReadOnly opens the keymapp database at path without
+// modifying it. If the database does not exist, a nil *sql.DB and
+// nil error are returned.
+func openDBReadOnly(path string, opts dbOptions) (*sql.DB, error) {
+	_, err := os.Stat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, dbError(err)
+	}
+	dsn, err := opts.dsn(path, true)
+	if err != nil {
+		return nil, dbError(err)
+	}
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, dbError(err)
+	}
+	err = db.Ping()
+	if err != nil {
+		db.Close()
+		return nil, dbError(err)
+	}
+	return db, nil
+}
+
+// openExisting opens the existing keymapp database at path, exiting
+// if it cannot be opened.
+func openExisting(path string, readOnly bool, opts dbOptions) *sql.DB {
+	_, err := os.Stat(path)
+	if err != nil {
+		fatal("failed to open db", dbError(err), "path", path)
+	}
+	var db *sql.DB
+	if readOnly {
+		db, err = openDBReadOnly(path, opts)
+	} else {
+		db, err = openDB(path, opts)
+	}
+	if err != nil {
+		fatal("failed to open db", err, "path", path)
+	}
+	return db
+}
+
+// retryBusy calls fn until it returns an error that is not an sqlite
+// busy or locked error, or until the retry limit has been reached.
+func retryBusy(fn func() error) error {
+	const retries = 5
+	delay := 100 * time.Millisecond
+	for attempt := 0; ; attempt++ {
+		err := fn()
+		if !isBusy(err) || attempt == retries {
+			return err
+		}
+		slog.Info("database busy, retrying", "after", delay)
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// hasTable returns whether db has the named table. A nil db has no
+// tables.
+func hasTable(db *sql.DB, table string) (bool, error) {
+	if db == nil {
+		return false, nil
+	}
+	return tableExists(db, table)
+}
+
+// querier is a database or transaction that can be queried.
+type querier interface {
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// tableExists returns whether the named table exists in the database
+// queried by q.
+func tableExists(q querier, table string) (bool, error) {
+	var n int
+	err := q.QueryRow(`SELECT count(*) FROM sqlite_master WHERE type='table' AND name=?`, table).Scan(&n)
+	return n != 0, err
+}
+
+// rowCount returns the number of rows in table matching the optional
+// where clause. Absent tables have no rows.
+func rowCount(db *sql.DB, table, where string, args ...any) (int, error) {
+	ok, err := hasTable(db, table)
+	if !ok || err != nil {
+		return 0, err
+	}
+	query := fmt.Sprintf(`SELECT count(*) FROM %q`, table)
+	if where != "" {
+		query += " WHERE " + where
+	}
+	var n int
+	err = db.QueryRow(query, args...).Scan(&n)
+	return n, err
+}
+
+// change is a planned modification to the database.
+type change struct {
+	table string
+	op    string // "insert", "update", "upsert" or "delete"
+	desc  string
+
+	query string
+	args  []any
+}
+
+func (c change) String() string {
+	return fmt.Sprintf("%s %s: %s", c.op, c.table, c.desc)
+}
+
+// printChanges prints a summary of the changes that would be made to
+// the database at path.
+func printChanges(path string, changes []change) {
+	fmt.Printf("changes to %s:\n", path)
+	for _, c := range changes {
+		fmt.Printf("\t%s\n", c)
+	}
+}
+
+// apply executes the changes against db in a single transaction,
+// after bringing the schema of db up to date in the same transaction.
+// If any change fails, no change is made.
+func apply(db *sql.DB, changes []change) error {
+	err := retryBusy(func() error {
+		return applyTx(db, changes)
+	})
+	if err != nil {
+		return dbError(err)
+	}
+	slog.Info("updated db", "changes", len(changes))
+	return nil
+}
+
+func applyTx(db *sql.DB, changes []change) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	err = execChanges(tx, changes)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// applySecure is apply for changes that delete secrets. The changes
+// are made with secure_delete enabled so that deleted content is
+// overwritten, and the database is then vacuumed and its WAL
+// checkpointed and truncated so that no copy of the deleted content
+// remains in free pages or the WAL.
+func applySecure(db *sql.DB, changes []change) error {
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return dbError(err)
+	}
+	defer conn.Close()
+	_, err = conn.ExecContext(ctx, `PRAGMA secure_delete=ON`)
+	if err != nil {
+		return dbError(err)
+	}
+	defer conn.ExecContext(ctx, `PRAGMA secure_delete=OFF`)
+	err = retryBusy(func() error {
+		tx, err := conn.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+		err = execChanges(tx, changes)
+		if err != nil {
+			return err
+		}
+		return tx.Commit()
+	})
+	if err != nil {
+		return dbError(err)
+	}
+	slog.Info("updated db", "changes", len(changes))
+
+	// VACUUM writes the rebuilt database through the WAL, so the
+	// checkpoint follows it.
+	_, err = conn.ExecContext(ctx, `VACUUM`)
+	if err != nil {
+		return dbError(fmt.Errorf("failed to vacuum: %w", err))
+	}
+	var busy, frames, checkpointed int
+	err = conn.QueryRowContext(ctx, `PRAGMA wal_checkpoint(TRUNCATE)`).Scan(&busy, &frames, &checkpointed)
+	if err != nil {
+		return dbError(fmt.Errorf("failed to checkpoint: %w", err))
+	}
+	if busy != 0 {
+		return dbError(errors.New("failed to checkpoint: database is in use"))
+	}
+	return nil
+}
+
+// execChanges brings the schema of the database up to date and makes
+// the changes in tx.
+func execChanges(tx *sql.Tx, changes []change) error {
+	err := migrate(tx)
+	if err != nil {
+		return err
+	}
+	for _, c := range changes {
+		res, err := tx.Exec(c.query, c.args...)
+		if err != nil {
+			return fmt.Errorf("failed to %s: %w", c, err)
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		slog.Debug("applied change", "table", c.table, "op", c.op, "desc", c.desc, "rows", n)
+	}
+	return nil
+}
+
+// configChanges returns the changes needed to add any missing default
+// configuration to db. Values in overrides replace the built-in
+// defaults and may add keys, and are checked against the keys known to
+// be read by the keymapp release that wrote db. Existing values are
+// never changed, except that values differing from those in overrides
+// are replaced if force is true; otherwise the difference is reported.
+func configChanges(db *sql.DB, overrides map[string]string, force bool) ([]change, error) {
+	var current map[string]string
+	if len(overrides) != 0 {
+		var err error
+		current, err = keymappConfig(db)
+		if err != nil {
+			return nil, err
+		}
+		release, err := keymappRelease(db)
+		if err != nil {
+			return nil, err
+		}
+		for _, k := range slices.Sorted(maps.Keys(overrides)) {
+			err = checkConfigValue(release, k, overrides[k])
+			if err != nil {
+				return nil, exitError{status: exitUsage, err: fmt.Errorf("config default: %w", err)}
+			}
+		}
+	}
+	var changes []change
+	for _, kv := range configDefaults(overrides) {
+		// I know. ¯\_(ツ)_/¯
+		n, err := rowCount(db, "config", "key=?", kv.key)
+		if err != nil {
+			return nil, err
+		}
+		if n != 0 {
+			want, ok := overrides[kv.key]
+			if !ok || current[kv.key] == want {
+				continue
+			}
+			if !force {
+				slog.Warn("keeping existing keymapp config value", "key", kv.key, "value", current[kv.key], "configured", want, "hint", "use -force to replace it")
+				continue
+			}
+			changes = append(changes, setConfig(kv.key, want)...)
+			continue
+		}
+		changes = append(changes, change{
+			table: "config",
+			op:    "insert",
+			desc:  fmt.Sprintf("key=%s value=%s", kv.key, kv.val),
+			query: `INSERT INTO config (key, value) VALUES (?, ?)`,
+			args:  []any{kv.key, kv.val},
+		})
+	}
+	return changes, nil
+}
+
+// setConfig returns the changes setting the keymapp configuration key
+// to val, replacing any existing values of the key.
+func setConfig(key, val string) []change {
+	return []change{
+		{
+			table: "config",
+			op:    "delete",
+			desc:  "key=" + key,
+			query: `DELETE FROM config WHERE key=?`,
+			args:  []any{key},
+		},
+		{
+			table: "config",
+			op:    "insert",
+			desc:  fmt.Sprintf("key=%s value=%s", key, val),
+			query: `INSERT INTO config (key, value) VALUES (?, ?)`,
+			args:  []any{key, val},
+		},
+	}
+}
+
+type keyValue struct {
+	key, val string
+}
+
+// configDefaults returns defaultConfig with values replaced or added
+// from overrides.
+func configDefaults(overrides map[string]string) []keyValue {
+	kvs := slices.Clone(defaultConfig)
+	for i, kv := range kvs {
+		if v, ok := overrides[kv.key]; ok {
+			kvs[i].val = v
+		}
+	}
+	for _, k := range slices.Sorted(maps.Keys(overrides)) {
+		if !slices.ContainsFunc(kvs, func(kv keyValue) bool { return kv.key == k }) {
+			kvs = append(kvs, keyValue{k, overrides[k]})
+		}
+	}
+	return kvs
+}
+
+// errNoMetadata is returned by storeMetadata when a database without
+// metadata must be initialised offline by a binary without a metadata
+// snapshot.
+var errNoMetadata = errors.New("no metadata to initialise the database with offline: run online, or build fkm with the snapshot tag")
+
+// storeMetadata returns a change storing the metadata blob in db if
+// it differs from the stored metadata. Metadata from the embedded
+// snapshot is only stored in a database without metadata. The returned
+// boolean is false if no change is needed.
+func storeMetadata(db *sql.DB, meta fetchedMetadata) (change, bool, error) {
+	// I know. ಠ_ಠ
+	n, err := rowCount(db, "metadata", "")
+	if err != nil {
+		return change{}, false, err
+	}
+	if n == 0 {
+		if meta.data == nil {
+			return change{}, false, errNoMetadata
+		}
+		desc := fmt.Sprintf("data (%d bytes)", len(meta.data))
+		if meta.embedded {
+			desc += " from embedded snapshot"
+		}
+		return change{
+			table: "metadata",
+			op:    "insert",
+			desc:  desc,
+			query: `INSERT INTO metadata (data) VALUES (?)`,
+			args:  []any{meta.data},
+		}, true, nil
+	}
+	if meta.embedded {
+		// The snapshot only seeds an empty table; the stored
+		// metadata may be newer.
+		slog.Debug("not replacing stored metadata with embedded snapshot")
+		return change{}, false, nil
+	}
+	n, err = rowCount(db, "metadata", "data=?", meta.data)
+	if n != 0 || err != nil {
+		return change{}, false, err
+	}
+	return change{
+		table: "metadata",
+		op:    "update",
+		desc:  fmt.Sprintf("data (%d bytes)", len(meta.data)),
+		query: `UPDATE metadata SET data=?`,
+		args:  []any{meta.data},
+	}, true, nil
+}
+
+// loadMetadata returns the metadata stored in the database, or nil if
+// there is none.
+func loadMetadata(db *sql.DB) ([]byte, error) {
+	ok, err := hasTable(db, "metadata")
+	if err != nil || !ok {
+		return nil, err
+	}
+	var data []byte
+	err = db.QueryRow(`SELECT data FROM metadata LIMIT 1`).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return data, err
+}
+
+// storeRevision returns a change storing the revision data. The op is
+// "insert" for new revisions and "update" for replaced revisions.
+func storeRevision(id string, data []byte, op string) change {
+	return change{
+		table: "revision",
+		op:    op,
+		desc:  fmt.Sprintf("revisionId=%s data (%d bytes)", id, len(data)),
+		query: `INSERT INTO revision (revisionId, data) VALUES (?, ?) ON CONFLICT DO UPDATE SET data=?`,
+		args:  []any{id, data, data},
+	}
+}
+
+// spooledRevision returns the change storing a revision as
+// storeRevision does, with the data held in a spool so that the changes
+// for a batch of revisions do not keep their data in memory.
+func spooledRevision(id string, data []byte, op string) (change, error) {
+	s, err := spoolBytes(data)
+	if err != nil {
+		return change{}, fmt.Errorf("revision %s: %w", id, err)
+	}
+	c := storeRevision(id, data, op)
+	c.args = []any{id, s, s}
+	return c, nil
+}
+
+// errRevisionConflict is returned when a revision being stored is
+// already stored with different content.
+var errRevisionConflict = errors.New("revision is stored with different content")
+
+// sameJSON returns whether a and b hold the same JSON value, ignoring
+// formatting and object key order. If either is not valid JSON, they
+// are compared byte for byte.
+func sameJSON(a, b []byte) bool {
+	ca, errA := canonicalJSON(a)
+	cb, errB := canonicalJSON(b)
+	if errA != nil || errB != nil {
+		return bytes.Equal(a, b)
+	}
+	return bytes.Equal(ca, cb)
+}
+
+// errRevisionNotFound is returned when a revision is not stored in
+// the database.
+var errRevisionNotFound = errors.New("revision not found")
+
+// loadRevision returns the stored data for the revision with the given
+// ID.
+func loadRevision(db *sql.DB, id string) ([]byte, error) {
+	var data []byte
+	err := db.QueryRow(`SELECT data FROM revision WHERE revisionId=?`, id).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%w: %s", errRevisionNotFound, id)
+	}
+	return data, err
+}
+
+// loadLayout returns the parsed layout of the stored revision with the
+// given ID.
+func loadLayout(db *sql.DB, id string) (*layout, error) {
+	data, err := loadRevision(db, id)
+	if err != nil {
+		return nil, err
+	}
+	l, err := parseLayout(data)
+	if err != nil || l.Revision.Tour != nil {
+		return l, err
+	}
+	// The tour may have been stored separately.
+	ok, err := hasTable(db, "fkm_tour")
+	if err != nil || !ok {
+		return l, err
+	}
+	var tourData []byte
+	err = db.QueryRow(`SELECT data FROM fkm_tour WHERE revisionId=?`, id).Scan(&tourData)
+	if errors.Is(err, sql.ErrNoRows) {
+		return l, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(tourData, &l.Revision.Tour)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse tour: %w", err)
+	}
+	return l, nil
+}
+
+// storedRevision is a revision held in the database.
+type storedRevision struct {
+	id      string
+	layout  *layout // nil if the data could not be parsed
+	heatmap bool
+}
+
+// storedRevisions returns the revisions held in the database, ordered
+// by layout and then newest first.
+func storedRevisions(db *sql.DB) ([]storedRevision, error) {
+	rows, err := db.Query(`SELECT r.revisionId, r.data, coalesce(h.enabled, 0) FROM revision r LEFT JOIN heatmap h ON r.revisionId=h.revisionId`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var revs []storedRevision
+	for rows.Next() {
+		var (
+			r    storedRevision
+			data []byte
+		)
+		err = rows.Scan(&r.id, &data, &r.heatmap)
+		if err != nil {
+			return nil, err
+		}
+		r.layout, err = parseLayout(data)
+		if err != nil {
+			slog.Warn("failed to parse revision", "revision", r.id, "error", err)
+		}
+		revs = append(revs, r)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	slices.SortFunc(revs, func(a, b storedRevision) int {
+		if a.layout == nil || b.layout == nil {
+			switch {
+			case a.layout != nil:
+				return -1
+			case b.layout != nil:
+				return 1
+			}
+			return strings.Compare(a.id, b.id)
+		}
+		if c := strings.Compare(a.layout.HashID, b.layout.HashID); c != 0 {
+			return c
+		}
+		return b.layout.Revision.Created().Compare(a.layout.Revision.Created())
+	})
+	return revs, nil
+}
+
+// setHeatmap returns a change enabling or disabling keymapp's heatmap
+// recording for the revision.
+func setHeatmap(id string, enabled bool) change {
+	return change{
+		table: "heatmap",
+		op:    "upsert",
+		desc:  fmt.Sprintf("revisionId=%s enabled=%t", id, enabled),
+		query: `INSERT INTO heatmap (revisionId, enabled) VALUES (?, ?) ON CONFLICT DO UPDATE SET enabled=excluded.enabled`,
+		args:  []any{id, enabled},
+	}
+}
+
+// setHeatmapData returns a change replacing keymapp's heatmap data for
+// the revision, leaving recording enabled or disabled.
+func setHeatmapData(id string, data []byte) change {
+	return change{
+		table: "heatmap",
+		op:    "upsert",
+		desc:  fmt.Sprintf("revisionId=%s data (%d bytes)", id, len(data)),
+		query: `INSERT INTO heatmap (revisionId, data) VALUES (?, ?) ON CONFLICT DO UPDATE SET data=excluded.data`,
+		args:  []any{id, data},
+	}
+}
+
+// upsertTour returns a change storing a revision's tour separately from
+// the revision.
+func upsertTour(id string, tour []byte) change {
+	return change{
+		table: "fkm_tour",
+		op:    "upsert",
+		desc:  fmt.Sprintf("revisionId=%s data (%d bytes)", id, len(tour)),
+		query: `INSERT INTO fkm_tour (revisionId, data) VALUES (?, ?) ON CONFLICT DO UPDATE SET data=excluded.data`,
+		args:  []any{id, tour},
+	}
+}
+
+// revisionChanges returns the changes storing the revision data with
+// the given ID and md5 checksum, tracking its layout for updates and
+// recording its tags. A revision that is already stored is not
+// rewritten, and if its stored content differs from data, for example
+// because keymapp wrote it, errRevisionConflict is returned unless force
+// is true. Data that does not match the layout schema is not stored.
+// The provenance of the data is recorded when the revision is written.
+func revisionChanges(db *sql.DB, id, md5sum string, data []byte, force bool, prov provenance) ([]change, error) {
+	err := validateLayout(data)
+	if err != nil {
+		return nil, fmt.Errorf("revision %s: %w", id, err)
+	}
+	n, err := rowCount(db, "revision", "revisionId=?", id)
+	if err != nil {
+		return nil, err
+	}
+	var stored []byte
+	if n != 0 {
+		stored, err = loadRevision(db, id)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var changes []change
+	switch {
+	case stored == nil:
+		store, err := spooledRevision(id, data, "insert")
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, store, recordProvenance(id, prov))
+	case sameJSON(stored, data):
+		// Keep the stored data, which may have been written
+		// by keymapp, so that its checksum is recorded.
+		slog.Debug("revision is already stored", "revision", id)
+		data = stored
+	case !force:
+		return nil, fmt.Errorf("%w: %s: use -force to replace it", errRevisionConflict, id)
+	default:
+		slog.Warn("replacing stored revision", "revision", id)
+		store, err := spooledRevision(id, data, "update")
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, store, recordProvenance(id, prov))
+	}
+	changes = append(changes, upsertChecksum(id, md5sum, data))
+	l, err := parseLayout(data)
+	if err != nil {
+		return nil, err
+	}
+	changes = append(changes, trackLayout(l))
+	return append(changes, tagLayout(l)...), nil
+}
+
+// tagLayout returns the changes replacing the recorded tags of the
+// layout with its current tags.
+func tagLayout(l *layout) []change {
+	changes := []change{{
+		table: "fkm_tag",
+		op:    "delete",
+		desc:  "hashId=" + l.HashID,
+		query: `DELETE FROM fkm_tag WHERE hashId=?`,
+		args:  []any{l.HashID},
+	}}
+	for _, t := range l.Tags {
+		if t.Name == "" {
+			continue
+		}
+		changes = append(changes, change{
+			table: "fkm_tag",
+			op:    "insert",
+			desc:  fmt.Sprintf("hashId=%s tag=%s", l.HashID, t.Name),
+			query: `INSERT OR IGNORE INTO fkm_tag (hashId, tag) VALUES (?, ?)`,
+			args:  []any{l.HashID, t.Name},
+		})
+	}
+	return changes
+}
+
+// errNoTags is returned by layoutTags when the database predates tag
+// records.
+var errNoTags = errors.New("database has no tag records: update the database to record them")
+
+// layoutTags returns the recorded tags of each layout keyed by layout
+// hash ID.
+func layoutTags(db *sql.DB) (map[string][]string, error) {
+	ok, err := hasTable(db, "fkm_tag")
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, errNoTags
+	}
+	rows, err := db.Query(`SELECT hashId, tag FROM fkm_tag ORDER BY hashId, tag`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	tags := make(map[string][]string)
+	for rows.Next() {
+		var hashID, tag string
+		err = rows.Scan(&hashID, &tag)
+		if err != nil {
+			return nil, err
+		}
+		tags[hashID] = append(tags[hashID], tag)
+	}
+	return tags, rows.Err()
+}
+
+// trackLayout returns a change recording the layout's revision as the
+// most recent revision of the layout that has been stored.
+func trackLayout(l *layout) change {
+	return change{
+		table: "fkm_tracked",
+		op:    "upsert",
+		desc:  fmt.Sprintf("hashId=%s geometry=%s revisionId=%s", l.HashID, l.Geometry, l.Revision.HashID),
+		query: `INSERT INTO fkm_tracked (hashId, geometry, revisionId, updated) VALUES (?, ?, ?, ?) ON CONFLICT DO UPDATE SET geometry=excluded.geometry, revisionId=excluded.revisionId, updated=excluded.updated`,
+		args:  []any{l.HashID, l.Geometry, l.Revision.HashID, time.Now().UTC().Format(time.RFC3339)},
+	}
+}
+
+// upsertChecksum returns a change recording the md5 checksum reported
+// by Oryx for the revision's config and the SHA-256 of the stored
+// revision data.
+func upsertChecksum(id, md5sum string, data []byte) change {
+	sum := fmt.Sprintf("%x", sha256.Sum256(data))
+	return change{
+		table: "fkm_checksum",
+		op:    "upsert",
+		desc:  fmt.Sprintf("revisionId=%s md5=%s sha256=%s", id, md5sum, sum),
+		query: `INSERT INTO fkm_checksum (revisionId, md5, sha256) VALUES (?, ?, ?) ON CONFLICT DO UPDATE SET md5=excluded.md5, sha256=excluded.sha256`,
+		args:  []any{id, md5sum, sum},
+	}
+}
+
+// deleteRevision returns the changes removing the revision with the
+// given ID and its fkm records.
+func deleteRevision(id string, l *layout) []change {
+	desc := "revisionId=" + id
+	if l != nil {
+		desc = fmt.Sprintf("revisionId=%s layout=%s created=%s", id, l.HashID, l.Revision.CreatedAt)
+	}
+	return []change{
+		{
+			table: "revision",
+			op:    "delete",
+			desc:  desc,
+			query: `DELETE FROM revision WHERE revisionId=?`,
+			args:  []any{id},
+		},
+		{
+			table: "fkm_checksum",
+			op:    "delete",
+			desc:  "revisionId=" + id,
+			query: `DELETE FROM fkm_checksum WHERE revisionId=?`,
+			args:  []any{id},
+		},
+		{
+			table: "fkm_tour",
+			op:    "delete",
+			desc:  "revisionId=" + id,
+			query: `DELETE FROM fkm_tour WHERE revisionId=?`,
+			args:  []any{id},
+		},
+		{
+			table: "fkm_provenance",
+			op:    "delete",
+			desc:  "revisionId=" + id,
+			query: `DELETE FROM fkm_provenance WHERE revisionId=?`,
+			args:  []any{id},
+		},
+	}
+}
+
+const schema = `
+CREATE TABLE IF NOT EXISTS "config" (
+            key TEXT,
+            value TEXT
+        );
+CREATE TABLE IF NOT EXISTS "metadata" (
+            data BLOB
+        );
+CREATE TABLE IF NOT EXISTS "heatmap" (
+            revisionId TEXT NOT NULL UNIQUE,
+            enabled boolean DEFAULT 0,
+            data BLOB DEFAULT NULL
+        );
+CREATE TABLE IF NOT EXISTS "revision" (
+            revisionId TEXT NOT NULL UNIQUE,
+            data BLOB DEFAULT NULL
+        );
+CREATE TABLE IF NOT EXISTS "smart_layer" (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            app TEXT NOT NULL,
+            layer INTEGER NOT NULL,
+            layoutId TEXT NOT NULL,
+            revisionId TEXT NOT NULL
+        );
+CREATE TABLE IF NOT EXISTS "auth" (
+            token TEXT NOT NULL UNIQUE,
+            username TEXT NOT NULL
+        );
+`
+
+var defaultConfig = []keyValue{
+	{"prompt_update_check", "1"},
+	{"update_check", "0"},
+	{"startup_minimized", "0"},
+	{"startup_autoconnect", "0"},
+	{"smart_layers_enabled", "1"},
+	{"api_enabled", "0"},
+	{"api_port", "50051"},
+}
@@ -0,0 +1,131 @@
+// Copyright ©2025 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// newTestDB returns a new keymapp database with the current schema.
+func newTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := openDB(filepath.Join(t.TempDir(), "keymapp.sqlite3"), dbOptions{})
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	err = apply(db, nil)
+	if err != nil {
+		t.Fatalf("failed to migrate db: %v", err)
+	}
+	return db
+}
+
+// testRevision returns the data of a minimal revision of a voyager
+// layout.
+func testRevision(hashID, id, created string) []byte {
+	return fmt.Appendf(nil, `{"layout":{"geometry":"voyager","hashId":%q,"title":"Test","revision":{"hashId":%q,"createdAt":%q,"layers":[{"hashId":"L0","position":0,"title":"Base","keys":[{"tap":{"code":"KC_A"}}]}]}}}`, hashID, id, created)
+}
+
+// storeTestRevision stores a minimal revision in db, making it the
+// tracked revision of its layout.
+func storeTestRevision(t *testing.T, db *sql.DB, hashID, id, created string) *layout {
+	t.Helper()
+	data := testRevision(hashID, id, created)
+	changes, err := revisionChanges(db, id, "", data, false, provenance{})
+	if err != nil {
+		t.Fatalf("failed to prepare revision %s: %v", id, err)
+	}
+	err = apply(db, changes)
+	if err != nil {
+		t.Fatalf("failed to store revision %s: %v", id, err)
+	}
+	l, err := parseLayout(data)
+	if err != nil {
+		t.Fatalf("failed to parse revision %s: %v", id, err)
+	}
+	return l
+}
+
+// deletedRevisions returns the sorted IDs of the revisions deleted by
+// changes.
+func deletedRevisions(changes []change) []string {
+	var ids []string
+	for _, c := range changes {
+		if c.table == "revision" && c.op == "delete" {
+			ids = append(ids, c.args[0].(string))
+		}
+	}
+	slices.Sort(ids)
+	return ids
+}
+
+func TestStoreMetadata(t *testing.T) {
+	tests := []struct {
+		name   string
+		stored []byte
+		meta   fetchedMetadata
+
+		wantOp   string
+		wantDesc string
+		wantErr  error
+	}{
+		{name: "empty no data", wantErr: errNoMetadata},
+		{name: "empty fetched", meta: fetchedMetadata{data: []byte(`{"a":1}`)}, wantOp: "insert", wantDesc: "data (7 bytes)"},
+		{name: "empty embedded", meta: fetchedMetadata{data: []byte(`{"a":1}`), embedded: true}, wantOp: "insert", wantDesc: "data (7 bytes) from embedded snapshot"},
+		{name: "stored embedded", stored: []byte(`{"a":1}`), meta: fetchedMetadata{data: []byte(`{"a":2}`), embedded: true}},
+		{name: "stored same", stored: []byte(`{"a":1}`), meta: fetchedMetadata{data: []byte(`{"a":1}`)}},
+		{name: "stored different", stored: []byte(`{"a":1}`), meta: fetchedMetadata{data: []byte(`{"a":22}`)}, wantOp: "update", wantDesc: "data (8 bytes)"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			db := newTestDB(t)
+			if test.stored != nil {
+				_, err := db.Exec(`INSERT INTO metadata (data) VALUES (?)`, test.stored)
+				if err != nil {
+					t.Fatalf("failed to store metadata: %v", err)
+				}
+			}
+			c, ok, err := storeMetadata(db, test.meta)
+			if !errors.Is(err, test.wantErr) {
+				t.Fatalf("unexpected error: got:%v want:%v", err, test.wantErr)
+			}
+			if ok != (test.wantOp != "") {
+				t.Fatalf("unexpected change needed: got:%t want:%t", ok, test.wantOp != "")
+			}
+			if !ok {
+				return
+			}
+			if c.op != test.wantOp || c.desc != test.wantDesc {
+				t.Errorf("unexpected change: got:%s %q want:%s %q", c.op, c.desc, test.wantOp, test.wantDesc)
+			}
+
+			err = apply(db, []change{c})
+			if err != nil {
+				t.Fatalf("failed to apply change: %v", err)
+			}
+			got, err := loadMetadata(db)
+			if err != nil {
+				t.Fatalf("failed to load metadata: %v", err)
+			}
+			if !bytes.Equal(got, test.meta.data) {
+				t.Errorf("unexpected stored metadata: got:%s want:%s", got, test.meta.data)
+			}
+			n, err := rowCount(db, "metadata", "")
+			if err != nil {
+				t.Fatalf("failed to count metadata: %v", err)
+			}
+			if n != 1 {
+				t.Errorf("unexpected number of metadata rows: got:%d want:1", n)
+			}
+		})
+	}
+}
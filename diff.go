@@ -0,0 +1,263 @@
+// Copyright ©2025 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// diff prints the differences between two revisions.
+func diff(args []string) {
+	fs := flag.NewFlagSet("fkm diff", flag.ExitOnError)
+	var (
+		common commonFlags
+		legend legendFlags
+	)
+	common.register(fs)
+	legend.register(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: fkm diff [flags] <revA> <revB>\n\nEach revision is the ID of a stored revision or the path to a revision file.\n\nFlags:\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	common.setup(fs)
+	if fs.NArg() != 2 {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+
+	db, err := openDBReadOnly(common.dbPath, common.db)
+	if err != nil {
+		fatal("failed to open db", err, "path", common.dbPath)
+	}
+	if db != nil {
+		defer db.Close()
+	}
+	a, err := diffOperand(db, fs.Arg(0))
+	if err != nil {
+		fatal("failed to read revision", err, "revision", fs.Arg(0))
+	}
+	b, err := diffOperand(db, fs.Arg(1))
+	if err != nil {
+		fatal("failed to read revision", err, "revision", fs.Arg(1))
+	}
+
+	d := diffRevisions(a, b, legend.legends())
+	if common.json {
+		printJSON(d)
+		return
+	}
+	d.print(os.Stdout)
+}
+
+// diffOperand returns the layout of the stored revision with the given
+// ID, or if there is no such revision, the layout in the file at that
+// path.
+func diffOperand(db *sql.DB, rev string) (*layout, error) {
+	if db != nil {
+		l, err := loadLayout(db, rev)
+		if !errors.Is(err, errRevisionNotFound) {
+			return l, err
+		}
+	}
+	_, data, err := revisionFile(rev)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("%w: %s", errRevisionNotFound, rev)
+		}
+		return nil, err
+	}
+	return parseLayout(data)
+}
+
+// revisionDiff is the set of differences between two revisions.
+type revisionDiff struct {
+	From   string      `json:"from"`
+	To     string      `json:"to"`
+	Layers []layerDiff `json:"layers,omitempty"`
+	Combos []entryDiff `json:"combos,omitempty"`
+}
+
+// layerDiff is the set of differences between two versions of a layer.
+type layerDiff struct {
+	Position int         `json:"position"`
+	Name     string      `json:"name"`
+	Change   string      `json:"change"` // added, removed or changed
+	Keys     []entryDiff `json:"keys,omitempty"`
+}
+
+// entryDiff is a change to a key or combo.
+type entryDiff struct {
+	Key    string `json:"key"`    // key index or combo name
+	Change string `json:"change"` // added, removed or remapped
+	From   string `json:"from,omitempty"`
+	To     string `json:"to,omitempty"`
+}
+
+// diffRevisions returns the differences between the revisions of a
+// and b. Layers are matched by hash ID, falling back to position when
+// a layer has no hash ID, and combos are matched by name. Entries are
+// compared by their key codes and described with legends, or as key
+// codes if legends is nil.
+func diffRevisions(a, b *layout, legends *keyLegends) revisionDiff {
+	d := revisionDiff{From: a.Revision.HashID, To: b.Revision.HashID}
+
+	layerKey := func(l layer) string {
+		if l.HashID != "" {
+			return l.HashID
+		}
+		return strconv.Itoa(l.Position)
+	}
+	old := make(map[string]layer)
+	for _, l := range a.Revision.Layers {
+		old[layerKey(l)] = l
+	}
+	seen := make(map[string]bool)
+	for _, nl := range b.Revision.Layers {
+		k := layerKey(nl)
+		seen[k] = true
+		ol, ok := old[k]
+		if !ok {
+			d.Layers = append(d.Layers, layerDiff{Position: nl.Position, Name: nl.Name(), Change: "added"})
+			continue
+		}
+		keys := diffEntries(keyDescriptions(ol, legends), keyDescriptions(nl, legends))
+		if len(keys) != 0 || ol.Name() != nl.Name() || ol.Position != nl.Position {
+			d.Layers = append(d.Layers, layerDiff{Position: nl.Position, Name: nl.Name(), Change: "changed", Keys: keys})
+		}
+	}
+	for _, ol := range a.Revision.Layers {
+		if !seen[layerKey(ol)] {
+			d.Layers = append(d.Layers, layerDiff{Position: ol.Position, Name: ol.Name(), Change: "removed"})
+		}
+	}
+
+	d.Combos = diffEntries(comboDescriptions(a, legends), comboDescriptions(b, legends))
+	return d
+}
+
+// describedEntry is a key or combo and its description. Entries are
+// compared by desc and shown by display.
+type describedEntry struct {
+	key     string
+	desc    string
+	display string
+}
+
+// diffEntries returns the changes between the entries in a and b,
+// matched by key, in the order they appear in b followed by removed
+// entries in the order they appear in a.
+func diffEntries(a, b []describedEntry) []entryDiff {
+	old := make(map[string]describedEntry)
+	for _, e := range a {
+		old[e.key] = e
+	}
+	var d []entryDiff
+	seen := make(map[string]bool)
+	for _, e := range b {
+		seen[e.key] = true
+		o, ok := old[e.key]
+		switch {
+		case !ok:
+			d = append(d, entryDiff{Key: e.key, Change: "added", To: e.display})
+		case o.desc != e.desc:
+			d = append(d, entryDiff{Key: e.key, Change: "remapped", From: o.display, To: e.display})
+		}
+	}
+	for _, e := range a {
+		if !seen[e.key] {
+			d = append(d, entryDiff{Key: e.key, Change: "removed", From: e.display})
+		}
+	}
+	return d
+}
+
+// keyDescriptions returns descriptions of the layer's keys keyed by
+// index.
+func keyDescriptions(l layer, legends *keyLegends) []describedEntry {
+	e := make([]describedEntry, len(l.Keys))
+	for i, k := range l.Keys {
+		e[i] = describedEntry{key: strconv.Itoa(i), desc: describeKey(k, nil), display: describeKey(k, legends)}
+	}
+	return e
+}
+
+// describeKey returns a description of all the actions of a key,
+// rendered with legends or as key codes if legends is nil.
+func describeKey(k key, legends *keyLegends) string {
+	var parts []string
+	for _, a := range k.Actions() {
+		parts = append(parts, a.Kind+"="+legends.action(a.Action))
+	}
+	if k.CustomLabel != "" {
+		parts = append(parts, strconv.Quote(k.CustomLabel))
+	}
+	if len(parts) == 0 {
+		return "none"
+	}
+	return strings.Join(parts, " ")
+}
+
+// comboDescriptions returns descriptions of the layout's combos keyed
+// by name, or by position if a combo has no name. Combo keys are
+// described by index so that remapping a key does not change the
+// description of combos using it.
+func comboDescriptions(l *layout, legends *keyLegends) []describedEntry {
+	e := make([]describedEntry, len(l.Revision.Combos))
+	for i, c := range l.Revision.Combos {
+		name := c.Name
+		if name == "" {
+			name = "#" + strconv.Itoa(i)
+		}
+		describe := func(trigger string) string {
+			return fmt.Sprintf("layer %d keys %s trigger %s", c.LayerIdx, joinInts(c.KeyIndices, "+"), trigger)
+		}
+		e[i] = describedEntry{
+			key:     name,
+			desc:    describe(strings.TrimPrefix(c.Trigger, "KC_")),
+			display: describe(legends.trigger(c.Trigger)),
+		}
+	}
+	return e
+}
+
+// print writes a human readable form of the differences to w.
+func (d revisionDiff) print(w io.Writer) {
+	fmt.Fprintf(w, "--- %s\n+++ %s\n", d.From, d.To)
+	if len(d.Layers) == 0 && len(d.Combos) == 0 {
+		fmt.Fprintln(w, "no differences")
+		return
+	}
+	for _, l := range d.Layers {
+		fmt.Fprintf(w, "layer %d %s: %s\n", l.Position, l.Name, l.Change)
+		for _, k := range l.Keys {
+			fmt.Fprintf(w, "\tkey %s\n", k)
+		}
+	}
+	if len(d.Combos) != 0 {
+		fmt.Fprintln(w, "combos:")
+		for _, c := range d.Combos {
+			fmt.Fprintf(w, "\t%s\n", c)
+		}
+	}
+}
+
+func (e entryDiff) String() string {
+	switch e.Change {
+	case "added":
+		return fmt.Sprintf("%s added: %s", e.Key, e.To)
+	case "removed":
+		return fmt.Sprintf("%s removed: %s", e.Key, e.From)
+	default:
+		return fmt.Sprintf("%s %s: %s → %s", e.Key, e.Change, e.From, e.To)
+	}
+}
@@ -0,0 +1,316 @@
+// Copyright ©2025 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// finding is the result of a doctor check.
+type finding struct {
+	level string // "ok", "warn" or "error"
+	check string
+	msg   string
+	fix   string
+}
+
+func (f finding) String() string {
+	if f.fix == "" {
+		return fmt.Sprintf("%-5s %s: %s", f.level, f.check, f.msg)
+	}
+	return fmt.Sprintf("%-5s %s: %s\n      fix: %s", f.level, f.check, f.msg, f.fix)
+}
+
+func (f finding) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Level   string `json:"level"`
+		Check   string `json:"check"`
+		Message string `json:"message"`
+		Fix     string `json:"fix,omitempty"`
+	}{f.level, f.check, f.msg, f.fix})
+}
+
+// doctor checks the environment and database for problems.
+func doctor(args []string) {
+	fs := flag.NewFlagSet("fkm doctor", flag.ExitOnError)
+	var common commonFlags
+	common.register(fs)
+	fs.Parse(args)
+	common.setup(fs)
+
+	findings := diagnose(common.dbPath, common.db)
+	var failed bool
+	for _, f := range findings {
+		if !common.json {
+			fmt.Println(f)
+		}
+		failed = failed || f.level == "error"
+	}
+	if common.json {
+		printJSON(findings)
+	}
+	if failed {
+		os.Exit(exitVerify)
+	}
+}
+
+// diagnose returns the findings for the database at path.
+func diagnose(path string, opts dbOptions) []finding {
+	findings := checkPath(path)
+	if slices.ContainsFunc(findings, func(f finding) bool { return f.level == "error" }) {
+		return findings
+	}
+	db, err := openDBReadOnly(path, opts)
+	if err != nil {
+		return append(findings, finding{"error", "open", err.Error(), "check that the path is a keymapp sqlite3 database"})
+	}
+	defer db.Close()
+
+	for _, check := range []func(*sql.DB) []finding{
+		checkIntegrity,
+		checkSchema,
+		checkMetadata,
+		checkSmartLayers,
+		checkAPIConfig,
+	} {
+		findings = append(findings, check(db)...)
+	}
+	return findings
+}
+
+func checkPath(path string) []finding {
+	fi, err := os.Stat(path)
+	if err != nil {
+		fix := ""
+		if os.IsNotExist(err) {
+			fix = "run fkm with -layout to create the database, or use -path to select an existing one"
+		}
+		return []finding{{"error", "path", err.Error(), fix}}
+	}
+	if !fi.Mode().IsRegular() {
+		return []finding{{"error", "path", fmt.Sprintf("%s is not a regular file", path), ""}}
+	}
+	findings := []finding{{"ok", "path", path, ""}}
+	findings = append(findings, checkPerm("permissions", path, fi.Mode().Perm())...)
+	dir := filepath.Dir(path)
+	di, err := os.Stat(dir)
+	if err != nil {
+		return append(findings, finding{"error", "directory", err.Error(), ""})
+	}
+	findings = append(findings, checkPerm("directory permissions", dir, di.Mode().Perm())...)
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		findings = append(findings, finding{"error", "permissions", fmt.Sprintf("database is not writable: %v", err), "chmod u+rw " + path})
+	} else {
+		f.Close()
+	}
+	return findings
+}
+
+func checkPerm(check, path string, perm fs.FileMode) []finding {
+	if perm&0o002 != 0 {
+		return []finding{{"warn", check, fmt.Sprintf("%s is world writable (%v)", path, perm), "chmod o-w " + path}}
+	}
+	return []finding{{"ok", check, perm.String(), ""}}
+}
+
+func checkIntegrity(db *sql.DB) []finding {
+	rows, err := db.Query(`PRAGMA integrity_check`)
+	if err != nil {
+		return []finding{{"error", "integrity", err.Error(), ""}}
+	}
+	defer rows.Close()
+	var problems []string
+	for rows.Next() {
+		var msg string
+		err = rows.Scan(&msg)
+		if err != nil {
+			return []finding{{"error", "integrity", err.Error(), ""}}
+		}
+		if msg != "ok" {
+			problems = append(problems, msg)
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return []finding{{"error", "integrity", err.Error(), ""}}
+	}
+	if len(problems) != 0 {
+		return []finding{{"error", "integrity", strings.Join(problems, "; "), "restore from a backup with fkm restore"}}
+	}
+	return []finding{{"ok", "integrity", "ok", ""}}
+}
+
+func checkSchema(db *sql.DB) []finding {
+	d, err := diffSchema(db)
+	if err != nil {
+		return []finding{{"error", "schema", err.Error(), ""}}
+	}
+	if d.empty() {
+		return []finding{{"ok", "schema", "matches expected keymapp schema", ""}}
+	}
+	var findings []finding
+	for _, t := range d.missingTables {
+		findings = append(findings, finding{"warn", "schema", "missing table " + t, "run fkm to create missing tables"})
+	}
+	for _, t := range slices.Sorted(maps.Keys(d.missingColumns)) {
+		for _, c := range d.missingColumns[t] {
+			findings = append(findings, finding{"warn", "schema", fmt.Sprintf("table %s is missing column %s", t, c.name), "run fkm to add missing columns"})
+		}
+	}
+	for _, t := range slices.Sorted(maps.Keys(d.extraColumns)) {
+		findings = append(findings, finding{"warn", "schema", fmt.Sprintf("table %s has columns unknown to fkm: %s", t, strings.Join(d.extraColumns[t], ", ")), "the database may have been written by a newer keymapp release"})
+	}
+	return findings
+}
+
+func checkMetadata(db *sql.DB) []finding {
+	ok, err := hasTable(db, "metadata")
+	if err != nil {
+		return []finding{{"error", "metadata", err.Error(), ""}}
+	}
+	if !ok {
+		return nil
+	}
+	rows, err := db.Query(`SELECT data FROM metadata`)
+	if err != nil {
+		return []finding{{"error", "metadata", err.Error(), ""}}
+	}
+	defer rows.Close()
+	var n int
+	for rows.Next() {
+		n++
+		var data []byte
+		err = rows.Scan(&data)
+		if err != nil {
+			return []finding{{"error", "metadata", err.Error(), ""}}
+		}
+		if !json.Valid(data) {
+			return []finding{{"error", "metadata", "stored metadata is not valid JSON", "run fkm metadata update"}}
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return []finding{{"error", "metadata", err.Error(), ""}}
+	}
+	switch n {
+	case 0:
+		return []finding{{"error", "metadata", "no metadata stored", "run fkm to fetch metadata"}}
+	case 1:
+		return []finding{{"ok", "metadata", "present", ""}}
+	default:
+		return []finding{{"warn", "metadata", fmt.Sprintf("%d metadata rows stored", n), "run fkm metadata update to replace them"}}
+	}
+}
+
+func checkSmartLayers(db *sql.DB) []finding {
+	ok, err := hasTable(db, "smart_layer")
+	if err != nil {
+		return []finding{{"error", "smart layers", err.Error(), ""}}
+	}
+	if !ok {
+		return nil
+	}
+	rows, err := db.Query(`SELECT id, app, revisionId FROM smart_layer WHERE revisionId NOT IN (SELECT revisionId FROM revision) ORDER BY id`)
+	if err != nil {
+		return []finding{{"error", "smart layers", err.Error(), ""}}
+	}
+	defer rows.Close()
+	var findings []finding
+	for rows.Next() {
+		var (
+			id       int
+			app, rev string
+		)
+		err = rows.Scan(&id, &app, &rev)
+		if err != nil {
+			return append(findings, finding{"error", "smart layers", err.Error(), ""})
+		}
+		findings = append(findings, finding{"warn", "smart layers", fmt.Sprintf("smart layer %d for %s references missing revision %s", id, app, rev), "fetch the revision with fkm or remove the rule in keymapp"})
+	}
+	if err = rows.Err(); err != nil {
+		return append(findings, finding{"error", "smart layers", err.Error(), ""})
+	}
+	mismatched, err := smartLayerMismatches(db)
+	if err != nil {
+		return append(findings, finding{"error", "smart layers", err.Error(), ""})
+	}
+	for _, m := range mismatched {
+		level := "warn"
+		if m.geometry {
+			level = "error"
+		}
+		findings = append(findings, finding{level, "smart layers", m.reason, "recreate the rule in keymapp for the layout on the intended keyboard"})
+	}
+	if len(findings) == 0 {
+		return []finding{{"ok", "smart layers", "no orphaned or mismatched smart layers", ""}}
+	}
+	return findings
+}
+
+func checkAPIConfig(db *sql.DB) []finding {
+	ok, err := hasTable(db, "config")
+	if err != nil {
+		return []finding{{"error", "api config", err.Error(), ""}}
+	}
+	if !ok {
+		return nil
+	}
+	rows, err := db.Query(`SELECT key, value FROM config WHERE key IN ('api_enabled', 'api_port')`)
+	if err != nil {
+		return []finding{{"error", "api config", err.Error(), ""}}
+	}
+	defer rows.Close()
+	vals := make(map[string][]string)
+	for rows.Next() {
+		var key, val string
+		err = rows.Scan(&key, &val)
+		if err != nil {
+			return []finding{{"error", "api config", err.Error(), ""}}
+		}
+		vals[key] = append(vals[key], val)
+	}
+	if err = rows.Err(); err != nil {
+		return []finding{{"error", "api config", err.Error(), ""}}
+	}
+
+	var findings []finding
+	for _, key := range []string{"api_enabled", "api_port"} {
+		switch len(vals[key]) {
+		case 0:
+			findings = append(findings, finding{"warn", "api config", key + " is not set", "run fkm to seed default configuration"})
+		case 1:
+		default:
+			findings = append(findings, finding{"warn", "api config", fmt.Sprintf("%s is set %d times", key, len(vals[key])), "remove the duplicate config rows"})
+		}
+	}
+	if v := vals["api_enabled"]; len(v) != 0 && v[0] != "0" && v[0] != "1" {
+		findings = append(findings, finding{"error", "api config", fmt.Sprintf("api_enabled has invalid value %q", v[0]), "set api_enabled to 0 or 1"})
+	}
+	if v := vals["api_port"]; len(v) != 0 {
+		port, err := strconv.Atoi(v[0])
+		if err != nil || port < 1 || port > 65535 {
+			findings = append(findings, finding{"error", "api config", fmt.Sprintf("api_port has invalid value %q", v[0]), "set api_port to a port number"})
+		} else if e := vals["api_enabled"]; len(e) != 0 && e[0] == "1" && port < 1024 {
+			findings = append(findings, finding{"warn", "api config", fmt.Sprintf("api is enabled on privileged port %d", port), "use a port above 1023"})
+		}
+	}
+	if len(findings) == 0 {
+		state := "disabled"
+		if vals["api_enabled"][0] == "1" {
+			state = "enabled on port " + vals["api_port"][0]
+		}
+		return []finding{{"ok", "api config", state, ""}}
+	}
+	return findings
+}
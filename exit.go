@@ -0,0 +1,85 @@
+// Copyright ©2025 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"net"
+
+	"modernc.org/sqlite"
+)
+
+// Exit statuses. Scripts may rely on these values, so they must not be
+// changed.
+const (
+	exitOK       = 0
+	exitFailure  = 1 // failures not covered by another status
+	exitUsage    = 2 // invalid command line
+	exitNetwork  = 3 // the server could not be reached
+	exitAPI      = 4 // the server reported an error
+	exitDatabase = 5 // the database could not be opened, read or written
+	exitVerify   = 6 // a check, checksum or signature failed
+)
+
+// exitHelp describes the exit statuses for usage messages.
+const exitHelp = `Exit status is 0 on success, 2 for usage errors, 3 for network failures,
+4 for errors reported by Oryx or keymapp, 5 for database errors, 6 for
+failed checks or verification, and 1 for other failures.`
+
+// exitError is an error with the exit status fkm terminates with when
+// the error is fatal.
+type exitError struct {
+	status int
+	err    error
+}
+
+func (e exitError) Error() string { return e.err.Error() }
+func (e exitError) Unwrap() error { return e.err }
+
+// dbError marks err as a database failure. A nil error is returned
+// unchanged.
+func dbError(err error) error {
+	if err == nil {
+		return nil
+	}
+	return exitError{status: exitDatabase, err: err}
+}
+
+// usageError is an error for an invalid command line.
+func usageError(msg string) error {
+	return exitError{status: exitUsage, err: errors.New(msg)}
+}
+
+// exitStatus returns the exit status for the fatal error err.
+func exitStatus(err error) int {
+	var e exitError
+	if errors.As(err, &e) {
+		return e.status
+	}
+	var (
+		gqlErr    graphqlErrors
+		httpErr   statusError
+		grpcErr   grpcStatusError
+		netErr    net.Error
+		sqliteErr *sqlite.Error
+		schemaErr schemaErrors
+	)
+	switch {
+	case err == nil:
+		return exitOK
+	case errors.As(err, &gqlErr), errors.As(err, &httpErr), errors.As(err, &grpcErr), errors.Is(err, errResponseTooLarge):
+		return exitAPI
+	// Replayed recordings that fail verification are reported
+	// by the HTTP client as url.Errors, which are net.Errors.
+	case errors.Is(err, errBadSignature), errors.Is(err, errExchangeMismatch), errors.Is(err, errChecksumMismatch), errors.As(err, &schemaErr):
+		return exitVerify
+	case errors.Is(err, errOffline), errors.Is(err, errNotRecorded), errors.As(err, &netErr):
+		return exitNetwork
+	case errors.As(err, &sqliteErr), errors.Is(err, errRevisionNotFound), errors.Is(err, sql.ErrNoRows):
+		return exitDatabase
+	}
+	return exitFailure
+}
@@ -0,0 +1,99 @@
+// Copyright ©2025 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/kortschak/fkm/format"
+)
+
+// export converts a stored revision to another keymap format.
+func export(args []string) {
+	loadFormatPlugins()
+	fs := flag.NewFlagSet("fkm export", flag.ExitOnError)
+	var common commonFlags
+	common.register(fs)
+	revID := fs.String("revision", "", "ID of the stored revision to export (required)")
+	formatName := fs.String("format", "", "export format (required): "+formatNames(format.Exporters()))
+	listFormats := fs.Bool("formats", false, "list the available export formats and exit")
+	out := fs.String("out", "", "path of the file to write (default stdout)")
+	provOut := fs.String("provenance", "", "path of a JSON file to write the revision's provenance records to")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: fkm export -revision <id> -format <format> [flags]\n\nFormats:\n")
+		formatUsage(fs.Output(), format.Exporters())
+		fmt.Fprintf(fs.Output(), "\nFlags:\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	common.setup(fs)
+	if *listFormats {
+		if fs.NArg() != 0 {
+			fs.Usage()
+			os.Exit(exitUsage)
+		}
+		printFormats(format.Exporters(), common.json)
+		return
+	}
+	f, ok := format.LookupExporter(*formatName)
+	if *revID == "" || !ok || fs.NArg() != 0 {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+
+	db := openExisting(common.dbPath, true, common.db)
+	defer db.Close()
+	l, err := loadLayout(db, *revID)
+	if err != nil {
+		fatal("failed to read revision", err, "revision", *revID)
+	}
+
+	var buf bytes.Buffer
+	err = f.Export(&buf, l)
+	if err != nil {
+		fatal("failed to export revision", err, "revision", *revID, "format", *formatName)
+	}
+	if *out == "" {
+		_, err = os.Stdout.Write(buf.Bytes())
+	} else {
+		err = os.WriteFile(*out, buf.Bytes(), 0o644)
+	}
+	if err != nil {
+		fatal("failed to write export", err, "path", *out)
+	}
+	if *provOut != "" {
+		err = writeProvenance(db, *revID, *provOut)
+		if err != nil {
+			fatal("failed to write provenance", err, "path", *provOut)
+		}
+	}
+}
+
+// writeProvenance writes the provenance records of the revision with
+// the given ID to the file at path as JSON so that exports can be
+// traced to the source of the revision they were made from.
+func writeProvenance(db *sql.DB, id, path string) error {
+	provs, err := revisionProvenance(db)
+	if err != nil {
+		return err
+	}
+	p := provs[id]
+	if p == nil {
+		p = []provenance{}
+	}
+	b, err := json.MarshalIndent(struct {
+		Revision   string       `json:"revision"`
+		Provenance []provenance `json:"provenance"`
+	}{id, p}, "", "\t")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(b, '\n'), 0o644)
+}
@@ -0,0 +1,308 @@
+// Copyright ©2025 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"time"
+)
+
+// firewallRules writes application firewall rules that block keymapp's
+// outbound traffic while allowing fkm to reach its endpoints.
+func firewallRules(args []string) {
+	fs := flag.NewFlagSet("fkm firewall-rules", flag.ExitOnError)
+	var (
+		common commonFlags
+		net    netFlags
+	)
+	common.register(fs)
+	net.register(fs)
+	format := fs.String("format", "", "rule format: opensnitch or littlesnitch (required)")
+	keymappPath := fs.String("keymapp", "", "path to the keymapp executable (default found in PATH)")
+	fkmPath := fs.String("fkm", "", "path to the fkm executable (default this executable)")
+	out := fs.String("out", "", "directory to write OpenSnitch rule files to, or file to write Little Snitch rules to (default current directory or standard output)")
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), `usage: fkm firewall-rules -format opensnitch|littlesnitch [flags]
+
+Write application firewall rules that deny all outbound connections made
+by keymapp, other than to the local host, and allow fkm to connect only
+to the GraphQL and metadata endpoints, or the proxy, given by the network
+flags. OpenSnitch rules are written as one file per rule, to be copied to
+/etc/opensnitchd/rules. Little Snitch rules are written as an .lsrules
+file to be imported or subscribed to.
+
+Flags:
+`)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	common.setup(fs)
+	if fs.NArg() != 0 || (*format != "opensnitch" && *format != "littlesnitch") {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+
+	keymapp := *keymappPath
+	if keymapp == "" {
+		var err error
+		keymapp, err = findKeymapp()
+		if err != nil {
+			fatal("failed to find keymapp", exitError{status: exitUsage, err: err}, "hint", "use -keymapp to give its path")
+		}
+	}
+	self := *fkmPath
+	if self == "" {
+		var err error
+		self, err = os.Executable()
+		if err != nil {
+			fatal("failed to find fkm executable", exitError{status: exitUsage, err: err}, "hint", "use -fkm to give its path")
+		}
+	}
+	for _, p := range []*string{&keymapp, &self} {
+		abs, err := filepath.Abs(*p)
+		if err == nil {
+			*p = abs
+		}
+	}
+
+	var endpoints []string
+	if net.proxy != "" {
+		endpoints = []string{net.proxy}
+	} else {
+		endpoints = []string{net.graphqlURL, net.metadataURL}
+	}
+	var allowed []firewallEndpoint
+	for _, e := range endpoints {
+		ep, err := parseFirewallEndpoint(e)
+		if err != nil {
+			fatal("invalid endpoint", exitError{status: exitUsage, err: err}, "url", e)
+		}
+		if !slices.Contains(allowed, ep) {
+			allowed = append(allowed, ep)
+		}
+	}
+
+	switch *format {
+	case "opensnitch":
+		dir := *out
+		if dir == "" {
+			dir = "."
+		}
+		err := os.MkdirAll(dir, 0o750)
+		if err != nil {
+			fatal("failed to create rule directory", err, "path", dir)
+		}
+		for _, r := range openSnitchRules(keymapp, self, allowed, time.Now()) {
+			b, err := json.MarshalIndent(r, "", "  ")
+			if err != nil {
+				fatal("failed to encode rule", err, "rule", r.Name)
+			}
+			path := filepath.Join(dir, r.Name+".json")
+			err = os.WriteFile(path, append(b, '\n'), 0o644)
+			if err != nil {
+				fatal("failed to write rule", err, "path", path)
+			}
+			fmt.Println(path)
+		}
+	case "littlesnitch":
+		b, err := json.MarshalIndent(littleSnitchRules(keymapp, self, allowed), "", "  ")
+		if err != nil {
+			fatal("failed to encode rules", err)
+		}
+		b = append(b, '\n')
+		if *out == "" {
+			os.Stdout.Write(b)
+			return
+		}
+		err = os.WriteFile(*out, b, 0o644)
+		if err != nil {
+			fatal("failed to write rules", err, "path", *out)
+		}
+	}
+}
+
+// findKeymapp returns the path of the keymapp executable.
+func findKeymapp() (string, error) {
+	path, err := exec.LookPath("keymapp")
+	if err == nil {
+		return path, nil
+	}
+	if runtime.GOOS == "darwin" {
+		const bundled = "/Applications/keymapp.app/Contents/MacOS/keymapp"
+		if _, err := os.Stat(bundled); err == nil {
+			return bundled, nil
+		}
+	}
+	return "", errors.New("keymapp is not in PATH")
+}
+
+// firewallEndpoint is a host and port that fkm is allowed to connect to.
+type firewallEndpoint struct {
+	host string
+	port string
+}
+
+// parseFirewallEndpoint returns the host and port of the URL addr.
+func parseFirewallEndpoint(addr string) (firewallEndpoint, error) {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return firewallEndpoint{}, err
+	}
+	ep := firewallEndpoint{host: u.Hostname(), port: u.Port()}
+	if ep.host == "" {
+		return firewallEndpoint{}, fmt.Errorf("no host in %q", addr)
+	}
+	if ep.port == "" {
+		switch u.Scheme {
+		case "http":
+			ep.port = "80"
+		case "https", "":
+			ep.port = "443"
+		default:
+			return firewallEndpoint{}, fmt.Errorf("unknown scheme %q in %q", u.Scheme, addr)
+		}
+	}
+	return ep, nil
+}
+
+// openSnitchRule is an OpenSnitch rule file.
+type openSnitchRule struct {
+	Created     time.Time          `json:"created"`
+	Updated     time.Time          `json:"updated"`
+	Name        string             `json:"name"`
+	Description string             `json:"description"`
+	Enabled     bool               `json:"enabled"`
+	Precedence  bool               `json:"precedence"`
+	Nolog       bool               `json:"nolog"`
+	Action      string             `json:"action"`
+	Duration    string             `json:"duration"`
+	Operator    openSnitchOperator `json:"operator"`
+}
+
+// openSnitchOperator is the condition of an OpenSnitch rule.
+type openSnitchOperator struct {
+	Type      string               `json:"type"`
+	Operand   string               `json:"operand"`
+	Sensitive bool                 `json:"sensitive"`
+	Data      string               `json:"data"`
+	List      []openSnitchOperator `json:"list"`
+}
+
+// openSnitchRules returns OpenSnitch rules allowing fkm to connect to
+// the allowed endpoints and keymapp to connect to the local host, and
+// denying all other connections by keymapp. Rules are applied in order
+// of their names, and the allow rules take precedence over others.
+func openSnitchRules(keymapp, fkm string, allowed []firewallEndpoint, now time.Time) []openSnitchRule {
+	now = now.UTC().Truncate(time.Second)
+	simple := func(operand, data string) openSnitchOperator {
+		return openSnitchOperator{Type: "simple", Operand: operand, Data: data}
+	}
+	all := func(ops ...openSnitchOperator) openSnitchOperator {
+		return openSnitchOperator{Type: "list", Operand: "list", List: ops}
+	}
+	rule := func(name, desc, action string, precedence bool, op openSnitchOperator) openSnitchRule {
+		return openSnitchRule{
+			Created:     now,
+			Updated:     now,
+			Name:        name,
+			Description: desc,
+			Enabled:     true,
+			Precedence:  precedence,
+			Action:      action,
+			Duration:    "always",
+			Operator:    op,
+		}
+	}
+	var rules []openSnitchRule
+	for _, ep := range allowed {
+		dest := simple("dest.host", ep.host)
+		if ip := net.ParseIP(ep.host); ip != nil {
+			dest = simple("dest.ip", ep.host)
+		}
+		rules = append(rules, rule(
+			"000-fkm-allow-"+strings.ReplaceAll(ep.host, ".", "-")+"-"+ep.port,
+			fmt.Sprintf("Allow fkm to connect to %s port %s.", ep.host, ep.port),
+			"allow", true,
+			all(simple("process.path", fkm), dest, simple("dest.port", ep.port)),
+		))
+	}
+	loopback := simple("dest.ip", `^(127\.|::1$)`)
+	loopback.Type = "regexp"
+	rules = append(rules,
+		rule("001-keymapp-allow-localhost", "Allow keymapp to connect to the local host, for example to fkm serve.", "allow", true,
+			all(simple("process.path", keymapp), loopback)),
+		rule("002-keymapp-deny", "Deny all other connections by keymapp; fkm fetches its layouts and metadata.", "deny", false,
+			simple("process.path", keymapp)),
+	)
+	return rules
+}
+
+// littleSnitchRuleGroup is a Little Snitch .lsrules file.
+type littleSnitchRuleGroup struct {
+	Name        string             `json:"name"`
+	Description string             `json:"description"`
+	Rules       []littleSnitchRule `json:"rules"`
+}
+
+// littleSnitchRule is a rule in a Little Snitch .lsrules file.
+type littleSnitchRule struct {
+	Process         string   `json:"process"`
+	Action          string   `json:"action"`
+	Direction       string   `json:"direction"`
+	Priority        string   `json:"priority,omitempty"`
+	Remote          string   `json:"remote,omitempty"`
+	RemoteHosts     []string `json:"remote-hosts,omitempty"`
+	RemoteAddresses []string `json:"remote-addresses,omitempty"`
+	Ports           string   `json:"ports,omitempty"`
+	Protocol        string   `json:"protocol,omitempty"`
+	Notes           string   `json:"notes,omitempty"`
+}
+
+// littleSnitchRules returns Little Snitch rules allowing fkm to connect
+// to the allowed endpoints and denying all outbound connections by
+// keymapp. Little Snitch does not filter connections to the local host.
+func littleSnitchRules(keymapp, fkm string, allowed []firewallEndpoint) littleSnitchRuleGroup {
+	g := littleSnitchRuleGroup{
+		Name:        "fkm",
+		Description: "Deny keymapp network access and allow fkm to fetch its layouts and metadata.",
+	}
+	for _, ep := range allowed {
+		r := littleSnitchRule{
+			Process:   fkm,
+			Action:    "allow",
+			Direction: "outgoing",
+			Priority:  "high",
+			Ports:     ep.port,
+			Protocol:  "tcp",
+			Notes:     fmt.Sprintf("Allow fkm to connect to %s port %s.", ep.host, ep.port),
+		}
+		if net.ParseIP(ep.host) != nil {
+			r.RemoteAddresses = []string{ep.host}
+		} else {
+			r.RemoteHosts = []string{ep.host}
+		}
+		g.Rules = append(g.Rules, r)
+	}
+	g.Rules = append(g.Rules, littleSnitchRule{
+		Process:   keymapp,
+		Action:    "deny",
+		Direction: "outgoing",
+		Priority:  "high",
+		Remote:    "any",
+		Notes:     "Deny all connections by keymapp; fkm fetches its layouts and metadata.",
+	})
+	return g
+}
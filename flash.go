@@ -0,0 +1,515 @@
+// Copyright ©2025 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// flash writes a firmware image to a ZSA keyboard in bootloader mode.
+func flash(args []string) {
+	fs := flag.NewFlagSet("fkm flash", flag.ExitOnError)
+	var common commonFlags
+	common.register(fs)
+	firmware := fs.String("firmware", "", "path to the firmware image, a .bin or Intel .hex file, or cache:<file name> for a previously flashed image (required)")
+	cacheDir := fs.String("cache-dir", defaultCacheDir(), "directory for cached downloads (empty to disable caching)")
+	wait := fs.Duration("wait", time.Minute, "time to wait for a keyboard to enter its bootloader")
+	geometry := fs.String("geometry", "", "geometry the firmware is for; flashing fails if the keyboard in the bootloader differs")
+	fs.Parse(args)
+	common.setup(fs)
+	if *firmware == "" || fs.NArg() != 0 {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+
+	img, err := readFirmware(*firmware, objectCache{dir: *cacheDir})
+	if err != nil {
+		fatal("failed to read firmware", err, "path", *firmware)
+	}
+	if len(img.data) == 0 {
+		fatal("failed to read firmware", errors.New("empty image"), "path", *firmware)
+	}
+
+	geoms, err := attachedGeometries()
+	if err == nil && len(geoms) != 0 {
+		fmt.Fprintf(os.Stderr, "reset the keyboard to enter its bootloader (attached: %s)\n", strings.Join(geoms, ", "))
+	} else {
+		fmt.Fprintln(os.Stderr, "reset the keyboard to enter its bootloader")
+	}
+	bl, err := waitForBootloader(*wait)
+	if err != nil {
+		fatal("failed to find keyboard bootloader", err)
+	}
+	slog.Info("found bootloader", "geometry", bl.geometry, "protocol", bl.protocol)
+	if *geometry != "" && *geometry != bl.geometry {
+		fatal("refusing to flash firmware", fmt.Errorf("firmware is for %s but bootloader is for %s", *geometry, bl.geometry))
+	}
+
+	switch bl.protocol {
+	case "dfuse":
+		err = flashDfuSe(bl, img, &common)
+	case "halfkay":
+		err = flashHalfKay(bl, img, &common)
+	}
+	if err != nil {
+		fatal("failed to flash firmware", err, "geometry", bl.geometry)
+	}
+	fmt.Fprintf(os.Stderr, "flashed %s firmware to %s\n", formatBytes(int64(len(img.data))), bl.geometry)
+}
+
+// bootloader is a ZSA keyboard bootloader.
+type bootloader struct {
+	geometry string
+	vendor   uint32
+	product  uint32
+	protocol string // "dfuse" or "halfkay"
+
+	// address is the flash address that firmware is written
+	// to when the image does not specify one.
+	address uint32
+	// pageSize is the erase page size for DfuSe devices and the
+	// block size for HalfKay devices.
+	pageSize int
+	// flashSize is the size of the flash available to firmware.
+	flashSize int
+}
+
+// bootloaders are the USB bootloaders of ZSA keyboards. The Moonlander
+// uses the STM32 system DFU bootloader, the Voyager uses ZSA's DfuSe
+// bootloader and the ErgoDox EZ uses the Teensy HalfKay bootloader.
+var bootloaders = []bootloader{
+	{geometry: "moonlander", vendor: 0x0483, product: 0xdf11, protocol: "dfuse", address: 0x08000000, pageSize: 2048, flashSize: 256 << 10},
+	{geometry: "voyager", vendor: zsaVendorID, product: 0x0791, protocol: "dfuse", address: 0x08002000, pageSize: 2048, flashSize: 248 << 10},
+	{geometry: "ergodox-ez", vendor: 0x16c0, product: 0x0478, protocol: "halfkay", address: 0, pageSize: 128, flashSize: 32256},
+}
+
+// waitForBootloader polls the attached USB devices until a ZSA
+// bootloader appears or the wait time has elapsed.
+func waitForBootloader(wait time.Duration) (bootloader, error) {
+	deadline := time.Now().Add(wait)
+	for {
+		devs, err := usbDevices()
+		if err != nil {
+			return bootloader{}, err
+		}
+		for _, d := range devs {
+			i := slices.IndexFunc(bootloaders, func(b bootloader) bool {
+				return b.vendor == d.vendor && b.product == d.product
+			})
+			if i >= 0 {
+				return bootloaders[i], nil
+			}
+		}
+		if time.Now().After(deadline) {
+			return bootloader{}, fmt.Errorf("no bootloader found after %v", wait)
+		}
+		time.Sleep(250 * time.Millisecond)
+	}
+}
+
+// firmwareImage is a contiguous firmware image.
+type firmwareImage struct {
+	// address is the load address of the image, or -1 if the
+	// image does not specify one.
+	address int64
+	data    []byte
+}
+
+// readFirmware reads a raw binary or Intel hex firmware image. Images
+// are added to the cache, and paths of the form cache:<file name> are
+// read from it.
+func readFirmware(path string, cache objectCache) (firmwareImage, error) {
+	var (
+		b   []byte
+		err error
+	)
+	if name, ok := strings.CutPrefix(path, "cache:"); ok {
+		if cache.dir == "" {
+			return firmwareImage{}, errors.New("no cache directory")
+		}
+		path = name
+		b, err = cache.get("firmware", filepath.Base(name))
+		if err == nil && b == nil {
+			err = fmt.Errorf("%s is not in the cache", name)
+		}
+	} else {
+		b, err = os.ReadFile(path)
+		if err == nil && cache.dir != "" {
+			_, cerr := cache.put(b, "firmware", filepath.Base(path))
+			if cerr != nil {
+				slog.Warn("failed to cache firmware", "error", cerr, "path", path)
+			}
+		}
+	}
+	if err != nil {
+		return firmwareImage{}, err
+	}
+	if strings.EqualFold(filepath.Ext(path), ".hex") {
+		return parseIntelHex(b)
+	}
+	return firmwareImage{address: -1, data: b}, nil
+}
+
+// parseIntelHex parses an Intel hex file into a contiguous image. Gaps
+// between records are filled with 0xff, the value of erased flash.
+func parseIntelHex(b []byte) (firmwareImage, error) {
+	type record struct {
+		addr uint32
+		data []byte
+	}
+	var (
+		records []record
+		base    uint32
+	)
+	sc := bufio.NewScanner(bytes.NewReader(b))
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" {
+			continue
+		}
+		if !strings.HasPrefix(line, ":") {
+			return firmwareImage{}, fmt.Errorf("line %d: missing start code", n)
+		}
+		rec, err := hex.DecodeString(line[1:])
+		if err != nil {
+			return firmwareImage{}, fmt.Errorf("line %d: %w", n, err)
+		}
+		if len(rec) < 5 || len(rec) != int(rec[0])+5 {
+			return firmwareImage{}, fmt.Errorf("line %d: invalid record length", n)
+		}
+		var sum byte
+		for _, c := range rec {
+			sum += c
+		}
+		if sum != 0 {
+			return firmwareImage{}, fmt.Errorf("line %d: checksum mismatch", n)
+		}
+		data := rec[4 : len(rec)-1]
+		switch rec[3] {
+		case 0x00: // Data.
+			addr := base + uint32(binary.BigEndian.Uint16(rec[1:3]))
+			records = append(records, record{addr: addr, data: data})
+		case 0x01: // End of file.
+			if len(records) == 0 {
+				return firmwareImage{}, errors.New("no data records")
+			}
+			lo, hi := ^uint32(0), uint32(0)
+			for _, r := range records {
+				lo = min(lo, r.addr)
+				hi = max(hi, r.addr+uint32(len(r.data)))
+			}
+			const maxImage = 16 << 20
+			if hi-lo > maxImage {
+				return firmwareImage{}, fmt.Errorf("image spans %d bytes", hi-lo)
+			}
+			img := bytes.Repeat([]byte{0xff}, int(hi-lo))
+			for _, r := range records {
+				copy(img[r.addr-lo:], r.data)
+			}
+			return firmwareImage{address: int64(lo), data: img}, nil
+		case 0x02: // Extended segment address.
+			if len(data) != 2 {
+				return firmwareImage{}, fmt.Errorf("line %d: invalid segment address", n)
+			}
+			base = uint32(binary.BigEndian.Uint16(data)) << 4
+		case 0x04: // Extended linear address.
+			if len(data) != 2 {
+				return firmwareImage{}, fmt.Errorf("line %d: invalid linear address", n)
+			}
+			base = uint32(binary.BigEndian.Uint16(data)) << 16
+		case 0x03, 0x05: // Start addresses are not needed for flashing.
+		default:
+			return firmwareImage{}, fmt.Errorf("line %d: unknown record type %#02x", n, rec[3])
+		}
+	}
+	err := sc.Err()
+	if err != nil {
+		return firmwareImage{}, err
+	}
+	return firmwareImage{}, errors.New("missing end of file record")
+}
+
+// DFU class requests and states.
+const (
+	dfuDnload    = 1
+	dfuGetStatus = 3
+	dfuClrStatus = 4
+	dfuAbort     = 6
+
+	dfuStateIdle       = 2
+	dfuStateDnloadBusy = 4
+	dfuStateDnloadIdle = 5
+	dfuStateManifest   = 7
+	dfuStateError      = 10
+
+	// DfuSe commands sent in block zero downloads.
+	dfuseSetAddress = 0x21
+	dfuseErase      = 0x41
+)
+
+// usbTimeout is the timeout for USB control transfers.
+const usbTimeout = 5 * time.Second
+
+// flashDfuSe writes img to a DfuSe bootloader, erasing the pages it
+// covers, and then starts the new firmware.
+func flashDfuSe(bl bootloader, img firmwareImage, common *commonFlags) error {
+	addr := bl.address
+	if img.address >= 0 {
+		addr = uint32(img.address)
+	}
+	if addr < bl.address || int(addr-bl.address)+len(img.data) > bl.flashSize {
+		return fmt.Errorf("image at %#08x of %d bytes does not fit flash at %#08x of %d bytes", addr, len(img.data), bl.address, bl.flashSize)
+	}
+
+	dev, err := openUSB(bl.vendor, bl.product, 0, false)
+	if err != nil {
+		return err
+	}
+	defer dev.Close()
+	d := dfuDevice{dev}
+
+	err = d.reset()
+	if err != nil {
+		return err
+	}
+	transfer, err := dev.dfuTransferSize()
+	if err != nil {
+		slog.Debug("failed to read dfu transfer size", "error", err)
+	}
+	if transfer <= 0 {
+		transfer = 1024
+	}
+	slog.Debug("dfu transfer size", "bytes", transfer)
+	p := common.progress("flashing", (len(img.data)+transfer-1)/transfer)
+	defer p.finish()
+
+	for a := addr &^ uint32(bl.pageSize-1); a < addr+uint32(len(img.data)); a += uint32(bl.pageSize) {
+		err = d.command(dfuseErase, a)
+		if err != nil {
+			return fmt.Errorf("failed to erase page at %#08x: %w", a, err)
+		}
+	}
+	err = d.command(dfuseSetAddress, addr)
+	if err != nil {
+		return fmt.Errorf("failed to set address: %w", err)
+	}
+	for i, off := 0, 0; off < len(img.data); i, off = i+1, off+transfer {
+		chunk := img.data[off:min(off+transfer, len(img.data))]
+		// DfuSe data blocks start at block number 2; the
+		// address is the set address plus (block-2)*transfer.
+		err = d.download(uint16(i+2), chunk)
+		if err != nil {
+			return fmt.Errorf("failed to write block at %#08x: %w", addr+uint32(off), err)
+		}
+		p.received(len(chunk))
+		p.step()
+	}
+
+	// Leave DFU mode by setting the start address and sending an
+	// empty download. The device resets during manifestation, so
+	// errors are expected.
+	err = d.command(dfuseSetAddress, addr)
+	if err != nil {
+		return fmt.Errorf("failed to set start address: %w", err)
+	}
+	_, err = dev.control(0x21, dfuDnload, 0, 0, nil, usbTimeout)
+	if err == nil {
+		d.status()
+	}
+	return nil
+}
+
+// dfuDevice is a USB device in DFU mode.
+type dfuDevice struct {
+	dev usbConn
+}
+
+// dfuStatus is a DFU_GETSTATUS reply.
+type dfuStatus struct {
+	status      byte
+	pollTimeout time.Duration
+	state       byte
+}
+
+// status returns the device's DFU status.
+func (d dfuDevice) status() (dfuStatus, error) {
+	var buf [6]byte
+	n, err := d.dev.control(0xa1, dfuGetStatus, 0, 0, buf[:], usbTimeout)
+	if err != nil {
+		return dfuStatus{}, err
+	}
+	if n != len(buf) {
+		return dfuStatus{}, fmt.Errorf("short dfu status: %d bytes", n)
+	}
+	poll := uint32(buf[1]) | uint32(buf[2])<<8 | uint32(buf[3])<<16
+	return dfuStatus{status: buf[0], pollTimeout: time.Duration(poll) * time.Millisecond, state: buf[4]}, nil
+}
+
+// reset returns the device to the idle state.
+func (d dfuDevice) reset() error {
+	s, err := d.status()
+	if err != nil {
+		return err
+	}
+	switch s.state {
+	case dfuStateIdle:
+		return nil
+	case dfuStateError:
+		_, err = d.dev.control(0x21, dfuClrStatus, 0, 0, nil, usbTimeout)
+	default:
+		_, err = d.dev.control(0x21, dfuAbort, 0, 0, nil, usbTimeout)
+	}
+	if err != nil {
+		return err
+	}
+	s, err = d.status()
+	if err != nil {
+		return err
+	}
+	if s.state != dfuStateIdle {
+		return fmt.Errorf("dfu device is in state %d after reset", s.state)
+	}
+	return nil
+}
+
+// command sends a DfuSe command with an address argument.
+func (d dfuDevice) command(cmd byte, addr uint32) error {
+	return d.download(0, binary.LittleEndian.AppendUint32([]byte{cmd}, addr))
+}
+
+// download sends a block to the device and waits for it to be
+// processed.
+func (d dfuDevice) download(block uint16, data []byte) error {
+	_, err := d.dev.control(0x21, dfuDnload, block, 0, data, usbTimeout)
+	if err != nil {
+		return err
+	}
+	for {
+		s, err := d.status()
+		if err != nil {
+			return err
+		}
+		if s.status != 0 {
+			return fmt.Errorf("dfu error status %d in state %d", s.status, s.state)
+		}
+		switch s.state {
+		case dfuStateDnloadIdle, dfuStateIdle:
+			return nil
+		case dfuStateDnloadBusy, dfuStateManifest:
+			time.Sleep(s.pollTimeout)
+		default:
+			return fmt.Errorf("unexpected dfu state %d", s.state)
+		}
+	}
+}
+
+// flashHalfKay writes img to a Teensy HalfKay bootloader and then
+// reboots into the new firmware.
+func flashHalfKay(bl bootloader, img firmwareImage, common *commonFlags) error {
+	if img.address > 0 {
+		return fmt.Errorf("image address %#x is not zero", img.address)
+	}
+	if len(img.data) > bl.flashSize {
+		return fmt.Errorf("image of %d bytes does not fit flash of %d bytes", len(img.data), bl.flashSize)
+	}
+
+	// The bootloader is a HID device, so the kernel driver must be
+	// detached.
+	dev, err := openUSB(bl.vendor, bl.product, 0, true)
+	if err != nil {
+		return err
+	}
+	defer dev.Close()
+
+	p := common.progress("flashing", (len(img.data)+bl.pageSize-1)/bl.pageSize)
+	defer p.finish()
+	buf := make([]byte, bl.pageSize+2)
+	for addr := 0; addr < len(img.data); addr += bl.pageSize {
+		block := img.data[addr:min(addr+bl.pageSize, len(img.data))]
+		// Blank blocks after the first need not be written; the
+		// first write erases the whole chip.
+		if addr != 0 && !slices.ContainsFunc(block, func(b byte) bool { return b != 0xff }) {
+			p.step()
+			continue
+		}
+		binary.LittleEndian.PutUint16(buf, uint16(addr))
+		n := copy(buf[2:], block)
+		for i := 2 + n; i < len(buf); i++ {
+			buf[i] = 0xff
+		}
+		err = halfKayWrite(dev, buf, addr == 0)
+		if err != nil {
+			return fmt.Errorf("failed to write block at %#04x: %w", addr, err)
+		}
+		p.received(len(block))
+		p.step()
+	}
+
+	// Writing to address 0xffff reboots into the firmware. The
+	// device disconnects, so errors are expected.
+	binary.LittleEndian.PutUint16(buf, 0xffff)
+	clear(buf[2:])
+	halfKayWrite(dev, buf, false)
+	return nil
+}
+
+// halfKayWrite sends a block to the HalfKay bootloader as a HID output
+// report, retrying while the bootloader is busy. The first block
+// triggers a chip erase, so it is given longer to complete.
+func halfKayWrite(dev usbConn, buf []byte, first bool) error {
+	wait := 500 * time.Millisecond
+	if first {
+		wait = 5 * time.Second
+	}
+	deadline := time.Now().Add(wait)
+	for {
+		// SET_REPORT, output report zero.
+		_, err := dev.control(0x21, 9, 0x0200, 0, buf, usbTimeout)
+		if err == nil || time.Now().After(deadline) {
+			return err
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// usbConn is an open USB device.
+type usbConn interface {
+	// control performs a control transfer on the default endpoint,
+	// returning the number of bytes transferred.
+	control(reqType, req uint8, value, index uint16, data []byte, timeout time.Duration) (int, error)
+	// dfuTransferSize returns the wTransferSize of the device's
+	// DFU functional descriptor.
+	dfuTransferSize() (int, error)
+	Close() error
+}
+
+// dfuTransferSize returns the wTransferSize of the DFU functional
+// descriptor in the raw device and configuration descriptors.
+func dfuTransferSize(desc []byte) (int, error) {
+	for len(desc) >= 2 {
+		l := int(desc[0])
+		if l < 2 || l > len(desc) {
+			break
+		}
+		// DFU functional descriptor type.
+		if desc[1] == 0x21 && l >= 7 {
+			return int(binary.LittleEndian.Uint16(desc[5:7])), nil
+		}
+		desc = desc[l:]
+	}
+	return 0, errors.New("no dfu functional descriptor")
+}
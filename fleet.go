@@ -0,0 +1,286 @@
+// Copyright ©2025 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// dbTarget is a keymapp database written by a run of update.
+type dbTarget struct {
+	name string // name used for logging and the target's backups
+	path string
+
+	db      *sql.DB
+	changes []change // changes to make to db
+}
+
+// readFleet returns the database targets listed in the fleet manifest
+// at path. The manifest is a YAML document listing the keymapp
+// databases to populate, for example
+//
+//	targets:
+//	  - name: ws01
+//	    path: /srv/images/ws01/home/user/.config/.keymapp/keymapp.sqlite3
+//	  - name: ws02
+//	    path: /srv/images/ws02/home/user/.config/.keymapp/keymapp.sqlite3
+//
+// Relative paths are relative to the directory holding the manifest.
+// Names are optional and default to the position of the target in the
+// list of databases being written.
+func readFleet(path string) ([]dbTarget, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	targets, err := parseFleet(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("%s: no targets", path)
+	}
+	dir := filepath.Dir(path)
+	for i := range targets {
+		t := &targets[i]
+		if t.path == "" {
+			return nil, fmt.Errorf("%s: target %d has no path", path, i+1)
+		}
+		t.path = expandHome(t.path)
+		if !filepath.IsAbs(t.path) {
+			t.path = filepath.Join(dir, t.path)
+		}
+	}
+	return targets, nil
+}
+
+// parseFleet parses the subset of YAML used by fleet manifests: a
+// targets key holding a block sequence of mappings with name and path
+// keys. Values may be plain, single-quoted or double-quoted scalars,
+// and comments and blank lines are ignored.
+func parseFleet(r io.Reader) ([]dbTarget, error) {
+	var (
+		targets  []dbTarget
+		inList   bool
+		keyLevel int // indentation of the keys of the current item
+	)
+	sc := bufio.NewScanner(r)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimRight(sc.Text(), " \t\r")
+		trimmed := strings.TrimLeft(line, " ")
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") || (n == 1 && line == "---") {
+			continue
+		}
+		if strings.HasPrefix(trimmed, "\t") {
+			return nil, fmt.Errorf("line %d: tabs are not allowed in indentation", n)
+		}
+		indent := len(line) - len(trimmed)
+		item, isItem := strings.CutPrefix(trimmed, "-")
+		isItem = inList && isItem && (item == "" || item[0] == ' ')
+		if indent == 0 && !isItem {
+			key, val, err := fleetKeyValue(trimmed)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", n, err)
+			}
+			if key != "targets" {
+				return nil, fmt.Errorf("line %d: unknown key %q", n, key)
+			}
+			if val != "" {
+				return nil, fmt.Errorf("line %d: targets must be a list", n)
+			}
+			if inList {
+				return nil, fmt.Errorf("line %d: repeated key %q", n, key)
+			}
+			inList = true
+			continue
+		}
+		if !inList {
+			return nil, fmt.Errorf("line %d: unexpected indentation", n)
+		}
+		if isItem {
+			targets = append(targets, dbTarget{})
+			rest := strings.TrimLeft(item, " ")
+			keyLevel = indent + 1 + len(item) - len(rest)
+			if rest == "" {
+				keyLevel = -1 // set by the item's first key
+				continue
+			}
+			trimmed, indent = rest, keyLevel
+		}
+		if len(targets) == 0 {
+			return nil, fmt.Errorf("line %d: expected a list item", n)
+		}
+		if keyLevel < 0 {
+			keyLevel = indent
+		}
+		if indent != keyLevel {
+			return nil, fmt.Errorf("line %d: unexpected indentation", n)
+		}
+		key, val, err := fleetKeyValue(trimmed)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		t := &targets[len(targets)-1]
+		var dst *string
+		switch key {
+		case "name":
+			dst = &t.name
+		case "path":
+			dst = &t.path
+		default:
+			return nil, fmt.Errorf("line %d: unknown key %q", n, key)
+		}
+		if *dst != "" {
+			return nil, fmt.Errorf("line %d: repeated key %q", n, key)
+		}
+		*dst = val
+	}
+	return targets, sc.Err()
+}
+
+// fleetKeyValue returns the key and scalar value of a YAML mapping
+// entry.
+func fleetKeyValue(entry string) (key, val string, err error) {
+	key, val, ok := strings.Cut(entry, ":")
+	if !ok || key == "" || (val != "" && val[0] != ' ') {
+		return "", "", fmt.Errorf("invalid entry %q", entry)
+	}
+	val = strings.TrimLeft(val, " ")
+	switch {
+	case val == "":
+		return key, "", nil
+	case val[0] == '"':
+		end := strings.LastIndexByte(val, '"')
+		if end == 0 || !isFleetComment(val[end+1:]) {
+			return "", "", fmt.Errorf("invalid quoted value for %s", key)
+		}
+		val, err = strconv.Unquote(val[:end+1])
+		if err != nil {
+			return "", "", fmt.Errorf("invalid quoted value for %s: %w", key, err)
+		}
+		return key, val, nil
+	case val[0] == '\'':
+		end := strings.LastIndexByte(val, '\'')
+		if end == 0 || !isFleetComment(val[end+1:]) {
+			return "", "", fmt.Errorf("invalid quoted value for %s", key)
+		}
+		return key, strings.ReplaceAll(val[1:end], "''", "'"), nil
+	}
+	if i := strings.Index(val, " #"); i >= 0 {
+		val = strings.TrimRight(val[:i], " ")
+	}
+	return key, val, nil
+}
+
+// isFleetComment returns whether s, following a quoted scalar, is
+// empty or a comment.
+func isFleetComment(s string) bool {
+	s = strings.TrimLeft(s, " ")
+	return s == "" || s[0] == '#'
+}
+
+// checkTargets names unnamed targets by their position and returns an
+// error if a name is not usable as a backup directory name or targets
+// repeat a name or a database path.
+func checkTargets(targets []dbTarget) error {
+	names := make(map[string]bool)
+	paths := make(map[string]bool)
+	for i := range targets {
+		t := &targets[i]
+		if t.name == "" {
+			t.name = strconv.Itoa(i + 1)
+		}
+		if t.name == "." || strings.ContainsAny(t.name, `/\`) || strings.Contains(t.name, "..") {
+			return fmt.Errorf("invalid target name %q: names must not contain path separators or ..", t.name)
+		}
+		if names[t.name] {
+			return fmt.Errorf("repeated target name %q", t.name)
+		}
+		names[t.name] = true
+		abs, err := filepath.Abs(t.path)
+		if err != nil {
+			abs = t.path
+		}
+		if paths[abs] {
+			return fmt.Errorf("repeated database path %q", t.path)
+		}
+		paths[abs] = true
+	}
+	return nil
+}
+
+// errPartialApply is returned by applyAll when a database could not be
+// committed after others were.
+var errPartialApply = errors.New("not all databases were updated")
+
+// applyAll makes the changes of each target to its database. The
+// changes to each database are made, after bringing its schema up to
+// date, in a transaction and the
+// transactions are committed only after every change has been made to
+// every database, so if any change fails no database is changed. The
+// transactions are committed in turn, so a failure to commit leaves
+// the databases committed before it changed; the error returned then
+// wraps errPartialApply.
+func applyAll(targets []dbTarget) error {
+	var txs []*sql.Tx
+	err := retryBusy(func() error {
+		txs = txs[:0]
+		for _, t := range targets {
+			tx, err := t.db.Begin()
+			if err != nil {
+				rollbackAll(txs)
+				return err
+			}
+			txs = append(txs, tx)
+			err = migrate(tx)
+			if err != nil {
+				rollbackAll(txs)
+				return fmt.Errorf("%s: %w", t.name, err)
+			}
+			for _, c := range t.changes {
+				_, err = tx.Exec(c.query, c.args...)
+				if err != nil {
+					rollbackAll(txs)
+					return fmt.Errorf("%s: failed to %s: %w", t.name, c, err)
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return dbError(err)
+	}
+	var committed []string
+	for i, tx := range txs {
+		err = tx.Commit()
+		if err != nil {
+			rollbackAll(txs[i+1:])
+			if len(committed) == 0 {
+				return dbError(fmt.Errorf("%s: %w", targets[i].name, err))
+			}
+			return dbError(fmt.Errorf("%w: updated %s: %s: %w", errPartialApply, strings.Join(committed, ", "), targets[i].name, err))
+		}
+		committed = append(committed, targets[i].name)
+		slog.Info("updated db", "target", targets[i].name, "path", targets[i].path, "changes", len(targets[i].changes))
+	}
+	return nil
+}
+
+// rollbackAll rolls back the transactions.
+func rollbackAll(txs []*sql.Tx) {
+	for _, tx := range txs {
+		tx.Rollback()
+	}
+}
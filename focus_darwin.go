@@ -0,0 +1,22 @@
+// Copyright ©2025 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// focusedApp returns the name of the frontmost application using
+// System Events, which requires fkm's terminal to be granted
+// accessibility access.
+func focusedApp() (string, error) {
+	out, err := exec.Command("osascript", "-e", `tell application "System Events" to get name of first application process whose frontmost is true`).Output()
+	if err != nil {
+		return "", fmt.Errorf("osascript: %w", err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
@@ -0,0 +1,143 @@
+// Copyright ©2025 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+)
+
+// focusedApp returns the application name of the focused window.
+//
+// On Wayland, Hyprland and sway are queried with hyprctl and swaymsg,
+// and other wlroots compositors through the foreign toplevel protocol
+// with lswt. On X11, the window's WM_CLASS class is read with xprop.
+func focusedApp() (string, error) {
+	switch {
+	case os.Getenv("HYPRLAND_INSTANCE_SIGNATURE") != "":
+		return hyprlandFocus()
+	case os.Getenv("SWAYSOCK") != "":
+		return swayFocus()
+	case os.Getenv("WAYLAND_DISPLAY") != "":
+		return wlrFocus()
+	case os.Getenv("DISPLAY") != "":
+		return x11Focus()
+	}
+	return "", errors.New("no graphical session found")
+}
+
+func hyprlandFocus() (string, error) {
+	out, err := exec.Command("hyprctl", "activewindow", "-j").Output()
+	if err != nil {
+		return "", fmt.Errorf("hyprctl: %w", err)
+	}
+	var w struct {
+		Class string `json:"class"`
+	}
+	err = json.Unmarshal(out, &w)
+	if err != nil {
+		return "", fmt.Errorf("hyprctl: %w", err)
+	}
+	return w.Class, nil
+}
+
+func swayFocus() (string, error) {
+	out, err := exec.Command("swaymsg", "-t", "get_tree").Output()
+	if err != nil {
+		return "", fmt.Errorf("swaymsg: %w", err)
+	}
+	type node struct {
+		Focused          bool   `json:"focused"`
+		AppID            string `json:"app_id"`
+		WindowProperties *struct {
+			Class string `json:"class"`
+		} `json:"window_properties"`
+		Nodes         []node `json:"nodes"`
+		FloatingNodes []node `json:"floating_nodes"`
+	}
+	var root node
+	err = json.Unmarshal(out, &root)
+	if err != nil {
+		return "", fmt.Errorf("swaymsg: %w", err)
+	}
+	var find func(n node) (string, bool)
+	find = func(n node) (string, bool) {
+		if n.Focused {
+			if n.AppID == "" && n.WindowProperties != nil {
+				// XWayland window.
+				return n.WindowProperties.Class, true
+			}
+			return n.AppID, true
+		}
+		for _, c := range append(n.Nodes, n.FloatingNodes...) {
+			if app, ok := find(c); ok {
+				return app, true
+			}
+		}
+		return "", false
+	}
+	app, _ := find(root)
+	return app, nil
+}
+
+func wlrFocus() (string, error) {
+	out, err := exec.Command("lswt", "-j").Output()
+	if err != nil {
+		return "", fmt.Errorf("lswt: %w", err)
+	}
+	type toplevel struct {
+		AppID     string `json:"app-id"`
+		Activated bool   `json:"activated"`
+	}
+	var toplevels []toplevel
+	err = json.Unmarshal(out, &toplevels)
+	if err != nil {
+		// Newer releases wrap the list in an object.
+		var v struct {
+			Toplevels []toplevel `json:"toplevels"`
+		}
+		err = json.Unmarshal(out, &v)
+		if err != nil {
+			return "", fmt.Errorf("lswt: %w", err)
+		}
+		toplevels = v.Toplevels
+	}
+	for _, t := range toplevels {
+		if t.Activated {
+			return t.AppID, nil
+		}
+	}
+	return "", nil
+}
+
+var (
+	xActiveWindow = regexp.MustCompile(`window id # (0x[0-9a-fA-F]+)`)
+	xClass        = regexp.MustCompile(`^WM_CLASS\([A-Z_]+\) = "((?:[^"\\]|\\.)*)", "((?:[^"\\]|\\.)*)"`)
+)
+
+func x11Focus() (string, error) {
+	out, err := exec.Command("xprop", "-root", "_NET_ACTIVE_WINDOW").Output()
+	if err != nil {
+		return "", fmt.Errorf("xprop: %w", err)
+	}
+	m := xActiveWindow.FindSubmatch(out)
+	if m == nil || string(m[1]) == "0x0" {
+		return "", nil
+	}
+	out, err = exec.Command("xprop", "-id", string(m[1]), "WM_CLASS").Output()
+	if err != nil {
+		return "", fmt.Errorf("xprop: %w", err)
+	}
+	m = xClass.FindSubmatch([]byte(strings.TrimSpace(string(out))))
+	if m == nil {
+		return "", nil
+	}
+	return string(m[2]), nil
+}
@@ -0,0 +1,15 @@
+// Copyright ©2025 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !linux && !darwin
+
+package main
+
+import "errors"
+
+// focusedApp returns the application name of the focused window.
+// Window focus is not supported on this platform.
+func focusedApp() (string, error) {
+	return "", errors.New("window focus detection is not supported on this platform")
+}
@@ -0,0 +1,190 @@
+// Copyright ©2025 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package format defines the layout model and the exporter and importer
+// registry used by the fkm export and import commands.
+//
+// A third-party format is provided to fkm by a format plugin, an
+// executable named fkm-format-<name> in a directory of PATH. A plugin
+// is written in Go by registering its formats from an init function
+// and calling Main:
+//
+//	package main
+//
+//	import "github.com/kortschak/fkm/format"
+//
+//	func init() {
+//		format.RegisterExporter("corp", "company keymap format", format.ExporterFunc(writeCorp))
+//	}
+//
+//	func main() { format.Main() }
+//
+// The formats of installed plugins are listed by fkm export -formats
+// and fkm import -formats alongside the built-in formats.
+package format
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"sync"
+)
+
+// Exporter converts a layout revision to a keymap format.
+type Exporter interface {
+	// Export writes the revision in l to w.
+	Export(w io.Writer, l *Layout) error
+}
+
+// ExporterFunc is an adapter allowing a function to be used as an
+// Exporter.
+type ExporterFunc func(w io.Writer, l *Layout) error
+
+// Export calls f(w, l).
+func (f ExporterFunc) Export(w io.Writer, l *Layout) error {
+	return f(w, l)
+}
+
+// Importer reconstructs revision data from a file in a keymap format.
+type Importer interface {
+	// Import returns revision data read from the file at path, in
+	// the form of an Oryx GraphQL layout query response. The data
+	// must include the layout's geometry and hash ID and the
+	// revision's hash ID.
+	Import(path string, opts ImportOptions) ([]byte, error)
+}
+
+// ImporterFunc is an adapter allowing a function to be used as an
+// Importer.
+type ImporterFunc func(path string, opts ImportOptions) ([]byte, error)
+
+// Import calls f(path, opts).
+func (f ImporterFunc) Import(path string, opts ImportOptions) ([]byte, error) {
+	return f(path, opts)
+}
+
+// ImportOptions are the user's choices for an imported revision.
+// Non-empty identifiers take precedence over any in the imported file.
+type ImportOptions struct {
+	Title    string // title of the layout and revision
+	Geometry string // keyboard geometry of the layout
+	HashID   string // hash ID of the layout
+	Revision string // hash ID of the revision
+
+	// Detect returns the geometry of the attached keyboard, or ""
+	// if it is not known. Importers should call it only when the
+	// geometry is neither given nor in the imported file. It is nil
+	// if keyboard detection is disabled.
+	Detect func() string
+}
+
+// Format describes a registered format.
+type Format struct {
+	Name string `json:"name"`
+	Help string `json:"help"`
+}
+
+// registry is a set of named formats.
+type registry[T comparable] struct {
+	kind string
+
+	mu      sync.RWMutex
+	formats map[string]entry[T]
+}
+
+// entry is a registered format.
+type entry[T any] struct {
+	help string
+	impl T
+}
+
+var (
+	exporters = &registry[Exporter]{kind: "exporter"}
+	importers = &registry[Importer]{kind: "importer"}
+)
+
+// errDuplicate is returned when a format is registered twice.
+var errDuplicate = errors.New("registered twice")
+
+// RegisterExporter makes an export format available by name, with help
+// describing the format. It panics if name is empty, e is nil or a
+// format with the same name is registered.
+func RegisterExporter(name, help string, e Exporter) {
+	err := exporters.add(name, help, e)
+	if err != nil {
+		panic("format: " + err.Error())
+	}
+}
+
+// RegisterImporter makes an import format available by name, with help
+// describing the format. It panics if name is empty, i is nil or a
+// format with the same name is registered.
+func RegisterImporter(name, help string, i Importer) {
+	err := importers.add(name, help, i)
+	if err != nil {
+		panic("format: " + err.Error())
+	}
+}
+
+// LookupExporter returns the export format registered with name.
+func LookupExporter(name string) (Exporter, bool) {
+	return exporters.lookup(name)
+}
+
+// LookupImporter returns the import format registered with name.
+func LookupImporter(name string) (Importer, bool) {
+	return importers.lookup(name)
+}
+
+// Exporters returns the registered export formats sorted by name.
+func Exporters() []Format {
+	return exporters.list()
+}
+
+// Importers returns the registered import formats sorted by name.
+func Importers() []Format {
+	return importers.list()
+}
+
+// add adds impl to the registry under name.
+func (r *registry[T]) add(name, help string, impl T) error {
+	var zero T
+	switch {
+	case name == "":
+		return fmt.Errorf("%s registered without a name", r.kind)
+	case impl == zero:
+		return fmt.Errorf("%s %q is nil", r.kind, name)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, dup := r.formats[name]; dup {
+		return fmt.Errorf("%s %q %w", r.kind, name, errDuplicate)
+	}
+	if r.formats == nil {
+		r.formats = make(map[string]entry[T])
+	}
+	r.formats[name] = entry[T]{help: help, impl: impl}
+	return nil
+}
+
+// lookup returns the format registered with name.
+func (r *registry[T]) lookup(name string) (T, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	e, ok := r.formats[name]
+	return e.impl, ok
+}
+
+// list returns the registered formats sorted by name.
+func (r *registry[T]) list() []Format {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	formats := make([]Format, 0, len(r.formats))
+	for _, name := range slices.Sorted(maps.Keys(r.formats)) {
+		formats = append(formats, Format{Name: name, Help: r.formats[name].help})
+	}
+	return formats
+}
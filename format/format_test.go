@@ -0,0 +1,231 @@
+// Copyright ©2025 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package format
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// pluginEnv is set when the test binary is run as a format plugin by
+// TestPlugin.
+const pluginEnv = "GO_TEST_FORMAT_PLUGIN"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(pluginEnv) != "" {
+		RegisterExporter("titles", "layer titles, one per line", ExporterFunc(writeTitles))
+		RegisterImporter("titled", "a layout from a title", ImporterFunc(readTitle))
+		Main()
+	}
+	os.Exit(m.Run())
+}
+
+// writeTitles writes the layer titles of l to w.
+func writeTitles(w io.Writer, l *Layout) error {
+	for _, layer := range l.Revision.Layers {
+		_, err := fmt.Fprintln(w, layer.Name())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readTitle returns a layout titled with the contents of the file at
+// path.
+func readTitle(path string, opts ImportOptions) ([]byte, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	geom := opts.Geometry
+	if geom == "" && opts.Detect != nil {
+		geom = opts.Detect()
+	}
+	if geom == "" {
+		return nil, errors.New("no geometry")
+	}
+	return json.Marshal(Data{Layout: Layout{
+		Geometry: geom,
+		HashID:   opts.HashID,
+		Title:    strings.TrimSpace(string(b)),
+		Revision: Revision{HashID: opts.Revision},
+	}})
+}
+
+func TestRegistry(t *testing.T) {
+	nop := ExporterFunc(func(io.Writer, *Layout) error { return nil })
+	tests := []struct {
+		name    string
+		formats []string
+		nilImpl bool
+		want    []Format
+		wantErr string
+	}{
+		{
+			name:    "sorted",
+			formats: []string{"b", "a", "c"},
+			want:    []Format{{"a", "help a"}, {"b", "help b"}, {"c", "help c"}},
+		},
+		{
+			name:    "duplicate",
+			formats: []string{"a", "a"},
+			want:    []Format{{"a", "help a"}},
+			wantErr: `exporter "a" registered twice`,
+		},
+		{
+			name:    "unnamed",
+			formats: []string{""},
+			want:    []Format{},
+			wantErr: "exporter registered without a name",
+		},
+		{
+			name:    "nil",
+			formats: []string{"a"},
+			nilImpl: true,
+			want:    []Format{},
+			wantErr: `exporter "a" is nil`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := &registry[Exporter]{kind: "exporter"}
+			var errs []error
+			for _, name := range test.formats {
+				var impl Exporter = nop
+				if test.nilImpl {
+					impl = nil
+				}
+				errs = append(errs, r.add(name, "help "+name, impl))
+			}
+			err := errors.Join(errs...)
+			switch {
+			case err == nil && test.wantErr != "":
+				t.Errorf("expected error %q", test.wantErr)
+			case err != nil && err.Error() != test.wantErr:
+				t.Errorf("unexpected error: got:%v want:%s", err, test.wantErr)
+			}
+			got := r.list()
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("unexpected formats: got:%v want:%v", got, test.want)
+			}
+			for _, f := range test.want {
+				if _, ok := r.lookup(f.Name); !ok {
+					t.Errorf("failed to look up %q", f.Name)
+				}
+			}
+			if _, ok := r.lookup("missing"); ok {
+				t.Error("unexpectedly found unregistered format")
+			}
+		})
+	}
+}
+
+func TestPlugin(t *testing.T) {
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatalf("failed to find test executable: %v", err)
+	}
+	t.Setenv(pluginEnv, "1")
+	err = RegisterPlugin(exe)
+	if err != nil {
+		t.Fatalf("failed to register plugin: %v", err)
+	}
+	t.Cleanup(func() {
+		exporters.mu.Lock()
+		delete(exporters.formats, "titles")
+		exporters.mu.Unlock()
+		importers.mu.Lock()
+		delete(importers.formats, "titled")
+		importers.mu.Unlock()
+	})
+
+	err = RegisterPlugin(exe)
+	if !errors.Is(err, errDuplicate) {
+		t.Errorf("expected duplicate registration error, got: %v", err)
+	}
+
+	t.Run("export", func(t *testing.T) {
+		e, ok := LookupExporter("titles")
+		if !ok {
+			t.Fatal("plugin exporter not registered")
+		}
+		l, err := Parse([]byte(`{"layout":{"geometry":"voyager","hashId":"abc","revision":{"hashId":"rev","layers":[{"title":"Base"},{"title":"Symbols"}]}}}`))
+		if err != nil {
+			t.Fatalf("failed to parse layout: %v", err)
+		}
+		var buf bytes.Buffer
+		err = e.Export(&buf, l)
+		if err != nil {
+			t.Fatalf("failed to export: %v", err)
+		}
+		want := "Base\nSymbols\n"
+		if buf.String() != want {
+			t.Errorf("unexpected export: got:%q want:%q", buf.String(), want)
+		}
+	})
+
+	t.Run("import", func(t *testing.T) {
+		i, ok := LookupImporter("titled")
+		if !ok {
+			t.Fatal("plugin importer not registered")
+		}
+		path := t.TempDir() + "/layout.txt"
+		err := os.WriteFile(path, []byte("Imported\n"), 0o644)
+		if err != nil {
+			t.Fatalf("failed to write import file: %v", err)
+		}
+		tests := []struct {
+			name    string
+			opts    ImportOptions
+			want    Layout
+			wantErr string
+		}{
+			{
+				name: "geometry",
+				opts: ImportOptions{Geometry: "moonlander", HashID: "abc", Revision: "rev"},
+				want: Layout{Geometry: "moonlander", HashID: "abc", Title: "Imported", Revision: Revision{HashID: "rev"}},
+			},
+			{
+				name: "detected",
+				opts: ImportOptions{HashID: "abc", Detect: func() string { return "voyager" }},
+				want: Layout{Geometry: "voyager", HashID: "abc", Title: "Imported"},
+			},
+			{
+				name:    "undetected",
+				opts:    ImportOptions{HashID: "abc"},
+				wantErr: "no geometry",
+			},
+		}
+		for _, test := range tests {
+			t.Run(test.name, func(t *testing.T) {
+				data, err := i.Import(path, test.opts)
+				if test.wantErr != "" {
+					if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+						t.Errorf("unexpected error: got:%v want:%s", err, test.wantErr)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatalf("failed to import: %v", err)
+				}
+				l, err := Parse(data)
+				if err != nil {
+					t.Fatalf("failed to parse import: %v", err)
+				}
+				if !reflect.DeepEqual(*l, test.want) {
+					t.Errorf("unexpected import: got:%+v want:%+v", *l, test.want)
+				}
+			})
+		}
+	})
+}
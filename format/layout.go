@@ -0,0 +1,267 @@
+// Copyright ©2025 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package format
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Layout is an Oryx layout with a single revision.
+type Layout struct {
+	Privacy  bool   `json:"privacy"`
+	Geometry string `json:"geometry"`
+	HashID   string `json:"hashId"`
+	Title    string `json:"title"`
+
+	Tags []Tag `json:"tags"`
+
+	Revision Revision `json:"revision"`
+
+	IsLatestRevision bool `json:"isLatestRevision"`
+}
+
+// Tag is a tag attached to a layout in Oryx.
+type Tag struct {
+	Name string `json:"name"`
+}
+
+// Revision is an Oryx layout revision.
+type Revision struct {
+	CreatedAt string `json:"createdAt"`
+	HashID    string `json:"hashId"`
+	Model     string `json:"model"`
+	Title     string `json:"title"`
+
+	Config           Config `json:"config"`
+	HasDeletedLayers bool   `json:"hasDeletedLayers"`
+
+	Combos []Combo `json:"combos"`
+	Layers []Layer `json:"layers"`
+	Tour   *Tour   `json:"tour"`
+}
+
+// Config holds the firmware settings of a revision that are used by
+// fkm.
+type Config struct {
+	TappingTerm int
+}
+
+// UnmarshalJSON decodes the settings used by fkm, ignoring unexpected
+// values so that changes to the config do not prevent the layout from
+// being parsed.
+func (c *Config) UnmarshalJSON(data []byte) error {
+	var v struct {
+		TappingTerm json.Number `json:"tappingTerm"`
+	}
+	if json.Unmarshal(data, &v) == nil {
+		n, _ := v.TappingTerm.Int64()
+		c.TappingTerm = int(n)
+	}
+	return nil
+}
+
+// MarshalJSON encodes the settings used by fkm.
+func (c Config) MarshalJSON() ([]byte, error) {
+	if c.TappingTerm == 0 {
+		return []byte("{}"), nil
+	}
+	return json.Marshal(struct {
+		TappingTerm int `json:"tappingTerm"`
+	}{c.TappingTerm})
+}
+
+// TappingTerm returns the revision's tapping term in milliseconds, or
+// the QMK default if it is not set.
+func (r Revision) TappingTerm() int {
+	if r.Config.TappingTerm > 0 {
+		return r.Config.TappingTerm
+	}
+	return 200
+}
+
+// Created returns the creation time of the revision. If the time
+// cannot be parsed, the zero time is returned.
+func (r Revision) Created() time.Time {
+	t, err := time.Parse(time.RFC3339, r.CreatedAt)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+// Combo is a set of keys on a layer that trigger an action when
+// pressed together.
+type Combo struct {
+	KeyIndices []int  `json:"keyIndices"`
+	LayerIdx   int    `json:"layerIdx"`
+	Name       string `json:"name"`
+	Trigger    string `json:"trigger"`
+}
+
+// Layer is a layer of a layout revision.
+type Layer struct {
+	HashID   string `json:"hashId"`
+	Position int    `json:"position"`
+	Title    string `json:"title"`
+	Color    string `json:"color"`
+	Keys     []Key  `json:"keys"`
+}
+
+// Name returns the layer's title, or its position if it has no title.
+func (l Layer) Name() string {
+	if l.Title != "" {
+		return l.Title
+	}
+	return fmt.Sprintf("Layer %d", l.Position)
+}
+
+// Key is the configuration of a single key on a layer.
+type Key struct {
+	Tap         *KeyAction `json:"tap"`
+	Hold        *KeyAction `json:"hold"`
+	DoubleTap   *KeyAction `json:"doubleTap"`
+	TapHold     *KeyAction `json:"tapHold"`
+	CustomLabel string     `json:"customLabel"`
+	GlowColor   string     `json:"glowColor"`
+}
+
+// Label returns a short legend for the key's tap action.
+func (k Key) Label() string {
+	if k.CustomLabel != "" {
+		return k.CustomLabel
+	}
+	return k.Tap.String()
+}
+
+// Actions returns the key's non-empty actions in order of tap, hold,
+// double tap and tap-hold, labelled by kind.
+func (k Key) Actions() []KeyActionKind {
+	var a []KeyActionKind
+	for _, v := range []KeyActionKind{
+		{"tap", k.Tap},
+		{"hold", k.Hold},
+		{"double tap", k.DoubleTap},
+		{"tap-hold", k.TapHold},
+	} {
+		if v.Action != nil && v.Action.Code != "" {
+			a = append(a, v)
+		}
+	}
+	return a
+}
+
+// KeyActionKind is a key action and the kind of press that triggers
+// it: "tap", "hold", "double tap" or "tap-hold".
+type KeyActionKind struct {
+	Kind   string
+	Action *KeyAction
+}
+
+// KeyAction is the action of a key press.
+type KeyAction struct {
+	Code      string    `json:"code"`
+	Modifier  string    `json:"modifier"`
+	Modifiers Modifiers `json:"modifiers"`
+	Layer     *int      `json:"layer"`
+}
+
+// String returns a short legend for the action with the KC_ prefix
+// removed from key codes and layer actions shown with their target.
+func (a *KeyAction) String() string {
+	if a == nil || a.Code == "" {
+		return ""
+	}
+	s := strings.TrimPrefix(a.Code, "KC_")
+	if a.Layer != nil {
+		s = fmt.Sprintf("%s(%d)", s, *a.Layer)
+	}
+	mods := a.Modifiers
+	if a.Modifier != "" {
+		mods = append(Modifiers{a.Modifier}, mods...)
+	}
+	if len(mods) != 0 {
+		s = strings.Join(mods, "+") + "+" + s
+	}
+	return s
+}
+
+// Modifiers is a set of modifier names. It may be encoded in JSON as
+// a string, an array of strings or an object of booleans keyed by
+// name.
+type Modifiers []string
+
+func (m *Modifiers) UnmarshalJSON(data []byte) error {
+	var v any
+	err := json.Unmarshal(data, &v)
+	if err != nil {
+		return err
+	}
+	*m = nil
+	switch v := v.(type) {
+	case nil:
+	case string:
+		if v != "" {
+			*m = Modifiers{v}
+		}
+	case []any:
+		for _, e := range v {
+			if s, ok := e.(string); ok && s != "" {
+				*m = append(*m, s)
+			}
+		}
+	case map[string]any:
+		for _, k := range slices.Sorted(maps.Keys(v)) {
+			if on, _ := v[k].(bool); on {
+				*m = append(*m, k)
+			}
+		}
+	default:
+		return fmt.Errorf("invalid modifiers: %s", data)
+	}
+	return nil
+}
+
+// Tour is a guided tour of a layout revision.
+type Tour struct {
+	HashID string     `json:"hashId"`
+	URL    string     `json:"url"`
+	Steps  []TourStep `json:"steps"`
+}
+
+// TourStep is a single step of a tour.
+type TourStep struct {
+	HashID   string `json:"hashId"`
+	Intro    string `json:"intro"`
+	Outro    string `json:"outro"`
+	Position int    `json:"position"`
+	Content  string `json:"content"`
+	KeyIndex *int   `json:"keyIndex"`
+	Layer    *struct {
+		HashID   string `json:"hashId"`
+		Position int    `json:"position"`
+	} `json:"layer"`
+}
+
+// Data is the data returned by the Oryx getLayout query and stored in
+// the keymapp revision table.
+type Data struct {
+	Layout Layout `json:"layout"`
+}
+
+// Parse parses revision data in the form of an Oryx getLayout query
+// response.
+func Parse(data []byte) (*Layout, error) {
+	var d Data
+	err := json.Unmarshal(data, &d)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse layout: %w", err)
+	}
+	return &d.Layout, nil
+}
@@ -0,0 +1,207 @@
+// Copyright ©2025 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package format
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// PluginPrefix is the prefix of the names of format plugin executables.
+const PluginPrefix = "fkm-format-"
+
+// A format plugin is run with one of the following sets of arguments:
+//
+//	list
+//		write the plugin's formats to stdout as pluginFormats JSON
+//	export <format>
+//		read Data JSON from stdin and write the export to stdout
+//	import <format> <path>
+//		read importRequest JSON from stdin and write the imported
+//		revision data to stdout
+//
+// On failure a plugin writes the error to stderr and exits with a
+// non-zero status.
+
+// pluginFormats is the output of a plugin's list command.
+type pluginFormats struct {
+	Export []Format `json:"export"`
+	Import []Format `json:"import"`
+}
+
+// importRequest is the input of a plugin's import command.
+type importRequest struct {
+	Title    string `json:"title,omitempty"`
+	Geometry string `json:"geometry,omitempty"`
+	HashID   string `json:"hashId,omitempty"`
+	Revision string `json:"revision,omitempty"`
+
+	// Detected is the geometry of the attached keyboard. It is
+	// only set when Geometry is empty and detection is enabled.
+	Detected string `json:"detected,omitempty"`
+}
+
+// Main runs the program as a format plugin serving the formats
+// registered in it. It is called by the main function of a plugin
+// and does not return.
+func Main() {
+	err := serve(os.Args[1:], os.Stdin, os.Stdout)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+// serve runs the plugin command in args.
+func serve(args []string, stdin io.Reader, stdout io.Writer) error {
+	usage := fmt.Errorf("usage: %s list | export <format> | import <format> <path>", filepath.Base(os.Args[0]))
+	if len(args) == 0 {
+		return usage
+	}
+	switch cmd := args[0]; {
+	case cmd == "list" && len(args) == 1:
+		return json.NewEncoder(stdout).Encode(pluginFormats{Export: Exporters(), Import: Importers()})
+
+	case cmd == "export" && len(args) == 2:
+		e, ok := LookupExporter(args[1])
+		if !ok {
+			return fmt.Errorf("unknown export format %q", args[1])
+		}
+		var d Data
+		err := json.NewDecoder(stdin).Decode(&d)
+		if err != nil {
+			return fmt.Errorf("failed to read layout: %w", err)
+		}
+		return e.Export(stdout, &d.Layout)
+
+	case cmd == "import" && len(args) == 3:
+		i, ok := LookupImporter(args[1])
+		if !ok {
+			return fmt.Errorf("unknown import format %q", args[1])
+		}
+		var req importRequest
+		err := json.NewDecoder(stdin).Decode(&req)
+		if err != nil {
+			return fmt.Errorf("failed to read import options: %w", err)
+		}
+		opts := ImportOptions{
+			Title:    req.Title,
+			Geometry: req.Geometry,
+			HashID:   req.HashID,
+			Revision: req.Revision,
+		}
+		if req.Detected != "" {
+			opts.Detect = func() string { return req.Detected }
+		}
+		data, err := i.Import(args[2], opts)
+		if err != nil {
+			return err
+		}
+		_, err = stdout.Write(data)
+		return err
+	}
+	return usage
+}
+
+// RegisterPlugin registers the formats served by the format plugin
+// executable at path. Formats with the name of an already registered
+// format are not registered and are reported in the returned error.
+func RegisterPlugin(path string) error {
+	var out bytes.Buffer
+	err := runPlugin(path, nil, &out, "list")
+	if err != nil {
+		return err
+	}
+	var formats pluginFormats
+	err = json.Unmarshal(out.Bytes(), &formats)
+	if err != nil {
+		return fmt.Errorf("%s: invalid format list: %w", path, err)
+	}
+	var errs []error
+	for _, f := range formats.Export {
+		err = exporters.add(f.Name, f.Help, pluginExporter{path: path, name: f.Name})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", path, err))
+		}
+	}
+	for _, f := range formats.Import {
+		err = importers.add(f.Name, f.Help, pluginImporter{path: path, name: f.Name})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", path, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// pluginExporter is an export format served by a plugin.
+type pluginExporter struct {
+	path string // path of the plugin executable
+	name string // name of the format
+}
+
+func (p pluginExporter) Export(w io.Writer, l *Layout) error {
+	b, err := json.Marshal(Data{Layout: *l})
+	if err != nil {
+		return err
+	}
+	return runPlugin(p.path, bytes.NewReader(b), w, "export", p.name)
+}
+
+// pluginImporter is an import format served by a plugin.
+type pluginImporter struct {
+	path string // path of the plugin executable
+	name string // name of the format
+}
+
+// Import runs the plugin's import command. As the plugin cannot call
+// opts.Detect, the attached keyboard is detected before the plugin is
+// run when no geometry is given.
+func (p pluginImporter) Import(path string, opts ImportOptions) ([]byte, error) {
+	req := importRequest{
+		Title:    opts.Title,
+		Geometry: opts.Geometry,
+		HashID:   opts.HashID,
+		Revision: opts.Revision,
+	}
+	if opts.Geometry == "" && opts.Detect != nil {
+		req.Detected = opts.Detect()
+	}
+	b, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	var out bytes.Buffer
+	err = runPlugin(p.path, bytes.NewReader(b), &out, "import", p.name, path)
+	if err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// runPlugin runs the plugin executable at path with args, returning
+// an error holding the plugin's stderr if it fails.
+func runPlugin(path string, stdin io.Reader, stdout io.Writer, args ...string) error {
+	var stderr bytes.Buffer
+	cmd := exec.Command(path, args...)
+	cmd.Stdin = stdin
+	cmd.Stdout = stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return fmt.Errorf("%s: %s", filepath.Base(path), msg)
+		}
+		return fmt.Errorf("%s: %w", filepath.Base(path), err)
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+// Copyright ©2025 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/kortschak/fkm/format"
+)
+
+// The built-in formats are registered with the format package, which
+// also holds the formats of any installed format plugins.
+func init() {
+	format.RegisterExporter("kanata", "kanata configuration for software remapping", format.ExporterFunc(writeKanata))
+	format.RegisterExporter("kmonad", "KMonad configuration for software remapping", format.ExporterFunc(writeKMonad))
+	format.RegisterExporter("qmk", "QMK keymap.json for use with qmk compile", format.ExporterFunc(writeQMKJSON))
+	format.RegisterExporter("qmk-c", "QMK keymap.c including combos", format.ExporterFunc(writeQMKC))
+	format.RegisterExporter("via", "VIA saved layout JSON", format.ExporterFunc(writeVIA))
+	format.RegisterExporter("vial", "Vial .vil layout including combos", format.ExporterFunc(writeVial))
+	format.RegisterImporter("oryx-zip", "Oryx layout source archive", format.ImporterFunc(importOryxZip))
+}
+
+// loadFormatPlugins registers the formats of the format plugins found
+// in the directories of PATH. Where plugins share a name, the first in
+// PATH is used. Plugins that cannot be loaded are logged and skipped.
+func loadFormatPlugins() {
+	seen := make(map[string]bool)
+	for _, dir := range filepath.SplitList(os.Getenv("PATH")) {
+		if dir == "" {
+			continue
+		}
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, e := range entries {
+			name, ok := pluginName(e)
+			if !ok || seen[name] {
+				continue
+			}
+			seen[name] = true
+			path := filepath.Join(dir, e.Name())
+			err = format.RegisterPlugin(path)
+			if err != nil {
+				slog.Warn("failed to load format plugin", "path", path, "error", err)
+			}
+		}
+	}
+}
+
+// pluginName returns the name of the format plugin executable e, and
+// whether e is a plugin.
+func pluginName(e os.DirEntry) (string, bool) {
+	name, ok := strings.CutPrefix(e.Name(), format.PluginPrefix)
+	if !ok || name == "" || e.IsDir() {
+		return "", false
+	}
+	if runtime.GOOS == "windows" {
+		return strings.CutSuffix(name, ".exe")
+	}
+	info, err := e.Info()
+	if err != nil || info.Mode()&0o111 == 0 {
+		return "", false
+	}
+	return name, true
+}
+
+// formatNames returns the names of the formats.
+func formatNames(formats []format.Format) string {
+	names := make([]string, len(formats))
+	for i, f := range formats {
+		names[i] = f.Name
+	}
+	return strings.Join(names, ", ")
+}
+
+// printFormats writes the names and descriptions of the formats to
+// stdout, as JSON if asJSON is true.
+func printFormats(formats []format.Format, asJSON bool) {
+	if asJSON {
+		printJSON(formats)
+		return
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "FORMAT\tDESCRIPTION")
+	for _, f := range formats {
+		fmt.Fprintf(w, "%s\t%s\n", f.Name, f.Help)
+	}
+	w.Flush()
+}
+
+// formatUsage writes the names and descriptions of the formats to w
+// in the style of flag defaults.
+func formatUsage(w io.Writer, formats []format.Format) {
+	for _, f := range formats {
+		fmt.Fprintf(w, "  %s\n    \t%s\n", f.Name, f.Help)
+	}
+}
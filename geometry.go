@@ -0,0 +1,223 @@
+// Copyright ©2025 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// geometries returns the keyboard geometry names described by the
+// metadata. The metadata's geometries field may be either an object
+// keyed by geometry name or an array of objects with a name field.
+// If the metadata has no recognisable geometries, nil is returned.
+func geometries(meta []byte) ([]string, error) {
+	var m struct {
+		Geometries json.RawMessage `json:"geometries"`
+	}
+	err := json.Unmarshal(meta, &m)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse metadata: %w", err)
+	}
+	if len(m.Geometries) == 0 {
+		return nil, nil
+	}
+	var byName map[string]json.RawMessage
+	if json.Unmarshal(m.Geometries, &byName) == nil {
+		return slices.Sorted(maps.Keys(byName)), nil
+	}
+	var list []struct {
+		Name string `json:"name"`
+		Slug string `json:"slug"`
+	}
+	err = json.Unmarshal(m.Geometries, &list)
+	if err != nil {
+		return nil, nil
+	}
+	var names []string
+	for _, g := range list {
+		name := cmp.Or(g.Slug, g.Name)
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	return names, nil
+}
+
+// checkGeometry returns an error if geom is not a geometry described
+// by the metadata. If the metadata does not describe geometries, no
+// check is made.
+func checkGeometry(meta []byte, geom string) error {
+	known, err := geometries(meta)
+	if err != nil {
+		return err
+	}
+	if known == nil {
+		slog.Debug("metadata has no geometries, skipping geometry check", "geometry", geom)
+		return nil
+	}
+	if slices.Contains(known, geom) {
+		return nil
+	}
+	if close := closeMatches(geom, known); len(close) != 0 {
+		return fmt.Errorf("unknown geometry %q: did you mean %s?", geom, strings.Join(close, " or "))
+	}
+	return fmt.Errorf("unknown geometry %q: known geometries are %s", geom, strings.Join(known, ", "))
+}
+
+// closeMatches returns the quoted known names that name is plausibly a
+// misspelling of, closest first.
+func closeMatches(name string, known []string) []string {
+	name = strings.ToLower(name)
+	type candidate struct {
+		name string
+		dist int
+	}
+	var close []candidate
+	for _, k := range known {
+		d := editDistance(name, strings.ToLower(k))
+		if d <= max(2, len(k)/3) || strings.HasPrefix(k, name) || strings.HasPrefix(name, k) {
+			close = append(close, candidate{k, d})
+		}
+	}
+	slices.SortStableFunc(close, func(a, b candidate) int { return a.dist - b.dist })
+	names := make([]string, len(close))
+	for i, c := range close {
+		names[i] = strconv.Quote(c.name)
+	}
+	return names
+}
+
+// editDistance returns the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(rb)]
+}
+
+// keyPlacement is the position and size of a key in key units.
+type keyPlacement struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+	W float64 `json:"w"`
+	H float64 `json:"h"`
+}
+
+// keyPlacements returns the positions of the keys of the geometry in
+// key index order. Placements are taken from the geometry's keys field
+// in the metadata if it is present, and otherwise from the built-in
+// descriptions of ZSA keyboards. If neither is available, n keys are
+// placed in rows of twelve.
+func keyPlacements(meta []byte, geom string, n int) []keyPlacement {
+	if meta != nil {
+		var m struct {
+			Geometries map[string]struct {
+				Keys []keyPlacement `json:"keys"`
+			} `json:"geometries"`
+		}
+		if json.Unmarshal(meta, &m) == nil && len(m.Geometries[geom].Keys) != 0 {
+			keys := m.Geometries[geom].Keys
+			for i := range keys {
+				if keys[i].W == 0 {
+					keys[i].W = 1
+				}
+				if keys[i].H == 0 {
+					keys[i].H = 1
+				}
+			}
+			return keys
+		}
+	}
+	rows, ok := builtinGeometries[geom]
+	if !ok {
+		slog.Debug("no key placements for geometry, using a grid", "geometry", geom)
+		rows = nil
+		for i := 0; i < n; i += 12 {
+			rows = append(rows, []int{min(12, n-i)})
+		}
+	}
+	var keys []keyPlacement
+	for y, row := range rows {
+		var (
+			x    float64
+			prev bool // Previous segment held keys.
+		)
+		for _, seg := range row {
+			if seg < 0 {
+				x -= float64(seg)
+				prev = false
+				continue
+			}
+			if prev {
+				x++ // Gap between halves.
+			}
+			prev = true
+			for range seg {
+				keys = append(keys, keyPlacement{X: x, Y: float64(y), W: 1, H: 1})
+				x++
+			}
+		}
+	}
+	return keys
+}
+
+// builtinGeometries describes the key rows of ZSA keyboards. Each row
+// is a list of the number of keys in each contiguous segment of the
+// row, from left to right, with adjacent segments separated by a key
+// width. Negative values are blank space of that many key widths. Keys
+// are indexed in row order.
+var builtinGeometries = map[string][][]int{
+	"voyager": {
+		{6, 6},
+		{6, 6},
+		{6, 6},
+		{6, 6},
+		{-4, 2, 2},
+	},
+	"moonlander": {
+		{7, 7},
+		{7, 7},
+		{7, 7},
+		{6, -2, 6},
+		{6, -2, 6},
+		{-4, 3, -1, 3},
+	},
+	"ergodox-ez": {
+		{7, 7},
+		{7, 7},
+		{6, -2, 6},
+		{7, 7},
+		{5, -4, 5},
+		{-1, 6, 6},
+	},
+	"planck-ez": {
+		{12},
+		{12},
+		{12},
+		{11},
+	},
+}
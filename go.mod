@@ -1,8 +1,11 @@
 module github.com/kortschak/fkm
 
-go 1.23.4
+go 1.24.0
 
-require modernc.org/sqlite v1.34.4
+require (
+	github.com/BurntSushi/toml v1.6.0
+	modernc.org/sqlite v1.34.4
+)
 
 require (
 	github.com/dustin/go-humanize v1.0.1 // indirect
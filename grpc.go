@@ -0,0 +1,204 @@
+// Copyright ©2025 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// grpcClient is a minimal unary gRPC client for local plaintext
+// servers such as the keymapp API. It uses HTTP/2 with prior knowledge
+// and does not support compression or streaming.
+type grpcClient struct {
+	http *http.Client
+	base string // scheme and authority of the server
+}
+
+// newGRPCClient returns a client for the gRPC server at addr, a host
+// and port.
+func newGRPCClient(addr string, timeout time.Duration) *grpcClient {
+	var p http.Protocols
+	p.SetUnencryptedHTTP2(true)
+	return &grpcClient{
+		http: &http.Client{
+			Timeout: timeout,
+			Transport: &http.Transport{
+				Protocols: &p,
+				// The API is local; never send it to a proxy.
+				Proxy: nil,
+			},
+		},
+		base: (&url.URL{Scheme: "http", Host: addr}).String(),
+	}
+}
+
+// grpcStatusError is a non-OK gRPC status returned by a server.
+type grpcStatusError struct {
+	code int
+	msg  string
+}
+
+func (e grpcStatusError) Error() string {
+	if e.msg == "" {
+		return fmt.Sprintf("grpc status %d", e.code)
+	}
+	return fmt.Sprintf("grpc status %d: %s", e.code, e.msg)
+}
+
+// call invokes the unary method, for example "/api.KeyboardService/GetStatus",
+// with the encoded request message and returns the encoded response
+// message.
+func (c *grpcClient) call(method string, req []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte(0) // Not compressed.
+	binary.Write(&buf, binary.BigEndian, uint32(len(req)))
+	buf.Write(req)
+	r, err := http.NewRequest(http.MethodPost, c.base+method, &buf)
+	if err != nil {
+		return nil, err
+	}
+	r.Header.Set("Content-Type", "application/grpc")
+	r.Header.Set("TE", "trailers")
+	resp, err := c.http.Do(r)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+	// Trailers-only responses carry the status in the headers.
+	status := resp.Trailer.Get("Grpc-Status")
+	msg := resp.Trailer.Get("Grpc-Message")
+	if status == "" {
+		status = resp.Header.Get("Grpc-Status")
+		msg = resp.Header.Get("Grpc-Message")
+	}
+	if status != "" && status != "0" {
+		code, _ := strconv.Atoi(status)
+		msg, _ = url.PathUnescape(msg)
+		return nil, grpcStatusError{code: code, msg: msg}
+	}
+	if len(body) < 5 {
+		return nil, errors.New("grpc: short response")
+	}
+	if body[0] != 0 {
+		return nil, errors.New("grpc: compressed responses are not supported")
+	}
+	n := binary.BigEndian.Uint32(body[1:5])
+	if uint32(len(body)-5) < n {
+		return nil, errors.New("grpc: truncated response")
+	}
+	return body[5 : 5+n], nil
+}
+
+// protoMessage is a protocol buffers message being encoded. Only the
+// varint and length-delimited wire types are supported.
+type protoMessage struct {
+	buf []byte
+}
+
+// varint appends an integer field. Zero values are omitted as in
+// proto3.
+func (m *protoMessage) varint(field int, v int64) {
+	if v == 0 {
+		return
+	}
+	m.buf = binary.AppendUvarint(m.buf, uint64(field)<<3)
+	m.buf = binary.AppendUvarint(m.buf, uint64(v))
+}
+
+// bytes returns the encoded message.
+func (m *protoMessage) bytes() []byte { return m.buf }
+
+// protoFields is a decoded protocol buffers message. Varint fields are
+// held as uint64 values and length-delimited fields as []byte. When a
+// field is repeated, the last value is held.
+type protoFields map[int]any
+
+// decodeProto decodes the fields of a protocol buffers message.
+func decodeProto(b []byte) (protoFields, error) {
+	f := make(protoFields)
+	for len(b) != 0 {
+		tag, n := binary.Uvarint(b)
+		if n <= 0 {
+			return nil, errors.New("proto: invalid tag")
+		}
+		b = b[n:]
+		field := int(tag >> 3)
+		switch tag & 7 {
+		case 0: // varint
+			v, n := binary.Uvarint(b)
+			if n <= 0 {
+				return nil, errors.New("proto: invalid varint")
+			}
+			f[field] = v
+			b = b[n:]
+		case 1: // 64-bit
+			if len(b) < 8 {
+				return nil, errors.New("proto: truncated fixed64")
+			}
+			f[field] = binary.LittleEndian.Uint64(b)
+			b = b[8:]
+		case 2: // length-delimited
+			l, n := binary.Uvarint(b)
+			if n <= 0 || uint64(len(b)-n) < l {
+				return nil, errors.New("proto: truncated field")
+			}
+			f[field] = b[n : n+int(l)]
+			b = b[n+int(l):]
+		case 5: // 32-bit
+			if len(b) < 4 {
+				return nil, errors.New("proto: truncated fixed32")
+			}
+			f[field] = uint64(binary.LittleEndian.Uint32(b))
+			b = b[4:]
+		default:
+			return nil, fmt.Errorf("proto: unsupported wire type %d", tag&7)
+		}
+	}
+	return f, nil
+}
+
+// int returns the integer value of the field, or zero if it is absent.
+func (f protoFields) int(field int) int64 {
+	v, _ := f[field].(uint64)
+	return int64(int32(v))
+}
+
+// bool returns the boolean value of the field, or false if it is
+// absent.
+func (f protoFields) bool(field int) bool {
+	v, _ := f[field].(uint64)
+	return v != 0
+}
+
+// string returns the string value of the field, or "" if it is absent.
+func (f protoFields) string(field int) string {
+	v, _ := f[field].([]byte)
+	return string(v)
+}
+
+// message returns the decoded embedded message in the field, or nil if
+// it is absent.
+func (f protoFields) message(field int) (protoFields, error) {
+	v, ok := f[field].([]byte)
+	if !ok {
+		return nil, nil
+	}
+	return decodeProto(v)
+}
@@ -0,0 +1,91 @@
+// Copyright ©2025 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// writeGuard prevents database writes while keymapp is using the
+// database.
+type writeGuard struct {
+	wait   bool
+	ignore bool
+}
+
+// register adds the guard's flags to fs.
+func (g *writeGuard) register(fs *flag.FlagSet) {
+	fs.BoolVar(&g.wait, "wait", false, "wait for keymapp to exit before changing the database")
+	fs.BoolVar(&g.ignore, "ignore-running", false, "change the database even if keymapp is running")
+}
+
+// errKeymappRunning is returned when keymapp is using the database.
+var errKeymappRunning = errors.New("keymapp is running")
+
+// check returns an error if keymapp is running or the database is
+// locked by another writer. If the guard is waiting, check blocks
+// until keymapp has exited and the database is unlocked, or ctx is
+// done.
+func (g *writeGuard) check(ctx context.Context, db *sql.DB) error {
+	if g.ignore {
+		return nil
+	}
+	var logged bool
+	for {
+		reason, err := keymappActive(ctx, db)
+		if err != nil {
+			return err
+		}
+		if reason == "" {
+			return nil
+		}
+		if !g.wait {
+			return fmt.Errorf("%w (%s): close keymapp or use -wait or -ignore-running", errKeymappRunning, reason)
+		}
+		if !logged {
+			slog.Warn("waiting for keymapp to exit", "reason", reason)
+			logged = true
+		}
+		err = sleep(ctx, 2*time.Second)
+		if err != nil {
+			return fmt.Errorf("stopped waiting for keymapp to exit: %w", err)
+		}
+	}
+}
+
+// keymappActive returns a description of why keymapp is considered
+// to be using db, or the empty string if it is not.
+func keymappActive(ctx context.Context, db *sql.DB) (string, error) {
+	pid, err := findProcess("keymapp")
+	if err != nil {
+		slog.Debug("failed to check for keymapp process", "error", err)
+	}
+	if pid != 0 {
+		return fmt.Sprintf("pid %d", pid), nil
+	}
+	if db == nil {
+		return "", nil
+	}
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	_, err = conn.ExecContext(ctx, `BEGIN IMMEDIATE`)
+	if isBusy(err) {
+		return "database locked", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	_, err = conn.ExecContext(ctx, `ROLLBACK`)
+	return "", err
+}
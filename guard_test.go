@@ -0,0 +1,50 @@
+// Copyright ©2025 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestWriteGuardLocked(t *testing.T) {
+	db := newTestDB(t)
+	conn, err := db.Conn(context.Background())
+	if err != nil {
+		t.Fatalf("failed to get connection: %v", err)
+	}
+	defer conn.Close()
+	_, err = conn.ExecContext(context.Background(), `BEGIN IMMEDIATE`)
+	if err != nil {
+		t.Fatalf("failed to lock db: %v", err)
+	}
+	defer conn.ExecContext(context.Background(), `ROLLBACK`)
+
+	tests := []struct {
+		name  string
+		guard writeGuard
+		want  error
+	}{
+		{name: "refuse", guard: writeGuard{}, want: errKeymappRunning},
+		{name: "wait", guard: writeGuard{wait: true}, want: context.DeadlineExceeded},
+		{name: "ignore", guard: writeGuard{ignore: true}, want: nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+			start := time.Now()
+			err := test.guard.check(ctx, db)
+			if !errors.Is(err, test.want) {
+				t.Errorf("unexpected error: got:%v want:%v", err, test.want)
+			}
+			if d := time.Since(start); d > 10*time.Second {
+				t.Errorf("check did not stop promptly: took %v", d)
+			}
+		})
+	}
+}
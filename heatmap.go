@@ -0,0 +1,268 @@
+// Copyright ©2025 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// heatmapCmd manages keymapp heatmap data.
+func heatmapCmd(args []string) {
+	usage := func() {
+		fmt.Fprintln(os.Stderr, `usage: fkm heatmap build -input keylog.csv -revision ID [flags]`)
+		os.Exit(exitUsage)
+	}
+	if len(args) == 0 {
+		usage()
+	}
+	switch args[0] {
+	case "build":
+		heatmapBuild(args[1:])
+	default:
+		usage()
+	}
+}
+
+// heatmapBuild stores key press counts from a local key log as the
+// heatmap data of a revision.
+func heatmapBuild(args []string) {
+	fs := flag.NewFlagSet("fkm heatmap build", flag.ExitOnError)
+	var (
+		common commonFlags
+		guard  writeGuard
+	)
+	common.register(fs)
+	guard.register(fs)
+	input := fs.String("input", "", "CSV key log with layer, key and optional count columns (required)")
+	revID := fs.String("revision", "", "ID of the stored revision the key log was recorded with (required)")
+	merge := fs.Bool("merge", false, "add the counts to the revision's existing heatmap data")
+	dryRun := fs.Bool("dry-run", false, "print the changes that would be made to the database without making them")
+	backupDir := fs.String("backup-dir", "", "directory to back up the database to before changing it")
+	fs.Parse(args)
+	common.setup(fs)
+	if *input == "" || *revID == "" || fs.NArg() != 0 {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+
+	f, err := os.Open(*input)
+	if err != nil {
+		fatal("failed to open key log", err, "path", *input)
+	}
+	presses, err := readKeyLog(f)
+	f.Close()
+	if err != nil {
+		fatal("failed to read key log", err, "path", *input)
+	}
+
+	db := openExisting(common.dbPath, *dryRun, common.db)
+	defer db.Close()
+	l, err := loadLayout(db, *revID)
+	if err != nil {
+		fatal("failed to load revision", err, "revision", *revID)
+	}
+	h := newHeatmap(l.Revision.Layers)
+	if *merge {
+		old, err := loadHeatmap(db, *revID)
+		if err != nil {
+			fatal("failed to load heatmap", err, "revision", *revID)
+		}
+		err = h.add(old)
+		if err != nil {
+			fatal("failed to merge heatmap", err, "revision", *revID)
+		}
+	}
+	var total int
+	for _, p := range presses {
+		err = h.press(p)
+		if err != nil {
+			fatal("invalid key log", err, "path", *input, "revision", *revID)
+		}
+		total += p.count
+	}
+	data, err := json.Marshal(h)
+	if err != nil {
+		fatal("failed to encode heatmap", err)
+	}
+
+	changes := []change{setHeatmapData(*revID, data)}
+	if *dryRun {
+		printChanges(common.dbPath, changes)
+		return
+	}
+	err = guard.check(context.Background(), db)
+	if err != nil {
+		fatal("refusing to update db", err, "path", common.dbPath)
+	}
+	if *backupDir != "" {
+		err = autoBackup(db, *backupDir)
+		if err != nil {
+			fatal("failed to back up db", err, "path", common.dbPath)
+		}
+	}
+	err = apply(db, changes)
+	if err != nil {
+		fatal("failed to update db", err, "path", common.dbPath)
+	}
+	fmt.Printf("stored %d key presses for revision %s\n", total, *revID)
+}
+
+// keyPress is a count of presses of a key on a layer.
+type keyPress struct {
+	line  int // record number in the key log
+	layer int
+	key   int
+	count int
+}
+
+// readKeyLog reads a CSV key log. Each record holds a layer index, a
+// key index within the layer, in the order of the layer's keys in the
+// revision data, and an optional press count that defaults to one. The
+// first record may be a header naming the layer, key and count columns
+// in any order.
+func readKeyLog(r io.Reader) ([]keyPress, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+	cols := map[string]int{"layer": 0, "key": 1, "count": 2}
+	var presses []keyPress
+	for n := 1; ; n++ {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			return presses, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if n == 1 && slices.ContainsFunc(rec, func(f string) bool {
+			_, err := strconv.Atoi(f)
+			return err != nil
+		}) {
+			cols, err = keyLogColumns(rec)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		p := keyPress{line: n, count: 1}
+		for name, dst := range map[string]*int{"layer": &p.layer, "key": &p.key, "count": &p.count} {
+			i, ok := cols[name]
+			if !ok || i >= len(rec) {
+				if name == "count" {
+					continue
+				}
+				return nil, fmt.Errorf("record %d: missing %s", n, name)
+			}
+			*dst, err = strconv.Atoi(rec[i])
+			if err != nil || *dst < 0 {
+				return nil, fmt.Errorf("record %d: invalid %s %q", n, name, rec[i])
+			}
+		}
+		presses = append(presses, p)
+	}
+}
+
+// keyLogColumns returns the column index of each key log field named in
+// the header.
+func keyLogColumns(header []string) (map[string]int, error) {
+	cols := make(map[string]int)
+	for i, h := range header {
+		h = strings.ToLower(strings.TrimSpace(h))
+		switch h {
+		case "layer", "key", "count":
+			cols[h] = i
+		}
+	}
+	for _, name := range []string{"layer", "key"} {
+		if _, ok := cols[name]; !ok {
+			return nil, fmt.Errorf("key log header has no %s column", name)
+		}
+	}
+	return cols, nil
+}
+
+// heatmap is keymapp heatmap data: the press count of each key of each
+// layer, in the order of the layers and keys in the revision data.
+type heatmap [][]int
+
+// newHeatmap returns an empty heatmap for the layers.
+func newHeatmap(layers []layer) heatmap {
+	h := make(heatmap, len(layers))
+	for i, l := range layers {
+		h[i] = make([]int, len(l.Keys))
+	}
+	return h
+}
+
+// press adds the presses in p to the heatmap.
+func (h heatmap) press(p keyPress) error {
+	if p.layer >= len(h) {
+		return fmt.Errorf("record %d: layer %d out of range: revision has %d layers", p.line, p.layer, len(h))
+	}
+	if p.key >= len(h[p.layer]) {
+		return fmt.Errorf("record %d: key %d out of range: layer %d has %d keys", p.line, p.key, p.layer, len(h[p.layer]))
+	}
+	h[p.layer][p.key] += p.count
+	return nil
+}
+
+// add adds the counts in other to the heatmap. The heatmaps must have
+// the same shape.
+func (h heatmap) add(other heatmap) error {
+	if other == nil {
+		return nil
+	}
+	if len(other) != len(h) {
+		return fmt.Errorf("heatmap has %d layers but revision has %d", len(other), len(h))
+	}
+	for i, l := range other {
+		if len(l) != len(h[i]) {
+			return fmt.Errorf("heatmap layer %d has %d keys but revision layer has %d", i, len(l), len(h[i]))
+		}
+		for j, c := range l {
+			h[i][j] += c
+		}
+	}
+	return nil
+}
+
+// layer returns the press counts of the keys of layer i, or nil if
+// there are none.
+func (h heatmap) layer(i int) []int {
+	if i < 0 || i >= len(h) {
+		return nil
+	}
+	return h[i]
+}
+
+// loadHeatmap returns the stored heatmap data of the revision, or nil
+// if it has none.
+func loadHeatmap(db *sql.DB, id string) (heatmap, error) {
+	var data []byte
+	err := db.QueryRow(`SELECT data FROM heatmap WHERE revisionId=?`, id).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil || len(data) == 0 {
+		return nil, err
+	}
+	var h heatmap
+	err = json.Unmarshal(data, &h)
+	if err != nil {
+		return nil, fmt.Errorf("invalid heatmap data: %w", err)
+	}
+	return h, nil
+}
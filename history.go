@@ -0,0 +1,137 @@
+// Copyright ©2025 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// layoutHistory is a git repository holding the JSON of each fetched
+// layout revision, one file per layout, so that changes to layouts can
+// be reviewed with git.
+type layoutHistory struct {
+	// dir is the repository's working tree. If dir is empty,
+	// no history is recorded.
+	dir string
+}
+
+// add commits the revision data to the history. The data is written in
+// a canonical form, with sorted keys and fixed indentation, so that
+// diffs only show changes to the layout. The commit is dated with the
+// revision's creation time. If the layout's file is unchanged, no
+// commit is made.
+func (h layoutHistory) add(data []byte) error {
+	if h.dir == "" {
+		return nil
+	}
+	l, err := parseLayout(data)
+	if err != nil {
+		return err
+	}
+	if l.HashID == "" || strings.ContainsAny(l.HashID, `/\.`) {
+		return fmt.Errorf("invalid layout hash ID %q", l.HashID)
+	}
+	canon, err := canonicalJSON(data)
+	if err != nil {
+		return err
+	}
+	err = h.init()
+	if err != nil {
+		return err
+	}
+	name := l.HashID + ".json"
+	err = os.WriteFile(filepath.Join(h.dir, name), canon, 0o644)
+	if err != nil {
+		return err
+	}
+	_, err = h.git(nil, "add", "--", name)
+	if err != nil {
+		return err
+	}
+	_, err = h.git(nil, "diff", "--cached", "--quiet", "--", name)
+	if err == nil {
+		slog.Debug("layout history unchanged", "layout", l.HashID, "revision", l.Revision.HashID)
+		return nil
+	}
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return err
+	}
+
+	msg := fmt.Sprintf("%s (%s): revision %s", l.Title, l.Geometry, l.Revision.HashID)
+	if l.Revision.Title != "" {
+		msg += "\n\n" + l.Revision.Title
+	}
+	var env []string
+	if l.Revision.CreatedAt != "" {
+		env = append(env, "GIT_AUTHOR_DATE="+l.Revision.CreatedAt)
+	}
+	args := []string{"commit", "--quiet", "--message", msg, "--", name}
+	if _, err := h.git(nil, "config", "user.email"); err != nil {
+		// Allow commits without a configured identity.
+		args = append([]string{"-c", "user.name=fkm", "-c", "user.email=fkm@localhost"}, args...)
+	}
+	_, err = h.git(env, args...)
+	if err != nil {
+		return err
+	}
+	slog.Info("recorded layout history", "layout", l.HashID, "revision", l.Revision.HashID, "path", h.dir)
+	return nil
+}
+
+// init creates the history repository if it does not exist.
+func (h layoutHistory) init() error {
+	_, err := os.Stat(filepath.Join(h.dir, ".git"))
+	if err == nil {
+		return nil
+	}
+	err = os.MkdirAll(h.dir, 0o750)
+	if err != nil {
+		return err
+	}
+	_, err = h.git(nil, "init", "--quiet")
+	return err
+}
+
+// git runs a git command in the history repository with the additional
+// environment variables, returning its output.
+func (h layoutHistory) git(env []string, args ...string) ([]byte, error) {
+	cmd := exec.Command("git", append([]string{"-C", h.dir}, args...)...)
+	cmd.Env = append(os.Environ(), env...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil && stderr.Len() != 0 {
+		return out, fmt.Errorf("git %s: %w: %s", args[0], err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	return out, err
+}
+
+// canonicalJSON returns data with object keys sorted and indented by
+// two spaces, without escaping HTML characters and with numbers kept as
+// written.
+func canonicalJSON(data []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v any
+	err := dec.Decode(&v)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	err = enc.Encode(v)
+	return buf.Bytes(), err
+}
@@ -0,0 +1,167 @@
+// Copyright ©2025 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/kortschak/fkm/format"
+)
+
+// errUnidentifiedLayout is returned when an imported layout does not
+// have a geometry and layout hash ID.
+var errUnidentifiedLayout = errors.New("imported layout does not identify the keyboard and layout: use -geometry and -hash-id")
+
+// importCmd stores a revision reconstructed from layout sources.
+func importCmd(args []string) {
+	loadFormatPlugins()
+	fs := flag.NewFlagSet("fkm import", flag.ExitOnError)
+	var (
+		common commonFlags
+		guard  writeGuard
+		prov   provenanceFlags
+	)
+	common.register(fs)
+	guard.register(fs)
+	prov.register(fs)
+	zipPath := fs.String("oryx-zip", "", "path to an Oryx layout source archive, the same as -format oryx-zip -in <path>")
+	formatName := fs.String("format", "oryx-zip", "import format: "+formatNames(format.Importers()))
+	in := fs.String("in", "", "path of the file to import (required unless -oryx-zip is given)")
+	listFormats := fs.Bool("formats", false, "list the available import formats and exit")
+	title := fs.String("title", "", "layout title (default from the file name)")
+	geometry := fs.String("geometry", "", "layout keyboard geometry (default from the file or the attached keyboard)")
+	hashID := fs.String("hash-id", "", "layout hash ID (default from the file name)")
+	revID := fs.String("revision-id", "", "revision hash ID (default from the file name or its contents)")
+	mkDir := fs.Bool("mkdir", true, "create config directory")
+	force := fs.Bool("force", false, "replace a stored revision with the same ID that differs from the imported revision")
+	dryRun := fs.Bool("dry-run", false, "print the changes that would be made to the database without making them")
+	backupDir := fs.String("backup-dir", "", "directory to back up the database to before changing it")
+	detect := fs.Bool("detect-keyboard", true, "detect attached ZSA keyboards to default -geometry and warn if the layout is for another keyboard")
+	fs.Parse(args)
+	common.setup(fs)
+	if *listFormats {
+		if fs.NArg() != 0 {
+			fs.Usage()
+			os.Exit(exitUsage)
+		}
+		printFormats(format.Importers(), common.json)
+		return
+	}
+	path := *in
+	if *zipPath != "" {
+		if path != "" || (isSet(fs, "format") && *formatName != "oryx-zip") {
+			fmt.Fprintln(fs.Output(), "-oryx-zip cannot be used with -in or another -format")
+			fs.Usage()
+			os.Exit(exitUsage)
+		}
+		path, *formatName = *zipPath, "oryx-zip"
+	}
+	f, ok := format.LookupImporter(*formatName)
+	if path == "" || !ok || fs.NArg() != 0 {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+
+	opts := format.ImportOptions{
+		Title:    cmp.Or(*title, strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))),
+		Geometry: *geometry,
+		HashID:   *hashID,
+		Revision: *revID,
+	}
+	var detected bool
+	if *detect {
+		opts.Detect = func() string {
+			detected = true
+			return attachedGeometry()
+		}
+	}
+	rev, err := f.Import(path, opts)
+	if err != nil {
+		fatal("failed to import layout", err, "path", path, "format", *formatName)
+	}
+	l, err := parseLayout(rev)
+	if err != nil {
+		fatal("failed to import layout", err, "path", path, "format", *formatName)
+	}
+	if l.Geometry == "" || l.HashID == "" {
+		fatal("failed to identify layout", errUnidentifiedLayout, "path", path, "format", *formatName)
+	}
+	if *detect && !detected {
+		warnUnattached(l.Geometry)
+	}
+	id, err := revisionID(rev)
+	if err != nil {
+		fatal("failed to import layout", err, "path", path, "format", *formatName)
+	}
+
+	var db *sql.DB
+	if *dryRun {
+		db, err = openDBReadOnly(common.dbPath, common.db)
+	} else {
+		if *mkDir {
+			err = os.MkdirAll(filepath.Dir(common.dbPath), 0o750)
+			if err != nil {
+				fatal("unable to create config directory", err, "path", filepath.Dir(common.dbPath))
+			}
+		}
+		db, err = openDB(common.dbPath, common.db)
+	}
+	if err != nil {
+		fatal("failed to open db", err, "path", common.dbPath)
+	}
+	if db != nil {
+		defer db.Close()
+	}
+
+	changes, err := revisionChanges(db, id, "", rev, *force, prov.provenance(fileSource(path)))
+	if err != nil {
+		fatal("failed to check revision", err)
+	}
+	if *dryRun {
+		printChanges(common.dbPath, changes)
+		return
+	}
+	err = guard.check(context.Background(), db)
+	if err != nil {
+		fatal("refusing to update db", err, "path", common.dbPath)
+	}
+	if *backupDir != "" {
+		err = autoBackup(db, *backupDir)
+		if err != nil {
+			fatal("failed to back up db", err, "path", common.dbPath)
+		}
+	}
+	err = apply(db, changes)
+	if err != nil {
+		fatal("failed to update db", err, "path", common.dbPath)
+	}
+}
+
+// importOryxZip returns revision data reconstructed from the Oryx
+// source archive at path.
+func importOryxZip(path string, opts format.ImportOptions) ([]byte, error) {
+	src, err := readOryxZip(path)
+	if err != nil {
+		return nil, err
+	}
+	src.geometry = cmp.Or(opts.Geometry, src.geometry)
+	src.hashID = cmp.Or(opts.HashID, src.hashID)
+	src.revision = cmp.Or(opts.Revision, src.revision)
+	if src.geometry == "" && opts.Detect != nil {
+		src.geometry = opts.Detect()
+	}
+	if src.geometry == "" || src.hashID == "" {
+		return nil, errUnidentifiedArchive
+	}
+	return src.layout(opts.Title)
+}
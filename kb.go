@@ -0,0 +1,295 @@
+// Copyright ©2025 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// kb controls a keyboard through the API of a running keymapp.
+func kb(args []string) {
+	fs := flag.NewFlagSet("fkm kb", flag.ExitOnError)
+	var common commonFlags
+	common.register(fs)
+	addr := fs.String("addr", "", "address of the keymapp API (default localhost and the api_port in the database)")
+	timeout := fs.Duration("timeout", 5*time.Second, "timeout for each API request")
+	revID := fs.String("revision", "", "ID of a stored revision to take layer names from for layers")
+	led := fs.Int("led", -1, "index of the LED to set for rgb (default all LEDs)")
+	sustain := fs.Duration("sustain", 0, "time for rgb colors to be held before being reset (default until changed)")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), `usage: fkm kb [flags] <command> [args]
+
+Commands:
+  status         show keymapp and connected keyboard status
+  layers         show the layers of the keyboard, marking the current layer
+  set-layer N    lock the keyboard to layer N
+  unset-layer N  release a lock on layer N
+  rgb RRGGBB     set the color of the keyboard's LEDs
+
+keymapp must be running with its API enabled.
+
+Flags:
+`)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	common.setup(fs)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+	cmd, cmdArgs := fs.Arg(0), fs.Args()[1:]
+	wantArgs := map[string]int{"status": 0, "layers": 0, "set-layer": 1, "unset-layer": 1, "rgb": 1}
+	n, ok := wantArgs[cmd]
+	if !ok || len(cmdArgs) != n {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+
+	db, err := openDBReadOnly(common.dbPath, common.db)
+	if err != nil {
+		fatal("failed to open db", err, "path", common.dbPath)
+	}
+	if db != nil {
+		defer db.Close()
+	}
+	if *addr == "" {
+		*addr, err = keymappAPIAddr(db)
+		if err != nil {
+			fatal("failed to read api config", err, "path", common.dbPath)
+		}
+	}
+	api := keymappAPI{newGRPCClient(*addr, *timeout)}
+
+	switch cmd {
+	case "status":
+		var s keymappStatus
+		s, err = api.status()
+		if err == nil {
+			fmt.Printf("keymapp %s\n", s.version)
+			if s.keyboard == nil {
+				fmt.Println("no keyboard connected")
+			} else {
+				fmt.Printf("keyboard %s firmware %s layer %d\n", s.keyboard.name, s.keyboard.firmware, s.keyboard.layer)
+			}
+		}
+	case "layers":
+		err = showKeyboardLayers(api, db, *revID)
+	case "set-layer", "unset-layer":
+		var layer int
+		layer, err = strconv.Atoi(cmdArgs[0])
+		if err != nil || layer < 0 {
+			fmt.Fprintf(fs.Output(), "invalid layer %q\n", cmdArgs[0])
+			os.Exit(exitUsage)
+		}
+		err = api.connect()
+		if err == nil {
+			err = api.setLayer(layer, cmd == "set-layer")
+		}
+	case "rgb":
+		var r, g, b int
+		r, g, b, err = parseRGB(cmdArgs[0])
+		if err != nil {
+			fmt.Fprintln(fs.Output(), err)
+			os.Exit(exitUsage)
+		}
+		err = api.connect()
+		if err == nil {
+			err = api.setRGB(*led, r, g, b, *sustain)
+		}
+	}
+	if err != nil {
+		fatal("keymapp API request failed", err, "addr", *addr, "command", cmd)
+	}
+}
+
+// keymappAPIAddr returns the address of the keymapp API configured in
+// db, or the keymapp default if db is nil or has no API configuration.
+func keymappAPIAddr(db *sql.DB) (string, error) {
+	port := "50051"
+	ok, err := hasTable(db, "config")
+	if err != nil {
+		return "", err
+	}
+	if ok {
+		var p string
+		err = db.QueryRow(`SELECT value FROM config WHERE key='api_port'`).Scan(&p)
+		switch {
+		case err == nil:
+			port = p
+		case !errors.Is(err, sql.ErrNoRows):
+			return "", err
+		}
+	}
+	return net.JoinHostPort("localhost", port), nil
+}
+
+// parseRGB parses a hex color, RRGGBB, optionally prefixed with '#'.
+func parseRGB(s string) (r, g, b int, err error) {
+	h := strings.TrimPrefix(s, "#")
+	v, err := strconv.ParseUint(h, 16, 32)
+	if len(h) != 6 || err != nil {
+		return 0, 0, 0, fmt.Errorf("invalid color %q: must be RRGGBB", s)
+	}
+	return int(v >> 16), int(v >> 8 & 0xff), int(v & 0xff), nil
+}
+
+// showKeyboardLayers prints the current layer of the connected keyboard
+// and, if revID is not empty, the layers of that stored revision.
+func showKeyboardLayers(api keymappAPI, db *sql.DB, revID string) error {
+	s, err := api.status()
+	if err != nil {
+		return err
+	}
+	if s.keyboard == nil {
+		return errors.New("no keyboard connected")
+	}
+	if revID == "" {
+		fmt.Printf("current layer %d\n", s.keyboard.layer)
+		return nil
+	}
+	if db == nil {
+		return errors.New("no database to read revision from")
+	}
+	l, err := loadLayout(db, revID)
+	if err != nil {
+		return err
+	}
+	for i, ly := range l.Revision.Layers {
+		mark := " "
+		if i == s.keyboard.layer {
+			mark = "*"
+		}
+		fmt.Printf("%s %d  %s\n", mark, i, ly.Name())
+	}
+	return nil
+}
+
+// keymappAPI is a client for the keymapp KeyboardService gRPC API, as
+// described by api.proto in ZSA's kontroll.
+type keymappAPI struct {
+	cli *grpcClient
+}
+
+const keymappService = "/api.KeyboardService/"
+
+// keymappStatus is a GetStatus reply.
+type keymappStatus struct {
+	version  string
+	keyboard *connectedKeyboard
+}
+
+// connectedKeyboard is the keyboard keymapp is connected to.
+type connectedKeyboard struct {
+	name     string
+	firmware string
+	layer    int
+}
+
+// status returns the status of keymapp and its connected keyboard.
+func (a keymappAPI) status() (keymappStatus, error) {
+	resp, err := a.call("GetStatus", nil)
+	if err != nil {
+		return keymappStatus{}, err
+	}
+	s := keymappStatus{version: resp.string(1)}
+	kb, err := resp.message(2)
+	if err != nil {
+		return keymappStatus{}, err
+	}
+	if kb != nil {
+		s.keyboard = &connectedKeyboard{
+			name:     kb.string(1),
+			firmware: kb.string(2),
+			layer:    int(kb.int(3)),
+		}
+	}
+	return s, nil
+}
+
+// connect connects keymapp to a keyboard if it is not already
+// connected.
+func (a keymappAPI) connect() error {
+	s, err := a.status()
+	if err != nil {
+		return err
+	}
+	if s.keyboard != nil {
+		return nil
+	}
+	resp, err := a.call("ConnectAnyKeyboard", nil)
+	if err != nil {
+		return err
+	}
+	if !resp.bool(1) {
+		return errors.New("failed to connect to a keyboard")
+	}
+	return nil
+}
+
+// setLayer locks the keyboard to the layer, or releases the lock if
+// lock is false.
+func (a keymappAPI) setLayer(layer int, lock bool) error {
+	method, verb := "SetLayer", "set"
+	if !lock {
+		method, verb = "UnsetLayer", "unset"
+	}
+	var req protoMessage
+	req.varint(1, int64(layer))
+	resp, err := a.call(method, req.bytes())
+	if err != nil {
+		return err
+	}
+	if !resp.bool(1) {
+		return fmt.Errorf("keymapp did not %s layer %d", verb, layer)
+	}
+	return nil
+}
+
+// setRGB sets the color of the LED, or all LEDs if led is negative,
+// for the sustain duration, or until changed if sustain is zero.
+func (a keymappAPI) setRGB(led, r, g, b int, sustain time.Duration) error {
+	var req protoMessage
+	method := "SetRGBAll"
+	field := 1
+	if led >= 0 {
+		method = "SetRGBLed"
+		req.varint(1, int64(led))
+		field = 2
+	}
+	req.varint(field, int64(r))
+	req.varint(field+1, int64(g))
+	req.varint(field+2, int64(b))
+	req.varint(field+3, sustain.Milliseconds())
+	resp, err := a.call(method, req.bytes())
+	if err != nil {
+		return err
+	}
+	if !resp.bool(1) {
+		return errors.New("keymapp did not set the LED color")
+	}
+	return nil
+}
+
+// call invokes the KeyboardService method and decodes its reply.
+func (a keymappAPI) call(method string, req []byte) (protoFields, error) {
+	b, err := a.cli.call(keymappService+method, req)
+	if err != nil {
+		var opErr *net.OpError
+		if errors.As(err, &opErr) {
+			return nil, fmt.Errorf("%w: is keymapp running with its API enabled?", err)
+		}
+		return nil, err
+	}
+	return decodeProto(b)
+}
@@ -0,0 +1,266 @@
+// Copyright ©2025 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// configCmd reads and writes keymapp configuration values.
+func configCmd(args []string) {
+	usage := func() {
+		fmt.Fprintln(os.Stderr, `usage: fkm config list [flags]
+       fkm config get [flags] <key>
+       fkm config set [flags] <key> <value>
+
+Read or write keymapp configuration values. Keys and values written by
+set, and by the defaults of the [config] table of the configuration file
+and profiles, are checked against the keys known to be read by the
+keymapp release that wrote the database.`)
+		os.Exit(exitUsage)
+	}
+	if len(args) == 0 {
+		usage()
+	}
+	cmd, args := args[0], args[1:]
+	nargs := map[string]int{"list": 0, "get": 1, "set": 2}
+	n, ok := nargs[cmd]
+	if !ok {
+		usage()
+	}
+
+	fs := flag.NewFlagSet("fkm config "+cmd, flag.ExitOnError)
+	var (
+		common commonFlags
+		guard  writeGuard
+	)
+	common.register(fs)
+	var dryRun, force *bool
+	var backupDir *string
+	if cmd == "set" {
+		guard.register(fs)
+		dryRun = fs.Bool("dry-run", false, "print the changes that would be made to the database without making them")
+		backupDir = fs.String("backup-dir", "", "directory to back up the database to before changing it")
+		force = fs.Bool("force", false, "write keys and values that are not known to be valid")
+	}
+	fs.Parse(args)
+	common.setup(fs)
+	if fs.NArg() != n {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+
+	readOnly := cmd != "set" || *dryRun
+	db := openExisting(common.dbPath, readOnly, common.db)
+	defer db.Close()
+
+	switch cmd {
+	case "list":
+		cfg, err := keymappConfig(db)
+		if err != nil {
+			fatal("failed to read config", err, "path", common.dbPath)
+		}
+		if common.json {
+			printJSON(cfg)
+			return
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "KEY\tVALUE")
+		for _, k := range slices.Sorted(maps.Keys(cfg)) {
+			fmt.Fprintf(w, "%s\t%s\n", k, cfg[k])
+		}
+		err = w.Flush()
+		if err != nil {
+			fatal("failed to write config", err)
+		}
+	case "get":
+		key := fs.Arg(0)
+		cfg, err := keymappConfig(db)
+		if err != nil {
+			fatal("failed to read config", err, "path", common.dbPath)
+		}
+		val, ok := cfg[key]
+		if !ok {
+			fatal("failed to get config", fmt.Errorf("%s is not set", key))
+		}
+		if common.json {
+			printJSON(map[string]string{key: val})
+			return
+		}
+		fmt.Println(val)
+	case "set":
+		key, val := fs.Arg(0), fs.Arg(1)
+		release, err := keymappRelease(db)
+		if err != nil {
+			fatal("failed to read schema", err, "path", common.dbPath)
+		}
+		err = checkConfigValue(release, key, val)
+		if err != nil {
+			if !*force {
+				fatal("refusing to set config", exitError{status: exitUsage, err: err}, "hint", "use -force to write it anyway")
+			}
+			slog.Warn("setting config value that is not known to be valid", "error", err)
+		}
+		changes := setConfig(key, val)
+		if *dryRun {
+			printChanges(common.dbPath, changes)
+			return
+		}
+		err = guard.check(context.Background(), db)
+		if err != nil {
+			fatal("refusing to update db", err, "path", common.dbPath)
+		}
+		if *backupDir != "" {
+			err = autoBackup(db, *backupDir)
+			if err != nil {
+				fatal("failed to back up db", err, "path", common.dbPath)
+			}
+		}
+		err = apply(db, changes)
+		if err != nil {
+			fatal("failed to update db", err, "path", common.dbPath)
+		}
+	}
+}
+
+// keymappConfig returns the keymapp configuration values in db. If a
+// key has more than one value, the last is returned.
+func keymappConfig(db *sql.DB) (map[string]string, error) {
+	ok, err := hasTable(db, "config")
+	if err != nil || !ok {
+		return nil, err
+	}
+	rows, err := db.Query(`SELECT key, value FROM config ORDER BY rowid`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	cfg := make(map[string]string)
+	for rows.Next() {
+		var key, val sql.NullString
+		err = rows.Scan(&key, &val)
+		if err != nil {
+			return nil, err
+		}
+		cfg[key.String] = val.String
+	}
+	return cfg, rows.Err()
+}
+
+// configKind is the type of a keymapp configuration value.
+type configKind int
+
+const (
+	configString configKind = iota
+	configBool              // "0" or "1"
+	configInt               // decimal integer in [min, max]
+)
+
+// configKey describes a keymapp configuration key.
+type configKey struct {
+	kind     configKind
+	min, max int
+}
+
+// keymappConfigKeys are the configuration keys read by the keymapp
+// release whose schema fkm knows. No differences in the keys read by
+// older releases are known, so databases written by older releases are
+// checked against the same keys. Newer releases may read keys fkm does
+// not know.
+var keymappConfigKeys = map[string]configKey{
+	"prompt_update_check":  {kind: configBool},
+	"update_check":         {kind: configBool},
+	"startup_minimized":    {kind: configBool},
+	"startup_autoconnect":  {kind: configBool},
+	"smart_layers_enabled": {kind: configBool},
+	"api_enabled":          {kind: configBool},
+	"api_port":             {kind: configInt, min: 1, max: 65535},
+}
+
+// keymappRelease returns a description of the keymapp release that
+// wrote db, relative to the release whose schema fkm knows. This is the
+// release recorded when fkm first migrated db, unless the schema now
+// has columns unknown to fkm, as added by a newer release. Databases
+// without keymapp tables, including a nil db, are described as current
+// since fkm will create them.
+func keymappRelease(db *sql.DB) (string, error) {
+	d, err := diffSchema(db)
+	if err != nil {
+		return "", err
+	}
+	if len(d.missingTables) == len(keymappTables) {
+		return "current", nil
+	}
+	release := d.keymapp()
+	if release == "newer" || release == "unknown" {
+		return release, nil
+	}
+	recorded, err := recordedRelease(db)
+	if err != nil || recorded == "" {
+		return release, err
+	}
+	return recorded, nil
+}
+
+// recordedRelease returns the keymapp release recorded when the first
+// fkm migration was applied to db, or the empty string if none was
+// recorded.
+func recordedRelease(db *sql.DB) (string, error) {
+	ok, err := hasTable(db, "fkm_schema_version")
+	if err != nil || !ok {
+		return "", err
+	}
+	var release sql.NullString
+	err = db.QueryRow(`SELECT keymapp FROM fkm_schema_version ORDER BY version LIMIT 1`).Scan(&release)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return release.String, err
+}
+
+// checkConfigValue returns an error if key is not read by the keymapp
+// release, or val is not a valid value for it. Keys unknown to fkm are
+// accepted for releases newer than the one fkm knows, but values of
+// known keys are still checked.
+func checkConfigValue(release, key, val string) error {
+	k, known := keymappConfigKeys[key]
+	if !known {
+		if release == "newer" || release == "unknown" {
+			slog.Warn("config key is not known to fkm", "key", key, "keymapp", release)
+			return nil
+		}
+		names := slices.Sorted(maps.Keys(keymappConfigKeys))
+		if close := closeMatches(key, names); len(close) != 0 {
+			return fmt.Errorf("unknown config key %q: did you mean %s?", key, strings.Join(close, " or "))
+		}
+		return fmt.Errorf("unknown config key %q: known keys are %s", key, strings.Join(names, ", "))
+	}
+	switch k.kind {
+	case configBool:
+		if val != "0" && val != "1" {
+			return fmt.Errorf("invalid value %q for %s: must be 0 or 1", val, key)
+		}
+	case configInt:
+		n, err := strconv.Atoi(val)
+		if err != nil {
+			return fmt.Errorf("invalid value %q for %s: must be an integer", val, key)
+		}
+		if n < k.min || n > k.max {
+			return fmt.Errorf("invalid value %d for %s: must be between %d and %d", n, key, k.min, k.max)
+		}
+	}
+	return nil
+}
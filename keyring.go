@@ -0,0 +1,55 @@
+// Copyright ©2025 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// The service and account names the Oryx login is held under in the
+// OS keyring.
+const (
+	keyringService = "fkm"
+	keyringAccount = "oryx"
+)
+
+// errNoKeyringSecret is returned when the OS keyring holds no Oryx
+// login.
+var errNoKeyringSecret = errors.New("no Oryx login in the OS keyring")
+
+// keyringLogin returns the Oryx login held in the OS keyring.
+func keyringLogin() (authLogin, error) {
+	secret, err := keyringGet()
+	if err != nil {
+		return authLogin{}, err
+	}
+	var l struct {
+		Username string `json:"username"`
+		Token    string `json:"token"`
+	}
+	err = json.Unmarshal([]byte(secret), &l)
+	if err != nil {
+		return authLogin{}, fmt.Errorf("invalid keyring secret: %w", err)
+	}
+	if l.Token == "" {
+		return authLogin{}, errors.New("invalid keyring secret: no token")
+	}
+	return authLogin{token: l.Token, username: l.Username}, nil
+}
+
+// setKeyringLogin stores the Oryx login in the OS keyring, replacing
+// any login already held.
+func setKeyringLogin(l authLogin) error {
+	b, err := json.Marshal(struct {
+		Username string `json:"username"`
+		Token    string `json:"token"`
+	}{l.username, l.token})
+	if err != nil {
+		return err
+	}
+	return keyringSet(string(b))
+}
@@ -0,0 +1,54 @@
+// Copyright ©2025 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// keyringSet stores secret in the login Keychain. The security command
+// is run interactively so that the secret does not appear in its
+// arguments.
+func keyringSet(secret string) error {
+	cmd := exec.Command("security", "-i")
+	cmd.Stdin = strings.NewReader(fmt.Sprintf("add-generic-password -U -s %s -a %s -l %s -w %s\n",
+		keyringService, keyringAccount, strconv.Quote("fkm Oryx login"), strconv.Quote(secret)))
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if msg := strings.TrimSpace(stderr.String()); msg != "" {
+		return errors.New(msg)
+	}
+	return err
+}
+
+// keyringGet returns the secret held in the login Keychain.
+func keyringGet() (string, error) {
+	out, err := exec.Command("security", "find-generic-password", "-s", keyringService, "-a", keyringAccount, "-w").Output()
+	if err != nil {
+		var exit *exec.ExitError
+		if errors.As(err, &exit) && exit.ExitCode() == 44 {
+			// errSecItemNotFound
+			return "", errNoKeyringSecret
+		}
+		return "", err
+	}
+	return strings.TrimSuffix(string(out), "\n"), nil
+}
+
+// keyringDelete removes the secret from the login Keychain.
+func keyringDelete() error {
+	err := exec.Command("security", "delete-generic-password", "-s", keyringService, "-a", keyringAccount).Run()
+	var exit *exec.ExitError
+	if errors.As(err, &exit) && exit.ExitCode() == 44 {
+		return errNoKeyringSecret
+	}
+	return err
+}
@@ -0,0 +1,62 @@
+// Copyright ©2025 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os/exec"
+	"strings"
+)
+
+// keyringSet stores secret in the Secret Service keyring using
+// secret-tool. The secret is passed on standard input.
+func keyringSet(secret string) error {
+	cmd := exec.Command("secret-tool", "store", "--label=fkm Oryx login", "service", keyringService, "account", keyringAccount)
+	cmd.Stdin = strings.NewReader(secret)
+	return keyringRun(cmd)
+}
+
+// keyringGet returns the secret held in the Secret Service keyring.
+func keyringGet() (string, error) {
+	cmd := exec.Command("secret-tool", "lookup", "service", keyringService, "account", keyringAccount)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	var exit *exec.ExitError
+	switch {
+	case errors.As(err, &exit) && stderr.Len() == 0, err == nil && stdout.Len() == 0:
+		// secret-tool lookup fails silently when there is
+		// no matching secret.
+		return "", errNoKeyringSecret
+	case err != nil:
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", errors.New(msg)
+		}
+		return "", err
+	}
+	return stdout.String(), nil
+}
+
+// keyringDelete removes the secret from the Secret Service keyring.
+func keyringDelete() error {
+	return keyringRun(exec.Command("secret-tool", "clear", "service", keyringService, "account", keyringAccount))
+}
+
+// keyringRun runs the keyring command, including its standard error
+// in any error returned.
+func keyringRun(cmd *exec.Cmd) error {
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return errors.New(msg)
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,23 @@
+// Copyright ©2025 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !linux && !darwin && !windows
+
+package main
+
+import "errors"
+
+var errNoKeyringSupport = errors.New("the OS keyring is not supported on this platform")
+
+// keyringSet stores secret in the OS keyring. The keyring is not
+// supported on this platform.
+func keyringSet(secret string) error { return errNoKeyringSupport }
+
+// keyringGet returns the secret held in the OS keyring. The keyring is
+// not supported on this platform.
+func keyringGet() (string, error) { return "", errNoKeyringSupport }
+
+// keyringDelete removes the secret from the OS keyring. The keyring is
+// not supported on this platform.
+func keyringDelete() error { return errNoKeyringSupport }
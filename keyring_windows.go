@@ -0,0 +1,63 @@
+// Copyright ©2025 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os/exec"
+	"strings"
+)
+
+// keyringVault loads the Windows Runtime password vault, which is held
+// by the Credential Manager. Scripts exit with status 44 if the secret
+// is not found.
+const keyringVault = `[Windows.Security.Credentials.PasswordVault, Windows.Security.Credentials, ContentType = WindowsRuntime] > $null
+$vault = [Windows.Security.Credentials.PasswordVault]::new()
+function Get-Secret { try { $vault.Retrieve('` + keyringService + `', '` + keyringAccount + `') } catch { exit 44 } }
+`
+
+// keyringSet stores secret in the Credential Manager. The secret is
+// passed on standard input.
+func keyringSet(secret string) error {
+	_, err := keyringPowerShell(keyringVault+`$secret = [Console]::In.ReadToEnd()
+$vault.Add([Windows.Security.Credentials.PasswordCredential]::new('`+keyringService+`', '`+keyringAccount+`', $secret))`, secret)
+	return err
+}
+
+// keyringGet returns the secret held in the Credential Manager.
+func keyringGet() (string, error) {
+	return keyringPowerShell(keyringVault+`$c = Get-Secret
+$c.RetrievePassword()
+[Console]::Out.Write($c.Password)`, "")
+}
+
+// keyringDelete removes the secret from the Credential Manager.
+func keyringDelete() error {
+	_, err := keyringPowerShell(keyringVault+`$vault.Remove((Get-Secret))`, "")
+	return err
+}
+
+// keyringPowerShell runs script with stdin as its standard input and
+// returns its standard output.
+func keyringPowerShell(script, stdin string) (string, error) {
+	cmd := exec.Command("powershell", "-NoProfile", "-NonInteractive", "-Command", script)
+	cmd.Stdin = strings.NewReader(stdin)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if err != nil {
+		var exit *exec.ExitError
+		if errors.As(err, &exit) && exit.ExitCode() == 44 {
+			return "", errNoKeyringSecret
+		}
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", errors.New(msg)
+		}
+		return "", err
+	}
+	return stdout.String(), nil
+}
@@ -0,0 +1,203 @@
+// Copyright ©2025 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"regexp"
+	"strings"
+	"text/tabwriter"
+)
+
+// layerCmd lists or edits the cosmetic attributes of the layers of a
+// stored revision.
+func layerCmd(args []string) {
+	usage := func() {
+		fmt.Fprintln(os.Stderr, `usage: fkm layer list -revision <id> [flags]
+       fkm layer set -revision <id> -layer N [-color '#rrggbb'] [-title <title>] [flags]
+
+List the layers of a stored revision, or set the color or title of a
+layer in the stored revision without a round trip through Oryx. An empty
+-color removes the layer's color. The stored revision is rewritten in
+place, so a later fetch of the same revision will report a conflict
+unless -force is used.`)
+		os.Exit(exitUsage)
+	}
+	if len(args) == 0 {
+		usage()
+	}
+	cmd, args := args[0], args[1:]
+	if cmd != "list" && cmd != "set" {
+		usage()
+	}
+
+	fs := flag.NewFlagSet("fkm layer "+cmd, flag.ExitOnError)
+	var (
+		common commonFlags
+		guard  writeGuard
+		prov   provenanceFlags
+	)
+	common.register(fs)
+	revID := fs.String("revision", "", "stored revision ID (required)")
+	var (
+		position           *int
+		color, title       *string
+		backupDir          *string
+		dryRun             = new(bool)
+		setColor, setTitle bool
+	)
+	if cmd == "set" {
+		guard.register(fs)
+		prov.register(fs)
+		position = fs.Int("layer", -1, "position of the layer to edit (required)")
+		color = fs.String("color", "", "layer color as #rrggbb")
+		title = fs.String("title", "", "layer title")
+		dryRun = fs.Bool("dry-run", false, "print the changes that would be made to the database without making them")
+		backupDir = fs.String("backup-dir", "", "directory to back up the database to before changing it")
+	}
+	fs.Parse(args)
+	common.setup(fs)
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "color":
+			setColor = true
+		case "title":
+			setTitle = true
+		}
+	})
+	if fs.NArg() != 0 || *revID == "" || (cmd == "set" && (*position < 0 || !setColor && !setTitle)) {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+	if setColor && *color != "" && !layerColor.MatchString(*color) {
+		fatal("invalid color", usageError("color must be #rrggbb"), "color", *color)
+	}
+
+	db := openExisting(common.dbPath, cmd == "list" || *dryRun, common.db)
+	defer db.Close()
+	data, err := loadRevision(db, *revID)
+	if err != nil {
+		fatal("failed to load revision", err, "revision", *revID)
+	}
+
+	if cmd == "list" {
+		l, err := parseLayout(data)
+		if err != nil {
+			fatal("failed to parse revision", err, "revision", *revID)
+		}
+		if common.json {
+			type listedLayer struct {
+				Position int    `json:"position"`
+				Title    string `json:"title"`
+				Color    string `json:"color,omitempty"`
+			}
+			layers := []listedLayer{}
+			for _, ly := range l.Revision.Layers {
+				layers = append(layers, listedLayer{ly.Position, ly.Title, ly.Color})
+			}
+			printJSON(layers)
+			return
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "LAYER\tTITLE\tCOLOR")
+		for _, ly := range l.Revision.Layers {
+			fmt.Fprintf(w, "%d\t%s\t%s\n", ly.Position, ly.Title, ly.Color)
+		}
+		err = w.Flush()
+		if err != nil {
+			fatal("failed to write layers", err)
+		}
+		return
+	}
+
+	attrs := make(map[string]any)
+	if setColor {
+		if *color == "" {
+			attrs["color"] = nil
+		} else {
+			attrs["color"] = strings.ToLower(*color)
+		}
+	}
+	if setTitle {
+		attrs["title"] = *title
+	}
+	edited, err := editLayer(data, *position, attrs)
+	if err != nil {
+		fatal("failed to edit layer", err, "revision", *revID, "layer", *position)
+	}
+	md5sum, err := checkMD5(edited)
+	if err != nil {
+		slog.Warn("revision config does not match its md5 checksum", "revision", *revID, "error", err)
+	}
+	changes := []change{
+		storeRevision(*revID, edited, "update"),
+		upsertChecksum(*revID, md5sum, edited),
+		recordProvenance(*revID, prov.provenance("revision:"+*revID)),
+	}
+	if *dryRun {
+		printChanges(common.dbPath, changes)
+		return
+	}
+	err = guard.check(context.Background(), db)
+	if err != nil {
+		fatal("refusing to update db", err, "path", common.dbPath)
+	}
+	if *backupDir != "" {
+		err = autoBackup(db, *backupDir)
+		if err != nil {
+			fatal("failed to back up db", err, "path", common.dbPath)
+		}
+	}
+	err = apply(db, changes)
+	if err != nil {
+		fatal("failed to update db", err, "path", common.dbPath)
+	}
+}
+
+// layerColor matches a layer color.
+var layerColor = regexp.MustCompile(`^#[0-9A-Fa-f]{6}$`)
+
+// editLayer returns the revision data with the attributes of the layer
+// at the given position replaced by attrs. Other fields keep their
+// original encoding.
+func editLayer(data []byte, position int, attrs map[string]any) ([]byte, error) {
+	return editLayout(data, func(_, revision map[string]json.RawMessage) error {
+		var layers []json.RawMessage
+		err := json.Unmarshal(revision["layers"], &layers)
+		if err != nil {
+			return fmt.Errorf("failed to parse layers: %w", err)
+		}
+		found := false
+		for i, raw := range layers {
+			var l map[string]json.RawMessage
+			err = json.Unmarshal(raw, &l)
+			if err != nil {
+				return fmt.Errorf("failed to parse layer: %w", err)
+			}
+			var pos int
+			if json.Unmarshal(l["position"], &pos) != nil || pos != position {
+				continue
+			}
+			for k, v := range attrs {
+				l[k], err = marshalRaw(v)
+				if err != nil {
+					return err
+				}
+			}
+			layers[i] = encodeObject(l)
+			found = true
+		}
+		if !found {
+			return fmt.Errorf("no layer at position %d", position)
+		}
+		revision["layers"] = encodeArray(layers)
+		return nil
+	})
+}
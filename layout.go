@@ -0,0 +1,33 @@
+// Copyright ©2025 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import "github.com/kortschak/fkm/format"
+
+// The layout model is defined in the format package so that format
+// plugins can use it.
+type (
+	// layoutData is the data returned by the Oryx getLayout query
+	// and stored in the keymapp revision table.
+	layoutData = format.Data
+
+	layout         = format.Layout
+	layoutTag      = format.Tag
+	layoutRevision = format.Revision
+	revisionConfig = format.Config
+	combo          = format.Combo
+	layer          = format.Layer
+	key            = format.Key
+	keyActionKind  = format.KeyActionKind
+	keyAction      = format.KeyAction
+	modifiers      = format.Modifiers
+	tour           = format.Tour
+	tourStep       = format.TourStep
+)
+
+// parseLayout parses stored revision data.
+func parseLayout(data []byte) (*layout, error) {
+	return format.Parse(data)
+}
@@ -0,0 +1,189 @@
+// Copyright ©2025 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// layoutSchemaJSON is the JSON Schema describing the revision data
+// fkm stores.
+//
+//go:embed layout.schema.json
+var layoutSchemaJSON []byte
+
+// jsonSchema is the subset of JSON Schema used by layout.schema.json:
+// type, required, properties, items, minItems, minLength, minimum and
+// references to $defs.
+type jsonSchema struct {
+	Type       schemaTypes            `json:"type"`
+	Required   []string               `json:"required"`
+	Properties map[string]*jsonSchema `json:"properties"`
+	Items      *jsonSchema            `json:"items"`
+	MinItems   *int                   `json:"minItems"`
+	MinLength  *int                   `json:"minLength"`
+	Minimum    *float64               `json:"minimum"`
+	Ref        string                 `json:"$ref"`
+	Defs       map[string]*jsonSchema `json:"$defs"`
+}
+
+// schemaTypes is the value of a type keyword, which may be a single
+// type name or a list of them.
+type schemaTypes []string
+
+func (t *schemaTypes) UnmarshalJSON(data []byte) error {
+	var s string
+	if json.Unmarshal(data, &s) == nil {
+		*t = schemaTypes{s}
+		return nil
+	}
+	return json.Unmarshal(data, (*[]string)(t))
+}
+
+// layoutSchema returns the parsed embedded layout schema.
+var layoutSchema = sync.OnceValues(func() (*jsonSchema, error) {
+	var s jsonSchema
+	err := json.Unmarshal(layoutSchemaJSON, &s)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse layout schema: %w", err)
+	}
+	return &s, nil
+})
+
+// schemaError is a failure of a JSON value to match a schema at the
+// path of the failing value.
+type schemaError struct {
+	path string
+	msg  string
+}
+
+func (e schemaError) String() string { return e.path + ": " + e.msg }
+
+// schemaErrors is the set of schema failures of a JSON value.
+type schemaErrors []schemaError
+
+// maxSchemaErrors is the number of schema failures included in the
+// text of a schemaErrors.
+const maxSchemaErrors = 5
+
+func (e schemaErrors) Error() string {
+	var buf strings.Builder
+	buf.WriteString("data does not match layout schema: ")
+	for i, err := range e {
+		if i == maxSchemaErrors {
+			fmt.Fprintf(&buf, " (and %d more)", len(e)-i)
+			break
+		}
+		if i != 0 {
+			buf.WriteString("; ")
+		}
+		buf.WriteString(err.String())
+	}
+	return buf.String()
+}
+
+// validateLayout checks that the revision data matches the embedded
+// layout schema, returning a schemaErrors describing each mismatch.
+// It is used before storing data fetched from Oryx or imported from
+// files so that truncated or changed responses are not stored.
+func validateLayout(data []byte) error {
+	s, err := layoutSchema()
+	if err != nil {
+		return err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v any
+	err = dec.Decode(&v)
+	if err != nil {
+		return schemaErrors{{path: "$", msg: fmt.Sprintf("invalid JSON: %v", err)}}
+	}
+	var errs schemaErrors
+	s.validate(s, "$", v, &errs)
+	if len(errs) != 0 {
+		return errs
+	}
+	return nil
+}
+
+// validate appends the failures of v to match s to errs. Paths are
+// written in JSONPath form, for example $.layout.revision.layers[2].keys.
+func (s *jsonSchema) validate(root *jsonSchema, path string, v any, errs *schemaErrors) {
+	if s.Ref != "" {
+		name, ok := strings.CutPrefix(s.Ref, "#/$defs/")
+		def := root.Defs[name]
+		if !ok || def == nil {
+			*errs = append(*errs, schemaError{path, fmt.Sprintf("unresolved schema reference %q", s.Ref)})
+			return
+		}
+		s = def
+	}
+	if len(s.Type) != 0 && !slices.Contains(s.Type, jsonType(v)) && !(jsonType(v) == "integer" && slices.Contains(s.Type, "number")) {
+		*errs = append(*errs, schemaError{path, fmt.Sprintf("expected %s, got %s", strings.Join(s.Type, " or "), jsonType(v))})
+		return
+	}
+	switch v := v.(type) {
+	case map[string]any:
+		for _, k := range s.Required {
+			if _, ok := v[k]; !ok {
+				*errs = append(*errs, schemaError{path, fmt.Sprintf("missing required field %q", k)})
+			}
+		}
+		for _, k := range slices.Sorted(maps.Keys(s.Properties)) {
+			if e, ok := v[k]; ok {
+				s.Properties[k].validate(root, path+"."+k, e, errs)
+			}
+		}
+	case []any:
+		if s.MinItems != nil && len(v) < *s.MinItems {
+			*errs = append(*errs, schemaError{path, fmt.Sprintf("expected at least %d items, got %d", *s.MinItems, len(v))})
+		}
+		if s.Items != nil {
+			for i, e := range v {
+				s.Items.validate(root, fmt.Sprintf("%s[%d]", path, i), e, errs)
+			}
+		}
+	case string:
+		if s.MinLength != nil && utf8.RuneCountInString(v) < *s.MinLength {
+			*errs = append(*errs, schemaError{path, fmt.Sprintf("expected at least %d characters, got %q", *s.MinLength, v)})
+		}
+	case json.Number:
+		f, err := v.Float64()
+		if err == nil && s.Minimum != nil && f < *s.Minimum {
+			*errs = append(*errs, schemaError{path, fmt.Sprintf("expected at least %v, got %v", *s.Minimum, v)})
+		}
+	}
+}
+
+// jsonType returns the JSON Schema type name of a value decoded with
+// numbers as json.Number.
+func jsonType(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case json.Number:
+		if _, err := v.Int64(); err == nil {
+			return "integer"
+		}
+		return "number"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	}
+	return fmt.Sprintf("%T", v)
+}
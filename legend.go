@@ -0,0 +1,198 @@
+// Copyright ©2025 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// keycodesJSON is the table of legends for QMK key codes in the host
+// keyboard locales fkm knows about.
+//
+//go:embed keycodes.json
+var keycodesJSON []byte
+
+// keycodeTable is the structure of keycodes.json.
+type keycodeTable struct {
+	Keys      map[string]string        `json:"keys"`
+	Aliases   map[string]string        `json:"aliases"`
+	Shifted   map[string]string        `json:"shifted"`
+	Modifiers map[string]string        `json:"modifiers"`
+	Locales   map[string]keycodeLocale `json:"locales"`
+}
+
+// keycodeLocale is the set of legends that differ from the US legends
+// for a host keyboard locale.
+type keycodeLocale struct {
+	Base    map[string]string `json:"base"`
+	Shifted map[string]string `json:"shifted"`
+}
+
+// keycodes returns the parsed embedded key code table.
+var keycodes = sync.OnceValues(func() (*keycodeTable, error) {
+	var t keycodeTable
+	err := json.Unmarshal(keycodesJSON, &t)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse key code table: %w", err)
+	}
+	return &t, nil
+})
+
+// legendFlags are the flags of the commands that display key
+// assignments.
+type legendFlags struct {
+	locale string
+	raw    bool
+}
+
+// register adds the legend flags to fs.
+func (l *legendFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&l.locale, "key-locale", "us", "host keyboard locale used to show the symbols typed by keys (de, uk or us)")
+	fs.BoolVar(&l.raw, "raw-keycodes", false, "show key assignments as the key codes in the revision data instead of legends")
+}
+
+// legends returns the key legends selected by the flags, or nil if raw
+// key codes were requested. It exits if the locale is not known.
+func (l *legendFlags) legends() *keyLegends {
+	if l.raw {
+		return nil
+	}
+	t, err := newKeyLegends(l.locale)
+	if err != nil {
+		fatal("invalid key locale", err, "locale", l.locale)
+	}
+	return t
+}
+
+// keyLegends renders key actions as human-readable legends, such as
+// "Ctrl+Shift+Tab", for a host keyboard locale. A nil *keyLegends
+// renders key actions as their key codes.
+type keyLegends struct {
+	table  *keycodeTable
+	locale keycodeLocale
+}
+
+// newKeyLegends returns the key legends for the named locale.
+func newKeyLegends(locale string) (*keyLegends, error) {
+	t, err := keycodes()
+	if err != nil {
+		return nil, err
+	}
+	loc, ok := t.Locales[locale]
+	if !ok {
+		return nil, usageError(fmt.Sprintf("unknown key locale %q: known locales are %s", locale, strings.Join(slices.Sorted(maps.Keys(t.Locales)), ", ")))
+	}
+	return &keyLegends{table: t, locale: loc}, nil
+}
+
+// legendModifierOrder is the order modifiers are shown in legends.
+var legendModifierOrder = []string{"LCTL", "RCTL", "LSFT", "RSFT", "LALT", "RALT", "LGUI", "RGUI"}
+
+// key returns the legend for the key's tap action, or its custom label
+// if it has one.
+func (t *keyLegends) key(k key) string {
+	if t == nil {
+		return k.Label()
+	}
+	if k.CustomLabel != "" {
+		return k.CustomLabel
+	}
+	return t.action(k.Tap)
+}
+
+// action returns the legend for a key action. Layer actions are shown
+// as their code and target layer. A key shifted by only a shift
+// modifier is shown as the symbol it types in the locale if it is
+// known, otherwise modifiers are joined to the key with "+".
+func (t *keyLegends) action(a *keyAction) string {
+	if t == nil {
+		return a.String()
+	}
+	if a == nil || a.Code == "" {
+		return ""
+	}
+	if a.Layer != nil {
+		return fmt.Sprintf("%s(%d)", strings.TrimPrefix(a.Code, "KC_"), *a.Layer)
+	}
+	code, shifted := t.canonical(a.Code)
+	mods := make(map[string]bool)
+	if shifted {
+		mods["LSFT"] = true
+	}
+	for _, m := range append(modifiers{a.Modifier}, a.Modifiers...) {
+		if m == "" {
+			continue
+		}
+		if q, ok := qmkModifiers[m]; ok {
+			m = q
+		}
+		mods[m] = true
+	}
+	if len(mods) == 1 && (mods["LSFT"] || mods["RSFT"]) {
+		if s, ok := t.locale.Shifted[code]; ok {
+			return s
+		}
+	}
+	var parts []string
+	for _, m := range legendModifierOrder {
+		if mods[m] {
+			parts = append(parts, t.table.Modifiers[m])
+			delete(mods, m)
+		}
+	}
+	// Modifiers unknown to the table are shown as given.
+	parts = append(parts, slices.Sorted(maps.Keys(mods))...)
+	return strings.Join(append(parts, t.legend(code)), "+")
+}
+
+// trigger returns the legend for a combo trigger key code.
+func (t *keyLegends) trigger(code string) string {
+	if t == nil {
+		return strings.TrimPrefix(code, "KC_")
+	}
+	return t.action(&keyAction{Code: code})
+}
+
+// legend returns the unshifted legend for a canonical key code.
+func (t *keyLegends) legend(code string) string {
+	if s, ok := t.locale.Base[code]; ok {
+		return s
+	}
+	if s, ok := t.table.Keys[code]; ok {
+		return s
+	}
+	return strings.TrimPrefix(code, "KC_")
+}
+
+// canonical returns the canonical spelling of a key code and whether
+// the code is a shifted key code, in which case the returned code is
+// the code of the key that is shifted.
+func (t *keyLegends) canonical(code string) (canon string, shifted bool) {
+	if !strings.HasPrefix(code, "KC_") && !t.known(code) && t.known("KC_"+code) {
+		code = "KC_" + code
+	}
+	if c, ok := t.table.Shifted[code]; ok {
+		return c, true
+	}
+	if c, ok := t.table.Aliases[code]; ok {
+		return c, false
+	}
+	return code, false
+}
+
+// known returns whether code is in the key code table.
+func (t *keyLegends) known(code string) bool {
+	_, key := t.table.Keys[code]
+	_, alias := t.table.Aliases[code]
+	_, shifted := t.table.Shifted[code]
+	return key || alias || shifted
+}
@@ -0,0 +1,253 @@
+// Copyright ©2025 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// lint checks a stored revision's keymap for problems.
+func lint(args []string) {
+	fs := flag.NewFlagSet("fkm lint", flag.ExitOnError)
+	var common commonFlags
+	common.register(fs)
+	revID := fs.String("revision", "", "ID of the stored revision to check (required)")
+	fs.Parse(args)
+	common.setup(fs)
+	if *revID == "" || fs.NArg() != 0 {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+
+	db := openExisting(common.dbPath, true, common.db)
+	defer db.Close()
+	l, err := loadLayout(db, *revID)
+	if err != nil {
+		fatal("failed to read revision", err, "revision", *revID)
+	}
+
+	problems := lintLayout(l)
+	if common.json {
+		printJSON(append([]lintProblem{}, problems...))
+	} else {
+		for _, p := range problems {
+			fmt.Println(p)
+		}
+	}
+	if len(problems) != 0 {
+		os.Exit(exitVerify)
+	}
+}
+
+// lintProblem is a problem found in a keymap.
+type lintProblem struct {
+	layer int    // layer index, or -1 if the problem is not in a layer
+	key   int    // key index, or -1 if the problem is not with a key
+	name  string // layer name
+	msg   string
+}
+
+func (p lintProblem) String() string {
+	var loc []string
+	if p.layer >= 0 {
+		loc = append(loc, fmt.Sprintf("layer %d (%s)", p.layer, p.name))
+	}
+	if p.key >= 0 {
+		loc = append(loc, fmt.Sprintf("key %d", p.key))
+	}
+	if len(loc) == 0 {
+		return "warn\t" + p.msg
+	}
+	return fmt.Sprintf("warn\t%s: %s", strings.Join(loc, " "), p.msg)
+}
+
+func (p lintProblem) MarshalJSON() ([]byte, error) {
+	v := struct {
+		Layer   *int   `json:"layer,omitempty"`
+		Name    string `json:"name,omitempty"`
+		Key     *int   `json:"key,omitempty"`
+		Message string `json:"message"`
+	}{Message: p.msg}
+	if p.layer >= 0 {
+		v.Layer = &p.layer
+		v.Name = p.name
+	}
+	if p.key >= 0 {
+		v.Key = &p.key
+	}
+	return json.Marshal(v)
+}
+
+// layerSwitch is a key action that changes the active layer.
+type layerSwitch struct {
+	from   int // layer holding the key
+	key    int
+	to     int
+	code   string
+	sticky bool // the layer remains active after the key is released
+}
+
+// layerSwitches returns the layer switching actions of the layout.
+// An action is sticky if it is a tap of TO, TG or DF.
+func layerSwitches(l *layout) []layerSwitch {
+	var switches []layerSwitch
+	for i, ly := range l.Revision.Layers {
+		for j, k := range ly.Keys {
+			for _, a := range k.Actions() {
+				if a.Action.Layer == nil {
+					continue
+				}
+				code := strings.TrimPrefix(a.Action.Code, "KC_")
+				sticky := a.Kind != "hold" && a.Kind != "tap-hold"
+				switch code {
+				case "TO", "TG", "DF":
+				default:
+					sticky = false
+				}
+				switches = append(switches, layerSwitch{from: i, key: j, to: *a.Action.Layer, code: code, sticky: sticky})
+			}
+		}
+	}
+	return switches
+}
+
+// lintLayout returns the problems found in the layout's keymap.
+func lintLayout(l *layout) []lintProblem {
+	layers := l.Revision.Layers
+	name := func(i int) string {
+		if i < 0 || i >= len(layers) {
+			return "?"
+		}
+		return layers[i].Name()
+	}
+	var problems []lintProblem
+	switches := layerSwitches(l)
+
+	// Keys referencing layers that do not exist.
+	for _, s := range switches {
+		if s.to < 0 || s.to >= len(layers) {
+			msg := fmt.Sprintf("%s(%d) references a layer that does not exist", s.code, s.to)
+			if l.Revision.HasDeletedLayers {
+				msg += " (the revision has deleted layers)"
+			}
+			problems = append(problems, lintProblem{layer: s.from, key: s.key, name: name(s.from), msg: msg})
+		}
+	}
+
+	// Layers that cannot be reached from the base layer.
+	reachable := make([]bool, len(layers))
+	if len(layers) != 0 {
+		reachable[0] = true
+		for changed := true; changed; {
+			changed = false
+			for _, s := range switches {
+				if reachable[s.from] && s.to >= 0 && s.to < len(layers) && !reachable[s.to] {
+					reachable[s.to] = true
+					changed = true
+				}
+			}
+		}
+	}
+	for i, ok := range reachable {
+		if !ok {
+			problems = append(problems, lintProblem{layer: i, key: -1, name: name(i), msg: "no key switches to this layer"})
+		}
+	}
+
+	// Layers entered by a sticky switch with no way back to the base
+	// layer. A layer can return if it has a sticky switch to a layer
+	// that can return, toggles itself off, or is entered by a toggle
+	// that is transparent on the layer.
+	returns := make([]bool, len(layers))
+	if len(layers) != 0 {
+		returns[0] = true
+		for changed := true; changed; {
+			changed = false
+			for _, s := range switches {
+				if s.from < 0 || s.from >= len(layers) || returns[s.from] || s.to < 0 || s.to >= len(layers) {
+					continue
+				}
+				if (s.sticky && s.code != "TG" && returns[s.to]) || (s.code == "TG" && s.to == s.from) {
+					returns[s.from] = true
+					changed = true
+				}
+			}
+			for _, s := range switches {
+				if s.code != "TG" || s.to < 0 || s.to >= len(layers) || returns[s.to] || !reachable[s.to] {
+					continue
+				}
+				keys := layers[s.to].Keys
+				if s.key < len(keys) && isTransparent(keys[s.key]) && returns[s.from] {
+					returns[s.to] = true
+					changed = true
+				}
+			}
+		}
+	}
+	var stuck []int
+	for _, s := range switches {
+		if s.sticky && s.to >= 0 && s.to < len(layers) && reachable[s.to] && !returns[s.to] && !slices.Contains(stuck, s.to) {
+			stuck = append(stuck, s.to)
+		}
+	}
+	slices.Sort(stuck)
+	for _, i := range stuck {
+		problems = append(problems, lintProblem{layer: i, key: -1, name: name(i), msg: "no way back to the base layer"})
+	}
+
+	// Transparent keys on the base layer.
+	if len(layers) != 0 {
+		for j, k := range layers[0].Keys {
+			if isTransparent(k) {
+				problems = append(problems, lintProblem{layer: 0, key: j, name: name(0), msg: "transparent key on the base layer"})
+			}
+		}
+	}
+
+	// Duplicate combos.
+	seen := make(map[string]int)
+	for i, c := range l.Revision.Combos {
+		keys := slices.Clone(c.KeyIndices)
+		slices.Sort(keys)
+		var sig strings.Builder
+		fmt.Fprintf(&sig, "%d:", c.LayerIdx)
+		for _, k := range keys {
+			sig.WriteString(strconv.Itoa(k) + ",")
+		}
+		if j, ok := seen[sig.String()]; ok {
+			problems = append(problems, lintProblem{layer: c.LayerIdx, key: -1, name: name(c.LayerIdx),
+				msg: fmt.Sprintf("combo %s uses the same keys as combo %s", comboName(l, i), comboName(l, j))})
+			continue
+		}
+		seen[sig.String()] = i
+	}
+
+	if l.Revision.HasDeletedLayers {
+		problems = append(problems, lintProblem{layer: -1, key: -1, msg: "revision has deleted layers"})
+	}
+	return problems
+}
+
+// isTransparent returns whether the key falls through to the layer
+// below.
+func isTransparent(k key) bool {
+	a := k.Actions()
+	return len(a) == 1 && a[0].Kind == "tap" && a[0].Action.Code == "KC_TRANSPARENT"
+}
+
+// comboName returns the name of combo i of the layout, or its index if
+// it has no name.
+func comboName(l *layout, i int) string {
+	if n := l.Revision.Combos[i].Name; n != "" {
+		return strconv.Quote(n)
+	}
+	return "#" + strconv.Itoa(i)
+}
@@ -0,0 +1,128 @@
+// Copyright ©2025 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// list prints the revisions stored in the database.
+func list(args []string) {
+	fs := flag.NewFlagSet("fkm list", flag.ExitOnError)
+	var common commonFlags
+	common.register(fs)
+	var tags stringList
+	fs.Var(&tags, "tag", "only list revisions of layouts with this tag (may be repeated)")
+	keyboard := fs.String("keyboard", "", "only list revisions for this keyboard geometry")
+	showProv := fs.Bool("provenance", false, "list the source, fetch time, fkm version and note of the most recent write of each revision")
+	fs.Parse(args)
+	common.setup(fs)
+	if fs.NArg() != 0 {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+
+	db := openExisting(common.dbPath, true, common.db)
+	defer db.Close()
+	revs, err := storedRevisions(db)
+	if err != nil {
+		fatal("failed to read revisions", err, "path", common.dbPath)
+	}
+	layoutTags, err := layoutTags(db)
+	if err != nil && (len(tags) != 0 || !errors.Is(err, errNoTags)) {
+		fatal("failed to read tags", err, "path", common.dbPath)
+	}
+	provs, err := revisionProvenance(db)
+	if err != nil {
+		fatal("failed to read provenance", err, "path", common.dbPath)
+	}
+
+	if common.json {
+		listed := []listedRevision{}
+		for _, r := range revs {
+			if r.layout == nil {
+				if len(tags) == 0 && *keyboard == "" {
+					listed = append(listed, listedRevision{Revision: r.id, Heatmap: r.heatmap, Provenance: provs[r.id]})
+				}
+				continue
+			}
+			l := r.layout
+			t := layoutTags[l.HashID]
+			if !hasAllTags(t, tags) || (*keyboard != "" && l.Geometry != *keyboard) {
+				continue
+			}
+			listed = append(listed, listedRevision{
+				Revision:   r.id,
+				Layout:     l.HashID,
+				Geometry:   l.Geometry,
+				Title:      l.Revision.Title,
+				Created:    l.Revision.CreatedAt,
+				Tags:       t,
+				Heatmap:    r.heatmap,
+				Provenance: provs[r.id],
+			})
+		}
+		printJSON(listed)
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	header := "REVISION\tLAYOUT\tGEOMETRY\tTITLE\tCREATED\tTAGS"
+	if *showProv {
+		header += "\tSOURCE\tFETCHED\tFKM\tNOTE"
+	}
+	fmt.Fprintln(w, header)
+	for _, r := range revs {
+		var line string
+		if r.layout == nil {
+			if len(tags) != 0 || *keyboard != "" {
+				continue
+			}
+			line = fmt.Sprintf("%s\t?\t?\t?\t?\t", r.id)
+		} else {
+			l := r.layout
+			t := layoutTags[l.HashID]
+			if !hasAllTags(t, tags) || (*keyboard != "" && l.Geometry != *keyboard) {
+				continue
+			}
+			line = fmt.Sprintf("%s\t%s\t%s\t%s\t%s\t%s", r.id, l.HashID, l.Geometry, l.Revision.Title, l.Revision.CreatedAt, strings.Join(t, ","))
+		}
+		if *showProv {
+			if p := provs[r.id]; len(p) != 0 {
+				last := p[len(p)-1]
+				line += fmt.Sprintf("\t%s\t%s\t%s\t%s", last.Source, last.Fetched.Format(time.RFC3339), last.Version, last.Note)
+			} else {
+				line += "\t?\t?\t?\t"
+			}
+		}
+		fmt.Fprintln(w, line)
+	}
+	err = w.Flush()
+	if err != nil {
+		fatal("failed to write revisions", err)
+	}
+}
+
+// listedRevision is the JSON description of a stored revision. Only
+// the revision ID is given for revisions that cannot be parsed.
+type listedRevision struct {
+	Revision string   `json:"revision"`
+	Layout   string   `json:"layout,omitempty"`
+	Geometry string   `json:"geometry,omitempty"`
+	Title    string   `json:"title,omitempty"`
+	Created  string   `json:"created,omitempty"`
+	Tags     []string `json:"tags,omitempty"`
+	Heatmap  bool     `json:"heatmap"`
+
+	// Provenance is the record of each write of the
+	// revision by fkm, oldest first.
+	Provenance []provenance `json:"provenance,omitempty"`
+}
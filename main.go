@@ -7,296 +7,294 @@
 // environment where network access by unauditable software is not allowed.
 //
 // Use OpenSnitch or Little Snitch to ensure it is not communicating with
-// the world.
+// the world. The firewall-rules command writes rules for either that deny
+// keymapp's outbound connections and allow fkm to reach its endpoints.
 package main
 
 import (
-	"bytes"
-	"database/sql"
-	"encoding/json"
 	"flag"
 	"fmt"
-	"io"
-	"log"
-	"net/http"
-	"net/url"
+	"maps"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
-
-	_ "modernc.org/sqlite"
+	"time"
 )
 
 func main() {
-	addr := flag.String("layout", "", "link to configure.zsa.io page for layout (required)")
-	dbPath := flag.String("path", "~/.config/.keymapp/keymapp.sqlite3", "path to kaymapp config database")
-	mkDir := flag.Bool("mkdir", true, "create config directory")
-	flag.Parse()
-	if *addr == "" {
-		flag.Usage()
-		os.Exit(2)
-	}
-
-	id, rev, err := revision(*addr)
-	if err != nil {
-		log.Fatalf("failed to collect revision data: %v", err)
-	}
-
-	var ok bool
-	*dbPath, ok = strings.CutPrefix(*dbPath, "~/")
-	if ok {
-		home, err := os.UserHomeDir()
-		if err != nil {
-			log.Fatalf("unable to get home directory: %v", err)
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s [command] [flags]\n\n", filepath.Base(os.Args[0]))
+		fmt.Fprintln(os.Stderr, "Without a command, fkm fetches a layout into the database.\n\nCommands:")
+		for _, name := range slices.Sorted(maps.Keys(commands)) {
+			fmt.Fprintf(os.Stderr, "  %s\n    \t%s\n", name, commands[name].help)
 		}
-		*dbPath = filepath.Join(home, *dbPath)
+		fmt.Fprintln(os.Stderr, "\nFlags:")
+		flag.PrintDefaults()
+		fmt.Fprintln(os.Stderr, "\nEach flag may also be set by an environment variable named for the flag,\nfor example -cache-dir by FKM_CACHE_DIR. The -path flag is set by FKM_DB_PATH.")
+		fmt.Fprintln(os.Stderr, "\n"+exitHelp)
 	}
-	if *mkDir {
-		err = os.MkdirAll(filepath.Dir(*dbPath), 0o750)
-		if err != nil {
-			log.Fatalf("unable to get home directory: %v", err)
+	if len(os.Args) > 1 {
+		if cmd, ok := commands[os.Args[1]]; ok {
+			commandName = os.Args[1]
+			cmd.run(os.Args[2:])
+			return
 		}
 	}
-	db, err := openDB(*dbPath)
-	if err != nil {
-		log.Fatalf("failed to open db: %v", err)
-	}
-	defer db.Close()
+	update()
+}
 
-	// I know. ಠ_ಠ
-	row := db.QueryRow(`SELECT count(*) FROM metadata`)
-	var n int
-	err = row.Scan(&n)
-	if n == 0 {
-		meta, err := metadata()
-		if err != nil {
-			log.Fatalf("failed to collect metadata: %v", err)
-		}
-		_, err = db.Exec(`INSERT INTO metadata (data) VALUES (?)`, meta)
-		if err != nil {
-			log.Fatal(err)
-		}
-	}
+// commandName is the name of the fkm command being run.
+var commandName = "update"
 
-	_, err = db.Exec(`INSERT INTO revision (revisionId, data) VALUES (?, ?) ON CONFLICT DO UPDATE SET data=?`, id, rev, rev)
-	if err != nil {
-		log.Fatal(err)
-	}
+// commands is the set of fkm subcommands.
+var commands = map[string]struct {
+	help string
+	run  func(args []string)
+}{
+	"analyze":        {"report typing statistics of revisions for a text corpus", analyze},
+	"api":            {"enable or disable keymapp's gRPC API", apiCmd},
+	"auth":           {"copy or report the keymapp login", authCmd},
+	"autolayer":      {"switch keyboard layers to follow the focused window using smart layer rules", autolayer},
+	"backup":         {"write a copy of the database to a file", backupCmd},
+	"cache":          {"list or clean the cache of fetched revisions and firmware", cacheCmd},
+	"cheatsheet":     {"write a printable HTML page describing a stored revision", cheatsheet},
+	"combos":         {"list the combos of a stored revision", combos},
+	"completion":     {"write a shell completion script", completion},
+	"compose":        {"store a new revision overlaying layers of one stored revision onto another", compose},
+	"config":         {"read or write keymapp configuration values", configCmd},
+	"diff":           {"print the differences between two revisions", diff},
+	"doctor":         {"check the environment and database for problems", doctor},
+	"export":         {"convert a stored revision to another keymap format", export},
+	"firewall-rules": {"write firewall rules blocking keymapp and allowing fkm", firewallRules},
+	"flash":          {"write a firmware image to a keyboard in bootloader mode", flash},
+	"heatmap":        {"build keymapp heatmap data from a local key log", heatmapCmd},
+	"import":         {"store a revision reconstructed from an Oryx source archive", importCmd},
+	"kb":             {"control a keyboard through a running keymapp's API", kb},
+	"layer":          {"list the layers of a stored revision or set their color and title", layerCmd},
+	"lint":           {"check a stored revision's keymap for problems", lint},
+	"list":           {"list the stored revisions", list},
+	"metadata":       {"fetch metadata.json and replace the stored metadata", metadataCmd},
+	"pin":            {"pin layouts to a stored revision so newer revisions are not stored", pin},
+	"profile":        {"manage named sets of defaults for multiple keymapp databases", profileCmd},
+	"prune":          {"remove unreferenced revisions and vacuum the database", prune},
+	"render":         {"write images of the layers of a stored revision", render},
+	"restore":        {"replace the database with a backup", restoreCmd},
+	"rollback":       {"make an older stored revision the current revision of a layout", rollback},
+	"schema-check":   {"check the fields fkm requests against the Oryx GraphQL schema", schemaCheck},
+	"search":         {"list public Oryx layouts matching a query", search},
+	"serve":          {"serve keymapp's metadata and layout requests from the database", serve},
+	"show":           {"draw the layers of a stored revision as keyboard diagrams", show},
+	"status":         {"print the status of a running watch, autolayer, serve or web daemon", status},
+	"tui":            {"interactively browse the stored layouts", tui},
+	"user-layouts":   {"list or store the public layouts of an Oryx user", userLayouts},
+	"verify":         {"verify stored revisions against their recorded checksums", verify},
+	"watch":          {"periodically store new revisions of tracked layouts", watch},
+	"web":            {"serve a read-only web interface for browsing the stored layouts", web},
 }
 
-func metadata() ([]byte, error) {
-	resp, err := http.Get("https://configure.zsa.io/metadata.json")
-	if err != nil {
-		fmt.Errorf("failed to get metadata: %w", err)
-	}
-	defer resp.Body.Close()
-	var buf bytes.Buffer
-	_, err = io.Copy(&buf, resp.Body)
-	if err != nil {
-		fmt.Errorf("failed to read metadata: %w", err)
-	}
-	return buf.Bytes(), nil
-}
+// commonFlags are the flags shared by all commands.
+type commonFlags struct {
+	config    string
+	profile   string
+	dbPath    string
+	printPath bool
 
-func revision(addr string) (string, []byte, error) {
-	u, err := url.Parse(addr)
-	if err != nil {
-		return "", nil, fmt.Errorf("failed to parse URL: %v", err)
-	}
-	p := strings.Split(strings.TrimLeft(u.Path, "/"), "/")
-	if len(p) < 4 {
-		return "", nil, fmt.Errorf("invalid config page: %v", addr)
-	}
-	geom := p[0]
-	layout := p[2]
-	rev := p[3]
+	// multiPath is set by commands that write to each database
+	// given by a repeated -path flag, before setup is called.
+	// Other commands accept only one -path. dbPaths holds the
+	// databases after the first.
+	multiPath bool
+	dbPaths   []string
 
-	var query = struct {
-		OperationName string            `json:"operationName"`
-		Variable      map[string]string `json:"variables"`
-		Query         string            `json:"query"`
-	}{
-		OperationName: "getLayout",
-		Variable: map[string]string{
-			"hashId":     layout,
-			"geometry":   geom,
-			"revisionId": rev,
-		},
-		Query: `
-query getLayout($hashId: String!, $revisionId: String!, $geometry: String) {
-	layout(hashId: $hashId, geometry: $geometry, revisionId: $revisionId) {
-		...LayoutData
-	}
+	db        dbOptions
+	verbose   bool
+	debug     bool
+	quiet     bool
+	logFormat string
+	json      bool // write command output as JSON
+
+	// keymappConfig holds default keymapp configuration
+	// values from the selected profile and configuration
+	// file.
+	keymappConfig map[string]string
 }
-fragment LayoutData on Layout {
-	privacy
-	geometry
-	hashId
-	parent {
-		hashId
+
+// register adds the common flags to fs.
+func (c *commonFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&c.config, "config", defaultConfigPath(), "path to fkm configuration file")
+	fs.StringVar(&c.profile, "profile", "", "name of the profile to take defaults from")
+	c.dbPath = defaultDBPath()
+	usage := "`path` to kaymapp config database"
+	if c.multiPath {
+		usage += " (may be repeated to write to several databases)"
 	}
-	tags {
-		id
-		hashId
-		name
+	fs.Var(&pathList{first: &c.dbPath, rest: &c.dbPaths}, "path", usage)
+	fs.BoolVar(&c.printPath, "print-path", false, "print the database path and exit")
+	fs.DurationVar(&c.db.busyTimeout, "busy-timeout", 5*time.Second, "time to wait for the database to be unlocked by other users")
+	fs.BoolVar(&c.db.wal, "wal", true, "use WAL journaling so the database can be updated while keymapp is running")
+	fs.BoolVar(&c.verbose, "v", false, "log progress")
+	fs.BoolVar(&c.debug, "vv", false, "log progress and debugging detail")
+	fs.BoolVar(&c.quiet, "quiet", false, "log only errors and show no progress")
+	fs.StringVar(&c.logFormat, "log-format", "text", "log format (text or json)")
+	fs.BoolVar(&c.json, "json", false, "write command output as JSON for commands that report results")
+}
+
+// setup applies flag values from the environment, the selected profile
+// and the configuration file, configures logging and resolves the
+// database path. It must be called after the flags have been parsed.
+// Flags set on the command line take precedence over the environment,
+// which takes precedence over the profile, which takes precedence over
+// the configuration file.
+func (c *commonFlags) setup(fs *flag.FlagSet) {
+	err := applyEnv(fs, os.LookupEnv)
+	if err != nil {
+		fmt.Fprintln(fs.Output(), err)
+		os.Exit(exitUsage)
 	}
-	title
-	user {
-		annotation
-		annotationPublic
-		name
-		hashId
-		pictureUrl
+	if c.profile != "" {
+		p, err := loadProfile(c.profile)
+		if err == nil {
+			err = applyDefaults(fs, singleValued(p.Flags), "profile "+c.profile)
+		}
+		if err != nil {
+			fmt.Fprintln(fs.Output(), err)
+			os.Exit(exitUsage)
+		}
+		c.keymappConfig = p.Config
+		if f := fs.Lookup("cache-dir"); f != nil && !isSet(fs, "cache-dir") && f.Value.String() != "" {
+			// Keep each profile's cached downloads apart.
+			fs.Set("cache-dir", filepath.Join(f.Value.String(), "profiles", c.profile))
+		}
 	}
-	isDefault
-	revision {
-		...RevisionData
+	if c.config != "" {
+		cfg, err := loadConfig(c.config, isSet(fs, "config"))
+		if err == nil {
+			err = applyDefaults(fs, cfg.flags, c.config)
+		}
+		if err != nil {
+			fmt.Fprintln(fs.Output(), err)
+			os.Exit(exitUsage)
+		}
+		for k, v := range cfg.config {
+			if _, ok := c.keymappConfig[k]; !ok {
+				if c.keymappConfig == nil {
+					c.keymappConfig = make(map[string]string)
+				}
+				c.keymappConfig[k] = v
+			}
+		}
 	}
-	lastRevisionCompiled
-	isLatestRevision
-}
-fragment RevisionData on Revision {
-	createdAt
-	hashId
-	model
-	title
-	config
-	swatch
-	qmkVersion
-	qmkUptodate
-	hasDeletedLayers
-	md5
-	combos {
-		keyIndices
-		layerIdx
-		name
-		trigger
+	err = setupLogging(c.verbose, c.debug, c.quiet, c.logFormat)
+	if err != nil {
+		fmt.Fprintln(fs.Output(), err)
+		fs.Usage()
+		os.Exit(exitUsage)
 	}
-	tour {
-		...TourData
+	if len(c.dbPaths) != 0 && !c.multiPath {
+		fmt.Fprintln(fs.Output(), "-path may only be given once")
+		fs.Usage()
+		os.Exit(exitUsage)
 	}
-	layers {
-		builtIn
-		hashId
-		keys
-		position
-		title
-		color
-		prevHashId
+	c.dbPath = expandHome(c.dbPath)
+	for i, p := range c.dbPaths {
+		c.dbPaths[i] = expandHome(p)
 	}
-}
-fragment TourData on Tour {
-	hashId url steps: tourSteps {
-		hashId intro outro position content keyIndex layer {
-			hashId position
+	if c.printPath {
+		fmt.Println(c.dbPath)
+		for _, p := range c.dbPaths {
+			fmt.Println(p)
 		}
+		os.Exit(0)
 	}
 }
-`,
-	}
-	b, err := json.Marshal(query)
-	if err != nil {
-		return "", nil, fmt.Errorf("failed to marshal query: %v", err)
-	}
 
-	resp, err := http.Post("https://oryx.zsa.io/graphql", "application/json", bytes.NewReader(b))
-	if err != nil {
-		return "", nil, fmt.Errorf("failed to get revision data: %w", err)
+// expandHome returns path with a leading "~/" replaced by the user's
+// home directory.
+func expandHome(path string) string {
+	rest, ok := strings.CutPrefix(path, "~/")
+	if !ok {
+		return path
 	}
-	defer resp.Body.Close()
-	var buf bytes.Buffer
-	_, err = io.Copy(&buf, resp.Body)
+	home, err := os.UserHomeDir()
 	if err != nil {
-		return "", nil, fmt.Errorf("failed to read revision data: %w", err)
-	}
-	var body struct {
-		Data json.RawMessage `json:"Data"`
-	}
-	err = json.Unmarshal(buf.Bytes(), &body)
-	if err != nil {
-		return "", nil, fmt.Errorf("failed to parse revision data: %w", err)
+		fatal("unable to get home directory", err)
 	}
+	return filepath.Join(home, rest)
+}
 
-	var revID struct {
-		Layout struct {
-			Revision struct {
-				HashID string `json:"hashId"`
-			} `json:"revision"`
-		} `json:"layout"`
-	}
-	err = json.Unmarshal(body.Data, &revID)
-	if err != nil {
-		return "", nil, fmt.Errorf("failed to parse revision ID: %w", err)
+// pathList is the flag.Value of the -path flag. The first value set
+// replaces the default path and later values are collected in rest.
+type pathList struct {
+	first *string
+	rest  *[]string
+	set   bool
+}
+
+func (p *pathList) String() string {
+	if p.first == nil {
+		return ""
 	}
-	return revID.Layout.Revision.HashID, body.Data, nil
+	return strings.Join(append([]string{*p.first}, *p.rest...), ",")
 }
 
-func openDB(path string) (*sql.DB, error) {
-	db, err := sql.Open("sqlite", path)
-	if err != nil {
-		return nil, err
+func (p *pathList) Set(v string) error {
+	if !p.set {
+		*p.first = v
+		p.set = true
+		return nil
 	}
-	_, err = db.Exec(schema)
-	if err != nil {
-		return nil, err
+	*p.rest = append(*p.rest, v)
+	return nil
+}
+
+// envAliases maps flag names to environment variable names that do
+// not follow the FKM_<FLAG> pattern.
+var envAliases = map[string]string{
+	"path": "FKM_DB_PATH",
+}
+
+// envName returns the name of the environment variable for the named
+// flag.
+func envName(flag string) string {
+	if name, ok := envAliases[flag]; ok {
+		return name
 	}
-	for _, kv := range defaultConfig {
-		// I know. ¯\_(ツ)_/¯
-		row := db.QueryRow(`SELECT count(*) FROM config WHERE key=?`, kv.key)
-		var n int
-		err := row.Scan(&n)
-		if err != nil {
-			return nil, err
-		}
-		if n != 0 {
-			continue
-		}
-		_, err = db.Exec(`INSERT INTO config (key, value) VALUES (?, ?)`, kv.key, kv.val)
-		if err != nil {
-			return nil, err
+	return "FKM_" + strings.ToUpper(strings.ReplaceAll(flag, "-", "_"))
+}
+
+// applyEnv sets each flag in fs that was not set on the command line
+// from its environment variable if the variable is set.
+func applyEnv(fs *flag.FlagSet, lookup func(string) (string, bool)) error {
+	env := make(map[string][]string)
+	fs.VisitAll(func(f *flag.Flag) {
+		name := envName(f.Name)
+		if v, ok := lookup(name); ok {
+			env[f.Name] = []string{v}
 		}
-	}
-	return db, nil
+	})
+	return applyDefaults(fs, env, "environment")
 }
 
-const schema = `
-CREATE TABLE IF NOT EXISTS "config" (
-            key TEXT,
-            value TEXT
-        );
-CREATE TABLE IF NOT EXISTS "metadata" (
-            data BLOB
-        );
-CREATE TABLE IF NOT EXISTS "heatmap" (
-            revisionId TEXT NOT NULL UNIQUE,
-            enabled boolean DEFAULT 0,
-            data BLOB DEFAULT NULL
-        );
-CREATE TABLE IF NOT EXISTS "revision" (
-            revisionId TEXT NOT NULL UNIQUE,
-            data BLOB DEFAULT NULL
-        );
-CREATE TABLE IF NOT EXISTS "smart_layer" (
-            id INTEGER PRIMARY KEY AUTOINCREMENT,
-            app TEXT NOT NULL,
-            layer INTEGER NOT NULL,
-            layoutId TEXT NOT NULL,
-            revisionId TEXT NOT NULL
-        );
-CREATE TABLE IF NOT EXISTS "auth" (
-            token TEXT NOT NULL UNIQUE,
-            username TEXT NOT NULL
-        );
-`
+// isSet returns whether the named flag was set on the command line.
+func isSet(fs *flag.FlagSet, name string) bool {
+	var set bool
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
 
-var defaultConfig = []struct {
-	key, val string
-}{
-	{"prompt_update_check", "1"},
-	{"update_check", "0"},
-	{"startup_minimized", "0"},
-	{"startup_autoconnect", "0"},
-	{"smart_layers_enabled", "1"},
-	{"api_enabled", "0"},
-	{"api_port", "50051"},
+// defaultDBPath returns the location of the keymapp database for the
+// current user. This is .keymapp/keymapp.sqlite3 in ~/.config on
+// Linux, ~/Library/Application Support on macOS and %AppData% on
+// Windows.
+func defaultDBPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "~/.config/.keymapp/keymapp.sqlite3"
+	}
+	return filepath.Join(dir, ".keymapp", "keymapp.sqlite3")
 }
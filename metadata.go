@@ -0,0 +1,240 @@
+// Copyright ©2025 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"os"
+	"slices"
+	"time"
+)
+
+// metadataCmd manages the keymapp metadata stored in the database.
+func metadataCmd(args []string) {
+	usage := func() {
+		fmt.Fprintln(os.Stderr, `usage: fkm metadata update [flags]
+
+Fetch metadata.json from the server, bypassing the metadata cache, and
+replace the metadata stored in the database with it. The -diff flag
+prints the sections of the metadata that changed, such as new keyboards
+and key codes.`)
+		os.Exit(exitUsage)
+	}
+	if len(args) == 0 || args[0] != "update" {
+		usage()
+	}
+	args = args[1:]
+
+	fs := flag.NewFlagSet("fkm metadata update", flag.ExitOnError)
+	var (
+		common commonFlags
+		net    netFlags
+		guard  writeGuard
+	)
+	common.register(fs)
+	net.register(fs)
+	guard.register(fs)
+	showDiff := fs.Bool("diff", false, "print the differences between the stored and fetched metadata")
+	dryRun := fs.Bool("dry-run", false, "print the changes that would be made to the database without making them")
+	cacheDir := fs.String("cache-dir", defaultCacheDir(), "directory for cached downloads to store the fetched metadata in (empty to disable caching)")
+	backupDir := fs.String("backup-dir", "", "directory to back up the database to before changing it")
+	fs.Parse(args)
+	common.setup(fs)
+	if fs.NArg() != 0 || net.cannotFetch() {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+
+	cli := net.client()
+	meta, err := metadata(context.Background(), cli, net.metadataURL)
+	if err != nil {
+		fatal("failed to collect metadata", err, "url", net.metadataURL)
+	}
+	if !json.Valid(meta) {
+		fatal("failed to collect metadata", exitError{status: exitAPI, err: errors.New("metadata is not valid JSON")}, "url", net.metadataURL)
+	}
+	if *cacheDir != "" {
+		err = metadataCache{dir: *cacheDir}.store(meta, metadataCacheInfo{Endpoint: net.metadataURL, Fetched: time.Now()})
+		if err != nil {
+			slog.Warn("failed to update metadata cache", "error", err)
+		}
+	}
+
+	db := openExisting(common.dbPath, *dryRun, common.db)
+	defer db.Close()
+	stored, err := loadMetadata(db)
+	if err != nil {
+		fatal("failed to read metadata", err, "path", common.dbPath)
+	}
+	if *showDiff {
+		d, err := diffMetadata(stored, meta)
+		if err != nil {
+			fatal("failed to compare metadata", err)
+		}
+		if common.json {
+			printJSON(d)
+		} else {
+			printMetadataDiff(os.Stdout, d)
+		}
+	}
+
+	c, ok, err := storeMetadata(db, fetchedMetadata{data: meta})
+	if err != nil {
+		fatal("failed to check metadata", err)
+	}
+	if !ok {
+		if !common.json {
+			fmt.Println("stored metadata is up to date")
+		}
+		return
+	}
+	changes := []change{c}
+	if *dryRun {
+		printChanges(common.dbPath, changes)
+		return
+	}
+	err = guard.check(context.Background(), db)
+	if err != nil {
+		fatal("refusing to update db", err, "path", common.dbPath)
+	}
+	if *backupDir != "" {
+		err = autoBackup(db, *backupDir)
+		if err != nil {
+			fatal("failed to back up db", err, "path", common.dbPath)
+		}
+	}
+	err = apply(db, changes)
+	if err != nil {
+		fatal("failed to update db", err, "path", common.dbPath)
+	}
+}
+
+// metadataChange is a difference between two versions of the metadata.
+type metadataChange struct {
+	Section string `json:"section"`
+	Name    string `json:"name,omitempty"` // empty if the whole section changed
+	Change  string `json:"change"`         // "added", "removed" or "changed"
+}
+
+// diffMetadata returns the differences between the old and new
+// metadata. Entries of object sections are identified by their keys,
+// and entries of array sections by their slug, code, name or hash ID,
+// so that new keyboards and key codes are reported individually.
+func diffMetadata(old, new []byte) ([]metadataChange, error) {
+	var a, b map[string]json.RawMessage
+	if old != nil {
+		err := json.Unmarshal(old, &a)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse stored metadata: %w", err)
+		}
+	}
+	err := json.Unmarshal(new, &b)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse fetched metadata: %w", err)
+	}
+	changes := []metadataChange{}
+	for _, sec := range slices.Sorted(maps.Keys(union(a, b))) {
+		av, inA := a[sec]
+		bv, inB := b[sec]
+		switch {
+		case !inA:
+			changes = append(changes, metadataChange{Section: sec, Change: "added"})
+			continue
+		case !inB:
+			changes = append(changes, metadataChange{Section: sec, Change: "removed"})
+			continue
+		case sameJSON(av, bv):
+			continue
+		}
+		ae, okA := metadataEntries(av)
+		be, okB := metadataEntries(bv)
+		if !okA || !okB {
+			changes = append(changes, metadataChange{Section: sec, Change: "changed"})
+			continue
+		}
+		for _, name := range slices.Sorted(maps.Keys(union(ae, be))) {
+			av, inA := ae[name]
+			bv, inB := be[name]
+			switch {
+			case !inA:
+				changes = append(changes, metadataChange{sec, name, "added"})
+			case !inB:
+				changes = append(changes, metadataChange{sec, name, "removed"})
+			case !sameJSON(av, bv):
+				changes = append(changes, metadataChange{sec, name, "changed"})
+			}
+		}
+	}
+	return changes, nil
+}
+
+// metadataEntries returns the entries of a metadata section keyed by
+// their name. The returned boolean is false if the section is not an
+// object or an array of uniquely named objects.
+func metadataEntries(data json.RawMessage) (map[string]json.RawMessage, bool) {
+	var obj map[string]json.RawMessage
+	if json.Unmarshal(data, &obj) == nil && obj != nil {
+		return obj, true
+	}
+	var list []json.RawMessage
+	if json.Unmarshal(data, &list) != nil {
+		return nil, false
+	}
+	entries := make(map[string]json.RawMessage)
+	for _, e := range list {
+		var id struct {
+			Slug   string `json:"slug"`
+			Code   string `json:"code"`
+			Name   string `json:"name"`
+			HashID string `json:"hashId"`
+		}
+		var name string
+		if json.Unmarshal(e, &id) == nil {
+			name = cmp.Or(id.Slug, id.Code, id.Name, id.HashID)
+		} else if json.Unmarshal(e, &name) != nil {
+			return nil, false
+		}
+		if _, dup := entries[name]; name == "" || dup {
+			return nil, false
+		}
+		entries[name] = e
+	}
+	return entries, true
+}
+
+// union returns a map holding the keys of a and b.
+func union[V any](a, b map[string]V) map[string]bool {
+	u := make(map[string]bool, len(a)+len(b))
+	for k := range a {
+		u[k] = true
+	}
+	for k := range b {
+		u[k] = true
+	}
+	return u
+}
+
+// printMetadataDiff writes the metadata differences to w.
+func printMetadataDiff(w io.Writer, changes []metadataChange) {
+	if len(changes) == 0 {
+		fmt.Fprintln(w, "no differences")
+		return
+	}
+	for _, c := range changes {
+		if c.Name == "" {
+			fmt.Fprintf(w, "%s %s\n", c.Section, c.Change)
+		} else {
+			fmt.Fprintf(w, "%s %s: %s\n", c.Section, c.Change, c.Name)
+		}
+	}
+}
@@ -0,0 +1,15 @@
+// Copyright ©2025 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !snapshot
+
+package main
+
+import "errors"
+
+// embeddedMetadata returns an error since binaries built without the
+// snapshot tag have no metadata snapshot.
+func embeddedMetadata() ([]byte, error) {
+	return nil, errors.New("no metadata snapshot embedded in this binary: it was built without the snapshot tag")
+}
@@ -0,0 +1,34 @@
+// Copyright ©2025 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// notifyUpdates shows a desktop notification summarising the updates.
+func notifyUpdates(updates []layoutUpdate) error {
+	if len(updates) == 0 {
+		return nil
+	}
+	title := "New keyboard layout revision"
+	if len(updates) > 1 {
+		title = fmt.Sprintf("%d new keyboard layout revisions", len(updates))
+	}
+	var body strings.Builder
+	for i, u := range updates {
+		if i != 0 {
+			body.WriteByte('\n')
+		}
+		name := u.title
+		if name == "" {
+			name = u.hashID
+		}
+		fmt.Fprintf(&body, "%s: %s → %s", name, u.from, u.to)
+	}
+	body.WriteString("\nRestart keymapp to use the new revision, then reflash.")
+	return notify(title, body.String())
+}
@@ -0,0 +1,16 @@
+// Copyright ©2025 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"os/exec"
+	"strconv"
+)
+
+// notify shows a desktop notification using osascript.
+func notify(title, body string) error {
+	script := "display notification " + strconv.Quote(body) + " with title " + strconv.Quote(title)
+	return exec.Command("osascript", "-e", script).Run()
+}
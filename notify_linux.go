@@ -0,0 +1,13 @@
+// Copyright ©2025 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import "os/exec"
+
+// notify shows a desktop notification using notify-send, which sends
+// the notification over D-Bus.
+func notify(title, body string) error {
+	return exec.Command("notify-send", "--app-name=fkm", title, body).Run()
+}
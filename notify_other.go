@@ -0,0 +1,15 @@
+// Copyright ©2025 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !linux && !darwin && !windows
+
+package main
+
+import "errors"
+
+// notify shows a desktop notification. Notifications are not supported
+// on this platform.
+func notify(title, body string) error {
+	return errors.New("desktop notifications are not supported on this platform")
+}
@@ -0,0 +1,346 @@
+// Copyright ©2025 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// objectCache is a content-addressed cache of fetched revisions and
+// firmware images. Objects are stored under objects/ named by the
+// SHA-256 of their contents, so identical data is stored once, and are
+// found through refs under refs/ holding the hex SHA-256 of the object
+// they name. Revisions are named refs/revisions/<hashId>/<revisionId>
+// and firmware images refs/firmware/<file name>.
+type objectCache struct {
+	// dir is the cache directory. If dir is empty,
+	// no caching is performed.
+	dir string
+}
+
+func (c objectCache) objectPath(sum string) string {
+	return filepath.Join(c.dir, "objects", sum[:2], sum)
+}
+
+// refPath returns the path of the ref. Ref components come from
+// layout links and server responses, so components that are empty,
+// are dot components or contain path separators are rejected to keep
+// refs within the cache.
+func (c objectCache) refPath(ref ...string) (string, error) {
+	for _, name := range ref {
+		if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) || !filepath.IsLocal(name) {
+			return "", fmt.Errorf("invalid cache ref name %q", name)
+		}
+	}
+	return filepath.Join(append([]string{c.dir, "refs"}, ref...)...), nil
+}
+
+// put stores data and points the ref at it, returning the SHA-256 of
+// the data.
+func (c objectCache) put(data []byte, ref ...string) (string, error) {
+	refPath, err := c.refPath(ref...)
+	if err != nil {
+		return "", err
+	}
+	sum := fmt.Sprintf("%x", sha256.Sum256(data))
+	path := c.objectPath(sum)
+	_, err = os.Stat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		err = os.MkdirAll(filepath.Dir(path), 0o750)
+		if err != nil {
+			return "", err
+		}
+		err = writeFileAtomic(path, data, 0o640)
+	}
+	if err != nil {
+		return "", err
+	}
+	err = os.MkdirAll(filepath.Dir(refPath), 0o750)
+	if err != nil {
+		return "", err
+	}
+	return sum, writeFileAtomic(refPath, []byte(sum+"\n"), 0o640)
+}
+
+// get returns the object named by the ref. If the ref does not exist,
+// nil data is returned.
+func (c objectCache) get(ref ...string) ([]byte, error) {
+	path, err := c.refPath(ref...)
+	if err != nil {
+		return nil, err
+	}
+	b, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return c.object(string(bytes.TrimSpace(b)))
+}
+
+// object returns the object with the given SHA-256, checking that its
+// contents match.
+func (c objectCache) object(sum string) ([]byte, error) {
+	if len(sum) != 2*sha256.Size {
+		return nil, fmt.Errorf("invalid object name %q", sum)
+	}
+	if _, err := hex.DecodeString(sum); err != nil {
+		return nil, fmt.Errorf("invalid object name %q", sum)
+	}
+	data, err := os.ReadFile(c.objectPath(sum))
+	if err != nil {
+		return nil, err
+	}
+	if got := fmt.Sprintf("%x", sha256.Sum256(data)); got != sum {
+		return nil, fmt.Errorf("corrupt object %s: contents have SHA-256 %s", sum, got)
+	}
+	return data, nil
+}
+
+// fetchRevision returns the layout revision data for ref. Revisions
+// named by ID are taken from the cache when they are present there, and
+// fetched revisions are added to the cache.
+func (c objectCache) fetchRevision(ctx context.Context, cli *client, endpoint string, ref layoutRef) (string, []byte, error) {
+	if c.dir == "" {
+		return fetchRevision(ctx, cli, endpoint, ref)
+	}
+	if ref.revision != "latest" {
+		data, err := c.get("revisions", ref.hashID, ref.revision)
+		if err == nil && data != nil {
+			err = checkCachedRevision(data, ref)
+		}
+		if err != nil {
+			slog.Warn("ignoring cached revision", "error", err, "layout", ref.hashID, "revision", ref.revision)
+		} else if data != nil {
+			slog.Info("using cached revision", "layout", ref.hashID, "revision", ref.revision)
+			return ref.revision, data, nil
+		}
+	}
+	id, data, err := fetchRevision(ctx, cli, endpoint, ref)
+	if err != nil {
+		return "", nil, err
+	}
+	_, err = c.put(data, "revisions", ref.hashID, id)
+	if err != nil {
+		slog.Warn("failed to cache revision", "error", err, "layout", ref.hashID, "revision", id)
+	}
+	return id, data, nil
+}
+
+// checkCachedRevision returns an error if the cached revision data is
+// not the revision of the layout identified by ref.
+func checkCachedRevision(data []byte, ref layoutRef) error {
+	l, err := parseLayout(data)
+	if err != nil {
+		return err
+	}
+	if l.HashID != ref.hashID || l.Revision.HashID != ref.revision {
+		return fmt.Errorf("cached data is revision %s of layout %s", l.Revision.HashID, l.HashID)
+	}
+	return nil
+}
+
+// cacheRef is a ref in an objectCache.
+type cacheRef struct {
+	name     string // slash separated path below refs/
+	sum      string
+	modified time.Time
+}
+
+// refs returns the refs in the cache sorted by name.
+func (c objectCache) refs() ([]cacheRef, error) {
+	root, _ := c.refPath()
+	var refs []cacheRef
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) && path == root {
+			return fs.SkipAll
+		}
+		if err != nil || d.IsDir() || strings.HasPrefix(d.Name(), ".") {
+			return err
+		}
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		name, _ := filepath.Rel(root, path)
+		refs = append(refs, cacheRef{name: filepath.ToSlash(name), sum: string(bytes.TrimSpace(b)), modified: info.ModTime()})
+		return nil
+	})
+	return refs, err
+}
+
+// objects returns the SHA-256 and size of each object in the cache.
+func (c objectCache) objects() (map[string]int64, error) {
+	root := filepath.Join(c.dir, "objects")
+	objects := make(map[string]int64)
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) && path == root {
+			return fs.SkipAll
+		}
+		if err != nil || d.IsDir() || len(d.Name()) != 2*sha256.Size {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		objects[d.Name()] = info.Size()
+		return nil
+	})
+	return objects, err
+}
+
+// cacheCmd lists and cleans the object cache.
+func cacheCmd(args []string) {
+	usage := func() {
+		fmt.Fprintln(os.Stderr, `usage: fkm cache ls [flags]
+       fkm cache gc [-max-age D] [-dry-run] [flags]`)
+		os.Exit(exitUsage)
+	}
+	if len(args) == 0 {
+		usage()
+	}
+	cmd, args := args[0], args[1:]
+	if cmd != "ls" && cmd != "gc" {
+		usage()
+	}
+
+	fs := flag.NewFlagSet("fkm cache "+cmd, flag.ExitOnError)
+	var common commonFlags
+	common.register(fs)
+	cacheDir := fs.String("cache-dir", defaultCacheDir(), "cache directory")
+	var (
+		maxAge time.Duration
+		dryRun bool
+	)
+	if cmd == "gc" {
+		fs.DurationVar(&maxAge, "max-age", 0, "remove refs not updated within this time (default keep all refs)")
+		fs.BoolVar(&dryRun, "dry-run", false, "print what would be removed without removing it")
+	}
+	fs.Parse(args)
+	common.setup(fs)
+	if fs.NArg() != 0 || *cacheDir == "" || maxAge < 0 {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+	c := objectCache{dir: *cacheDir}
+	switch cmd {
+	case "ls":
+		err := c.list()
+		if err != nil {
+			fatal("failed to list cache", err, "path", *cacheDir)
+		}
+	case "gc":
+		err := c.gc(maxAge, dryRun)
+		if err != nil {
+			fatal("failed to clean cache", err, "path", *cacheDir)
+		}
+	}
+}
+
+// list prints the refs in the cache and any unreferenced objects.
+func (c objectCache) list() error {
+	refs, err := c.refs()
+	if err != nil {
+		return err
+	}
+	objects, err := c.objects()
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "SHA256\tSIZE\tREF\tUPDATED")
+	used := make(map[string]bool)
+	for _, r := range refs {
+		size := "missing"
+		if n, ok := objects[r.sum]; ok {
+			size = formatBytes(n)
+		}
+		used[r.sum] = true
+		fmt.Fprintf(w, "%.12s\t%s\t%s\t%s\n", r.sum, size, r.name, r.modified.UTC().Format(time.RFC3339))
+	}
+	var unused []string
+	for sum := range objects {
+		if !used[sum] {
+			unused = append(unused, sum)
+		}
+	}
+	slices.Sort(unused)
+	for _, sum := range unused {
+		fmt.Fprintf(w, "%.12s\t%s\t-\t-\n", sum, formatBytes(objects[sum]))
+	}
+	return w.Flush()
+}
+
+// gc removes refs older than maxAge, if it is not zero, and refs to
+// missing objects, and then removes objects no ref names.
+func (c objectCache) gc(maxAge time.Duration, dryRun bool) error {
+	refs, err := c.refs()
+	if err != nil {
+		return err
+	}
+	objects, err := c.objects()
+	if err != nil {
+		return err
+	}
+	remove := func(kind, name, path string) error {
+		fmt.Printf("remove %s %s\n", kind, name)
+		if dryRun {
+			return nil
+		}
+		return os.Remove(path)
+	}
+	used := make(map[string]bool)
+	var errs []error
+	for _, r := range refs {
+		_, ok := objects[r.sum]
+		if ok && (maxAge == 0 || time.Since(r.modified) < maxAge) {
+			used[r.sum] = true
+			continue
+		}
+		path, err := c.refPath(strings.Split(r.name, "/")...)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		errs = append(errs, remove("ref", r.name, path))
+	}
+	var freed int64
+	for sum, size := range objects {
+		if used[sum] {
+			continue
+		}
+		err := remove("object", sum, c.objectPath(sum))
+		if err == nil {
+			freed += size
+		}
+		errs = append(errs, err)
+	}
+	verb := "freed"
+	if dryRun {
+		verb = "would free"
+	}
+	fmt.Printf("%s %s\n", verb, formatBytes(freed))
+	return errors.Join(errs...)
+}
@@ -0,0 +1,90 @@
+// Copyright ©2025 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestObjectCacheRefs(t *testing.T) {
+	tests := []struct {
+		ref     []string
+		wantErr bool
+	}{
+		{ref: []string{"revisions", "abc", "r1"}},
+		{ref: []string{"firmware", "voyager_abc_r1.bin"}},
+		{ref: []string{"revisions", "..", "r1"}, wantErr: true},
+		{ref: []string{"revisions", "abc", "."}, wantErr: true},
+		{ref: []string{"revisions", "abc", ""}, wantErr: true},
+		{ref: []string{"revisions", "../../x", "r1"}, wantErr: true},
+		{ref: []string{"revisions", "abc", `..\x`}, wantErr: true},
+		{ref: []string{"revisions", "/etc", "passwd"}, wantErr: true},
+	}
+	for _, test := range tests {
+		dir := t.TempDir()
+		c := objectCache{dir: filepath.Join(dir, "cache")}
+		data := []byte("data")
+		_, err := c.put(data, test.ref...)
+		if (err != nil) != test.wantErr {
+			t.Errorf("unexpected put error for %q: got %v want error %t", test.ref, err, test.wantErr)
+		}
+		got, err := c.get(test.ref...)
+		if (err != nil) != test.wantErr {
+			t.Errorf("unexpected get error for %q: got %v want error %t", test.ref, err, test.wantErr)
+		}
+		if test.wantErr {
+			entries, err := os.ReadDir(dir)
+			if err != nil {
+				t.Fatalf("failed to read directory: %v", err)
+			}
+			for _, e := range entries {
+				if e.Name() != "cache" {
+					t.Errorf("put for %q wrote outside the cache: %s", test.ref, e.Name())
+				}
+			}
+			continue
+		}
+		if !bytes.Equal(got, data) {
+			t.Errorf("unexpected data for %q: got %q want %q", test.ref, got, data)
+		}
+	}
+}
+
+func TestObjectCacheCorrupt(t *testing.T) {
+	c := objectCache{dir: t.TempDir()}
+	sum, err := c.put([]byte("data"), "revisions", "abc", "r1")
+	if err != nil {
+		t.Fatalf("failed to put object: %v", err)
+	}
+	err = os.WriteFile(c.objectPath(sum), []byte("changed"), 0o600)
+	if err != nil {
+		t.Fatalf("failed to corrupt object: %v", err)
+	}
+	_, err = c.get("revisions", "abc", "r1")
+	if err == nil {
+		t.Error("expected error for corrupt object")
+	}
+}
+
+func TestCheckCachedRevision(t *testing.T) {
+	tests := []struct {
+		hashID, id string
+		ref        layoutRef
+		wantErr    bool
+	}{
+		{hashID: "abc", id: "r1", ref: layoutRef{hashID: "abc", revision: "r1"}},
+		{hashID: "abc", id: "r2", ref: layoutRef{hashID: "abc", revision: "r1"}, wantErr: true},
+		{hashID: "def", id: "r1", ref: layoutRef{hashID: "abc", revision: "r1"}, wantErr: true},
+	}
+	for _, test := range tests {
+		err := checkCachedRevision(testRevision(test.hashID, test.id, "2025-01-01T00:00:00Z"), test.ref)
+		if (err != nil) != test.wantErr {
+			t.Errorf("unexpected error for %s/%s with %+v: got %v want error %t", test.hashID, test.id, test.ref, err, test.wantErr)
+		}
+	}
+}
@@ -0,0 +1,339 @@
+// Copyright ©2025 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+)
+
+const (
+	defaultGraphqlURL  = "https://oryx.zsa.io/graphql"
+	defaultMetadataURL = "https://configure.zsa.io/metadata.json"
+)
+
+func metadata(ctx context.Context, cli *client, endpoint string) ([]byte, error) {
+	b, err := cli.get(ctx, endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get metadata: %w", err)
+	}
+	return b, nil
+}
+
+// layoutRef identifies a layout revision.
+type layoutRef struct {
+	geometry string
+	hashID   string
+	revision string
+}
+
+// errUnrecognisedURL is returned by parseLayoutURL when a URL does
+// not have the form of a layout page.
+var errUnrecognisedURL = errors.New("unrecognised layout URL")
+
+// parseLayoutURL returns the layout revision identified by a
+// configure.zsa.io layout page URL. URLs may have the forms
+//
+//	/<geometry>/layouts/<hashId>/<revisionId>[/<layer>]
+//	/<geometry>/layouts/<hashId>
+//	/layouts/<hashId>[/<revisionId>[/<layer>]]
+//
+// When the revision is absent, the latest revision is used. When the
+// geometry is absent, it is left empty to be resolved by the API.
+func parseLayoutURL(addr string) (layoutRef, error) {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return layoutRef{}, fmt.Errorf("failed to parse URL: %v", err)
+	}
+	p := strings.Split(strings.Trim(u.Path, "/"), "/")
+	i := slices.Index(p, "layouts")
+	if i < 0 || i > 1 || i+1 >= len(p) || p[i+1] == "" {
+		return layoutRef{}, fmt.Errorf("%w: %v", errUnrecognisedURL, addr)
+	}
+	ref := layoutRef{hashID: p[i+1], revision: "latest"}
+	if i == 1 {
+		ref.geometry = p[0]
+	}
+	if i+2 < len(p) && p[i+2] != "" {
+		ref.revision = p[i+2]
+	}
+	return ref, nil
+}
+
+// resolveLayoutURL returns the layout revision identified by addr. If
+// addr is not a layout page URL, for example a share link, it is
+// fetched and the URL it redirects to is used.
+func resolveLayoutURL(ctx context.Context, cli *client, addr string) (layoutRef, error) {
+	ref, err := parseLayoutURL(addr)
+	if !errors.Is(err, errUnrecognisedURL) {
+		return ref, err
+	}
+	resp, err := cli.do(ctx, http.MethodGet, addr, nil, nil)
+	if err != nil {
+		return layoutRef{}, fmt.Errorf("failed to resolve layout URL: %w", err)
+	}
+	resp.body.close()
+	if resp.url == addr {
+		return layoutRef{}, fmt.Errorf("invalid config page: %v", addr)
+	}
+	slog.Info("resolved layout URL", "url", addr, "resolved", resp.url)
+	ref, err = parseLayoutURL(resp.url)
+	if err != nil {
+		return layoutRef{}, fmt.Errorf("invalid config page: %v resolved to %v", addr, resp.url)
+	}
+	return ref, nil
+}
+
+// fetchRevision fetches the layout revision data for ref, returning
+// the revision's ID and the layout data. Data that does not match the
+// layout schema is rejected.
+func fetchRevision(ctx context.Context, cli *client, endpoint string, ref layoutRef) (string, []byte, error) {
+	vars := map[string]any{
+		"hashId":     ref.hashID,
+		"revisionId": ref.revision,
+		"geometry":   nil,
+	}
+	if ref.geometry != "" {
+		vars["geometry"] = ref.geometry
+	}
+	data, err := graphql(ctx, cli, endpoint, "getLayout", vars, cmp.Or(cli.layoutQuery, layoutQuery))
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to get revision data: %w", err)
+	}
+
+	id, err := revisionID(data)
+	if err != nil {
+		return "", nil, fmt.Errorf("%w: layout %s revision %s for %s", err, ref.hashID, ref.revision, ref.geometry)
+	}
+	err = validateLayout(data)
+	if err != nil {
+		return "", nil, fmt.Errorf("layout %s revision %s: %w", ref.hashID, id, err)
+	}
+	return id, data, nil
+}
+
+// revisionFile reads layout revision data from the file at path,
+// returning the revision's ID and the layout data. The file may hold
+// either a complete GraphQL getLayout response or its data field, and
+// must match the layout schema.
+func revisionFile(path string) (string, []byte, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", nil, err
+	}
+	var probe map[string]json.RawMessage
+	err = json.Unmarshal(b, &probe)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to parse revision file: %w", err)
+	}
+	data := json.RawMessage(b)
+	if _, ok := probe["layout"]; !ok {
+		data, err = decodeGraphqlResponse(b)
+		if err != nil {
+			return "", nil, err
+		}
+	}
+	id, err := revisionID(data)
+	if err != nil {
+		return "", nil, fmt.Errorf("%w: %s", err, path)
+	}
+	err = validateLayout(data)
+	if err != nil {
+		return "", nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return id, data, nil
+}
+
+var (
+	errNoLayout     = errors.New("layout not found")
+	errNoRevision   = errors.New("revision not found")
+	errNoRevisionID = errors.New("missing revision ID")
+)
+
+// revisionID returns the revision hash ID from layout data.
+func revisionID(data []byte) (string, error) {
+	var revID struct {
+		Layout *struct {
+			Revision *struct {
+				HashID string `json:"hashId"`
+			} `json:"revision"`
+		} `json:"layout"`
+	}
+	err := json.Unmarshal(data, &revID)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse revision ID: %w", err)
+	}
+	switch {
+	case revID.Layout == nil:
+		return "", errNoLayout
+	case revID.Layout.Revision == nil:
+		return "", errNoRevision
+	case revID.Layout.Revision.HashID == "":
+		return "", errNoRevisionID
+	}
+	return revID.Layout.Revision.HashID, nil
+}
+
+// graphql performs a GraphQL query against endpoint and returns the
+// data field of the response. Errors reported by the server are
+// returned as a graphqlErrors.
+func graphql(ctx context.Context, cli *client, endpoint, op string, vars map[string]any, query string) (json.RawMessage, error) {
+	b, err := json.Marshal(struct {
+		OperationName string         `json:"operationName"`
+		Variables     map[string]any `json:"variables"`
+		Query         string         `json:"query"`
+	}{
+		OperationName: op,
+		Variables:     vars,
+		Query:         query,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal query: %w", err)
+	}
+
+	header := http.Header{"Content-Type": {"application/json"}}
+	if cli.token != "" {
+		header.Set("Authorization", "Bearer "+cli.token)
+	}
+	resp, err := cli.do(ctx, http.MethodPost, endpoint, header, b)
+	if err != nil {
+		return nil, err
+	}
+	body, err := resp.data()
+	if err != nil {
+		return nil, err
+	}
+	return decodeGraphqlResponse(body)
+}
+
+// decodeGraphqlResponse returns the data field of a GraphQL response.
+// Errors reported in the response are returned as a graphqlErrors.
+func decodeGraphqlResponse(resp []byte) (json.RawMessage, error) {
+	var body struct {
+		Data   json.RawMessage `json:"data"`
+		Errors graphqlErrors   `json:"errors"`
+	}
+	// Unmarshal, unlike a Decoder, does not copy the response
+	// before parsing it, and rejects trailing data.
+	err := json.Unmarshal(resp, &body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+	if len(body.Errors) != 0 {
+		return nil, body.Errors
+	}
+	if len(body.Data) == 0 || string(body.Data) == "null" {
+		return nil, errors.New("response has no data")
+	}
+	return body.Data, nil
+}
+
+// graphqlErrors is the set of errors reported in a GraphQL response.
+type graphqlErrors []graphqlError
+
+func (e graphqlErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return "graphql: " + strings.Join(msgs, "; ")
+}
+
+// graphqlError is a single GraphQL response error.
+type graphqlError struct {
+	Message string `json:"message"`
+	Path    []any  `json:"path,omitempty"`
+}
+
+func (e graphqlError) Error() string {
+	if len(e.Path) == 0 {
+		return e.Message
+	}
+	p := make([]string, len(e.Path))
+	for i, v := range e.Path {
+		p[i] = fmt.Sprint(v)
+	}
+	return fmt.Sprintf("%s (at %s)", e.Message, strings.Join(p, "."))
+}
+
+const layoutQuery = `
+query getLayout($hashId: String!, $revisionId: String!, $geometry: String) {
+	layout(hashId: $hashId, geometry: $geometry, revisionId: $revisionId) {
+		...LayoutData
+	}
+}
+fragment LayoutData on Layout {
+	privacy
+	geometry
+	hashId
+	parent {
+		hashId
+	}
+	tags {
+		id
+		hashId
+		name
+	}
+	title
+	user {
+		annotation
+		annotationPublic
+		name
+		hashId
+		pictureUrl
+	}
+	isDefault
+	revision {
+		...RevisionData
+	}
+	lastRevisionCompiled
+	isLatestRevision
+}
+fragment RevisionData on Revision {
+	createdAt
+	hashId
+	model
+	title
+	config
+	swatch
+	qmkVersion
+	qmkUptodate
+	hasDeletedLayers
+	md5
+	combos {
+		keyIndices
+		layerIdx
+		name
+		trigger
+	}
+	tour {
+		...TourData
+	}
+	layers {
+		builtIn
+		hashId
+		keys
+		position
+		title
+		color
+		prevHashId
+	}
+}
+fragment TourData on Tour {
+	hashId url steps: tourSteps {
+		hashId intro outro position content keyIndex layer {
+			hashId position
+		}
+	}
+}`
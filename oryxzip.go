@@ -0,0 +1,296 @@
+// Copyright ©2025 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// oryxSource is the layout information recovered from an Oryx source
+// archive.
+type oryxSource struct {
+	geometry string
+	hashID   string
+	revision string
+	keymap   []byte // keymap.c
+	config   []byte // config.h, if present
+	modified time.Time
+}
+
+// errUnidentifiedArchive is returned when the geometry or layout of an
+// Oryx source archive cannot be determined.
+var errUnidentifiedArchive = errors.New("archive does not identify the layout: use -geometry and -hash-id")
+
+// oryxSourceName matches the names of Oryx source archives and their
+// top level directories, capturing the geometry, layout hash ID and
+// revision hash ID.
+var oryxSourceName = regexp.MustCompile(`^zsa_([a-z0-9_-]+?)_([A-Za-z0-9]+)_([A-Za-z0-9]+)_source$`)
+
+// readOryxZip reads the keymap sources from the Oryx source archive at
+// path. The geometry, layout and revision are taken from the archive's
+// name when it follows the Oryx naming pattern.
+func readOryxZip(name string) (*oryxSource, error) {
+	r, err := zip.OpenReader(name)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	var src oryxSource
+	var dir string
+	for _, f := range r.File {
+		if path.Base(f.Name) != "keymap.c" {
+			continue
+		}
+		src.keymap, err = readZipFile(f)
+		if err != nil {
+			return nil, err
+		}
+		src.modified = f.Modified
+		dir = path.Dir(f.Name)
+		break
+	}
+	if src.keymap == nil {
+		return nil, errors.New("no keymap.c in archive")
+	}
+	for _, f := range r.File {
+		if f.Name == path.Join(dir, "config.h") {
+			src.config, err = readZipFile(f)
+			if err != nil {
+				return nil, err
+			}
+			break
+		}
+	}
+
+	for _, n := range []string{strings.TrimSuffix(filepath.Base(name), filepath.Ext(name)), path.Base(dir)} {
+		m := oryxSourceName.FindStringSubmatch(n)
+		if m != nil {
+			src.geometry = strings.ReplaceAll(m[1], "_", "-")
+			src.hashID = m[2]
+			src.revision = m[3]
+			break
+		}
+	}
+	if src.geometry == "" {
+		if m := regexp.MustCompile(`=\s*LAYOUT_([a-z0-9_]+)\s*\(`).FindSubmatch(src.keymap); m != nil {
+			src.geometry = strings.ReplaceAll(string(m[1]), "_", "-")
+		}
+	}
+	return &src, nil
+}
+
+func readZipFile(f *zip.File) ([]byte, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return io.ReadAll(io.LimitReader(rc, 1<<20))
+}
+
+var (
+	keymapLayer  = regexp.MustCompile(`\[\s*(\d+)\s*\]\s*=\s*LAYOUT\w*\s*\(`)
+	comboArray   = regexp.MustCompile(`(?s)const\s+uint16_t\s+PROGMEM\s+(\w+)\[\]\s*=\s*\{(.*?)\}`)
+	comboAction  = regexp.MustCompile(`COMBO\(\s*(\w+)\s*,\s*([^)]*(?:\([^)]*\))?)\s*\)`)
+	tappingTermH = regexp.MustCompile(`(?m)^\s*#define\s+TAPPING_TERM\s+(\d+)`)
+)
+
+// layout reconstructs layout revision data from the sources. The
+// title is used for both the layout and the revision. If the revision
+// hash ID is not known, one is derived from the sources.
+func (s *oryxSource) layout(title string) ([]byte, error) {
+	src := string(s.keymap)
+	var layers []layer
+	for _, m := range keymapLayer.FindAllStringSubmatchIndex(src, -1) {
+		pos, _ := strconv.Atoi(src[m[2]:m[3]])
+		args, err := splitArgs(src[m[1]:])
+		if err != nil {
+			return nil, fmt.Errorf("layer %d: %w", pos, err)
+		}
+		ly := layer{HashID: fmt.Sprintf("L%d", pos), Position: pos, Title: fmt.Sprintf("Layer %d", pos)}
+		for _, a := range args {
+			ly.Keys = append(ly.Keys, parseQMKKey(a))
+		}
+		layers = append(layers, ly)
+	}
+	if len(layers) == 0 {
+		return nil, errors.New("no layers found in keymap.c")
+	}
+
+	var combos []combo
+	keys := make(map[string][]string)
+	for _, m := range comboArray.FindAllStringSubmatch(src, -1) {
+		for _, k := range strings.Split(m[2], ",") {
+			k = strings.TrimSpace(k)
+			if k != "" && k != "COMBO_END" {
+				keys[m[1]] = append(keys[m[1]], k)
+			}
+		}
+	}
+	for _, m := range comboAction.FindAllStringSubmatch(src, -1) {
+		c := combo{Name: m[1], Trigger: strings.TrimSpace(m[2])}
+		for _, k := range keys[m[1]] {
+			idx := -1
+			for j, lk := range layers[0].Keys {
+				if code, _ := qmkKey(lk); strings.ReplaceAll(code, " ", "") == strings.ReplaceAll(k, " ", "") {
+					idx = j
+					break
+				}
+			}
+			if idx < 0 {
+				slog.Warn("combo key not found on base layer", "combo", m[1], "key", k)
+				continue
+			}
+			c.KeyIndices = append(c.KeyIndices, idx)
+		}
+		combos = append(combos, c)
+	}
+
+	config := map[string]any{}
+	if m := tappingTermH.FindSubmatch(s.config); m != nil {
+		n, _ := strconv.Atoi(string(m[1]))
+		config["tappingTerm"] = n
+	}
+
+	rev := s.revision
+	if rev == "" {
+		rev = fmt.Sprintf("zip-%x", sha256.Sum256(s.keymap))[:16]
+	}
+	created := s.modified
+	if created.IsZero() {
+		created = time.Now()
+	}
+	return json.Marshal(map[string]any{
+		"layout": map[string]any{
+			"privacy":  true,
+			"geometry": s.geometry,
+			"hashId":   s.hashID,
+			"title":    title,
+			"revision": map[string]any{
+				"createdAt": created.UTC().Format(time.RFC3339),
+				"hashId":    rev,
+				"title":     title,
+				"config":    config,
+				"combos":    combos,
+				"layers":    layers,
+			},
+			"isLatestRevision": true,
+		},
+	})
+}
+
+// splitArgs returns the comma separated arguments of the C macro call
+// whose argument list starts at the beginning of s, up to the matching
+// closing parenthesis.
+func splitArgs(s string) ([]string, error) {
+	var (
+		args  []string
+		depth int
+		start int
+	)
+	for i, r := range s {
+		switch r {
+		case '(':
+			depth++
+		case ')':
+			if depth == 0 {
+				if a := strings.TrimSpace(s[start:i]); a != "" {
+					args = append(args, a)
+				}
+				return args, nil
+			}
+			depth--
+		case ',':
+			if depth == 0 {
+				args = append(args, strings.TrimSpace(s[start:i]))
+				start = i + 1
+			}
+		}
+	}
+	return nil, errors.New("unterminated layout")
+}
+
+var (
+	qmkCall = regexp.MustCompile(`^(\w+)\((.*)\)$`)
+	// qmkModMasks maps QMK mod-tap modifier masks to modifier names.
+	qmkModMasks = map[string]string{
+		"MOD_LCTL": "KC_LCTL", "MOD_LSFT": "KC_LSFT", "MOD_LALT": "KC_LALT", "MOD_LGUI": "KC_LGUI",
+		"MOD_RCTL": "KC_RCTL", "MOD_RSFT": "KC_RSFT", "MOD_RALT": "KC_RALT", "MOD_RGUI": "KC_RGUI",
+	}
+)
+
+// parseQMKKey returns the key described by a QMK keycode expression.
+// Expressions that are not recognised are kept as the tap code.
+func parseQMKKey(code string) key {
+	code = strings.Join(strings.Fields(code), "")
+	m := qmkCall.FindStringSubmatch(code)
+	if m == nil {
+		return key{Tap: &keyAction{Code: code}}
+	}
+	fn := m[1]
+	args, err := splitArgs(m[2] + ")")
+	if err != nil {
+		slog.Warn("unrecognised keycode imported as is", "code", code)
+		return key{Tap: &keyAction{Code: code}}
+	}
+	layerArg := func(i int) *int {
+		n, err := strconv.Atoi(args[i])
+		if err != nil {
+			return nil
+		}
+		return &n
+	}
+	switch {
+	case qmkLayerActions[fn] && len(args) == 1:
+		if n := layerArg(0); n != nil {
+			return key{Tap: &keyAction{Code: fn, Layer: n}}
+		}
+	case fn == "LT" && len(args) == 2:
+		if n := layerArg(0); n != nil {
+			k := parseQMKKey(args[1])
+			k.Hold = &keyAction{Code: "MO", Layer: n}
+			return k
+		}
+	case fn == "MT" && len(args) == 2:
+		mask := strings.Split(args[0], "|")
+		if hold, ok := qmkModMasks[mask[0]]; ok {
+			if len(mask) > 1 {
+				slog.Warn("only the first modifier of a mod-tap is imported", "code", code)
+			}
+			k := parseQMKKey(args[1])
+			k.Hold = &keyAction{Code: hold}
+			return k
+		}
+	case strings.HasSuffix(fn, "_T") && len(args) == 1:
+		if _, ok := qmkModifiers[strings.TrimSuffix(fn, "_T")]; ok {
+			k := parseQMKKey(args[0])
+			k.Hold = &keyAction{Code: "KC_" + strings.TrimSuffix(fn, "_T")}
+			return k
+		}
+	case len(args) == 1:
+		if _, ok := qmkModifiers[fn]; ok {
+			k := parseQMKKey(args[0])
+			if k.Tap != nil {
+				k.Tap.Modifiers = append(modifiers{fn}, k.Tap.Modifiers...)
+			}
+			return k
+		}
+	}
+	slog.Warn("unrecognised keycode imported as is", "code", code)
+	return key{Tap: &keyAction{Code: code}}
+}
@@ -0,0 +1,88 @@
+// Copyright ©2025 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// pins is a set of SHA-256 certificate fingerprints keyed by host name.
+type pins map[string]map[[sha256.Size]byte]bool
+
+// loadPins reads a certificate pin file. Each non-blank line that
+// does not start with '#' holds a host name and the hex-encoded SHA-256
+// fingerprint of a DER-encoded certificate that is acceptable in the
+// host's chain, separated by white space. Fingerprint bytes may be
+// separated by colons. A host may have more than one pin.
+func loadPins(path string) (pins, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	p := make(pins)
+	sc := bufio.NewScanner(bytes.NewReader(b))
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		f := strings.Fields(line)
+		if len(f) != 2 {
+			return nil, fmt.Errorf("%s:%d: invalid pin line: %q", path, n, line)
+		}
+		fp, err := hex.DecodeString(strings.ReplaceAll(f[1], ":", ""))
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid fingerprint: %w", path, n, err)
+		}
+		if len(fp) != sha256.Size {
+			return nil, fmt.Errorf("%s:%d: invalid fingerprint length: %d", path, n, len(fp))
+		}
+		host := strings.ToLower(f[0])
+		if p[host] == nil {
+			p[host] = make(map[[sha256.Size]byte]bool)
+		}
+		p[host][[sha256.Size]byte(fp)] = true
+	}
+	err = sc.Err()
+	if err != nil {
+		return nil, err
+	}
+	if len(p) == 0 {
+		return nil, fmt.Errorf("%s: no pins", path)
+	}
+	return p, nil
+}
+
+// verify is a tls.Config.VerifyConnection function that rejects
+// connections to hosts without a pin, and connections that do not have
+// a pinned certificate in the server's chain. It is called after
+// normal certificate verification has succeeded.
+func (p pins) verify(cs tls.ConnectionState) error {
+	host := strings.ToLower(cs.ServerName)
+	want, ok := p[host]
+	if !ok {
+		return pinError{fmt.Sprintf("no certificate pin for %s", host)}
+	}
+	for _, cert := range cs.PeerCertificates {
+		if want[sha256.Sum256(cert.Raw)] {
+			return nil
+		}
+	}
+	return pinError{fmt.Sprintf("certificate chain for %s does not match pinned fingerprints", host)}
+}
+
+// pinError is returned when a connection fails certificate pinning.
+type pinError struct {
+	msg string
+}
+
+func (e pinError) Error() string { return e.msg }
@@ -0,0 +1,155 @@
+// Copyright ©2025 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+// pin freezes layouts at a stored revision so that update, watch and
+// user-layouts -import do not store newer revisions of them.
+func pin(args []string) {
+	fs := flag.NewFlagSet("fkm pin", flag.ExitOnError)
+	var (
+		common commonFlags
+		guard  writeGuard
+	)
+	common.register(fs)
+	guard.register(fs)
+	revID := fs.String("revision", "", "stored revision ID to pin its layout to")
+	unpin := fs.String("unpin", "", "hash ID of a layout to unpin")
+	dryRun := fs.Bool("dry-run", false, "print the changes that would be made to the database without making them")
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), `usage: fkm pin [-revision <id> | -unpin <hashId>] [flags]
+
+Pin a layout to a stored revision, or unpin it. Newer revisions of pinned
+layouts are not stored by update, watch or user-layouts -import. With no
+-revision or -unpin flag, the pinned layouts are listed.
+
+Flags:
+`)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	common.setup(fs)
+	if fs.NArg() != 0 || (*revID != "" && *unpin != "") {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+
+	readOnly := *dryRun || (*revID == "" && *unpin == "")
+	db := openExisting(common.dbPath, readOnly, common.db)
+	defer db.Close()
+
+	var changes []change
+	switch {
+	case *revID != "":
+		l, err := loadLayout(db, *revID)
+		if err != nil {
+			fatal("failed to load revision", err, "revision", *revID)
+		}
+		changes = []change{pinLayout(l), trackLayout(l)}
+	case *unpin != "":
+		changes = []change{unpinLayout(*unpin)}
+	default:
+		err := listPins(db)
+		if err != nil {
+			fatal("failed to list pins", err, "path", common.dbPath)
+		}
+		return
+	}
+	if *dryRun {
+		printChanges(common.dbPath, changes)
+		return
+	}
+	err := guard.check(context.Background(), db)
+	if err != nil {
+		fatal("refusing to update db", err, "path", common.dbPath)
+	}
+	err = apply(db, changes)
+	if err != nil {
+		fatal("failed to update db", err, "path", common.dbPath)
+	}
+}
+
+// listPins prints the pinned layouts.
+func listPins(db *sql.DB) error {
+	ok, err := hasTable(db, "fkm_pinned")
+	if err != nil || !ok {
+		return err
+	}
+	rows, err := db.Query(`SELECT hashId, revisionId, pinned FROM fkm_pinned ORDER BY hashId`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "LAYOUT\tREVISION\tPINNED")
+	for rows.Next() {
+		var hashID, revID, pinned string
+		err = rows.Scan(&hashID, &revID, &pinned)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", hashID, revID, pinned)
+	}
+	err = rows.Err()
+	if err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// pinnedRevisions returns the revision each pinned layout is pinned to,
+// keyed by layout hash ID.
+func pinnedRevisions(db *sql.DB) (map[string]string, error) {
+	ok, err := hasTable(db, "fkm_pinned")
+	if err != nil || !ok {
+		return nil, err
+	}
+	rows, err := db.Query(`SELECT hashId, revisionId FROM fkm_pinned`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	pinned := make(map[string]string)
+	for rows.Next() {
+		var hashID, revID string
+		err = rows.Scan(&hashID, &revID)
+		if err != nil {
+			return nil, err
+		}
+		pinned[hashID] = revID
+	}
+	return pinned, rows.Err()
+}
+
+// pinLayout returns a change pinning the layout to its revision.
+func pinLayout(l *layout) change {
+	return change{
+		table: "fkm_pinned",
+		op:    "upsert",
+		desc:  fmt.Sprintf("hashId=%s revisionId=%s", l.HashID, l.Revision.HashID),
+		query: `INSERT INTO fkm_pinned (hashId, revisionId, pinned) VALUES (?, ?, ?) ON CONFLICT DO UPDATE SET revisionId=excluded.revisionId, pinned=excluded.pinned`,
+		args:  []any{l.HashID, l.Revision.HashID, time.Now().UTC().Format(time.RFC3339)},
+	}
+}
+
+// unpinLayout returns a change unpinning the layout.
+func unpinLayout(hashID string) change {
+	return change{
+		table: "fkm_pinned",
+		op:    "delete",
+		desc:  "hashId=" + hashID,
+		query: `DELETE FROM fkm_pinned WHERE hashId=?`,
+		args:  []any{hashID},
+	}
+}
@@ -0,0 +1,28 @@
+// Copyright ©2025 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os/exec"
+	"strconv"
+)
+
+// findProcess returns the PID of a running process with the given
+// name, or zero if there is none.
+func findProcess(name string) (int, error) {
+	out, err := exec.Command("pgrep", "-x", name).Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 {
+			// No matching process.
+			return 0, nil
+		}
+		return 0, err
+	}
+	pid, _, _ := bytes.Cut(out, []byte("\n"))
+	return strconv.Atoi(string(bytes.TrimSpace(pid)))
+}
@@ -0,0 +1,37 @@
+// Copyright ©2025 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// findProcess returns the PID of a running process with the given
+// name, or zero if there is none.
+func findProcess(name string) (int, error) {
+	paths, err := filepath.Glob("/proc/[0-9]*/comm")
+	if err != nil {
+		return 0, err
+	}
+	for _, p := range paths {
+		comm, err := os.ReadFile(p)
+		if err != nil {
+			// The process may have exited.
+			continue
+		}
+		if string(bytes.TrimSpace(comm)) != name {
+			continue
+		}
+		pid, err := strconv.Atoi(filepath.Base(filepath.Dir(p)))
+		if err != nil || pid == os.Getpid() {
+			continue
+		}
+		return pid, nil
+	}
+	return 0, nil
+}
@@ -0,0 +1,14 @@
+// Copyright ©2025 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !linux && !darwin && !windows
+
+package main
+
+// findProcess returns the PID of a running process with the given
+// name, or zero if there is none. Process detection is not supported
+// on this platform, so it always reports no process.
+func findProcess(name string) (int, error) {
+	return 0, nil
+}
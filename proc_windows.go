@@ -0,0 +1,36 @@
+// Copyright ©2025 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// findProcess returns the PID of a running process with the given
+// name, or zero if there is none.
+func findProcess(name string) (int, error) {
+	image := name + ".exe"
+	out, err := exec.Command("tasklist", "/FO", "CSV", "/NH", "/FI", "IMAGENAME eq "+image).Output()
+	if err != nil {
+		return 0, err
+	}
+	records, err := csv.NewReader(bytes.NewReader(out)).ReadAll()
+	if err != nil {
+		// tasklist prints an informational message
+		// rather than CSV when nothing matches.
+		return 0, nil
+	}
+	for _, r := range records {
+		if len(r) < 2 || !strings.EqualFold(r[0], image) {
+			continue
+		}
+		return strconv.Atoi(r[1])
+	}
+	return 0, nil
+}
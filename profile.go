@@ -0,0 +1,227 @@
+// Copyright ©2025 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// defaultStateDir returns the default fkm state directory.
+func defaultStateDir() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "fkm")
+}
+
+// profile is a named set of defaults for fkm invocations.
+type profile struct {
+	// Flags holds default values for command flags, keyed
+	// by flag name. Flags set on the command line take
+	// precedence.
+	Flags map[string]string `json:"flags,omitempty"`
+	// Config holds default keymapp configuration values
+	// used when seeding a database's config table.
+	Config map[string]string `json:"config,omitempty"`
+}
+
+var validProfileName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
+// profilePath returns the path to the named profile.
+func profilePath(name string) (string, error) {
+	if !validProfileName.MatchString(name) {
+		return "", fmt.Errorf("invalid profile name: %q", name)
+	}
+	dir := defaultStateDir()
+	if dir == "" {
+		return "", errors.New("no fkm state directory")
+	}
+	return filepath.Join(dir, "profiles", name+".json"), nil
+}
+
+// profileNames returns the names of the stored profiles.
+func profileNames() ([]string, error) {
+	paths, err := filepath.Glob(filepath.Join(defaultStateDir(), "profiles", "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, len(paths))
+	for i, p := range paths {
+		names[i] = strings.TrimSuffix(filepath.Base(p), ".json")
+	}
+	return names, nil
+}
+
+// loadProfile returns the named profile.
+func loadProfile(name string) (*profile, error) {
+	path, err := profilePath(name)
+	if err != nil {
+		return nil, err
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("no profile %q: %w", name, err)
+		}
+		return nil, err
+	}
+	var p profile
+	err = json.Unmarshal(b, &p)
+	if err != nil {
+		return nil, fmt.Errorf("invalid profile %q: %w", name, err)
+	}
+	return &p, nil
+}
+
+// storeProfile writes the named profile.
+func storeProfile(name string, p *profile) error {
+	path, err := profilePath(name)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(path), 0o750)
+	if err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(p, "", "\t")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, append(b, '\n'), 0o640)
+}
+
+// applyDefaults sets each flag in fs that was not set on the command
+// line to its values in defaults. Defaults for flags not defined by fs
+// are ignored so that a single set of defaults can serve all commands.
+// Each value is set in turn, so flags that may be repeated may have
+// more than one value.
+func applyDefaults(fs *flag.FlagSet, defaults map[string][]string, source string) error {
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	for _, name := range slices.Sorted(maps.Keys(defaults)) {
+		if set[name] || fs.Lookup(name) == nil {
+			continue
+		}
+		for _, val := range defaults[name] {
+			err := fs.Set(name, val)
+			if err != nil {
+				return fmt.Errorf("%s: invalid value %q for flag -%s: %w", source, val, name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// singleValued returns m with each value wrapped in a slice.
+func singleValued(m map[string]string) map[string][]string {
+	v := make(map[string][]string, len(m))
+	for k, s := range m {
+		v[k] = []string{s}
+	}
+	return v
+}
+
+// profileCmd manages fkm profiles.
+func profileCmd(args []string) {
+	usage := func() {
+		fmt.Fprintln(os.Stderr, `usage: fkm profile list
+       fkm profile show <name>
+       fkm profile set <name> [flag=value...] [config.key=value...]
+       fkm profile unset <name> [flag...] [config.key...]
+       fkm profile delete <name>`)
+		os.Exit(exitUsage)
+	}
+	if len(args) == 0 {
+		usage()
+	}
+	switch cmd, args := args[0], args[1:]; cmd {
+	case "list":
+		if len(args) != 0 {
+			usage()
+		}
+		names, err := profileNames()
+		if err != nil {
+			fatal("failed to list profiles", err)
+		}
+		for _, n := range names {
+			fmt.Println(n)
+		}
+	case "show":
+		if len(args) != 1 {
+			usage()
+		}
+		p, err := loadProfile(args[0])
+		if err != nil {
+			fatal("failed to load profile", err)
+		}
+		for _, k := range slices.Sorted(maps.Keys(p.Flags)) {
+			fmt.Printf("%s=%s\n", k, p.Flags[k])
+		}
+		for _, k := range slices.Sorted(maps.Keys(p.Config)) {
+			fmt.Printf("config.%s=%s\n", k, p.Config[k])
+		}
+	case "set", "unset":
+		if len(args) < 2 {
+			usage()
+		}
+		name := args[0]
+		p, err := loadProfile(name)
+		switch {
+		case err == nil:
+		case errors.Is(err, fs.ErrNotExist) && cmd == "set":
+			p = &profile{}
+		default:
+			fatal("failed to load profile", err)
+		}
+		for _, arg := range args[1:] {
+			key, val, ok := strings.Cut(arg, "=")
+			if ok == (cmd == "unset") {
+				usage()
+			}
+			dst := &p.Flags
+			if k, isConfig := strings.CutPrefix(key, "config."); isConfig {
+				key = k
+				dst = &p.Config
+			}
+			if *dst == nil {
+				*dst = make(map[string]string)
+			}
+			if cmd == "set" {
+				(*dst)[key] = val
+			} else {
+				delete(*dst, key)
+			}
+		}
+		err = storeProfile(name, p)
+		if err != nil {
+			fatal("failed to store profile", err)
+		}
+	case "delete":
+		if len(args) != 1 {
+			usage()
+		}
+		path, err := profilePath(args[0])
+		if err != nil {
+			fatal("failed to delete profile", err)
+		}
+		err = os.Remove(path)
+		if err != nil {
+			fatal("failed to delete profile", err)
+		}
+	default:
+		usage()
+	}
+}
@@ -0,0 +1,112 @@
+// Copyright ©2025 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// progress reports the progress of a long running operation on a
+// terminal. A nil *progress reports nothing, so callers need not check
+// whether reporting is enabled.
+type progress struct {
+	w    io.Writer
+	what string
+
+	mu    sync.Mutex
+	total int
+	done  int
+	bytes int64
+	last  time.Time
+}
+
+// progress returns a progress reporter for an operation over total
+// items, or nil if progress should not be shown. Progress is only shown
+// when stderr is a terminal and neither -quiet nor verbose logging,
+// which would interleave with it, is in effect.
+func (c *commonFlags) progress(what string, total int) *progress {
+	if c.quiet || c.verbose || c.debug {
+		return nil
+	}
+	fi, err := os.Stderr.Stat()
+	if err != nil || fi.Mode()&os.ModeCharDevice == 0 {
+		return nil
+	}
+	return &progress{w: os.Stderr, what: what, total: total}
+}
+
+// received records n bytes received over the network.
+func (p *progress) received(n int) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.bytes += int64(n)
+	p.draw(false)
+}
+
+// step records the completion of an item.
+func (p *progress) step() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.done++
+	p.draw(true)
+}
+
+// finish clears the progress line.
+func (p *progress) finish() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	fmt.Fprint(p.w, "\r\033[K")
+}
+
+// draw redraws the progress line. Unless force is true, redraws are
+// limited to ten per second. p.mu must be held.
+func (p *progress) draw(force bool) {
+	now := time.Now()
+	if !force && now.Sub(p.last) < 100*time.Millisecond {
+		return
+	}
+	p.last = now
+	const width = 20
+	filled := width
+	if p.total > 0 {
+		filled = min(width*p.done/p.total, width)
+	}
+	bar := make([]rune, width)
+	for i := range bar {
+		if i < filled {
+			bar[i] = '='
+		} else {
+			bar[i] = ' '
+		}
+	}
+	fmt.Fprintf(p.w, "\r\033[K%s [%s] %d/%d %s", p.what, string(bar), p.done, p.total, formatBytes(p.bytes))
+}
+
+// formatBytes returns n as a human readable byte count.
+func formatBytes(n int64) string {
+	const unit = 1000
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "kMGTPE"[exp])
+}
@@ -0,0 +1,140 @@
+// Copyright ©2025 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"path/filepath"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// provenance is the record of where a stored revision came from, kept
+// in the fkm_provenance table each time fkm writes the revision.
+type provenance struct {
+	Source  string    `json:"source"`  // URL, API reference or file the data was read from
+	Fetched time.Time `json:"fetched"` // time the data was fetched or read
+	Command string    `json:"command"` // fkm command that wrote the revision
+	Version string    `json:"fkm"`     // fkm version that wrote the revision
+	Note    string    `json:"note,omitempty"`
+}
+
+// provenanceFlags are the flags of the commands that store revisions.
+type provenanceFlags struct {
+	note string
+}
+
+// register adds the provenance flags to fs.
+func (p *provenanceFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&p.note, "note", "", "note recorded with the provenance of stored revisions, such as a ticket or reason")
+}
+
+// provenance returns the provenance of revision data read from source
+// now.
+func (p provenanceFlags) provenance(source string) provenance {
+	return provenance{
+		Source:  source,
+		Fetched: time.Now().UTC().Truncate(time.Second),
+		Command: commandName,
+		Version: fkmVersion(),
+		Note:    p.note,
+	}
+}
+
+// refSource returns the provenance source of a revision fetched from
+// the GraphQL endpoint.
+func refSource(endpoint string, ref layoutRef) string {
+	return fmt.Sprintf("%s layout=%s geometry=%s revision=%s", endpoint, ref.hashID, ref.geometry, ref.revision)
+}
+
+// fileSource returns the provenance source of revision data read from
+// the file at path.
+func fileSource(path string) string {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		abs = path
+	}
+	return "file:" + abs
+}
+
+// fkmVersion returns the module version of the fkm binary, or its VCS
+// revision for development builds.
+var fkmVersion = sync.OnceValue(func() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	if v := info.Main.Version; v != "" && v != "(devel)" {
+		return v
+	}
+	var rev, modified string
+	for _, s := range info.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			rev = s.Value
+		case "vcs.modified":
+			if s.Value == "true" {
+				modified = "+dirty"
+			}
+		}
+	}
+	if rev == "" {
+		return "devel"
+	}
+	return "devel-" + rev[:min(len(rev), 12)] + modified
+})
+
+// recordProvenance returns a change adding the provenance of the
+// revision with the given ID.
+func recordProvenance(id string, p provenance) change {
+	fetched := p.Fetched.Format(time.RFC3339)
+	desc := fmt.Sprintf("revisionId=%s source=%q fetched=%s command=%s fkm=%s", id, p.Source, fetched, p.Command, p.Version)
+	if p.Note != "" {
+		desc += fmt.Sprintf(" note=%q", p.Note)
+	}
+	return change{
+		table: "fkm_provenance",
+		op:    "insert",
+		desc:  desc,
+		query: `INSERT INTO fkm_provenance (revisionId, source, fetched, command, fkm, note) VALUES (?, ?, ?, ?, ?, ?)`,
+		args:  []any{id, p.Source, fetched, p.Command, p.Version, p.Note},
+	}
+}
+
+// revisionProvenance returns the provenance records of the stored
+// revisions keyed by revision ID, oldest first. Databases predating
+// provenance records have none.
+func revisionProvenance(db *sql.DB) (map[string][]provenance, error) {
+	ok, err := hasTable(db, "fkm_provenance")
+	if err != nil || !ok {
+		return nil, err
+	}
+	rows, err := db.Query(`SELECT revisionId, source, fetched, command, fkm, coalesce(note, '') FROM fkm_provenance ORDER BY rowid`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	provs := make(map[string][]provenance)
+	for rows.Next() {
+		var (
+			id      string
+			p       provenance
+			fetched string
+		)
+		err = rows.Scan(&id, &p.Source, &fetched, &p.Command, &p.Version, &p.Note)
+		if err != nil {
+			return nil, err
+		}
+		p.Fetched, err = time.Parse(time.RFC3339, fetched)
+		if err != nil {
+			return nil, fmt.Errorf("invalid provenance time for revision %s: %w", id, err)
+		}
+		provs[id] = append(provs[id], p)
+	}
+	return provs, rows.Err()
+}
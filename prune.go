@@ -0,0 +1,157 @@
+// Copyright ©2025 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"flag"
+	"log/slog"
+	"maps"
+	"os"
+	"slices"
+)
+
+// prune removes unreferenced revisions from the database.
+func prune(args []string) {
+	fs := flag.NewFlagSet("fkm prune", flag.ExitOnError)
+	var (
+		common commonFlags
+		guard  writeGuard
+	)
+	common.register(fs)
+	guard.register(fs)
+	keep := fs.Int("keep", 1, "number of newest unreferenced revisions to keep for each layout")
+	vacuum := fs.Bool("vacuum", true, "vacuum the database after pruning")
+	dryRun := fs.Bool("dry-run", false, "print the revisions that would be removed without removing them")
+	backupDir := fs.String("backup-dir", "", "directory to back up the database to before changing it")
+	fs.Parse(args)
+	common.setup(fs)
+	if *keep < 0 {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+
+	db := openExisting(common.dbPath, *dryRun, common.db)
+	defer db.Close()
+
+	changes, err := pruneChanges(db, *keep)
+	if err != nil {
+		fatal("failed to find prunable revisions", err)
+	}
+	if *dryRun {
+		printChanges(common.dbPath, changes)
+		return
+	}
+	err = guard.check(context.Background(), db)
+	if err != nil {
+		fatal("refusing to prune db", err, "path", common.dbPath)
+	}
+	if *backupDir != "" && len(changes) != 0 {
+		err = autoBackup(db, *backupDir)
+		if err != nil {
+			fatal("failed to back up db", err, "path", common.dbPath)
+		}
+	}
+	err = apply(db, changes)
+	if err != nil {
+		fatal("failed to prune db", err, "path", common.dbPath)
+	}
+	if *vacuum {
+		_, err = db.Exec(`VACUUM`)
+		if err != nil {
+			fatal("failed to vacuum db", err, "path", common.dbPath)
+		}
+		slog.Info("vacuumed db", "path", common.dbPath)
+	}
+}
+
+// pruneChanges returns the changes needed to remove revisions that are
+// not referenced by a smart layer or heatmap, retaining the newest keep
+// unreferenced revisions of each layout. Pinned and tracked revisions
+// are never removed.
+func pruneChanges(db *sql.DB, keep int) ([]change, error) {
+	retained, err := retainedRevisions(db)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := db.Query(`
+SELECT revisionId, data FROM revision
+WHERE revisionId NOT IN (SELECT revisionId FROM smart_layer)
+AND revisionId NOT IN (SELECT revisionId FROM heatmap)`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	type candidate struct {
+		id string
+		l  *layout
+	}
+	byLayout := make(map[string][]candidate)
+	for rows.Next() {
+		var (
+			id   string
+			data []byte
+		)
+		err = rows.Scan(&id, &data)
+		if err != nil {
+			return nil, err
+		}
+		l, err := parseLayout(data)
+		if err != nil {
+			slog.Warn("skipping unparseable revision", "revision", id, "error", err)
+			continue
+		}
+		byLayout[l.HashID] = append(byLayout[l.HashID], candidate{id: id, l: l})
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	var changes []change
+	for _, hashID := range slices.Sorted(maps.Keys(byLayout)) {
+		revs := byLayout[hashID]
+		slices.SortFunc(revs, func(a, b candidate) int {
+			return cmp.Or(
+				b.l.Revision.Created().Compare(a.l.Revision.Created()),
+				cmp.Compare(a.id, b.id),
+			)
+		})
+		if len(revs) <= keep {
+			continue
+		}
+		for _, r := range revs[keep:] {
+			if why, ok := retained[r.id]; ok {
+				slog.Debug("keeping revision", "revision", r.id, "reason", why)
+				continue
+			}
+			changes = append(changes, deleteRevision(r.id, r.l)...)
+		}
+	}
+	return changes, nil
+}
+
+// retainedRevisions returns the IDs of the revisions that are pinned or
+// tracked, with the reason they must be kept.
+func retainedRevisions(db *sql.DB) (map[string]string, error) {
+	retained := make(map[string]string)
+	tracked, err := trackedLayouts(db)
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range tracked {
+		retained[t.revision] = "tracked"
+	}
+	pinned, err := pinnedRevisions(db)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range pinned {
+		retained[id] = "pinned"
+	}
+	return retained, nil
+}
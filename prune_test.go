@@ -0,0 +1,94 @@
+// Copyright ©2025 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestPruneChanges(t *testing.T) {
+	type rev struct {
+		hashID, id, created string
+	}
+	tests := []struct {
+		name    string
+		revs    []rev // stored in order, the last of a layout is tracked
+		pin     string
+		heatmap string
+		keep    int
+		want    []string
+	}{
+		{
+			name: "older removed",
+			revs: []rev{{"abc", "r1", "2025-01-01T00:00:00Z"}, {"abc", "r2", "2025-02-01T00:00:00Z"}},
+			keep: 1,
+			want: []string{"r1"},
+		},
+		{
+			name: "pinned kept",
+			revs: []rev{{"abc", "r1", "2025-01-01T00:00:00Z"}, {"abc", "r2", "2025-02-01T00:00:00Z"}},
+			pin:  "r1",
+			keep: 1,
+		},
+		{
+			name: "tracked kept",
+			revs: []rev{{"abc", "r2", "2025-02-01T00:00:00Z"}, {"abc", "r1", "2025-01-01T00:00:00Z"}},
+			keep: 1,
+		},
+		{
+			name: "tracked kept with keep zero",
+			revs: []rev{{"abc", "r1", "2025-01-01T00:00:00Z"}, {"abc", "r2", "2025-02-01T00:00:00Z"}},
+			keep: 0,
+			want: []string{"r1"},
+		},
+		{
+			name:    "heatmap kept",
+			revs:    []rev{{"abc", "r1", "2025-01-01T00:00:00Z"}, {"abc", "r2", "2025-02-01T00:00:00Z"}, {"abc", "r3", "2025-03-01T00:00:00Z"}},
+			heatmap: "r1",
+			keep:    0,
+			want:    []string{"r2"},
+		},
+		{
+			name: "layouts pruned separately",
+			revs: []rev{
+				{"abc", "r1", "2025-01-01T00:00:00Z"}, {"abc", "r2", "2025-02-01T00:00:00Z"}, {"abc", "r3", "2025-03-01T00:00:00Z"},
+				{"def", "s1", "2025-01-01T00:00:00Z"}, {"def", "s2", "2025-02-01T00:00:00Z"},
+			},
+			keep: 1,
+			want: []string{"r1", "r2", "s1"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			db := newTestDB(t)
+			layouts := make(map[string]*layout)
+			for _, r := range test.revs {
+				layouts[r.id] = storeTestRevision(t, db, r.hashID, r.id, r.created)
+			}
+			if test.pin != "" {
+				err := apply(db, []change{pinLayout(layouts[test.pin])})
+				if err != nil {
+					t.Fatalf("failed to pin %s: %v", test.pin, err)
+				}
+			}
+			if test.heatmap != "" {
+				_, err := db.Exec(`INSERT INTO heatmap (revisionId) VALUES (?)`, test.heatmap)
+				if err != nil {
+					t.Fatalf("failed to add heatmap: %v", err)
+				}
+			}
+
+			changes, err := pruneChanges(db, test.keep)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			got := deletedRevisions(changes)
+			if !slices.Equal(got, test.want) {
+				t.Errorf("unexpected deleted revisions: got %v want %v", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,201 @@
+// Copyright ©2025 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+// qmkKeyboards maps Oryx geometries to QMK keyboard names and layout
+// macros.
+var qmkKeyboards = map[string]struct {
+	keyboard string
+	layout   string
+}{
+	"voyager":    {"zsa/voyager", "LAYOUT"},
+	"moonlander": {"zsa/moonlander", "LAYOUT"},
+	"ergodox-ez": {"ergodox_ez", "LAYOUT_ergodox_pretty"},
+	"planck-ez":  {"planck/ez", "LAYOUT_planck_1x2uC"},
+}
+
+// qmkKeyboard returns the QMK keyboard name and layout macro for the
+// layout's geometry.
+func qmkKeyboard(l *layout) (keyboard, macro string) {
+	kb, ok := qmkKeyboards[l.Geometry]
+	if !ok {
+		slog.Warn("unknown QMK keyboard for geometry", "geometry", l.Geometry)
+		return l.Geometry, "LAYOUT"
+	}
+	return kb.keyboard, kb.layout
+}
+
+// qmkModifiers maps modifier names to QMK modifier prefixes.
+var qmkModifiers = map[string]string{
+	"LCTL": "LCTL", "KC_LCTL": "LCTL", "KC_LEFT_CTRL": "LCTL", "leftCtrl": "LCTL",
+	"LSFT": "LSFT", "KC_LSFT": "LSFT", "KC_LEFT_SHIFT": "LSFT", "leftShift": "LSFT",
+	"LALT": "LALT", "KC_LALT": "LALT", "KC_LEFT_ALT": "LALT", "leftAlt": "LALT",
+	"LGUI": "LGUI", "KC_LGUI": "LGUI", "KC_LEFT_GUI": "LGUI", "leftGui": "LGUI",
+	"RCTL": "RCTL", "KC_RCTL": "RCTL", "KC_RIGHT_CTRL": "RCTL", "rightCtrl": "RCTL",
+	"RSFT": "RSFT", "KC_RSFT": "RSFT", "KC_RIGHT_SHIFT": "RSFT", "rightShift": "RSFT",
+	"RALT": "RALT", "KC_RALT": "RALT", "KC_RIGHT_ALT": "RALT", "rightAlt": "RALT",
+	"RGUI": "RGUI", "KC_RGUI": "RGUI", "KC_RIGHT_GUI": "RGUI", "rightGui": "RGUI",
+}
+
+// qmkLayerActions is the set of Oryx layer action codes that have QMK
+// equivalents of the same name.
+var qmkLayerActions = map[string]bool{
+	"MO": true, "TO": true, "TG": true, "TT": true, "OSL": true, "DF": true,
+}
+
+// qmkAction returns the QMK keycode for a single key action.
+func qmkAction(a *keyAction) (string, error) {
+	if a == nil || a.Code == "" {
+		return "KC_NO", nil
+	}
+	if a.Layer != nil {
+		code := strings.TrimPrefix(a.Code, "KC_")
+		if !qmkLayerActions[code] {
+			return "", fmt.Errorf("unsupported layer action %s", a.Code)
+		}
+		return fmt.Sprintf("%s(%d)", code, *a.Layer), nil
+	}
+	code := a.Code
+	if !strings.HasPrefix(code, "KC_") && !strings.Contains(code, "(") {
+		code = "KC_" + code
+	}
+	mods := a.Modifiers
+	if a.Modifier != "" {
+		mods = append(modifiers{a.Modifier}, mods...)
+	}
+	for i := len(mods) - 1; i >= 0; i-- {
+		m, ok := qmkModifiers[mods[i]]
+		if !ok {
+			return "", fmt.Errorf("unsupported modifier %s", mods[i])
+		}
+		code = fmt.Sprintf("%s(%s)", m, code)
+	}
+	return code, nil
+}
+
+// qmkKey returns the QMK keycode for a key. Hold actions are encoded as
+// layer-taps or mod-taps. Double tap and tap-hold actions require tap
+// dance, which is not exported; they are dropped with a warning.
+func qmkKey(k key) (string, error) {
+	tap, err := qmkAction(k.Tap)
+	if err != nil {
+		return "", err
+	}
+	if k.DoubleTap != nil && k.DoubleTap.Code != "" || k.TapHold != nil && k.TapHold.Code != "" {
+		slog.Warn("tap dance actions are not exported", "key", describeKey(k, nil))
+	}
+	if k.Hold == nil || k.Hold.Code == "" {
+		return tap, nil
+	}
+	if k.Hold.Layer != nil {
+		return fmt.Sprintf("LT(%d, %s)", *k.Hold.Layer, tap), nil
+	}
+	if m, ok := qmkModifiers[k.Hold.Code]; ok {
+		return fmt.Sprintf("%s_T(%s)", m, tap), nil
+	}
+	return "", fmt.Errorf("unsupported hold action %s", k.Hold.Code)
+}
+
+// qmkLayers returns the QMK keycodes of each layer of the layout. Keys
+// that cannot be converted are exported as KC_NO with a warning.
+func qmkLayers(l *layout) [][]string {
+	layers := make([][]string, len(l.Revision.Layers))
+	for i, ly := range l.Revision.Layers {
+		codes := make([]string, len(ly.Keys))
+		for j, k := range ly.Keys {
+			code, err := qmkKey(k)
+			if err != nil {
+				slog.Warn("key cannot be exported", "layer", i, "key", j, "error", err)
+				code = "KC_NO"
+			}
+			codes[j] = code
+		}
+		layers[i] = codes
+	}
+	return layers
+}
+
+// writeQMKJSON writes a QMK keymap.json for the layout. Combos cannot
+// be expressed in keymap.json, so they are omitted with a warning.
+func writeQMKJSON(w io.Writer, l *layout) error {
+	if len(l.Revision.Combos) != 0 {
+		slog.Warn("combos are not exported to keymap.json: use the qmk-c format", "combos", len(l.Revision.Combos))
+	}
+	keyboard, macro := qmkKeyboard(l)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(struct {
+		Version  int        `json:"version"`
+		Keyboard string     `json:"keyboard"`
+		Keymap   string     `json:"keymap"`
+		Layout   string     `json:"layout"`
+		Layers   [][]string `json:"layers"`
+		Notes    string     `json:"notes,omitempty"`
+	}{
+		Version:  1,
+		Keyboard: keyboard,
+		Keymap:   "fkm",
+		Layout:   macro,
+		Layers:   qmkLayers(l),
+		Notes:    fmt.Sprintf("%s revision %s exported by fkm", l.Title, l.Revision.HashID),
+	})
+}
+
+// writeQMKC writes a QMK keymap.c for the layout, including its combos.
+func writeQMKC(w io.Writer, l *layout) error {
+	_, macro := qmkKeyboard(l)
+	layers := qmkLayers(l)
+	var b strings.Builder
+	fmt.Fprintf(&b, "// %s revision %s exported by fkm.\n\n#include QMK_KEYBOARD_H\n\n", l.Title, l.Revision.HashID)
+	b.WriteString("const uint16_t PROGMEM keymaps[][MATRIX_ROWS][MATRIX_COLS] = {\n")
+	for i, codes := range layers {
+		fmt.Fprintf(&b, "    // %s\n    [%d] = %s(\n        %s\n    ),\n", l.Revision.Layers[i].Name(), i, macro, strings.Join(codes, ", "))
+	}
+	b.WriteString("};\n")
+
+	var combos []string
+	for i, c := range l.Revision.Combos {
+		if c.LayerIdx < 0 || c.LayerIdx >= len(layers) {
+			slog.Warn("combo references a layer that does not exist", "combo", comboName(l, i), "layer", c.LayerIdx)
+			continue
+		}
+		var keys []string
+		for _, k := range c.KeyIndices {
+			if k < 0 || k >= len(layers[c.LayerIdx]) {
+				keys = nil
+				break
+			}
+			keys = append(keys, layers[c.LayerIdx][k])
+		}
+		if keys == nil {
+			slog.Warn("combo references a key that does not exist", "combo", comboName(l, i))
+			continue
+		}
+		trigger, err := qmkAction(&keyAction{Code: c.Trigger})
+		if err != nil {
+			slog.Warn("combo cannot be exported", "combo", comboName(l, i), "error", err)
+			continue
+		}
+		fmt.Fprintf(&b, "\n// Combo %s.\nconst uint16_t PROGMEM combo%d[] = {%s, COMBO_END};", comboName(l, i), len(combos), strings.Join(keys, ", "))
+		combos = append(combos, fmt.Sprintf("    COMBO(combo%d, %s),\n", len(combos), trigger))
+	}
+	if len(combos) != 0 {
+		b.WriteString("\n\n// Combos require COMBO_ENABLE = yes in rules.mk.\ncombo_t key_combos[] = {\n")
+		for _, c := range combos {
+			b.WriteString(c)
+		}
+		b.WriteString("};\n")
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,180 @@
+// Copyright ©2025 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// graphqlDefinition is a top-level definition in a GraphQL document.
+type graphqlDefinition struct {
+	kind string // "query" or "fragment"
+	name string
+	text string
+}
+
+// definitionHead matches the start of a named query or fragment
+// definition.
+var definitionHead = regexp.MustCompile(`^(query|fragment)\s+([_A-Za-z][_0-9A-Za-z]*)`)
+
+// parseGraphqlDefinitions splits a GraphQL document into its named
+// query and fragment definitions. Comments are removed.
+func parseGraphqlDefinitions(doc string) ([]graphqlDefinition, error) {
+	var (
+		defs  []graphqlDefinition
+		start = -1
+		depth int
+		text  strings.Builder
+	)
+	for i := 0; i < len(doc); i++ {
+		c := doc[i]
+		switch {
+		case c == '#':
+			for i < len(doc) && doc[i] != '\n' {
+				i++
+			}
+			text.WriteByte('\n')
+			continue
+		case c == '"':
+			j := i + 1
+			for ; j < len(doc) && doc[j] != '"'; j++ {
+				if doc[j] == '\\' {
+					j++
+				}
+			}
+			if j >= len(doc) {
+				return nil, errors.New("unterminated string")
+			}
+			text.WriteString(doc[i : j+1])
+			i = j
+			continue
+		case start < 0 && (c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == ','):
+			continue
+		case start < 0:
+			start = text.Len()
+		}
+		text.WriteByte(c)
+		switch c {
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth < 0 {
+				return nil, errors.New("unbalanced braces")
+			}
+			if depth == 0 {
+				def := strings.TrimSpace(text.String()[start:])
+				m := definitionHead.FindStringSubmatch(def)
+				if m == nil {
+					return nil, fmt.Errorf("unsupported definition: %.40q", def)
+				}
+				defs = append(defs, graphqlDefinition{kind: m[1], name: m[2], text: def})
+				start = -1
+			}
+		}
+	}
+	if start >= 0 {
+		return nil, errors.New("incomplete definition")
+	}
+	return defs, nil
+}
+
+// fragmentSpread matches a named fragment spread, but not an inline
+// fragment.
+var fragmentSpread = regexp.MustCompile(`\.\.\.\s*([_A-Za-z][_0-9A-Za-z]*)`)
+
+// mergeLayoutQuery returns the getLayout query with the definitions in
+// doc replacing the built-in definitions of the same name, and other
+// definitions in doc added. Fragments that are no longer used are
+// removed, since Oryx rejects queries with unused fragments.
+func mergeLayoutQuery(doc string) (string, error) {
+	defs, err := parseGraphqlDefinitions(layoutQuery)
+	if err != nil {
+		return "", fmt.Errorf("built-in query: %w", err)
+	}
+	custom, err := parseGraphqlDefinitions(doc)
+	if err != nil {
+		return "", err
+	}
+	if len(custom) == 0 {
+		return "", errors.New("no query or fragment definitions")
+	}
+	for _, c := range custom {
+		if c.kind == "query" && c.name != "getLayout" {
+			return "", fmt.Errorf("query %s is not getLayout", c.name)
+		}
+		i := slices.IndexFunc(defs, func(d graphqlDefinition) bool {
+			return d.kind == c.kind && d.name == c.name
+		})
+		if i < 0 {
+			defs = append(defs, c)
+		} else {
+			defs[i] = c
+		}
+	}
+
+	fragments := make(map[string]graphqlDefinition)
+	for _, d := range defs {
+		if d.kind == "fragment" {
+			fragments[d.name] = d
+		}
+	}
+	used := make(map[string]bool)
+	var walk func(text string) error
+	walk = func(text string) error {
+		for _, m := range fragmentSpread.FindAllStringSubmatch(text, -1) {
+			name := m[1]
+			if name == "on" || used[name] {
+				continue
+			}
+			f, ok := fragments[name]
+			if !ok {
+				return fmt.Errorf("fragment %s is not defined", name)
+			}
+			used[name] = true
+			err := walk(f.text)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	var b strings.Builder
+	for _, d := range defs {
+		if d.kind == "query" {
+			err = walk(d.text)
+			if err != nil {
+				return "", err
+			}
+			b.WriteString(d.text)
+		}
+	}
+	for _, d := range defs {
+		if d.kind == "fragment" && used[d.name] {
+			b.WriteString("\n")
+			b.WriteString(d.text)
+		}
+	}
+	return b.String(), nil
+}
+
+// loadLayoutQuery returns the getLayout query modified by the GraphQL
+// definitions in the file at path.
+func loadLayoutQuery(path string) (string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	q, err := mergeLayoutQuery(string(b))
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", path, err)
+	}
+	return q, nil
+}
@@ -0,0 +1,212 @@
+// Copyright ©2025 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+	"unicode/utf8"
+)
+
+// exchange is a recorded HTTP request and its response.
+type exchange struct {
+	Time    time.Time `json:"time"`
+	Request struct {
+		Method string      `json:"method"`
+		URL    string      `json:"url"`
+		Header http.Header `json:"header,omitempty"`
+		body
+	} `json:"request"`
+	Response struct {
+		Status int         `json:"status"`
+		Header http.Header `json:"header,omitempty"`
+		body
+	} `json:"response"`
+}
+
+// body is an HTTP message body. Bodies that are valid UTF-8 are held
+// as text so that recordings can be read directly.
+type body struct {
+	Text   string `json:"body,omitempty"`
+	Base64 []byte `json:"bodyBase64,omitempty"`
+}
+
+func makeBody(b []byte) body {
+	if utf8.Valid(b) {
+		return body{Text: string(b)}
+	}
+	return body{Base64: b}
+}
+
+func (b body) bytes() []byte {
+	if b.Base64 != nil {
+		return b.Base64
+	}
+	return []byte(b.Text)
+}
+
+// exchangeName returns the file name for the exchange of a request
+// with the given method, URL and body. Requests that are the same in
+// these respects share a recording.
+func exchangeName(method, url string, body []byte) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s %s\n", method, url)
+	h.Write(body)
+	return fmt.Sprintf("%x.json", h.Sum(nil)[:12])
+}
+
+// readRequestBody returns the body of req, leaving req able to be
+// sent.
+func readRequestBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil {
+		return nil, nil
+	}
+	b, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	req.Body = io.NopCloser(bytes.NewReader(b))
+	return b, nil
+}
+
+// recorder is an http.RoundTripper that writes each exchange made
+// through it to a file in dir. If key is set, each recording is
+// signed.
+type recorder struct {
+	next http.RoundTripper
+	dir  string
+	key  *signingKey
+	max  int64 // maximum response body size, if positive
+}
+
+func (r recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	reqBody, err := readRequestBody(req)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := r.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	spooled, err := spoolBody(resp, r.max)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	respBody, err := spooled.bytes()
+	spooled.close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(respBody))
+
+	var e exchange
+	e.Time = time.Now().UTC()
+	e.Request.Method = req.Method
+	e.Request.URL = req.URL.String()
+	e.Request.Header = redactHeader(req.Header)
+	e.Request.body = makeBody(reqBody)
+	e.Response.Status = resp.StatusCode
+	e.Response.Header = redactHeader(resp.Header)
+	e.Response.body = makeBody(respBody)
+	b, err := json.MarshalIndent(e, "", "\t")
+	if err != nil {
+		return nil, err
+	}
+	path := filepath.Join(r.dir, exchangeName(req.Method, e.Request.URL, reqBody))
+	b = append(b, '\n')
+	err = os.WriteFile(path, b, 0o600)
+	if err == nil && r.key != nil {
+		err = writeSignature(path, *r.key, bytes.NewReader(b))
+	}
+	if err != nil {
+		return nil, permanentError{fmt.Errorf("failed to record exchange: %w", err)}
+	}
+	slog.Debug("recorded exchange", "method", req.Method, "url", e.Request.URL, "path", path)
+	return resp, nil
+}
+
+// redactHeader returns a copy of h with credentials replaced so that
+// recordings can be shared for review.
+func redactHeader(h http.Header) http.Header {
+	h = h.Clone()
+	for _, k := range []string{"Authorization", "Cookie", "Set-Cookie"} {
+		if _, ok := h[k]; ok {
+			h[k] = []string{"REDACTED"}
+		}
+	}
+	return h
+}
+
+// errNotRecorded is returned when a replayed request has no recorded
+// exchange.
+var errNotRecorded = errors.New("no recorded exchange")
+
+// errExchangeMismatch is returned when the recorded exchange found
+// for a replayed request was recorded for a different request.
+var errExchangeMismatch = errors.New("recorded exchange does not match request")
+
+// replayer is an http.RoundTripper that responds to requests with the
+// exchanges recorded in dir by a recorder. It makes no network
+// requests. If key is set, recordings must have a valid signature made
+// with its secret key.
+type replayer struct {
+	dir string
+	key *verifyKey
+}
+
+func (r replayer) RoundTrip(req *http.Request) (*http.Response, error) {
+	reqBody, err := readRequestBody(req)
+	if err != nil {
+		return nil, err
+	}
+	url := req.URL.String()
+	path := filepath.Join(r.dir, exchangeName(req.Method, url, reqBody))
+	b, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, permanentError{fmt.Errorf("%w for %s %s in %s", errNotRecorded, req.Method, url, r.dir)}
+	}
+	if err == nil && r.key != nil {
+		err = verifySignature(path, *r.key, bytes.NewReader(b))
+	}
+	if err != nil {
+		return nil, permanentError{err}
+	}
+	var e exchange
+	err = json.Unmarshal(b, &e)
+	if err != nil {
+		return nil, permanentError{fmt.Errorf("failed to parse recorded exchange %s: %w", path, err)}
+	}
+	// The signature covers only the recording, so check that it is
+	// the recording of this request and has not been copied to the
+	// name of another request's recording.
+	if e.Request.Method != req.Method || e.Request.URL != url || !bytes.Equal(e.Request.bytes(), reqBody) {
+		return nil, permanentError{fmt.Errorf("%w: %s holds %s %s for %s %s", errExchangeMismatch, path, e.Request.Method, e.Request.URL, req.Method, url)}
+	}
+	slog.Debug("replaying exchange", "method", req.Method, "url", url, "path", path)
+	respBody := e.Response.bytes()
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", e.Response.Status, http.StatusText(e.Response.Status)),
+		StatusCode:    e.Response.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        e.Response.Header,
+		Body:          io.NopCloser(bytes.NewReader(respBody)),
+		ContentLength: int64(len(respBody)),
+		Request:       req,
+	}, nil
+}
@@ -0,0 +1,281 @@
+// Copyright ©2025 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// remapDialect describes the configuration syntax of a software key
+// remapper.
+type remapDialect struct {
+	name   string
+	header string // configuration preceding defsrc
+
+	tapHold   func(ms int, tap, hold string) string
+	whileHeld func(layer string) string
+	switchTo  func(layer string) string
+	toggle    func(layer string) (string, bool)
+	oneShot   func(layer string) string
+}
+
+var (
+	kanataDialect = remapDialect{
+		name:   "kanata",
+		header: "(defcfg\n  process-unmapped-keys yes\n)\n",
+		tapHold: func(ms int, tap, hold string) string {
+			return fmt.Sprintf("(tap-hold %d %d %s %s)", ms, ms, tap, hold)
+		},
+		whileHeld: func(layer string) string { return "(layer-while-held " + layer + ")" },
+		switchTo:  func(layer string) string { return "(layer-switch " + layer + ")" },
+		toggle:    func(layer string) (string, bool) { return "(layer-toggle " + layer + ")", true },
+		oneShot:   func(layer string) string { return "(one-shot 500 (layer-while-held " + layer + "))" },
+	}
+	kmonadDialect = remapDialect{
+		name:   "kmonad",
+		header: "(defcfg\n  input  (device-file \"/dev/input/by-id/CHANGE-ME-event-kbd\")\n  output (uinput-sink \"fkm\")\n  fallthrough true\n)\n",
+		tapHold: func(ms int, tap, hold string) string {
+			return fmt.Sprintf("(tap-hold %d %s %s)", ms, tap, hold)
+		},
+		whileHeld: func(layer string) string { return "(layer-toggle " + layer + ")" },
+		switchTo:  func(layer string) string { return "(layer-switch " + layer + ")" },
+		toggle:    func(layer string) (string, bool) { return "", false },
+		oneShot:   func(layer string) string { return "(sticky-key 500 (layer-toggle " + layer + "))" },
+	}
+)
+
+// remapKeys maps QMK key names without the KC_ prefix to the key names
+// shared by kanata and KMonad. Letters and digits map to themselves in
+// lower case.
+var remapKeys = map[string]string{
+	"SPACE": "spc", "SPC": "spc",
+	"ENTER": "ret", "ENT": "ret",
+	"BSPC": "bspc", "BACKSPACE": "bspc",
+	"DELETE": "del", "DEL": "del",
+	"TAB": "tab", "ESCAPE": "esc", "ESC": "esc",
+	"SCLN": ";", "SEMICOLON": ";",
+	"QUOTE": "'", "QUOT": "'",
+	"COMMA": ",", "COMM": ",",
+	"DOT": ".", "SLASH": "/", "SLSH": "/",
+	"BSLS": "\\", "BACKSLASH": "\\",
+	"MINUS": "-", "MINS": "-",
+	"EQUAL": "=", "EQL": "=",
+	"LBRC": "[", "LEFT_BRACKET": "[",
+	"RBRC": "]", "RIGHT_BRACKET": "]",
+	"GRAVE": "grv", "GRV": "grv",
+	"LEFT": "left", "RIGHT": "rght", "UP": "up", "DOWN": "down",
+	"HOME": "home", "END": "end", "PGUP": "pgup", "PAGE_UP": "pgup", "PGDN": "pgdn", "PAGE_DOWN": "pgdn",
+	"CAPS": "caps", "CAPS_LOCK": "caps",
+	"LCTL": "lctl", "LEFT_CTRL": "lctl", "RCTL": "rctl", "RIGHT_CTRL": "rctl",
+	"LSFT": "lsft", "LEFT_SHIFT": "lsft", "RSFT": "rsft", "RIGHT_SHIFT": "rsft",
+	"LALT": "lalt", "LEFT_ALT": "lalt", "RALT": "ralt", "RIGHT_ALT": "ralt",
+	"LGUI": "lmet", "LEFT_GUI": "lmet", "RGUI": "rmet", "RIGHT_GUI": "rmet",
+	"INSERT": "ins", "INS": "ins", "PSCR": "prnt", "PRINT_SCREEN": "prnt",
+	"APPLICATION": "comp", "APP": "comp",
+	"AUDIO_VOL_UP": "volu", "KB_VOLUME_UP": "volu", "AUDIO_VOL_DOWN": "voldwn", "KB_VOLUME_DOWN": "voldwn",
+	"AUDIO_MUTE": "mute", "KB_MUTE": "mute",
+}
+
+// remapShifted maps QMK shifted symbol names to their unshifted keys.
+var remapShifted = map[string]string{
+	"EXLM": "1", "AT": "2", "HASH": "3", "DLR": "4", "PERC": "5",
+	"CIRC": "6", "AMPR": "7", "ASTR": "8", "LPRN": "9", "RPRN": "0",
+	"UNDS": "-", "PLUS": "=", "LCBR": "[", "RCBR": "]", "PIPE": "\\",
+	"COLN": ";", "DQUO": "'", "DQT": "'", "TILD": "grv", "LABK": ",", "RABK": ".", "QUES": "/",
+}
+
+// remapModifierPrefixes maps QMK modifier names to remapper chord
+// prefixes.
+var remapModifierPrefixes = map[string]string{
+	"LCTL": "C-", "RCTL": "RC-",
+	"LSFT": "S-", "RSFT": "RS-",
+	"LALT": "A-", "RALT": "RA-",
+	"LGUI": "M-", "RGUI": "RM-",
+}
+
+var letterOrDigit = regexp.MustCompile(`^[A-Z0-9]$|^F[0-9]{1,2}$`)
+
+// remapKeyName returns the remapper name for a plain QMK key code.
+func remapKeyName(code string) (string, bool) {
+	c := strings.TrimPrefix(code, "KC_")
+	if letterOrDigit.MatchString(c) {
+		return strings.ToLower(c), true
+	}
+	if k, ok := remapKeys[c]; ok {
+		return k, true
+	}
+	if k, ok := remapShifted[c]; ok {
+		return "S-" + k, true
+	}
+	return "", false
+}
+
+// remapper converts a layout to a remapper configuration.
+type remapper struct {
+	dialect remapDialect
+	layout  *layout
+	names   []string // layer names
+}
+
+// remapLayerNames returns unique remapper layer names for the layers.
+func remapLayerNames(layers []layer) []string {
+	names := make([]string, len(layers))
+	used := make(map[string]bool)
+	for i, ly := range layers {
+		name := strings.Map(func(r rune) rune {
+			switch {
+			case 'a' <= r && r <= 'z', '0' <= r && r <= '9', r == '-':
+				return r
+			case 'A' <= r && r <= 'Z':
+				return r + 'a' - 'A'
+			}
+			return '-'
+		}, strings.TrimSpace(ly.Title))
+		name = strings.Trim(name, "-")
+		if name == "" || used[name] {
+			name = "layer" + strconv.Itoa(i)
+		}
+		used[name] = true
+		names[i] = name
+	}
+	return names
+}
+
+// action returns the remapper form of a single key action.
+func (r *remapper) action(a *keyAction) (string, error) {
+	if a == nil || a.Code == "" || a.Code == "KC_NO" {
+		return "XX", nil
+	}
+	if a.Code == "KC_TRANSPARENT" || a.Code == "KC_TRNS" {
+		return "_", nil
+	}
+	if a.Layer != nil {
+		if *a.Layer < 0 || *a.Layer >= len(r.names) {
+			return "", fmt.Errorf("layer %d does not exist", *a.Layer)
+		}
+		name := r.names[*a.Layer]
+		switch strings.TrimPrefix(a.Code, "KC_") {
+		case "MO", "TT":
+			return r.dialect.whileHeld(name), nil
+		case "TO", "DF":
+			return r.dialect.switchTo(name), nil
+		case "TG":
+			if s, ok := r.dialect.toggle(name); ok {
+				return s, nil
+			}
+			return "", fmt.Errorf("%s has no layer toggle", r.dialect.name)
+		case "OSL":
+			return r.dialect.oneShot(name), nil
+		}
+		return "", fmt.Errorf("unsupported layer action %s", a.Code)
+	}
+	k, ok := remapKeyName(a.Code)
+	if !ok {
+		return "", fmt.Errorf("unsupported key %s", a.Code)
+	}
+	mods := a.Modifiers
+	if a.Modifier != "" {
+		mods = append(modifiers{a.Modifier}, mods...)
+	}
+	for i := len(mods) - 1; i >= 0; i-- {
+		m, ok := qmkModifiers[mods[i]]
+		if !ok {
+			return "", fmt.Errorf("unsupported modifier %s", mods[i])
+		}
+		k = remapModifierPrefixes[m] + k
+	}
+	return k, nil
+}
+
+// key returns the remapper form of a key, with hold actions expressed
+// as tap-hold.
+func (r *remapper) key(k key) (string, error) {
+	tap, err := r.action(k.Tap)
+	if err != nil {
+		return "", err
+	}
+	if k.DoubleTap != nil && k.DoubleTap.Code != "" || k.TapHold != nil && k.TapHold.Code != "" {
+		slog.Warn("tap dance actions are not exported", "format", r.dialect.name, "key", describeKey(k, nil))
+	}
+	if k.Hold == nil || k.Hold.Code == "" {
+		return tap, nil
+	}
+	hold, err := r.action(k.Hold)
+	if err != nil {
+		return "", err
+	}
+	return r.dialect.tapHold(r.layout.Revision.TappingTerm(), tap, hold), nil
+}
+
+// write writes the remapper configuration for the layout to w.
+//
+// The source keys are the plain tap keys of the base layer. Remappers
+// intercept the host's keyboard, so the base layer describes the keys
+// that the keyboard sends; only columns with a unique plain base key
+// can be remapped and other columns are omitted with a warning.
+func (r *remapper) write(w io.Writer) error {
+	layers := r.layout.Revision.Layers
+	if len(layers) == 0 {
+		return fmt.Errorf("revision has no layers")
+	}
+	var (
+		src  []string
+		cols []int
+		seen = make(map[string]bool)
+	)
+	for i, k := range layers[0].Keys {
+		if k.Tap == nil || k.Tap.Layer != nil || len(k.Tap.Modifiers) != 0 || k.Tap.Modifier != "" {
+			slog.Warn("base layer key has no plain source key, omitting", "format", r.dialect.name, "key", i)
+			continue
+		}
+		name, ok := remapKeyName(k.Tap.Code)
+		if !ok || strings.Contains(name, "-") && len(name) > 1 || seen[name] {
+			slog.Warn("base layer key has no unique source key, omitting", "format", r.dialect.name, "key", i, "code", k.Tap.Code)
+			continue
+		}
+		seen[name] = true
+		src = append(src, name)
+		cols = append(cols, i)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, ";; %s revision %s exported by fkm.\n\n%s\n", r.layout.Title, r.layout.Revision.HashID, r.dialect.header)
+	fmt.Fprintf(&b, "(defsrc\n  %s\n)\n", strings.Join(src, " "))
+	for i, ly := range layers {
+		keys := make([]string, len(cols))
+		for j, c := range cols {
+			if c >= len(ly.Keys) {
+				keys[j] = "_"
+				continue
+			}
+			s, err := r.key(ly.Keys[c])
+			if err != nil {
+				slog.Warn("key cannot be exported", "format", r.dialect.name, "layer", i, "key", c, "error", err)
+				s = "XX"
+			}
+			keys[j] = s
+		}
+		fmt.Fprintf(&b, "\n;; %s\n(deflayer %s\n  %s\n)\n", ly.Name(), r.names[i], strings.Join(keys, " "))
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// writeKanata writes a kanata configuration for the layout.
+func writeKanata(w io.Writer, l *layout) error {
+	r := remapper{dialect: kanataDialect, layout: l, names: remapLayerNames(l.Revision.Layers)}
+	return r.write(w)
+}
+
+// writeKMonad writes a KMonad configuration for the layout.
+func writeKMonad(w io.Writer, l *layout) error {
+	r := remapper{dialect: kmonadDialect, layout: l, names: remapLayerNames(l.Revision.Layers)}
+	return r.write(w)
+}
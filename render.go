@@ -0,0 +1,200 @@
+// Copyright ©2025 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// render writes images of the layers of a stored revision.
+func render(args []string) {
+	fs := flag.NewFlagSet("fkm render", flag.ExitOnError)
+	var (
+		common commonFlags
+		legend legendFlags
+	)
+	common.register(fs)
+	legend.register(fs)
+	revID := fs.String("revision", "", "ID of the stored revision to render (required)")
+	format := fs.String("format", "svg", "image format (svg)")
+	outDir := fs.String("out", ".", "directory to write images to")
+	overlay := fs.Bool("heatmap", false, "shade keys by their press counts in the revision's stored heatmap data")
+	fs.Parse(args)
+	common.setup(fs)
+	if *revID == "" || fs.NArg() != 0 {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+	if *format != "svg" {
+		fmt.Fprintf(fs.Output(), "unknown format %q\n", *format)
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+	legends := legend.legends()
+
+	db := openExisting(common.dbPath, true, common.db)
+	defer db.Close()
+	l, err := loadLayout(db, *revID)
+	if err != nil {
+		fatal("failed to read revision", err, "revision", *revID)
+	}
+	meta, err := loadMetadata(db)
+	if err != nil {
+		fatal("failed to read metadata", err)
+	}
+	var h heatmap
+	if *overlay {
+		h, err = loadHeatmap(db, *revID)
+		if err != nil {
+			fatal("failed to read heatmap", err, "revision", *revID)
+		}
+		if h == nil {
+			fatal("failed to read heatmap", errors.New("no heatmap data stored"), "revision", *revID)
+		}
+	}
+
+	err = os.MkdirAll(*outDir, 0o750)
+	if err != nil {
+		fatal("failed to create output directory", err, "path", *outDir)
+	}
+	for i, ly := range l.Revision.Layers {
+		var buf bytes.Buffer
+		renderSVG(&buf, l, i, keyPlacements(meta, l.Geometry, len(ly.Keys)), svgOptions{heat: h.layer(i), legends: legends})
+		path := filepath.Join(*outDir, fmt.Sprintf("%s-%d.svg", l.Revision.HashID, ly.Position))
+		err = os.WriteFile(path, buf.Bytes(), 0o644)
+		if err != nil {
+			fatal("failed to write image", err, "path", path)
+		}
+		fmt.Println(path)
+	}
+}
+
+const (
+	// svgKeySize is the size of a one unit key in SVG user units,
+	// and svgMargin is the space around the keyboard.
+	svgKeySize = 60
+	svgMargin  = 20
+	svgTitle   = 30
+
+	// svgHeatColor is the colour keys are shaded with in heatmap
+	// overlays, with svgHeatOpacity the opacity of the shading of
+	// the most pressed key.
+	svgHeatColor   = "#d7301f"
+	svgHeatOpacity = 0.8
+)
+
+// svgOptions are the options for drawing a layer with renderSVG.
+type svgOptions struct {
+	// heat holds the press counts of the layer's keys. If it is
+	// not nil, each key is shaded in proportion to its count
+	// relative to the most pressed key of the layer, and labelled
+	// with its count.
+	heat []int
+
+	// legends renders the keys' actions. If it is nil, actions
+	// are shown as their key codes.
+	legends *keyLegends
+}
+
+// renderSVG writes an SVG image of layer i of the layout to w. Keys are
+// drawn at the given placements, with their tap legend and any hold
+// action, and the layer's combos are drawn as lines joining their keys.
+func renderSVG(w io.Writer, l *layout, i int, placements []keyPlacement, opts svgOptions) {
+	ly := l.Revision.Layers[i]
+	if len(placements) < len(ly.Keys) {
+		slog.Warn("geometry has fewer keys than layer, using a grid", "keys", len(ly.Keys), "placements", len(placements))
+		placements = keyPlacements(nil, "", len(ly.Keys))
+	}
+	placements = placements[:len(ly.Keys)]
+	var width, height float64
+	for _, p := range placements {
+		width = max(width, (p.X+p.W)*svgKeySize)
+		height = max(height, (p.Y+p.H)*svgKeySize)
+	}
+	width += 2 * svgMargin
+	height += 2*svgMargin + svgTitle
+	color := ly.Color
+	if color == "" {
+		color = "#888888"
+	}
+	heat := opts.heat
+	var hottest int
+	for _, n := range heat {
+		hottest = max(hottest, n)
+	}
+
+	fmt.Fprintf(w, `<svg xmlns="http://www.w3.org/2000/svg" width="%g" height="%g" viewBox="0 0 %[1]g %[2]g" font-family="sans-serif">`+"\n", width, height)
+	fmt.Fprintf(w, `<rect width="100%%" height="100%%" fill="white"/>`+"\n")
+	fmt.Fprintf(w, `<text x="%d" y="%d" font-size="18" fill="%s">%s</text>`+"\n",
+		svgMargin, svgMargin+svgTitle/2, escapeXML(color), escapeXML(fmt.Sprintf("%s — %d: %s", l.Title, ly.Position, ly.Name())))
+	for j, k := range ly.Keys {
+		p := placements[j]
+		x := svgMargin + p.X*svgKeySize
+		y := svgMargin + svgTitle + p.Y*svgKeySize
+		fill := "white"
+		if k.GlowColor != "" {
+			fill = k.GlowColor
+		}
+		fmt.Fprintf(w, `<rect x="%g" y="%g" width="%g" height="%g" rx="6" fill="%s" stroke="%s" stroke-width="2"/>`+"\n",
+			x+2, y+2, p.W*svgKeySize-4, p.H*svgKeySize-4, escapeXML(fill), escapeXML(color))
+		if j < len(heat) && heat[j] > 0 {
+			fmt.Fprintf(w, `<rect x="%g" y="%g" width="%g" height="%g" rx="6" fill="%s" fill-opacity="%.3f"><title>presses: %d</title></rect>`+"\n",
+				x+2, y+2, p.W*svgKeySize-4, p.H*svgKeySize-4, svgHeatColor, svgHeatOpacity*float64(heat[j])/float64(hottest), heat[j])
+			fmt.Fprintf(w, `<text x="%g" y="%g" font-size="8" text-anchor="end" fill="#333333">%d</text>`+"\n", x+p.W*svgKeySize-6, y+12, heat[j])
+		}
+		cx := x + p.W*svgKeySize/2
+		if tap := opts.legends.key(k); tap != "" {
+			fmt.Fprintf(w, `<text x="%g" y="%g" font-size="12" text-anchor="middle">%s</text>`+"\n", cx, y+p.H*svgKeySize/2, escapeXML(tap))
+		}
+		if hold := opts.legends.action(k.Hold); hold != "" {
+			fmt.Fprintf(w, `<text x="%g" y="%g" font-size="9" text-anchor="middle" fill="#555555">%s</text>`+"\n", cx, y+p.H*svgKeySize-10, escapeXML(hold))
+		}
+	}
+	for _, c := range l.Revision.Combos {
+		if c.LayerIdx != i {
+			continue
+		}
+		var (
+			sx, sy float64
+			n      int
+			points []string
+		)
+		for _, k := range c.KeyIndices {
+			if k < 0 || k >= len(placements) {
+				continue
+			}
+			p := placements[k]
+			px := svgMargin + (p.X+p.W/2)*svgKeySize
+			py := svgMargin + svgTitle + (p.Y+p.H/2)*svgKeySize
+			points = append(points, fmt.Sprintf("%g,%g", px, py))
+			sx += px
+			sy += py
+			n++
+		}
+		if n == 0 {
+			continue
+		}
+		fmt.Fprintf(w, `<polyline points="%s" fill="none" stroke="#cc3333" stroke-width="3" stroke-opacity="0.6"/>`+"\n", strings.Join(points, " "))
+		fmt.Fprintf(w, `<text x="%g" y="%g" font-size="10" text-anchor="middle" fill="#cc3333">%s</text>`+"\n",
+			sx/float64(n), sy/float64(n)+svgKeySize/4, escapeXML(opts.legends.trigger(c.Trigger)))
+	}
+	fmt.Fprintln(w, "</svg>")
+}
+
+// escapeXML returns s escaped for use in XML text and attributes.
+func escapeXML(s string) string {
+	var buf strings.Builder
+	xml.EscapeText(&buf, []byte(s))
+	return buf.String()
+}
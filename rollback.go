@@ -0,0 +1,196 @@
+// Copyright ©2025 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+)
+
+// rollback makes an older stored revision of a layout its current
+// revision.
+func rollback(args []string) {
+	fs := flag.NewFlagSet("fkm rollback", flag.ExitOnError)
+	var (
+		common commonFlags
+		guard  writeGuard
+	)
+	common.register(fs)
+	guard.register(fs)
+	hashID := fs.String("layout", "", "hash ID of the layout to roll back")
+	to := fs.String("to", "", "stored revision ID to roll back to (default the revision before the current revision)")
+	del := fs.Bool("delete", false, "remove the rolled back revision from the database")
+	dryRun := fs.Bool("dry-run", false, "print the changes that would be made to the database without making them")
+	backupDir := fs.String("backup-dir", "", "directory to back up the database to before changing it")
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), `usage: fkm rollback -layout <hashId> [flags]
+
+Make an older stored revision the current revision of a layout without
+network access. The layout's tracked revision and any smart layers using
+the current revision are moved to the older revision. The keyboard must
+be flashed with the older revision's firmware for keymapp to show it.
+fkm watch leaves the rollback in place while the newer revision is
+stored; pin the layout with fkm pin to prevent watch storing newer
+revisions, or the newer revision again if it is deleted.
+
+Flags:
+`)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	common.setup(fs)
+	if fs.NArg() != 0 || *hashID == "" {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+
+	db := openExisting(common.dbPath, *dryRun, common.db)
+	defer db.Close()
+
+	changes, err := rollbackChanges(db, *hashID, *to, *del)
+	if err != nil {
+		fatal("failed to roll back layout", err, "layout", *hashID)
+	}
+	if *dryRun {
+		printChanges(common.dbPath, changes)
+		return
+	}
+	err = guard.check(context.Background(), db)
+	if err != nil {
+		fatal("refusing to update db", err, "path", common.dbPath)
+	}
+	if *backupDir != "" {
+		err = autoBackup(db, *backupDir)
+		if err != nil {
+			fatal("failed to back up db", err, "path", common.dbPath)
+		}
+	}
+	err = apply(db, changes)
+	if err != nil {
+		fatal("failed to update db", err, "path", common.dbPath)
+	}
+}
+
+// rollbackChanges returns the changes making the stored revision to,
+// or the stored revision before the current revision if to is empty,
+// the current revision of the layout. The current revision is the
+// tracked revision of the layout, or the newest stored revision if the
+// layout is not tracked. If del is true, the current revision is
+// removed.
+func rollbackChanges(db *sql.DB, hashID, to string, del bool) ([]change, error) {
+	all, err := storedRevisions(db)
+	if err != nil {
+		return nil, err
+	}
+	var revs []storedRevision
+	for _, r := range all {
+		if r.layout != nil && r.layout.HashID == hashID {
+			revs = append(revs, r)
+		}
+	}
+	if len(revs) == 0 {
+		return nil, fmt.Errorf("no stored revisions of layout %s", hashID)
+	}
+
+	cur := 0
+	tracked, err := trackedRevision(db, hashID)
+	if err != nil {
+		return nil, err
+	}
+	if tracked != "" {
+		cur = -1
+		for i, r := range revs {
+			if r.id == tracked {
+				cur = i
+				break
+			}
+		}
+		if cur < 0 {
+			return nil, fmt.Errorf("tracked revision %s is not stored", tracked)
+		}
+	}
+
+	target := -1
+	if to == "" {
+		if cur+1 < len(revs) {
+			target = cur + 1
+		}
+	} else {
+		for i, r := range revs {
+			if r.id == to {
+				target = i
+				break
+			}
+		}
+	}
+	switch {
+	case target < 0 && to == "":
+		return nil, fmt.Errorf("no stored revision older than %s", revs[cur].id)
+	case target < 0:
+		return nil, fmt.Errorf("%w: %s in layout %s", errRevisionNotFound, to, hashID)
+	case target == cur:
+		return nil, fmt.Errorf("%s is already the current revision", to)
+	}
+	from, into := revs[cur], revs[target]
+	slog.Info("rolling back layout", "layout", hashID, "from", from.id, "to", into.id)
+
+	changes := []change{trackLayout(into.layout)}
+	n, err := rowCount(db, "smart_layer", "revisionId=?", from.id)
+	if err != nil {
+		return nil, err
+	}
+	if n != 0 && into.layout.Geometry != from.layout.Geometry {
+		return nil, fmt.Errorf("smart layers use %s revision %s, but %s is a %s revision", from.layout.Geometry, from.id, into.id, into.layout.Geometry)
+	}
+	if n != 0 {
+		changes = append(changes, change{
+			table: "smart_layer",
+			op:    "update",
+			desc:  fmt.Sprintf("revisionId=%s to revisionId=%s (%d rows)", from.id, into.id, n),
+			query: `UPDATE smart_layer SET revisionId=? WHERE revisionId=?`,
+			args:  []any{into.id, from.id},
+		})
+	}
+	if del {
+		// The tracked revision and smart layers are moved to the
+		// older revision, but pins and heatmaps are not.
+		pinned, err := pinnedRevisions(db)
+		if err != nil {
+			return nil, err
+		}
+		if pinned[hashID] == from.id {
+			return nil, fmt.Errorf("cannot delete %s: it is pinned", from.id)
+		}
+		n, err := rowCount(db, "heatmap", "revisionId=?", from.id)
+		if err != nil {
+			return nil, err
+		}
+		if n != 0 {
+			return nil, fmt.Errorf("cannot delete %s: it is used by heatmap data", from.id)
+		}
+		changes = append(changes, deleteRevision(from.id, from.layout)...)
+	}
+	return changes, nil
+}
+
+// trackedRevision returns the tracked revision of the layout, or the
+// empty string if the layout is not tracked.
+func trackedRevision(db *sql.DB, hashID string) (string, error) {
+	ok, err := hasTable(db, "fkm_tracked")
+	if err != nil || !ok {
+		return "", err
+	}
+	var id string
+	err = db.QueryRow(`SELECT revisionId FROM fkm_tracked WHERE hashId=?`, hashID).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return id, err
+}
@@ -0,0 +1,83 @@
+// Copyright ©2025 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestRollbackChanges(t *testing.T) {
+	tests := []struct {
+		name    string
+		to      string
+		del     bool
+		pin     bool // pin the current revision
+		heatmap bool // add heatmap data for the current revision
+		want    string
+		deleted []string
+		wantErr error
+	}{
+		{name: "previous", want: "r2"},
+		{name: "named", to: "r1", want: "r1"},
+		{name: "delete", del: true, want: "r2", deleted: []string{"r3"}},
+		{name: "unknown", to: "r9", wantErr: errRevisionNotFound},
+		{name: "current", to: "r3", wantErr: errAny},
+		{name: "delete pinned", del: true, pin: true, wantErr: errAny},
+		{name: "delete heatmap", del: true, heatmap: true, wantErr: errAny},
+		{name: "pinned without delete", pin: true, want: "r2"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			db := newTestDB(t)
+			storeTestRevision(t, db, "abc", "r1", "2025-01-01T00:00:00Z")
+			storeTestRevision(t, db, "abc", "r2", "2025-02-01T00:00:00Z")
+			cur := storeTestRevision(t, db, "abc", "r3", "2025-03-01T00:00:00Z")
+			if test.pin {
+				err := apply(db, []change{pinLayout(cur)})
+				if err != nil {
+					t.Fatalf("failed to pin: %v", err)
+				}
+			}
+			if test.heatmap {
+				_, err := db.Exec(`INSERT INTO heatmap (revisionId) VALUES ('r3')`)
+				if err != nil {
+					t.Fatalf("failed to add heatmap: %v", err)
+				}
+			}
+
+			changes, err := rollbackChanges(db, "abc", test.to, test.del)
+			switch {
+			case test.wantErr == errAny && err != nil:
+				return
+			case test.wantErr != nil:
+				if !errors.Is(err, test.wantErr) {
+					t.Fatalf("unexpected error: got %v want %v", err, test.wantErr)
+				}
+				return
+			case err != nil:
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := deletedRevisions(changes); !slices.Equal(got, test.deleted) {
+				t.Errorf("unexpected deleted revisions: got %v want %v", got, test.deleted)
+			}
+			err = apply(db, changes)
+			if err != nil {
+				t.Fatalf("failed to apply rollback: %v", err)
+			}
+			got, err := trackedRevision(db, "abc")
+			if err != nil {
+				t.Fatalf("failed to read tracked revision: %v", err)
+			}
+			if got != test.want {
+				t.Errorf("unexpected tracked revision: got %s want %s", got, test.want)
+			}
+		})
+	}
+}
+
+// errAny is a sentinel for tests expecting an error of any kind.
+var errAny = errors.New("any error")
@@ -0,0 +1,312 @@
+// Copyright ©2025 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"strings"
+)
+
+// fkmMigrations are the migrations for tables owned by fkm. The
+// schema version of a database is the number of migrations that have
+// been applied to it. Migrations must only be appended.
+var fkmMigrations = []string{
+	1: `
+CREATE TABLE IF NOT EXISTS "fkm_checksum" (
+            revisionId TEXT NOT NULL UNIQUE,
+            md5 TEXT,
+            sha256 TEXT NOT NULL
+        );
+`,
+	2: `
+CREATE TABLE IF NOT EXISTS "fkm_tracked" (
+            hashId TEXT NOT NULL UNIQUE,
+            geometry TEXT NOT NULL,
+            revisionId TEXT NOT NULL,
+            updated TEXT NOT NULL
+        );
+`,
+	3: `
+CREATE TABLE IF NOT EXISTS "fkm_tour" (
+            revisionId TEXT NOT NULL UNIQUE,
+            data BLOB NOT NULL
+        );
+`,
+	4: `
+CREATE TABLE IF NOT EXISTS "fkm_tag" (
+            hashId TEXT NOT NULL,
+            tag TEXT NOT NULL,
+            UNIQUE(hashId, tag)
+        );
+WITH r AS MATERIALIZED (
+        SELECT CAST(data AS TEXT) AS data FROM revision WHERE json_valid(CAST(data AS TEXT))
+)
+INSERT OR IGNORE INTO fkm_tag (hashId, tag)
+        SELECT json_extract(r.data, '$.layout.hashId'), json_extract(t.value, '$.name')
+        FROM r, json_each(r.data, '$.layout.tags') t
+        WHERE json_extract(r.data, '$.layout.hashId') IS NOT NULL AND json_extract(t.value, '$.name') IS NOT NULL;
+`,
+	5: `
+CREATE TABLE IF NOT EXISTS "fkm_pinned" (
+            hashId TEXT NOT NULL UNIQUE,
+            revisionId TEXT NOT NULL,
+            pinned TEXT NOT NULL
+        );
+`,
+	6: `
+CREATE TABLE IF NOT EXISTS "fkm_provenance" (
+            revisionId TEXT NOT NULL,
+            source TEXT NOT NULL,
+            fetched TEXT NOT NULL,
+            command TEXT NOT NULL,
+            fkm TEXT NOT NULL,
+            note TEXT
+        );
+CREATE INDEX IF NOT EXISTS "fkm_provenance_revision" ON "fkm_provenance" (revisionId);
+`,
+}
+
+// fkmSchemaVersion is the current version of the fkm-owned schema.
+var fkmSchemaVersion = len(fkmMigrations) - 1
+
+// column is a table column as described by PRAGMA table_info.
+type column struct {
+	name    string
+	typ     string
+	notNull bool
+	dflt    sql.NullString
+	pk      bool
+}
+
+// tableColumns returns the columns of the named table in db.
+func tableColumns(db interface {
+	Query(string, ...any) (*sql.Rows, error)
+}, table string) (map[string]column, error) {
+	rows, err := db.Query(fmt.Sprintf(`PRAGMA table_info(%q)`, table))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	cols := make(map[string]column)
+	for rows.Next() {
+		var (
+			cid int
+			c   column
+		)
+		err = rows.Scan(&cid, &c.name, &c.typ, &c.notNull, &c.dflt, &c.pk)
+		if err != nil {
+			return nil, err
+		}
+		cols[c.name] = c
+	}
+	return cols, rows.Err()
+}
+
+// schemaDiff is the difference between a database's keymapp tables
+// and the tables expected by fkm.
+type schemaDiff struct {
+	missingTables  []string
+	missingColumns map[string][]column
+	extraColumns   map[string][]string
+}
+
+func (d schemaDiff) empty() bool {
+	return len(d.missingTables) == 0 && len(d.missingColumns) == 0 && len(d.extraColumns) == 0
+}
+
+// keymapp returns a description of the keymapp release the schema
+// difference suggests wrote the database, relative to the release
+// whose schema fkm knows.
+func (d schemaDiff) keymapp() string {
+	older := len(d.missingTables) != 0 || len(d.missingColumns) != 0
+	newer := len(d.extraColumns) != 0
+	switch {
+	case older && newer:
+		return "unknown"
+	case older:
+		return "older"
+	case newer:
+		return "newer"
+	default:
+		return "current"
+	}
+}
+
+// keymappTables is the set of tables in schema.
+var keymappTables = []string{"auth", "config", "heatmap", "metadata", "revision", "smart_layer"}
+
+// expectedSchema returns the columns of each keymapp table as
+// described by schema.
+func expectedSchema() (map[string]map[string]column, error) {
+	mem, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		return nil, err
+	}
+	defer mem.Close()
+	_, err = mem.Exec(schema)
+	if err != nil {
+		return nil, err
+	}
+	tables, err := tableNames(mem)
+	if err != nil {
+		return nil, err
+	}
+	want := make(map[string]map[string]column)
+	for _, t := range tables {
+		want[t], err = tableColumns(mem, t)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return want, nil
+}
+
+// tableNames returns the names of the user tables in db.
+func tableNames(db *sql.DB) ([]string, error) {
+	rows, err := db.Query(`SELECT name FROM sqlite_master WHERE type='table' AND name NOT LIKE 'sqlite_%' ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var names []string
+	for rows.Next() {
+		var name string
+		err = rows.Scan(&name)
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
+
+// diffSchema compares the keymapp tables in db with those expected
+// by fkm. Tables owned by fkm are not considered.
+func diffSchema(db querier) (schemaDiff, error) {
+	d := schemaDiff{
+		missingColumns: make(map[string][]column),
+		extraColumns:   make(map[string][]string),
+	}
+	want, err := expectedSchema()
+	if err != nil {
+		return d, err
+	}
+	for table, wantCols := range want {
+		ok, err := tableExists(db, table)
+		if err != nil {
+			return d, err
+		}
+		if !ok {
+			d.missingTables = append(d.missingTables, table)
+			continue
+		}
+		gotCols, err := tableColumns(db, table)
+		if err != nil {
+			return d, err
+		}
+		for name, c := range wantCols {
+			if _, ok := gotCols[name]; !ok {
+				d.missingColumns[table] = append(d.missingColumns[table], c)
+			}
+		}
+		for name := range gotCols {
+			if _, ok := wantCols[name]; !ok {
+				d.extraColumns[table] = append(d.extraColumns[table], name)
+			}
+		}
+	}
+	return d, nil
+}
+
+// migrate brings the schema of the database being changed by tx up
+// to date. It is run in the transaction making the changes to the
+// database so that the schema is only changed once the write guard
+// has passed and any backup has been taken. The keymapp schema is
+// checked before missing tables are created, so that the release
+// that wrote the database can be recorded. Missing keymapp tables
+// are created and missing keymapp columns are added where sqlite
+// allows. Columns unknown to fkm, for example those added by newer
+// keymapp releases, are left in place. Outstanding fkm migrations are
+// then applied, each recorded in fkm_schema_version with the keymapp
+// release detected when it was applied.
+func migrate(tx *sql.Tx) error {
+	d, err := diffSchema(tx)
+	if err != nil {
+		return err
+	}
+	release := d.keymapp()
+	if len(d.missingTables) == len(keymappTables) {
+		// This is a new database.
+		release = "current"
+	}
+	_, err = tx.Exec(schema)
+	if err != nil {
+		return err
+	}
+	slog.Debug("detected keymapp schema", "release", release)
+	for table, cols := range d.extraColumns {
+		slog.Info("keymapp table has columns unknown to fkm", "table", table, "columns", cols)
+	}
+
+	for table, cols := range d.missingColumns {
+		for _, c := range cols {
+			stmt, err := addColumn(table, c)
+			if err != nil {
+				return err
+			}
+			slog.Info("adding missing keymapp column", "table", table, "column", c.name)
+			_, err = tx.Exec(stmt)
+			if err != nil {
+				return fmt.Errorf("failed to add column %s to %s: %w", c.name, table, err)
+			}
+		}
+	}
+
+	_, err = tx.Exec(`CREATE TABLE IF NOT EXISTS "fkm_schema_version" (version INTEGER NOT NULL, keymapp TEXT)`)
+	if err != nil {
+		return err
+	}
+	var version int
+	err = tx.QueryRow(`SELECT coalesce(max(version), 0) FROM fkm_schema_version`).Scan(&version)
+	if err != nil {
+		return err
+	}
+	if version > fkmSchemaVersion {
+		return fmt.Errorf("database fkm schema version %d is newer than supported version %d", version, fkmSchemaVersion)
+	}
+	for v := version + 1; v <= fkmSchemaVersion; v++ {
+		slog.Info("applying fkm migration", "version", v)
+		_, err = tx.Exec(fkmMigrations[v])
+		if err != nil {
+			return fmt.Errorf("failed to apply fkm migration %d: %w", v, err)
+		}
+		// The release is recorded with each migration when it is
+		// applied and not changed later, since once missing
+		// columns have been added every database looks current.
+		_, err = tx.Exec(`INSERT INTO fkm_schema_version (version, keymapp) VALUES (?, ?)`, v, release)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addColumn returns an ALTER TABLE statement adding c to table.
+func addColumn(table string, c column) (string, error) {
+	if c.pk || (c.notNull && !c.dflt.Valid) {
+		return "", fmt.Errorf("cannot add column %s to existing table %s", c.name, table)
+	}
+	var buf strings.Builder
+	fmt.Fprintf(&buf, "ALTER TABLE %q ADD COLUMN %q %s", table, c.name, c.typ)
+	if c.notNull {
+		buf.WriteString(" NOT NULL")
+	}
+	if c.dflt.Valid {
+		fmt.Fprintf(&buf, " DEFAULT %s", c.dflt.String)
+	}
+	return buf.String(), nil
+}
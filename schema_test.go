@@ -0,0 +1,170 @@
+// Copyright ©2025 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"maps"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestMigrate(t *testing.T) {
+	// versions returns the expected fkm_schema_version rows when
+	// migrations from to fkmSchemaVersion are applied to a database
+	// written by release.
+	versions := func(from int, release string) []string {
+		var v []string
+		for i := from; i <= fkmSchemaVersion; i++ {
+			v = append(v, fmt.Sprintf("%d:%s", i, release))
+		}
+		return v
+	}
+	tests := []struct {
+		name  string
+		setup string
+
+		wantVersions []string
+		wantColumns  map[string][]string // columns that must be present after migration
+		wantErr      string
+	}{
+		{
+			name:         "new",
+			wantVersions: versions(1, "current"),
+		},
+		{
+			name:         "current",
+			setup:        schema,
+			wantVersions: versions(1, "current"),
+		},
+		{
+			name: "older",
+			setup: `
+CREATE TABLE config (key TEXT, value TEXT);
+CREATE TABLE metadata (data BLOB);
+CREATE TABLE heatmap (revisionId TEXT NOT NULL UNIQUE, enabled boolean DEFAULT 0);
+CREATE TABLE revision (revisionId TEXT NOT NULL UNIQUE, data BLOB DEFAULT NULL);
+`,
+			wantVersions: versions(1, "older"),
+			wantColumns: map[string][]string{
+				"heatmap": {"data", "enabled", "revisionId"},
+				"auth":    {"token", "username"},
+			},
+		},
+		{
+			name:         "newer",
+			setup:        schema + `ALTER TABLE config ADD COLUMN scope TEXT;`,
+			wantVersions: versions(1, "newer"),
+			wantColumns: map[string][]string{
+				"config": {"key", "scope", "value"},
+			},
+		},
+		{
+			name:    "unaddable column",
+			setup:   `CREATE TABLE revision (data BLOB);`,
+			wantErr: "cannot add column revisionId to existing table revision",
+		},
+		{
+			name: "partially migrated",
+			setup: schema + fkmMigrations[1] + `
+CREATE TABLE fkm_schema_version (version INTEGER NOT NULL, keymapp TEXT);
+INSERT INTO fkm_schema_version VALUES (1, 'older');
+`,
+			wantVersions: append([]string{"1:older"}, versions(2, "current")...),
+		},
+		{
+			name: "future version",
+			setup: schema + fmt.Sprintf(`
+CREATE TABLE fkm_schema_version (version INTEGER NOT NULL, keymapp TEXT);
+INSERT INTO fkm_schema_version VALUES (%d, 'current');
+`, fkmSchemaVersion+1),
+			wantErr: "is newer than supported version",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			db, err := openDB(filepath.Join(t.TempDir(), "keymapp.sqlite3"), dbOptions{})
+			if err != nil {
+				t.Fatalf("failed to open db: %v", err)
+			}
+			defer db.Close()
+			if test.setup != "" {
+				_, err = db.Exec(test.setup)
+				if err != nil {
+					t.Fatalf("failed to set up db: %v", err)
+				}
+			}
+
+			// Migrating twice must be the same as migrating once.
+			for range 2 {
+				tx, err := db.Begin()
+				if err != nil {
+					t.Fatalf("failed to begin transaction: %v", err)
+				}
+				err = migrate(tx)
+				if test.wantErr != "" {
+					tx.Rollback()
+					if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+						t.Fatalf("unexpected error: got:%v want:%s", err, test.wantErr)
+					}
+					return
+				}
+				if err != nil {
+					tx.Rollback()
+					t.Fatalf("failed to migrate: %v", err)
+				}
+				err = tx.Commit()
+				if err != nil {
+					t.Fatalf("failed to commit migration: %v", err)
+				}
+			}
+
+			d, err := diffSchema(db)
+			if err != nil {
+				t.Fatalf("failed to diff schema: %v", err)
+			}
+			if len(d.missingTables) != 0 || len(d.missingColumns) != 0 {
+				t.Errorf("schema incomplete after migration: missing tables %v, missing columns %v", d.missingTables, d.missingColumns)
+			}
+			for table, want := range test.wantColumns {
+				cols, err := tableColumns(db, table)
+				if err != nil {
+					t.Fatalf("failed to read %s columns: %v", table, err)
+				}
+				got := slices.Sorted(maps.Keys(cols))
+				if !slices.Equal(got, want) {
+					t.Errorf("unexpected %s columns: got:%v want:%v", table, got, want)
+				}
+			}
+
+			rows, err := db.Query(`SELECT version, keymapp FROM fkm_schema_version ORDER BY version`)
+			if err != nil {
+				t.Fatalf("failed to read schema versions: %v", err)
+			}
+			defer rows.Close()
+			var got []string
+			for rows.Next() {
+				var (
+					v       int
+					release string
+				)
+				err = rows.Scan(&v, &release)
+				if err != nil {
+					t.Fatalf("failed to read schema version: %v", err)
+				}
+				got = append(got, fmt.Sprintf("%d:%s", v, release))
+			}
+			if err = rows.Err(); err != nil {
+				t.Fatalf("failed to read schema versions: %v", err)
+			}
+			if !reflect.DeepEqual(got, test.wantVersions) {
+				t.Errorf("unexpected schema versions:\ngot: %v\nwant:%v", got, test.wantVersions)
+			}
+		})
+	}
+}
@@ -0,0 +1,382 @@
+// Copyright ©2025 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// schemaCheck compares the fields fkm requests from Oryx with the
+// GraphQL schema Oryx reports by introspection.
+func schemaCheck(args []string) {
+	fs := flag.NewFlagSet("fkm schema-check", flag.ExitOnError)
+	var (
+		common commonFlags
+		net    netFlags
+	)
+	common.register(fs)
+	net.register(fs)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), `usage: fkm schema-check [flags]
+
+Check the fields of the queries fkm makes, including any changes made by
+-query-file, against the Oryx GraphQL schema. Deprecated fields are
+reported as warnings and fields missing from the schema as errors.
+
+Flags:
+`)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	common.setup(fs)
+	if fs.NArg() != 0 || net.cannotFetch() {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+
+	cli := net.client()
+	schema, err := introspectSchema(context.Background(), cli, net.graphqlURL)
+	if err != nil {
+		fatal("failed to introspect schema", err, "url", net.graphqlURL)
+	}
+	var findings []finding
+	seen := make(map[string]bool)
+	for _, q := range []struct{ op, query string }{
+		{"getLayout", cmp.Or(cli.layoutQuery, layoutQuery)},
+		{"getUserLayouts", userLayoutsQuery},
+		{"searchLayouts", searchQuery},
+	} {
+		f, err := schema.check(q.query, seen)
+		if err != nil {
+			fatal("failed to check query", err, "operation", q.op)
+		}
+		if len(f) == 0 {
+			f = []finding{{"ok", q.op, "all requested fields are current", ""}}
+		}
+		findings = append(findings, f...)
+	}
+
+	if common.json {
+		printJSON(findings)
+	} else {
+		for _, f := range findings {
+			fmt.Println(f)
+		}
+	}
+	if slices.ContainsFunc(findings, func(f finding) bool { return f.level == "error" }) {
+		os.Exit(exitVerify)
+	}
+}
+
+// graphqlSchema is the part of an introspected GraphQL schema needed
+// to check requested fields.
+type graphqlSchema struct {
+	queryType string
+	types     map[string]map[string]schemaField // fields keyed by type and field name
+}
+
+// schemaField is a field of an introspected type.
+type schemaField struct {
+	typ               string // named type of the field
+	deprecated        bool
+	deprecationReason string
+}
+
+// schemaTypeRef is a possibly wrapped type reference in an
+// introspection response.
+type schemaTypeRef struct {
+	Name   string         `json:"name"`
+	OfType *schemaTypeRef `json:"ofType"`
+}
+
+// named returns the name of the type with list and non-null wrappers
+// removed.
+func (t *schemaTypeRef) named() string {
+	for t != nil && t.Name == "" {
+		t = t.OfType
+	}
+	if t == nil {
+		return ""
+	}
+	return t.Name
+}
+
+const introspectionQuery = `
+query IntrospectionQuery {
+	__schema {
+		queryType { name }
+		types {
+			name
+			fields(includeDeprecated: true) {
+				name
+				isDeprecated
+				deprecationReason
+				type { name ofType { name ofType { name ofType { name } } } }
+			}
+		}
+	}
+}`
+
+// introspectSchema returns the schema of the GraphQL endpoint.
+func introspectSchema(ctx context.Context, cli *client, endpoint string) (*graphqlSchema, error) {
+	data, err := graphql(ctx, cli, endpoint, "IntrospectionQuery", nil, introspectionQuery)
+	if err != nil {
+		return nil, err
+	}
+	var resp struct {
+		Schema *struct {
+			QueryType struct {
+				Name string `json:"name"`
+			} `json:"queryType"`
+			Types []struct {
+				Name   string `json:"name"`
+				Fields []struct {
+					Name              string        `json:"name"`
+					IsDeprecated      bool          `json:"isDeprecated"`
+					DeprecationReason string        `json:"deprecationReason"`
+					Type              schemaTypeRef `json:"type"`
+				} `json:"fields"`
+			} `json:"types"`
+		} `json:"__schema"`
+	}
+	err = json.Unmarshal(data, &resp)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse schema: %w", err)
+	}
+	if resp.Schema == nil {
+		return nil, errors.New("no schema in response: introspection may be disabled")
+	}
+	s := &graphqlSchema{
+		queryType: cmp.Or(resp.Schema.QueryType.Name, "Query"),
+		types:     make(map[string]map[string]schemaField),
+	}
+	for _, t := range resp.Schema.Types {
+		fields := make(map[string]schemaField)
+		for _, f := range t.Fields {
+			fields[f.Name] = schemaField{typ: f.Type.named(), deprecated: f.IsDeprecated, deprecationReason: f.DeprecationReason}
+		}
+		s.types[t.Name] = fields
+	}
+	return s, nil
+}
+
+// check returns findings for the fields of the query document that are
+// deprecated or missing from the schema. Fields already recorded in
+// seen, keyed by type and field name, are not reported again.
+func (s *graphqlSchema) check(doc string, seen map[string]bool) ([]finding, error) {
+	defs, err := parseGraphqlDefinitions(doc)
+	if err != nil {
+		return nil, err
+	}
+	type fragment struct {
+		on   string
+		sels []gqlSelection
+	}
+	fragments := make(map[string]fragment)
+	var ops [][]gqlSelection
+	for _, d := range defs {
+		p := gqlParser{tokens: graphqlToken.FindAllString(d.text, -1)}
+		p.next() // query or fragment
+		p.next() // name
+		var on string
+		if d.kind == "fragment" {
+			if p.tok() != "on" {
+				return nil, fmt.Errorf("fragment %s has no type condition", d.name)
+			}
+			on = p.next()
+			p.next()
+		} else if p.tok() == "(" {
+			p.skipBalanced()
+		}
+		sels, err := p.selectionSet()
+		if err != nil {
+			return nil, fmt.Errorf("%s %s: %w", d.kind, d.name, err)
+		}
+		if d.kind == "fragment" {
+			fragments[d.name] = fragment{on: on, sels: sels}
+		} else {
+			ops = append(ops, sels)
+		}
+	}
+
+	var (
+		findings []finding
+		visiting = make(map[string]bool)
+		walk     func(typ, path string, sels []gqlSelection)
+	)
+	walk = func(typ, path string, sels []gqlSelection) {
+		fields, ok := s.types[typ]
+		if !ok {
+			if !seen[typ] {
+				seen[typ] = true
+				findings = append(findings, finding{"error", path, fmt.Sprintf("type %s is not in the schema", typ), ""})
+			}
+			return
+		}
+		for _, sel := range sels {
+			switch {
+			case sel.spread != "":
+				f, ok := fragments[sel.spread]
+				if !ok || visiting[sel.spread] {
+					continue
+				}
+				visiting[sel.spread] = true
+				walk(f.on, path, f.sels)
+				visiting[sel.spread] = false
+			case sel.on != "" || sel.field == "":
+				walk(cmp.Or(sel.on, typ), path, sel.sels)
+			case strings.HasPrefix(sel.field, "__"):
+				// Meta-fields are always available.
+			default:
+				key := typ + "." + sel.field
+				at := strings.TrimPrefix(path+"."+sel.field, ".")
+				f, ok := fields[sel.field]
+				switch {
+				case !ok:
+					if !seen[key] {
+						findings = append(findings, finding{"error", at, fmt.Sprintf("%s is not in the schema", key), "remove the field with -query-file or update fkm"})
+					}
+					seen[key] = true
+					continue
+				case f.deprecated && !seen[key]:
+					msg := key + " is deprecated"
+					if f.deprecationReason != "" {
+						msg += ": " + f.deprecationReason
+					}
+					findings = append(findings, finding{"warn", at, msg, ""})
+				}
+				seen[key] = true
+				if len(sel.sels) != 0 {
+					walk(f.typ, at, sel.sels)
+				}
+			}
+		}
+	}
+	for _, sels := range ops {
+		walk(s.queryType, "", sels)
+	}
+	return findings, nil
+}
+
+// graphqlToken matches the tokens of a GraphQL document that has had
+// its comments removed.
+var graphqlToken = regexp.MustCompile(`\.\.\.|[_A-Za-z][_0-9A-Za-z]*|"(?:[^"\\]|\\.)*"|-?[0-9]+(?:\.[0-9]+)?|[{}()\[\]:!=$@]`)
+
+// gqlSelection is a field, fragment spread or inline fragment in a
+// selection set.
+type gqlSelection struct {
+	field  string // field name; empty for fragments
+	spread string // name of a spread fragment
+	on     string // type condition of an inline fragment
+	sels   []gqlSelection
+}
+
+// gqlParser parses GraphQL selection sets.
+type gqlParser struct {
+	tokens []string
+	pos    int
+}
+
+func (p *gqlParser) tok() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+// next advances to the next token and returns it.
+func (p *gqlParser) next() string {
+	p.pos++
+	return p.tok()
+}
+
+// skipBalanced skips the bracketed group starting at the current token.
+func (p *gqlParser) skipBalanced() {
+	depth := 0
+	for ; p.pos < len(p.tokens); p.pos++ {
+		switch p.tok() {
+		case "(", "[", "{":
+			depth++
+		case ")", "]", "}":
+			depth--
+		}
+		if depth == 0 {
+			p.pos++
+			return
+		}
+	}
+}
+
+// skipDirectives skips any directives at the current token.
+func (p *gqlParser) skipDirectives() {
+	for p.tok() == "@" {
+		p.next()
+		if p.next() == "(" {
+			p.skipBalanced()
+		}
+	}
+}
+
+// selectionSet parses the selection set starting at the current token.
+func (p *gqlParser) selectionSet() ([]gqlSelection, error) {
+	if p.tok() != "{" {
+		return nil, fmt.Errorf("expected selection set at %q", p.tok())
+	}
+	p.next()
+	var sels []gqlSelection
+	for p.tok() != "}" {
+		var sel gqlSelection
+		switch tok := p.tok(); {
+		case tok == "":
+			return nil, errors.New("unterminated selection set")
+		case tok == "...":
+			switch p.next() {
+			case "on":
+				sel.on = p.next()
+				p.next()
+			case "{", "@":
+			default:
+				sel.spread = p.tok()
+				p.next()
+			}
+		case graphqlName.MatchString(tok):
+			sel.field = tok
+			if p.next() == ":" {
+				sel.field = p.next()
+				p.next()
+			}
+			if p.tok() == "(" {
+				p.skipBalanced()
+			}
+		default:
+			return nil, fmt.Errorf("unexpected %q in selection set", tok)
+		}
+		p.skipDirectives()
+		if sel.spread == "" && p.tok() == "{" {
+			var err error
+			sel.sels, err = p.selectionSet()
+			if err != nil {
+				return nil, err
+			}
+		} else if sel.field == "" && sel.spread == "" {
+			return nil, errors.New("inline fragment has no selection set")
+		}
+		sels = append(sels, sel)
+	}
+	p.next()
+	return sels, nil
+}
+
+// graphqlName matches a GraphQL name.
+var graphqlName = regexp.MustCompile(`^[_A-Za-z][_0-9A-Za-z]*$`)
@@ -0,0 +1,208 @@
+// Copyright ©2025 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"maps"
+	"slices"
+)
+
+// revisionFilters are the transformations applied to revision data
+// before it is stored.
+type revisionFilters struct {
+	scrubPII bool
+	tour     string // keep, strip or separate
+}
+
+// register adds the filter flags to fs.
+func (f *revisionFilters) register(fs *flag.FlagSet) {
+	fs.BoolVar(&f.scrubPII, "scrub-pii", false, "redact layout author and tour author details before storing revisions")
+	fs.StringVar(&f.tour, "tour", "keep", "handling of layout tours: keep them in the revision, strip them, or store them separately from the revision")
+}
+
+// apply returns the revision data with the selected filters applied,
+// and any changes needed to store data removed from the revision.
+func (f revisionFilters) apply(id string, data []byte) ([]byte, []change, error) {
+	switch f.tour {
+	case "", "keep", "strip", "separate":
+	default:
+		return nil, nil, fmt.Errorf("invalid tour handling %q: must be keep, strip or separate", f.tour)
+	}
+	if !f.scrubPII && (f.tour == "" || f.tour == "keep") {
+		return data, nil, nil
+	}
+	var changes []change
+	data, err := editLayout(data, func(layout, revision map[string]json.RawMessage) error {
+		if f.scrubPII {
+			err := scrubPII(layout, revision)
+			if err != nil {
+				return err
+			}
+		}
+		tour := revision["tour"]
+		if len(tour) == 0 || string(tour) == "null" {
+			return nil
+		}
+		switch f.tour {
+		case "strip":
+			revision["tour"] = json.RawMessage("null")
+		case "separate":
+			revision["tour"] = json.RawMessage("null")
+			changes = append(changes, upsertTour(id, tour))
+		}
+		return nil
+	})
+	return data, changes, err
+}
+
+// editLayout decodes the layout and revision objects of the revision
+// data, calls fn to modify them and re-encodes the result. Fields that
+// are not modified keep their original encoding so that checksums over
+// them remain valid.
+func editLayout(data []byte, fn func(layout, revision map[string]json.RawMessage) error) ([]byte, error) {
+	var doc map[string]json.RawMessage
+	err := json.Unmarshal(data, &doc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse revision: %w", err)
+	}
+	var layout, revision map[string]json.RawMessage
+	err = json.Unmarshal(doc["layout"], &layout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse layout: %w", err)
+	}
+	if layout == nil {
+		return nil, errNoLayout
+	}
+	err = json.Unmarshal(layout["revision"], &revision)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse revision: %w", err)
+	}
+	if revision == nil {
+		return nil, errNoRevision
+	}
+	err = fn(layout, revision)
+	if err != nil {
+		return nil, err
+	}
+	layout["revision"] = encodeObject(revision)
+	doc["layout"] = encodeObject(layout)
+	return encodeObject(doc), nil
+}
+
+// encodeObject returns the JSON object holding the fields of m with
+// their values copied verbatim. The encoding/json package is not used
+// since it compacts raw messages, which would alter checksummed values.
+func encodeObject(m map[string]json.RawMessage) json.RawMessage {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, k := range slices.Sorted(maps.Keys(m)) {
+		if i != 0 {
+			buf.WriteByte(',')
+		}
+		name, _ := marshalRaw(k)
+		buf.Write(name)
+		buf.WriteByte(':')
+		buf.Write(m[k])
+	}
+	buf.WriteByte('}')
+	return buf.Bytes()
+}
+
+// encodeArray returns the JSON array holding the elements of a copied
+// verbatim.
+func encodeArray(a []json.RawMessage) json.RawMessage {
+	var buf bytes.Buffer
+	buf.WriteByte('[')
+	for i, v := range a {
+		if i != 0 {
+			buf.WriteByte(',')
+		}
+		buf.Write(v)
+	}
+	buf.WriteByte(']')
+	return buf.Bytes()
+}
+
+// marshalRaw returns the JSON encoding of v without escaping HTML
+// characters.
+func marshalRaw(v any) (json.RawMessage, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	err := enc.Encode(v)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+// scrubbedUser holds the only layout author fields kept by scrubPII,
+// with the redacted values they are given. These are the user fields
+// requested from Oryx, kept so that the data has the shape keymapp
+// expects. Any other field is removed.
+var scrubbedUser = map[string]json.RawMessage{
+	"annotation":       json.RawMessage(`""`),
+	"annotationPublic": json.RawMessage(`false`),
+	"hashId":           json.RawMessage(`""`),
+	"name":             json.RawMessage(`""`),
+	"pictureUrl":       json.RawMessage(`""`),
+}
+
+// scrubPII redacts the layout author's details, and removes author
+// details from the revision's tour. Only the author fields in
+// scrubbedUser are kept, with their redacted values, so that nothing
+// in the layout identifies its author.
+func scrubPII(layout, revision map[string]json.RawMessage) error {
+	if user := layout["user"]; len(user) != 0 && string(user) != "null" {
+		var u map[string]json.RawMessage
+		err := json.Unmarshal(user, &u)
+		if err != nil {
+			return fmt.Errorf("failed to parse layout user: %w", err)
+		}
+		scrubbed := make(map[string]json.RawMessage)
+		for k := range u {
+			if v, ok := scrubbedUser[k]; ok {
+				scrubbed[k] = v
+			}
+		}
+		layout["user"] = encodeObject(scrubbed)
+	}
+	if tour := revision["tour"]; len(tour) != 0 && string(tour) != "null" {
+		var t map[string]any
+		dec := json.NewDecoder(bytes.NewReader(tour))
+		dec.UseNumber()
+		err := dec.Decode(&t)
+		if err != nil {
+			return fmt.Errorf("failed to parse tour: %w", err)
+		}
+		removeAuthors(t)
+		revision["tour"], err = marshalRaw(t)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeAuthors removes author and user fields from v and the values
+// it holds.
+func removeAuthors(v any) {
+	switch v := v.(type) {
+	case map[string]any:
+		delete(v, "author")
+		delete(v, "user")
+		for _, e := range v {
+			removeAuthors(e)
+		}
+	case []any:
+		for _, e := range v {
+			removeAuthors(e)
+		}
+	}
+}
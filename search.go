@@ -0,0 +1,195 @@
+// Copyright ©2025 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+)
+
+// search lists the public Oryx layouts matching a query.
+func search(args []string) {
+	fs := flag.NewFlagSet("fkm search", flag.ExitOnError)
+	var (
+		common commonFlags
+		net    netFlags
+	)
+	common.register(fs)
+	net.register(fs)
+	geometry := fs.String("geometry", "", "keyboard geometry to search layouts for (required without -tag)")
+	query := fs.String("query", "", "text to search layout titles and descriptions for")
+	var tags stringList
+	fs.Var(&tags, "tag", "only list layouts with this tag (may be repeated)")
+	limit := fs.Int("limit", 25, "maximum number of layouts to list")
+	format := fs.String("format", "text", "output format (text or json)")
+	fs.Parse(args)
+	common.setup(fs)
+	if common.json {
+		*format = "json"
+	}
+	if *geometry == "" && len(tags) == 0 || *limit <= 0 || fs.NArg() != 0 {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+	switch *format {
+	case "text", "json":
+	default:
+		fmt.Fprintf(fs.Output(), "unknown format %q\n", *format)
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+
+	layouts, err := searchLayouts(context.Background(), net.client(), net.graphqlURL, layoutSearch{
+		geometry: *geometry,
+		query:    *query,
+		tags:     tags,
+		limit:    *limit,
+	})
+	if err != nil {
+		fatal("failed to search layouts", err, "geometry", *geometry, "query", *query)
+	}
+	err = writeLayoutSummaries(os.Stdout, layouts, *format)
+	if err != nil {
+		fatal("failed to write layouts", err)
+	}
+}
+
+// layoutSearch holds the parameters of a public layout search.
+type layoutSearch struct {
+	geometry string
+	query    string
+	tags     []string // all must be present
+	limit    int
+}
+
+// layoutSummary is the description of a layout returned by a search.
+type layoutSummary struct {
+	HashID   string `json:"hashId"`
+	Geometry string `json:"geometry"`
+	Title    string `json:"title"`
+	User     *struct {
+		Name   string `json:"name"`
+		HashID string `json:"hashId"`
+	} `json:"user"`
+	Tags []layoutTag `json:"tags"`
+}
+
+// author returns the name of the layout's author, or "?" if it is not
+// known.
+func (s layoutSummary) author() string {
+	if s.User == nil || s.User.Name == "" {
+		return "?"
+	}
+	return s.User.Name
+}
+
+// tagNames returns the names of the layout's tags.
+func (s layoutSummary) tagNames() []string {
+	names := make([]string, len(s.Tags))
+	for i, t := range s.Tags {
+		names[i] = t.Name
+	}
+	return names
+}
+
+// searchLayouts returns the public layouts matching s.
+func searchLayouts(ctx context.Context, cli *client, endpoint string, s layoutSearch) ([]layoutSummary, error) {
+	vars := map[string]any{
+		"geometry": nil,
+		"query":    s.query,
+		"tags":     s.tags,
+		"limit":    s.limit,
+	}
+	if s.geometry != "" {
+		vars["geometry"] = s.geometry
+	}
+	data, err := graphql(ctx, cli, endpoint, "searchLayouts", vars, searchQuery)
+	if err != nil {
+		return nil, err
+	}
+	var resp struct {
+		Layouts []layoutSummary `json:"searchLayouts"`
+	}
+	err = json.Unmarshal(data, &resp)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse search results: %w", err)
+	}
+	// Check the tags in case the server treats them as
+	// alternatives rather than requirements.
+	layouts := resp.Layouts[:0]
+	for _, l := range resp.Layouts {
+		if hasAllTags(l.tagNames(), s.tags) {
+			layouts = append(layouts, l)
+		}
+	}
+	return layouts, nil
+}
+
+// hasAllTags returns whether have includes each of want, ignoring case.
+func hasAllTags(have, want []string) bool {
+	for _, w := range want {
+		if !slices.ContainsFunc(have, func(h string) bool { return strings.EqualFold(h, w) }) {
+			return false
+		}
+	}
+	return true
+}
+
+// stringList is a flag.Value collecting the values of a repeated flag.
+type stringList []string
+
+func (l *stringList) String() string { return strings.Join(*l, ",") }
+
+func (l *stringList) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
+// writeLayoutSummaries writes the layouts to w in the given format,
+// text or json.
+func writeLayoutSummaries(w io.Writer, layouts []layoutSummary, format string) error {
+	if format == "json" {
+		if layouts == nil {
+			layouts = []layoutSummary{}
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "\t")
+		return enc.Encode(layouts)
+	}
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "HASH ID\tGEOMETRY\tTITLE\tAUTHOR\tTAGS")
+	for _, l := range layouts {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", l.HashID, l.Geometry, l.Title, l.author(), strings.Join(l.tagNames(), ","))
+	}
+	return tw.Flush()
+}
+
+const searchQuery = `
+query searchLayouts($geometry: String, $query: String, $tags: [String!], $limit: Int) {
+	searchLayouts(geometry: $geometry, query: $query, tags: $tags, limit: $limit) {
+		...LayoutSummary
+	}
+}` + layoutSummaryFragment
+
+const layoutSummaryFragment = `
+fragment LayoutSummary on Layout {
+	hashId
+	geometry
+	title
+	user {
+		name
+		hashId
+	}
+	tags {
+		name
+	}
+}`
@@ -0,0 +1,239 @@
+// Copyright ©2025 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+)
+
+// serve answers keymapp's metadata and GraphQL requests from the local
+// database so that keymapp can run without reaching ZSA's servers.
+func serve(args []string) {
+	fs := flag.NewFlagSet("fkm serve", flag.ExitOnError)
+	var (
+		common commonFlags
+		dmn    daemonFlags
+	)
+	common.register(fs)
+	dmn.register(fs)
+	addr := fs.String("addr", "localhost:8080", "address to listen on")
+	cacheDir := fs.String("cache-dir", defaultCacheDir(), "directory of cached downloads used for metadata missing from the database")
+	certFile := fs.String("tls-cert", "", "TLS certificate file, for serving keymapp through a hosts file entry")
+	keyFile := fs.String("tls-key", "", "TLS key file, used with -tls-cert")
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), `usage: fkm serve [flags]
+
+Serve the metadata (/metadata.json) and Oryx GraphQL (/graphql) endpoints
+from the local database. Only getLayout queries are answered. keymapp may
+be directed to the server with a proxy, or with a hosts file entry for
+configure.zsa.io and oryx.zsa.io and a certificate it trusts. When
+socket activated, the server listens on the passed socket instead of
+-addr.
+
+Flags:
+`)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	common.setup(fs)
+	if fs.NArg() != 0 || (*certFile == "") != (*keyFile == "") {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+
+	db := openExisting(common.dbPath, true, common.db)
+	defer db.Close()
+	m := mirror{db: db, cacheDir: *cacheDir}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /metadata.json", m.metadata)
+	mux.HandleFunc("POST /graphql", m.graphql)
+	srv := &http.Server{
+		Handler:           logRequests(mux),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	ctx, stop := shutdownContext()
+	defer stop()
+	d, err := dmn.start(ctx, "serve")
+	if err != nil {
+		fatal("failed to serve status", err, "addr", dmn.statusAddr)
+	}
+	ln, err := serviceListener(*addr)
+	if err != nil {
+		fatal("failed to listen", err, "addr", *addr)
+	}
+	go func() {
+		<-ctx.Done()
+		d.setState("stopping")
+		shutdown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdown)
+	}()
+	slog.Info("serving", "addr", ln.Addr(), "path", common.dbPath, "tls", *certFile != "")
+	d.set("addr", ln.Addr().String())
+	d.setState("running")
+	if *certFile != "" {
+		err = srv.ServeTLS(ln, *certFile, *keyFile)
+	} else {
+		err = srv.Serve(ln)
+	}
+	if !errors.Is(err, http.ErrServerClosed) {
+		fatal("server failed", err, "addr", *addr)
+	}
+}
+
+// logRequests logs each request handled by h.
+func logRequests(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		slog.Info("request", "method", r.Method, "url", r.URL.String(), "remote", r.RemoteAddr)
+		h.ServeHTTP(w, r)
+	})
+}
+
+// mirror serves Oryx endpoints from the local database.
+type mirror struct {
+	db       *sql.DB
+	cacheDir string
+}
+
+// metadata serves the stored metadata, falling back to the cached or
+// embedded metadata.
+func (m mirror) metadata(w http.ResponseWriter, r *http.Request) {
+	meta, err := loadMetadata(m.db)
+	if err == nil && meta == nil {
+		var fetched fetchedMetadata
+		fetched, err = metadataCache{dir: m.cacheDir, offline: true}.get(r.Context(), nil, defaultMetadataURL)
+		meta = fetched.data
+		if err == nil && meta == nil {
+			err = errNoMetadata
+		}
+	}
+	if err != nil {
+		slog.Error("failed to load metadata", "error", err)
+		http.Error(w, "failed to load metadata", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(meta)
+}
+
+// graphql answers getLayout queries with stored revisions.
+func (m mirror) graphql(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		OperationName string `json:"operationName"`
+		Variables     struct {
+			HashID     string  `json:"hashId"`
+			RevisionID string  `json:"revisionId"`
+			Geometry   *string `json:"geometry"`
+		} `json:"variables"`
+	}
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req)
+	if err != nil {
+		writeGraphqlError(w, http.StatusBadRequest, fmt.Sprintf("invalid request: %v", err))
+		return
+	}
+	if req.OperationName != "getLayout" {
+		writeGraphqlError(w, http.StatusOK, fmt.Sprintf("operation %q is not available from the local mirror", req.OperationName))
+		return
+	}
+	v := req.Variables
+	data, err := m.layout(v.HashID, v.RevisionID, v.Geometry)
+	if err != nil {
+		slog.Error("failed to load layout", "hash_id", v.HashID, "revision", v.RevisionID, "error", err)
+		writeGraphqlError(w, http.StatusOK, "failed to load layout")
+		return
+	}
+	if data == nil {
+		data = json.RawMessage(`{"layout":null}`)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Data json.RawMessage `json:"data"`
+	}{data})
+}
+
+// layout returns the stored layout data for the revision of the layout,
+// or nil if it is not stored. The "latest" revision is the newest stored
+// revision of the layout.
+func (m mirror) layout(hashID, revID string, geometry *string) (json.RawMessage, error) {
+	revs, err := storedRevisions(m.db)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range revs {
+		l := r.layout
+		if l == nil || l.HashID != hashID {
+			continue
+		}
+		if geometry != nil && *geometry != "" && l.Geometry != *geometry {
+			continue
+		}
+		if revID != "latest" && r.id != revID {
+			continue
+		}
+		return revisionWithTour(m.db, r.id)
+	}
+	return nil, nil
+}
+
+// revisionWithTour returns the stored data of the revision with any
+// separately stored tour restored to it.
+func revisionWithTour(db *sql.DB, id string) (json.RawMessage, error) {
+	data, err := loadRevision(db, id)
+	if err != nil {
+		return nil, err
+	}
+	ok, err := hasTable(db, "fkm_tour")
+	if err != nil || !ok {
+		return data, err
+	}
+	var tour []byte
+	err = db.QueryRow(`SELECT data FROM fkm_tour WHERE revisionId=?`, id).Scan(&tour)
+	if errors.Is(err, sql.ErrNoRows) {
+		return data, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var v struct {
+		Layout map[string]json.RawMessage `json:"layout"`
+	}
+	err = json.Unmarshal(data, &v)
+	if err != nil {
+		return nil, err
+	}
+	var rev map[string]json.RawMessage
+	err = json.Unmarshal(v.Layout["revision"], &rev)
+	if err != nil {
+		return nil, err
+	}
+	if t, ok := rev["tour"]; ok && string(t) != "null" {
+		return data, nil
+	}
+	rev["tour"] = tour
+	v.Layout["revision"], err = json.Marshal(rev)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(v)
+}
+
+// writeGraphqlError writes a GraphQL error response.
+func writeGraphqlError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(struct {
+		Errors graphqlErrors `json:"errors"`
+	}{graphqlErrors{{Message: msg}}})
+}
@@ -0,0 +1,147 @@
+// Copyright ©2025 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+// show prints the layers of a stored revision as keyboard diagrams.
+func show(args []string) {
+	fs := flag.NewFlagSet("fkm show", flag.ExitOnError)
+	var (
+		common commonFlags
+		legend legendFlags
+	)
+	common.register(fs)
+	legend.register(fs)
+	revID := fs.String("revision", "", "ID of the stored revision to show (required)")
+	layerIdx := fs.Int("layer", -1, "index of the layer to show (default all layers)")
+	ascii := fs.Bool("ascii", false, "draw with ASCII characters instead of Unicode box drawing")
+	fs.Parse(args)
+	common.setup(fs)
+	if *revID == "" || fs.NArg() != 0 {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+	legends := legend.legends()
+
+	db := openExisting(common.dbPath, true, common.db)
+	defer db.Close()
+	l, err := loadLayout(db, *revID)
+	if err != nil {
+		fatal("failed to read revision", err, "revision", *revID)
+	}
+	meta, err := loadMetadata(db)
+	if err != nil {
+		fatal("failed to read metadata", err)
+	}
+
+	layers := l.Revision.Layers
+	if *layerIdx >= 0 {
+		if *layerIdx >= len(layers) {
+			fatal("invalid layer", fmt.Errorf("revision has %d layers", len(layers)), "layer", *layerIdx)
+		}
+		layers = layers[*layerIdx : *layerIdx+1]
+	}
+	style := unicodeBox
+	if *ascii {
+		style = asciiBox
+	}
+	fmt.Printf("%s (%s) revision %s\n", l.Title, l.Geometry, l.Revision.HashID)
+	for _, ly := range layers {
+		fmt.Printf("\n%d: %s\n", ly.Position, ly.Name())
+		drawLayer(os.Stdout, ly, keyPlacements(meta, l.Geometry, len(ly.Keys)), style, legends)
+	}
+}
+
+// boxStyle is the set of characters used to draw key caps.
+type boxStyle struct {
+	topLeft, topRight, bottomLeft, bottomRight rune
+	horizontal, vertical                       rune
+	transparent                                string
+}
+
+var (
+	unicodeBox = boxStyle{'┌', '┐', '└', '┘', '─', '│', "▽"}
+	asciiBox   = boxStyle{'+', '+', '+', '+', '-', '|', "_"}
+)
+
+const (
+	// keyWidth and keyHeight are the size in characters of
+	// a one unit key cap.
+	keyWidth  = 8
+	keyHeight = 4
+)
+
+// drawLayer writes a diagram of the layer's keys at the given
+// placements to w. Each key cap shows its tap legend and, if it has
+// one, its hold action, rendered with legends or as key codes if
+// legends is nil.
+func drawLayer(w io.Writer, ly layer, placements []keyPlacement, style boxStyle, legends *keyLegends) {
+	if len(placements) < len(ly.Keys) {
+		slog.Warn("geometry has fewer keys than layer, using a grid", "keys", len(ly.Keys), "placements", len(placements))
+		placements = keyPlacements(nil, "", len(ly.Keys))
+	}
+	var width, height int
+	for _, p := range placements[:len(ly.Keys)] {
+		width = max(width, int(math.Round((p.X+p.W)*keyWidth)))
+		height = max(height, int(math.Round((p.Y+p.H)*keyHeight)))
+	}
+	canvas := make([][]rune, height)
+	for i := range canvas {
+		canvas[i] = []rune(strings.Repeat(" ", width))
+	}
+	for i, k := range ly.Keys {
+		p := placements[i]
+		left := int(math.Round(p.X * keyWidth))
+		right := int(math.Round((p.X+p.W)*keyWidth)) - 1
+		top := int(math.Round(p.Y * keyHeight))
+		bottom := int(math.Round((p.Y+p.H)*keyHeight)) - 1
+		for x := left + 1; x < right; x++ {
+			canvas[top][x] = style.horizontal
+			canvas[bottom][x] = style.horizontal
+		}
+		for y := top + 1; y < bottom; y++ {
+			canvas[y][left] = style.vertical
+			canvas[y][right] = style.vertical
+		}
+		canvas[top][left] = style.topLeft
+		canvas[top][right] = style.topRight
+		canvas[bottom][left] = style.bottomLeft
+		canvas[bottom][right] = style.bottomRight
+
+		inner := right - left - 1
+		tap := legends.key(k)
+		if k.CustomLabel == "" && k.Tap != nil && k.Tap.Code == "KC_TRANSPARENT" {
+			tap = style.transparent
+		}
+		center(canvas[top+1][left+1:right], tap, inner)
+		if top+2 < bottom {
+			center(canvas[top+2][left+1:right], legends.action(k.Hold), inner)
+		}
+	}
+	for _, line := range canvas {
+		fmt.Fprintln(w, strings.TrimRight(string(line), " "))
+	}
+}
+
+// center writes s centred in dst, truncating it to n runes.
+func center(dst []rune, s string, n int) {
+	if utf8.RuneCountInString(s) > n {
+		s = string([]rune(s)[:n])
+	}
+	off := (n - utf8.RuneCountInString(s)) / 2
+	for i, r := range []rune(s) {
+		dst[off+i] = r
+	}
+}
@@ -0,0 +1,248 @@
+// Copyright ©2025 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Signatures are minisign detached signatures, held in a file next to
+// the signed file with a .minisig suffix, so files signed by fkm can be
+// checked with minisign and files signed by minisign can be checked by
+// fkm. Keys are minisign key files, as written by
+//
+//	minisign -G -W -p fkm.pub -s fkm.key
+//
+// Secret keys must not be encrypted, since fkm signs unattended. A
+// signed file is checked with
+//
+//	minisign -V -p fkm.pub -m keymapp.sqlite3
+
+// sigSuffix is the suffix of detached signature files.
+const sigSuffix = ".minisig"
+
+// errBadSignature is returned when a signature does not verify.
+var errBadSignature = errors.New("signature verification failed")
+
+// Minisign signature algorithms. Signatures of BLAKE2b-512 digests
+// are written; signatures of the complete contents, written by early
+// versions of minisign, are also verified.
+const (
+	sigPrehashed = "ED"
+	sigLegacy    = "Ed"
+)
+
+// signingKey is a minisign secret key.
+type signingKey struct {
+	id  [8]byte
+	key ed25519.PrivateKey
+}
+
+// verifyKey is a minisign public key.
+type verifyKey struct {
+	id  [8]byte
+	key ed25519.PublicKey
+}
+
+// keyID returns the key ID as it is shown by minisign.
+func keyID(id [8]byte) string {
+	return fmt.Sprintf("%016X", binary.LittleEndian.Uint64(id[:]))
+}
+
+// loadSigningKey returns the minisign secret key in the file at path.
+func loadSigningKey(path string) (signingKey, error) {
+	b, err := readMinisign(path)
+	if err != nil {
+		return signingKey{}, err
+	}
+	// sig alg, kdf alg, checksum alg, kdf salt, kdf opslimit,
+	// kdf memlimit, key ID, key, checksum.
+	const size = 2 + 2 + 2 + 32 + 8 + 8 + 8 + ed25519.PrivateKeySize + 32
+	if len(b) != size || string(b[:2]) != sigLegacy {
+		return signingKey{}, fmt.Errorf("%s: not a minisign secret key", path)
+	}
+	if b[2] != 0 || b[3] != 0 {
+		return signingKey{}, fmt.Errorf("%s: encrypted secret keys are not supported: generate the key with minisign -G -W", path)
+	}
+	if string(b[4:6]) != "B2" {
+		return signingKey{}, fmt.Errorf("%s: unknown secret key checksum algorithm", path)
+	}
+	keynum := b[54:]
+	var k signingKey
+	copy(k.id[:], keynum[:8])
+	k.key = ed25519.PrivateKey(bytes.Clone(keynum[8 : 8+ed25519.PrivateKeySize]))
+	h := newBlake2b(32)
+	h.Write(b[:2])
+	h.Write(keynum[:8+ed25519.PrivateKeySize])
+	if subtle.ConstantTimeCompare(h.Sum(nil), keynum[8+ed25519.PrivateKeySize:]) != 1 {
+		return signingKey{}, fmt.Errorf("%s: secret key checksum mismatch", path)
+	}
+	return k, nil
+}
+
+// loadVerifyKey returns the minisign public key in the file at path.
+func loadVerifyKey(path string) (verifyKey, error) {
+	b, err := readMinisign(path)
+	if err != nil {
+		return verifyKey{}, err
+	}
+	if len(b) != 2+8+ed25519.PublicKeySize || string(b[:2]) != sigLegacy {
+		return verifyKey{}, fmt.Errorf("%s: not a minisign public key", path)
+	}
+	var k verifyKey
+	copy(k.id[:], b[2:10])
+	k.key = ed25519.PublicKey(bytes.Clone(b[10:]))
+	return k, nil
+}
+
+// readMinisign returns the decoded data line of the minisign key file
+// at path, which follows an untrusted comment line.
+func readMinisign(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	lines, err := readLines(f, 2)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if !strings.HasPrefix(lines[0], "untrusted comment:") {
+		return nil, fmt.Errorf("%s: not a minisign key file", path)
+	}
+	b, err := base64.StdEncoding.DecodeString(lines[1])
+	if err != nil {
+		return nil, fmt.Errorf("%s: not a minisign key file: %w", path, err)
+	}
+	return b, nil
+}
+
+// readLines returns the first n lines of r, with surrounding white
+// space removed.
+func readLines(r io.Reader, n int) ([]string, error) {
+	sc := bufio.NewScanner(r)
+	lines := make([]string, 0, n)
+	for len(lines) < n && sc.Scan() {
+		lines = append(lines, strings.TrimSpace(sc.Text()))
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if len(lines) < n {
+		return nil, errors.New("truncated file")
+	}
+	return lines, nil
+}
+
+// writeSignature writes the detached signature of data, the contents of
+// the file at path, to the signature file for path.
+func writeSignature(path string, key signingKey, data io.Reader) error {
+	h := newBlake2b(64)
+	_, err := io.Copy(h, data)
+	if err != nil {
+		return err
+	}
+	sig := append([]byte(sigPrehashed), key.id[:]...)
+	sig = append(sig, ed25519.Sign(key.key, h.Sum(nil))...)
+	trusted := fmt.Sprintf("timestamp:%d\tfile:%s\thashed", time.Now().Unix(), filepath.Base(path))
+	global := ed25519.Sign(key.key, slices.Concat(sig[2+8:], []byte(trusted)))
+
+	var buf bytes.Buffer
+	fmt.Fprintln(&buf, "untrusted comment: signature from fkm secret key "+keyID(key.id))
+	fmt.Fprintln(&buf, base64.StdEncoding.EncodeToString(sig))
+	fmt.Fprintln(&buf, "trusted comment: "+trusted)
+	fmt.Fprintln(&buf, base64.StdEncoding.EncodeToString(global))
+	return os.WriteFile(path+sigSuffix, buf.Bytes(), 0o644)
+}
+
+// signFile writes the detached signature of the file at path.
+func signFile(path string, key signingKey) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return writeSignature(path, key, f)
+}
+
+// verifySignature checks data, the contents of the file at path,
+// against the detached signature in the signature file for path.
+func verifySignature(path string, key verifyKey, data io.Reader) error {
+	f, err := os.Open(path + sigSuffix)
+	if errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("%w: %s is not signed", errBadSignature, path)
+	}
+	if err != nil {
+		return err
+	}
+	lines, err := readLines(f, 4)
+	f.Close()
+	if err != nil {
+		return fmt.Errorf("%w: %s: %v", errBadSignature, path+sigSuffix, err)
+	}
+	sig, err := base64.StdEncoding.DecodeString(lines[1])
+	if err != nil || len(sig) != 2+8+ed25519.SignatureSize {
+		return fmt.Errorf("%w: %s: invalid signature", errBadSignature, path+sigSuffix)
+	}
+	trusted, ok := strings.CutPrefix(lines[2], "trusted comment: ")
+	if !ok {
+		return fmt.Errorf("%w: %s: no trusted comment", errBadSignature, path+sigSuffix)
+	}
+	global, err := base64.StdEncoding.DecodeString(lines[3])
+	if err != nil {
+		return fmt.Errorf("%w: %s: invalid trusted comment signature", errBadSignature, path+sigSuffix)
+	}
+	var id [8]byte
+	copy(id[:], sig[2:10])
+	if id != key.id {
+		return fmt.Errorf("%w: %s is signed by key %s, not %s", errBadSignature, path, keyID(id), keyID(key.id))
+	}
+
+	var msg []byte
+	switch string(sig[:2]) {
+	case sigPrehashed:
+		h := newBlake2b(64)
+		_, err = io.Copy(h, data)
+		msg = h.Sum(nil)
+	case sigLegacy:
+		msg, err = io.ReadAll(data)
+	default:
+		return fmt.Errorf("%w: %s: unknown signature algorithm", errBadSignature, path+sigSuffix)
+	}
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(key.key, msg, sig[10:]) {
+		return fmt.Errorf("%w: %s", errBadSignature, path)
+	}
+	if !ed25519.Verify(key.key, slices.Concat(sig[10:], []byte(trusted)), global) {
+		return fmt.Errorf("%w: %s: trusted comment does not verify", errBadSignature, path)
+	}
+	return nil
+}
+
+// verifyFile checks the file at path against its detached signature.
+func verifyFile(path string, key verifyKey) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return verifySignature(path, key, f)
+}
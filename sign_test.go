@@ -0,0 +1,235 @@
+// Copyright ©2025 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestBlake2b(t *testing.T) {
+	// Digests of the bytes i%251 for i in [0, n) from Python's
+	// hashlib.blake2b.
+	tests := []struct {
+		n    int
+		size int
+		want string
+	}{
+		{n: 0, size: 32, want: "0e5751c026e543b2e8ab2eb06099daa1d1e5df47778f7787faab45cdf12fe3a8"},
+		{n: 0, size: 64, want: "786a02f742015903c6c6fd852552d272912f4740e15847618a86e217f71f5419d25e1031afee585313896444934eb04b903a685b1448b755d56f701afe9be2ce"},
+		{n: 3, size: 32, want: "3d8c3d594928271f44aad7a04b177154806867bcf918e1549c0bc16f9da2b09b"},
+		{n: 3, size: 64, want: "40a374727302d9a4769c17b5f409ff32f58aa24ff122d7603e4fda1509e919d4107a52c57570a6d94e50967aea573b11f86f473f537565c66f7039830a85d186"},
+		{n: 127, size: 64, want: "b6292669ccd38d5f01caae96ba272c76a879a45743afa0725d83b9ebb26665b731f1848c52f11972b6644f554c064fa90780dbbbf3a89d4fc31f67df3e5857ef"},
+		{n: 128, size: 32, want: "c3582f71ebb2be66fa5dd750f80baae97554f3b015663c8be377cfcb2488c1d1"},
+		{n: 128, size: 64, want: "2319e3789c47e2daa5fe807f61bec2a1a6537fa03f19ff32e87eecbfd64b7e0e8ccff439ac333b040f19b0c4ddd11a61e24ac1fe0f10a039806c5dcc0da3d115"},
+		{n: 129, size: 64, want: "f59711d44a031d5f97a9413c065d1e614c417ede998590325f49bad2fd444d3e4418be19aec4e11449ac1a57207898bc57d76a1bcf3566292c20c683a5c4648f"},
+		{n: 256, size: 64, want: "93463ac058b6163eb43be3f5bb32b28541498f4e3366f1effe253ad44e1e076e41c3616046027c82a7124f8f4746668ad10b12e8e25a95ac8f3151df01cd5a93"},
+		{n: 1000, size: 32, want: "b372d0608f720c8c3dd41e9c8eecb10143b41abe520b616607e754bf79c08331"},
+		{n: 1000, size: 64, want: "c11e1c0340bd7e5a1b275f1230c962fad215ecb1391486e74e31b960a2f2996381a5fad092da06841d5f26e38f6ecfeaf441acbcd1c2de61aef121e7927175f5"},
+	}
+	for _, test := range tests {
+		data := make([]byte, test.n)
+		for i := range data {
+			data[i] = byte(i % 251)
+		}
+		// Write in uneven pieces to exercise buffering.
+		h := newBlake2b(test.size)
+		for p := data; len(p) > 0; {
+			n := min(len(p), 1+len(p)%61)
+			h.Write(p[:n])
+			p = p[n:]
+		}
+		got := hex.EncodeToString(h.Sum(nil))
+		if got != test.want {
+			t.Errorf("unexpected BLAKE2b-%d of %d bytes:\ngot: %s\nwant:%s", 8*test.size, test.n, got, test.want)
+		}
+		// Sum must not change the state.
+		if again := hex.EncodeToString(h.Sum(nil)); again != got {
+			t.Errorf("repeated Sum of %d bytes changed: got:%s want:%s", test.n, again, got)
+		}
+	}
+}
+
+// writeTestKeys writes an unencrypted minisign key pair into dir,
+// returning the paths of the secret and public key files.
+func writeTestKeys(t *testing.T, dir, name string) (secret, public string) {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	id := make([]byte, 8)
+	rand.Read(id)
+
+	h := newBlake2b(32)
+	h.Write([]byte("Ed"))
+	h.Write(id)
+	h.Write(priv)
+	sk := slices.Concat([]byte("Ed"), []byte{0, 0}, []byte("B2"), make([]byte, 32+8+8), id, priv, h.Sum(nil))
+	pk := slices.Concat([]byte("Ed"), id, pub)
+
+	secret = filepath.Join(dir, name+".key")
+	public = filepath.Join(dir, name+".pub")
+	writeMinisignFile(t, secret, "minisign secret key", sk)
+	writeMinisignFile(t, public, "minisign public key", pk)
+	return secret, public
+}
+
+func writeMinisignFile(t *testing.T, path, comment string, data []byte) {
+	t.Helper()
+	b := fmt.Appendf(nil, "untrusted comment: %s\n%s\n", comment, base64.StdEncoding.EncodeToString(data))
+	err := os.WriteFile(path, b, 0o600)
+	if err != nil {
+		t.Fatalf("failed to write key: %v", err)
+	}
+}
+
+func TestSignature(t *testing.T) {
+	dir := t.TempDir()
+	secret, public := writeTestKeys(t, dir, "fkm")
+	_, other := writeTestKeys(t, dir, "other")
+	sk, err := loadSigningKey(secret)
+	if err != nil {
+		t.Fatalf("failed to load signing key: %v", err)
+	}
+
+	data := []byte("keymapp database bundle")
+	tests := []struct {
+		name   string
+		key    string
+		data   []byte
+		tamper func(t *testing.T, sigPath string)
+		want   error
+	}{
+		{name: "valid", key: public, data: data},
+		{name: "legacy", key: public, data: data, tamper: legacySignature(sk, data)},
+		{name: "modified data", key: public, data: []byte("keymapp database bundle!"), want: errBadSignature},
+		{name: "other key", key: other, data: data, want: errBadSignature},
+		{name: "unsigned", key: public, data: data, tamper: removeFile, want: errBadSignature},
+		{name: "trusted comment", key: public, data: data, tamper: editTrustedComment, want: errBadSignature},
+		{name: "truncated", key: public, data: data, tamper: truncateSignature, want: errBadSignature},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "bundle.sqlite3")
+			err := os.WriteFile(path, data, 0o600)
+			if err != nil {
+				t.Fatalf("failed to write data: %v", err)
+			}
+			err = signFile(path, sk)
+			if err != nil {
+				t.Fatalf("failed to sign: %v", err)
+			}
+			if test.tamper != nil {
+				test.tamper(t, path+sigSuffix)
+			}
+			vk, err := loadVerifyKey(test.key)
+			if err != nil {
+				t.Fatalf("failed to load verify key: %v", err)
+			}
+			err = verifySignature(path, vk, bytes.NewReader(test.data))
+			if !errors.Is(err, test.want) {
+				t.Errorf("unexpected verification error: got:%v want:%v", err, test.want)
+			}
+		})
+	}
+}
+
+// legacySignature returns a function replacing a signature with one of
+// the complete data, as written by early versions of minisign.
+func legacySignature(key signingKey, data []byte) func(t *testing.T, sigPath string) {
+	return func(t *testing.T, sigPath string) {
+		sig := slices.Concat([]byte("Ed"), key.id[:], ed25519.Sign(key.key, data))
+		trusted := "timestamp:0\tfile:bundle.sqlite3"
+		global := ed25519.Sign(key.key, slices.Concat(sig[10:], []byte(trusted)))
+		b := fmt.Appendf(nil, "untrusted comment: legacy\n%s\ntrusted comment: %s\n%s\n",
+			base64.StdEncoding.EncodeToString(sig), trusted, base64.StdEncoding.EncodeToString(global))
+		err := os.WriteFile(sigPath, b, 0o600)
+		if err != nil {
+			t.Fatalf("failed to write signature: %v", err)
+		}
+	}
+}
+
+func removeFile(t *testing.T, path string) {
+	err := os.Remove(path)
+	if err != nil {
+		t.Fatalf("failed to remove signature: %v", err)
+	}
+}
+
+func editTrustedComment(t *testing.T, path string) {
+	editFile(t, path, func(lines []string) []string {
+		lines[2] += "\tedited"
+		return lines
+	})
+}
+
+func truncateSignature(t *testing.T, path string) {
+	editFile(t, path, func(lines []string) []string {
+		return lines[:2]
+	})
+}
+
+func editFile(t *testing.T, path string, edit func([]string) []string) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read signature: %v", err)
+	}
+	lines := edit(strings.Split(strings.TrimSpace(string(b)), "\n"))
+	err = os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0o600)
+	if err != nil {
+		t.Fatalf("failed to write signature: %v", err)
+	}
+}
+
+func TestLoadSigningKey(t *testing.T) {
+	dir := t.TempDir()
+	secret, public := writeTestKeys(t, dir, "fkm")
+	b, err := readMinisign(secret)
+	if err != nil {
+		t.Fatalf("failed to read key: %v", err)
+	}
+	tests := []struct {
+		name    string
+		edit    func([]byte) []byte
+		path    string
+		wantErr string
+	}{
+		{name: "valid", edit: func(b []byte) []byte { return b }},
+		{name: "encrypted", edit: func(b []byte) []byte { b[2], b[3] = 'S', 'c'; return b }, wantErr: "encrypted secret keys are not supported"},
+		{name: "checksum", edit: func(b []byte) []byte { b[len(b)-1] ^= 1; return b }, wantErr: "checksum mismatch"},
+		{name: "public key", path: public, wantErr: "not a minisign secret key"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := test.path
+			if path == "" {
+				path = filepath.Join(t.TempDir(), "test.key")
+				writeMinisignFile(t, path, "minisign secret key", test.edit(bytes.Clone(b)))
+			}
+			_, err := loadSigningKey(path)
+			if test.wantErr == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("unexpected error: got:%v want:%s", err, test.wantErr)
+			}
+		})
+	}
+}
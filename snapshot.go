@@ -0,0 +1,22 @@
+// Copyright ©2025 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build snapshot
+
+package main
+
+import _ "embed"
+
+// snapshotMetadata is the metadata.json snapshot used for offline
+// initialisation. It is embedded only in builds with the snapshot build
+// tag, which require the snapshot to have been fetched with go generate.
+//
+//go:embed snapshot/metadata.json
+var snapshotMetadata []byte
+
+// embeddedMetadata returns the metadata.json snapshot embedded in the
+// binary at build time.
+func embeddedMetadata() ([]byte, error) {
+	return snapshotMetadata, nil
+}
@@ -0,0 +1,87 @@
+// Copyright ©2025 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+)
+
+// spool is data held in a temporary file rather than in memory. Fetched
+// response bodies and revision data waiting to be stored are spooled
+// so that a batch of large revisions is not held in memory at once. A
+// spool is a driver.Valuer, so it is read back only while the statement
+// writing it to the database is executed.
+type spool struct {
+	f    *os.File
+	size int64
+}
+
+// newSpool copies r to a new spool, failing with errResponseTooLarge
+// without reading the rest of r if it holds more than max bytes and max
+// is positive.
+func newSpool(r io.Reader, max int64) (*spool, error) {
+	f, err := os.CreateTemp("", "fkm-spool-*")
+	if err != nil {
+		return nil, err
+	}
+	if runtime.GOOS != "windows" {
+		// Unlink the file so that it does not outlive the process,
+		// even when fkm exits without closing it. Open files cannot
+		// be removed on Windows, so there it is removed by close.
+		os.Remove(f.Name())
+	}
+	s := &spool{f: f}
+	if max > 0 {
+		r = io.LimitReader(r, max+1)
+	}
+	s.size, err = io.Copy(f, r)
+	if err == nil && max > 0 && s.size > max {
+		err = permanentError{fmt.Errorf("%w: more than the limit of %d bytes", errResponseTooLarge, max)}
+	}
+	if err != nil {
+		s.close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// spoolBytes returns a spool holding data.
+func spoolBytes(data []byte) (*spool, error) {
+	return newSpool(bytes.NewReader(data), 0)
+}
+
+// Len returns the number of bytes in the spool.
+func (s *spool) Len() int {
+	return int(s.size)
+}
+
+// bytes returns the spooled data.
+func (s *spool) bytes() ([]byte, error) {
+	b := make([]byte, s.size)
+	_, err := s.f.ReadAt(b, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read spooled data: %w", err)
+	}
+	return b, nil
+}
+
+// Value returns the spooled data for writing to the database as a blob.
+func (s *spool) Value() (driver.Value, error) {
+	return s.bytes()
+}
+
+// close releases the spool's temporary file.
+func (s *spool) close() error {
+	err := s.f.Close()
+	if runtime.GOOS == "windows" {
+		os.Remove(s.f.Name())
+	}
+	return err
+}
@@ -0,0 +1,89 @@
+// Copyright ©2025 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestSpool(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		max     int64
+		wantErr error
+	}{
+		{name: "empty", data: ""},
+		{name: "unlimited", data: strings.Repeat("data", 1<<12)},
+		{name: "at limit", data: "0123456789", max: 10},
+		{name: "over limit", data: "0123456789A", max: 10, wantErr: errResponseTooLarge},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s, err := newSpool(strings.NewReader(test.data), test.max)
+			if !errors.Is(err, test.wantErr) {
+				t.Fatalf("unexpected error: got:%v want:%v", err, test.wantErr)
+			}
+			if err != nil {
+				var perm permanentError
+				if !errors.As(err, &perm) {
+					t.Errorf("expected permanent error for oversized body: %v", err)
+				}
+				return
+			}
+			defer s.close()
+			if s.Len() != len(test.data) {
+				t.Errorf("unexpected length: got:%d want:%d", s.Len(), len(test.data))
+			}
+			got, err := s.bytes()
+			if err != nil {
+				t.Fatalf("failed to read spool: %v", err)
+			}
+			if string(got) != test.data {
+				t.Errorf("unexpected spooled data: got:%q want:%q", got, test.data)
+			}
+			v, err := s.Value()
+			if err != nil {
+				t.Fatalf("failed to get spool value: %v", err)
+			}
+			if b, ok := v.([]byte); !ok || !bytes.Equal(b, got) {
+				t.Errorf("unexpected spool value: got:%v want:%q", v, test.data)
+			}
+		})
+	}
+}
+
+func TestSpooledRevision(t *testing.T) {
+	db := newTestDB(t)
+	data := testRevision("abc", "r1", "2025-01-01T00:00:00Z")
+	changes, err := revisionChanges(db, "r1", "", data, false, provenance{})
+	if err != nil {
+		t.Fatalf("failed to prepare revision: %v", err)
+	}
+	for _, c := range changes {
+		if c.table != "revision" {
+			continue
+		}
+		for _, arg := range c.args[1:] {
+			if _, ok := arg.(*spool); !ok {
+				t.Errorf("revision data is held as %T, not spooled", arg)
+			}
+		}
+	}
+	err = apply(db, changes)
+	if err != nil {
+		t.Fatalf("failed to store revision: %v", err)
+	}
+	got, err := loadRevision(db, "r1")
+	if err != nil {
+		t.Fatalf("failed to load revision: %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("unexpected stored revision:\ngot: %s\nwant:%s", got, data)
+	}
+}
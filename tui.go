@@ -0,0 +1,352 @@
+// Copyright ©2025 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// tui runs an interactive browser over the revisions stored in the
+// database.
+func tui(args []string) {
+	fs := flag.NewFlagSet("fkm tui", flag.ExitOnError)
+	var (
+		common commonFlags
+		guard  writeGuard
+	)
+	common.register(fs)
+	guard.register(fs)
+	fs.Parse(args)
+	common.setup(fs)
+	if fs.NArg() != 0 {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+
+	db := openExisting(common.dbPath, false, common.db)
+	defer db.Close()
+
+	b := browser{
+		db:    db,
+		guard: guard,
+		in:    bufio.NewScanner(os.Stdin),
+		out:   os.Stdout,
+		layer: -1,
+	}
+	err := b.run()
+	if err != nil {
+		fatal("browser failed", err, "path", common.dbPath)
+	}
+}
+
+// browser is a line-oriented interactive browser of stored revisions.
+type browser struct {
+	db    *sql.DB
+	guard writeGuard
+	in    *bufio.Scanner
+	out   io.Writer
+
+	revs     []storedRevision
+	selected *storedRevision // nil when no revision is selected
+	layer    int             // index into selected layers, or -1
+}
+
+const browserHelp = `commands:
+  ls        list stored revisions
+  N         select revision N, or layer N of the selected revision
+  n, p      show the next or previous layer
+  c         show the combos of the selected revision
+  t         show the tour of the selected revision
+  h         toggle heatmap recording for the selected revision
+  d         delete the selected revision
+  b         go back
+  ?         show this help
+  q         quit
+`
+
+// run reads and executes commands until the input is exhausted or the
+// user quits.
+func (b *browser) run() error {
+	err := b.list()
+	if err != nil {
+		return err
+	}
+	for {
+		fmt.Fprintf(b.out, "%s> ", b.prompt())
+		if !b.in.Scan() {
+			fmt.Fprintln(b.out)
+			return b.in.Err()
+		}
+		cmd := strings.TrimSpace(b.in.Text())
+		switch cmd {
+		case "":
+		case "q", "quit", "exit":
+			return nil
+		case "?", "help":
+			fmt.Fprint(b.out, browserHelp)
+		case "ls":
+			err = b.list()
+		case "b":
+			switch {
+			case b.layer >= 0:
+				b.layer = -1
+				b.showRevision()
+			case b.selected != nil:
+				b.selected = nil
+				err = b.list()
+			}
+		case "n", "p":
+			if !b.haveRevision() {
+				continue
+			}
+			step := 1
+			if cmd == "p" {
+				step = -1
+			}
+			b.showLayer(b.layer + step)
+		case "c":
+			if b.haveRevision() {
+				b.showCombos()
+			}
+		case "t":
+			if b.haveRevision() {
+				b.showTour()
+			}
+		case "h":
+			if b.haveRevision() {
+				err = b.toggleHeatmap()
+			}
+		case "d":
+			if b.haveRevision() {
+				err = b.delete()
+			}
+		default:
+			n, perr := strconv.Atoi(cmd)
+			if perr != nil {
+				fmt.Fprintf(b.out, "unknown command %q: type ? for help\n", cmd)
+				continue
+			}
+			if b.selected == nil {
+				b.selectRevision(n)
+			} else {
+				b.showLayer(n)
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// prompt returns the prompt describing the current selection.
+func (b *browser) prompt() string {
+	if b.selected == nil {
+		return "fkm"
+	}
+	if b.layer < 0 || b.selected.layout == nil {
+		return b.selected.id
+	}
+	return fmt.Sprintf("%s/%s", b.selected.id, b.selected.layout.Revision.Layers[b.layer].Name())
+}
+
+// haveRevision returns whether a revision with layout data is selected,
+// printing a message if it is not.
+func (b *browser) haveRevision() bool {
+	switch {
+	case b.selected == nil:
+		fmt.Fprintln(b.out, "no revision selected: select one by number")
+		return false
+	case b.selected.layout == nil:
+		fmt.Fprintln(b.out, "revision data could not be parsed")
+		return false
+	}
+	return true
+}
+
+// list refreshes and prints the stored revisions.
+func (b *browser) list() error {
+	var err error
+	b.revs, err = storedRevisions(b.db)
+	if err != nil {
+		return err
+	}
+	b.selected = nil
+	b.layer = -1
+	if len(b.revs) == 0 {
+		fmt.Fprintln(b.out, "no revisions stored")
+		return nil
+	}
+	w := tabwriter.NewWriter(b.out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "#\tREVISION\tLAYOUT\tTITLE\tCREATED\tHEATMAP")
+	for i, r := range b.revs {
+		if r.layout == nil {
+			fmt.Fprintf(w, "%d\t%s\t?\t?\t?\t%s\n", i, r.id, onOff(r.heatmap))
+			continue
+		}
+		l := r.layout
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\n", i, r.id, l.Title, l.Revision.Title, l.Revision.CreatedAt, onOff(r.heatmap))
+	}
+	return w.Flush()
+}
+
+// selectRevision selects revision i of the listed revisions.
+func (b *browser) selectRevision(i int) {
+	if i < 0 || i >= len(b.revs) {
+		fmt.Fprintf(b.out, "no revision %d\n", i)
+		return
+	}
+	if l, err := loadLayout(b.db, b.revs[i].id); err == nil {
+		// Pick up any separately stored tour.
+		b.revs[i].layout = l
+	}
+	b.selected = &b.revs[i]
+	b.layer = -1
+	b.showRevision()
+}
+
+// showRevision prints a summary of the selected revision.
+func (b *browser) showRevision() {
+	if !b.haveRevision() {
+		return
+	}
+	l := b.selected.layout
+	fmt.Fprintf(b.out, "%s (%s) revision %s %q created %s\n", l.Title, l.Geometry, l.Revision.HashID, l.Revision.Title, l.Revision.CreatedAt)
+	fmt.Fprintf(b.out, "heatmap %s, %d combos\n", onOff(b.selected.heatmap), len(l.Revision.Combos))
+	for i, ly := range l.Revision.Layers {
+		fmt.Fprintf(b.out, "  %d  %s\n", i, ly.Name())
+	}
+}
+
+// showLayer prints the key assignments of layer i of the selected
+// revision.
+func (b *browser) showLayer(i int) {
+	layers := b.selected.layout.Revision.Layers
+	if i < 0 || i >= len(layers) {
+		fmt.Fprintf(b.out, "no layer %d\n", i)
+		return
+	}
+	b.layer = i
+	ly := layers[i]
+	fmt.Fprintf(b.out, "layer %d: %s\n", i, ly.Name())
+	w := tabwriter.NewWriter(b.out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "KEY\tTAP\tHOLD\tDOUBLE TAP\tTAP-HOLD\tLABEL")
+	for j, k := range ly.Keys {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\n", j, k.Tap, k.Hold, k.DoubleTap, k.TapHold, k.CustomLabel)
+	}
+	w.Flush()
+}
+
+// showCombos prints the combos of the selected revision.
+func (b *browser) showCombos() {
+	combos := b.selected.layout.Revision.Combos
+	if len(combos) == 0 {
+		fmt.Fprintln(b.out, "no combos")
+		return
+	}
+	w := tabwriter.NewWriter(b.out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tLAYER\tKEYS\tTRIGGER")
+	for _, c := range combos {
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\n", c.Name, c.LayerIdx, comboKeys(b.selected.layout, c, nil), strings.TrimPrefix(c.Trigger, "KC_"))
+	}
+	w.Flush()
+}
+
+// comboKeys returns a description of the keys of a combo using the
+// key legends of the combo's layer where they are available, rendered
+// with legends or as key codes if legends is nil.
+func comboKeys(l *layout, c combo, legends *keyLegends) string {
+	var keys []string
+	for _, i := range c.KeyIndices {
+		s := strconv.Itoa(i)
+		if c.LayerIdx >= 0 && c.LayerIdx < len(l.Revision.Layers) {
+			if ks := l.Revision.Layers[c.LayerIdx].Keys; i >= 0 && i < len(ks) {
+				s = fmt.Sprintf("%d:%s", i, legends.key(ks[i]))
+			}
+		}
+		keys = append(keys, s)
+	}
+	return strings.Join(keys, " + ")
+}
+
+// showTour prints the tour of the selected revision.
+func (b *browser) showTour() {
+	t := b.selected.layout.Revision.Tour
+	if t == nil || len(t.Steps) == 0 {
+		fmt.Fprintln(b.out, "no tour")
+		return
+	}
+	for _, s := range t.Steps {
+		fmt.Fprintf(b.out, "step %d", s.Position)
+		if s.Layer != nil {
+			fmt.Fprintf(b.out, " layer %d", s.Layer.Position)
+		}
+		if s.KeyIndex != nil {
+			fmt.Fprintf(b.out, " key %d", *s.KeyIndex)
+		}
+		fmt.Fprintln(b.out)
+		for _, text := range []string{s.Intro, s.Content, s.Outro} {
+			if text != "" {
+				fmt.Fprintf(b.out, "  %s\n", text)
+			}
+		}
+	}
+}
+
+// toggleHeatmap toggles keymapp's heatmap recording for the selected
+// revision.
+func (b *browser) toggleHeatmap() error {
+	err := b.guard.check(context.Background(), b.db)
+	if err != nil {
+		fmt.Fprintln(b.out, err)
+		return nil
+	}
+	enabled := !b.selected.heatmap
+	err = apply(b.db, []change{setHeatmap(b.selected.id, enabled)})
+	if err != nil {
+		return err
+	}
+	b.selected.heatmap = enabled
+	fmt.Fprintf(b.out, "heatmap %s\n", onOff(enabled))
+	return nil
+}
+
+// delete deletes the selected revision after confirmation.
+func (b *browser) delete() error {
+	fmt.Fprintf(b.out, "delete revision %s? [y/N] ", b.selected.id)
+	if !b.in.Scan() {
+		return b.in.Err()
+	}
+	if answer := strings.TrimSpace(b.in.Text()); answer != "y" && answer != "yes" {
+		return nil
+	}
+	err := b.guard.check(context.Background(), b.db)
+	if err != nil {
+		fmt.Fprintln(b.out, err)
+		return nil
+	}
+	err = apply(b.db, deleteRevision(b.selected.id, b.selected.layout))
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(b.out, "deleted %s\n", b.selected.id)
+	return b.list()
+}
+
+// onOff returns "on" if v is true and "off" otherwise.
+func onOff(v bool) string {
+	if v {
+		return "on"
+	}
+	return "off"
+}
@@ -0,0 +1,392 @@
+// Copyright ©2025 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// update fetches the layout specified on the command line and
+// stores it in the database, or in each database given by repeated
+// -path flags and a fleet manifest.
+func update() {
+	var (
+		common  commonFlags
+		net     netFlags
+		guard   writeGuard
+		filters revisionFilters
+		prov    provenanceFlags
+	)
+	common.multiPath = true
+	common.register(flag.CommandLine)
+	net.register(flag.CommandLine)
+	guard.register(flag.CommandLine)
+	filters.register(flag.CommandLine)
+	prov.register(flag.CommandLine)
+	addr := flag.String("layout", "", "link to configure.zsa.io page for layout, or - to read links from standard input, one per line")
+	geometry := flag.String("geometry", "", "layout keyboard geometry, used with -hash-id (default the geometry of the attached keyboard)")
+	hashID := flag.String("hash-id", "", "layout hash ID, used instead of -layout")
+	revID := flag.String("revision", "latest", "layout revision ID, used with -hash-id")
+	revFile := flag.String("revision-file", "", "file holding layout revision data to use instead of fetching it")
+	mkDir := flag.Bool("mkdir", true, "create config directory")
+	force := flag.Bool("force", false, "replace stored revisions and keymapp config values that differ from those being written")
+	dryRun := flag.Bool("dry-run", false, "print the changes that would be made to the database without making them")
+	verifyMD5 := flag.Bool("verify-md5", false, "fail if the revision config does not match its md5 checksum instead of warning")
+	cacheDir := flag.String("cache-dir", defaultCacheDir(), "directory for cached downloads (empty to disable caching)")
+	metadataMaxAge := flag.Duration("metadata-max-age", 24*time.Hour, "age below which cached metadata is used without checking for updates")
+	backupDir := flag.String("backup-dir", "", "directory to back up the database to before changing it")
+	historyDir := flag.String("history-dir", "", "git repository to commit the fetched revision's JSON to after updating the database")
+	refreshMetadata := flag.Bool("refresh-metadata", false, "fetch metadata even if the cached copy is current")
+	jobs := flag.Int("jobs", defaultJobs, "maximum number of layouts to fetch concurrently when reading links from standard input")
+	fleet := flag.String("fleet", "", "path of a YAML manifest listing keymapp databases to write, in addition to those given by -path if it is set")
+	detect := flag.Bool("detect-keyboard", true, "detect attached ZSA keyboards to default -geometry and warn if the layout is for another keyboard")
+	flag.Parse()
+	common.setup(flag.CommandLine)
+	if *hashID != "" && *geometry == "" && *detect {
+		*geometry = attachedGeometry()
+	}
+	var sources int
+	for _, s := range []string{*addr, *hashID, *revFile} {
+		if s != "" {
+			sources++
+		}
+	}
+	if sources != 1 || (net.cannotFetch() && *revFile == "") || (*hashID != "" && *geometry == "") {
+		fmt.Fprintln(flag.CommandLine.Output(), "exactly one of -layout, -hash-id with -geometry or an attached keyboard, or -revision-file is required; -offline requires -revision-file or -replay")
+		flag.Usage()
+		os.Exit(exitUsage)
+	}
+
+	ctx := context.Background()
+	cli := net.client()
+
+	meta, err := metadataCache{
+		dir:     *cacheDir,
+		maxAge:  *metadataMaxAge,
+		refresh: *refreshMetadata,
+		offline: net.cannotFetch(),
+	}.get(ctx, cli, net.metadataURL)
+	if err != nil {
+		fatal("failed to collect metadata", err)
+	}
+
+	var revs []fetchedRevision
+	if *revFile != "" {
+		id, rev, err := revisionFile(*revFile)
+		if err != nil {
+			fatal("failed to collect revision data", err)
+		}
+		r := prepareRevision(id, rev, filters, *verifyMD5, *detect)
+		r.prov = prov.provenance(fileSource(*revFile))
+		revs = append(revs, r)
+	} else {
+		refs := []layoutRef{{geometry: *geometry, hashID: *hashID, revision: *revID}}
+		sources := []string{refSource(net.graphqlURL, refs[0])}
+		if *addr != "" {
+			addrs := []string{*addr}
+			if *addr == "-" {
+				addrs, err = readLayoutURLs(os.Stdin)
+				if err != nil {
+					fatal("failed to read layout links", err)
+				}
+				if len(addrs) == 0 {
+					fatal("failed to read layout links", usageError("no layout links on standard input"))
+				}
+			}
+			refs, sources = make([]layoutRef, len(addrs)), addrs
+		}
+		type fetched struct {
+			id  string
+			rev *spool
+		}
+		results := make([]fetched, len(refs))
+		p := common.progress("fetching layouts", len(refs))
+		cli.received = p.received
+		err = forEach(len(refs), *jobs, func(i int) error {
+			defer p.step()
+			if *addr != "" {
+				ref, err := resolveLayoutURL(ctx, cli, sources[i])
+				if err != nil {
+					return fmt.Errorf("layout %s: %w", sources[i], err)
+				}
+				refs[i] = ref
+			}
+			ref := refs[i]
+			// URLs without a geometry leave it to be resolved by
+			// the API.
+			if ref.geometry != "" {
+				err := checkGeometry(meta.data, ref.geometry)
+				if err != nil {
+					return fmt.Errorf("layout %s: %w", sources[i], err)
+				}
+			}
+			id, rev, err := objectCache{dir: *cacheDir}.fetchRevision(ctx, cli, net.graphqlURL, ref)
+			if err != nil {
+				return fmt.Errorf("layout %s: %w", sources[i], err)
+			}
+			// Keep the fetched data out of memory until
+			// the whole batch has been fetched.
+			spooled, err := spoolBytes(rev)
+			if err != nil {
+				return fmt.Errorf("layout %s: %w", sources[i], err)
+			}
+			results[i] = fetched{id: id, rev: spooled}
+			return nil
+		})
+		p.finish()
+		cli.received = nil
+		if err != nil {
+			fatal("failed to collect revision data", err)
+		}
+		for i, f := range results {
+			rev, err := f.rev.bytes()
+			f.rev.close()
+			if err != nil {
+				fatal("failed to collect revision data", err, "revision", f.id)
+			}
+			if slices.ContainsFunc(revs, func(r fetchedRevision) bool { return r.id == f.id }) {
+				slog.Info("skipping repeated revision", "revision", f.id)
+				continue
+			}
+			r := prepareRevision(f.id, rev, filters, *verifyMD5, *detect)
+			r.prov = prov.provenance(sources[i])
+			revs = append(revs, r)
+		}
+	}
+
+	targets := []dbTarget{{path: common.dbPath}}
+	for _, p := range common.dbPaths {
+		targets = append(targets, dbTarget{path: p})
+	}
+	if *fleet != "" {
+		fleetTargets, err := readFleet(*fleet)
+		if err != nil {
+			fatal("failed to read fleet manifest", err, "path", *fleet)
+		}
+		if !isSet(flag.CommandLine, "path") {
+			targets = targets[:0]
+		}
+		targets = append(targets, fleetTargets...)
+	}
+	err = checkTargets(targets)
+	if err != nil {
+		fatal("invalid database targets", usageError(err.Error()))
+	}
+
+	var (
+		pending []dbTarget
+		stored  []fetchedRevision
+	)
+	for _, t := range targets {
+		t.db = openTarget(t.path, *dryRun, *mkDir, common.db)
+		if t.db != nil {
+			defer t.db.Close()
+		}
+		storing, changes := targetChanges(t.db, t.path, common.keymappConfig, meta, revs, *force)
+		if len(storing) == 0 {
+			continue
+		}
+		for _, r := range storing {
+			if !slices.ContainsFunc(stored, func(s fetchedRevision) bool { return s.id == r.id }) {
+				stored = append(stored, r)
+			}
+		}
+		t.changes = changes
+		pending = append(pending, t)
+	}
+	if len(pending) == 0 {
+		return
+	}
+
+	if *dryRun {
+		for _, t := range pending {
+			printChanges(t.path, t.changes)
+		}
+		return
+	}
+	for _, t := range pending {
+		err = guard.check(ctx, t.db)
+		if err != nil {
+			fatal("refusing to update db", err, "path", t.path)
+		}
+	}
+	if *backupDir != "" {
+		for _, t := range pending {
+			if len(t.changes) == 0 {
+				continue
+			}
+			dir := *backupDir
+			if len(targets) > 1 {
+				// Keep the backups of each target apart.
+				dir = filepath.Join(dir, t.name)
+			}
+			err = autoBackup(t.db, dir)
+			if err != nil {
+				fatal("failed to back up db", err, "path", t.path)
+			}
+		}
+	}
+	if len(pending) == 1 {
+		err = apply(pending[0].db, pending[0].changes)
+		if err != nil {
+			fatal("failed to update db", err, "path", pending[0].path)
+		}
+	} else {
+		err = applyAll(pending)
+		if err != nil {
+			fatal("failed to update databases", err)
+		}
+	}
+	if *historyDir == "" {
+		return
+	}
+	for _, r := range stored {
+		data, err := r.rev.bytes()
+		if err == nil {
+			err = layoutHistory{dir: *historyDir}.add(data)
+		}
+		if err != nil {
+			fatal("failed to record layout history", err, "path", *historyDir)
+		}
+	}
+}
+
+// openTarget opens the database at path for update, read-only if
+// dryRun is true, creating its directory if mkDir is true. It exits on
+// failure. The returned database may be nil for a dry run against a
+// database that does not exist.
+func openTarget(path string, dryRun, mkDir bool, opts dbOptions) *sql.DB {
+	var (
+		db  *sql.DB
+		err error
+	)
+	if dryRun {
+		db, err = openDBReadOnly(path, opts)
+	} else {
+		if mkDir {
+			err = os.MkdirAll(filepath.Dir(path), 0o750)
+			if err != nil {
+				fatal("unable to create config directory", err, "path", filepath.Dir(path))
+			}
+		}
+		db, err = openDB(path, opts)
+	}
+	if err != nil {
+		fatal("failed to open db", err, "path", path)
+	}
+	slog.Info("opened db", "path", path, "read_only", dryRun)
+	return db
+}
+
+// targetChanges returns the revisions to store in the database at
+// path, omitting revisions of layouts pinned in db to another
+// revision, and the changes needed to store them along with the
+// keymapp configuration defaults in config and the metadata. It exits
+// on failure.
+func targetChanges(db *sql.DB, path string, config map[string]string, meta fetchedMetadata, revs []fetchedRevision, force bool) (stored []fetchedRevision, changes []change) {
+	pinned, err := pinnedRevisions(db)
+	if err != nil {
+		fatal("failed to read pinned layouts", err, "path", path)
+	}
+
+	changes, err = configChanges(db, config, force)
+	if err != nil {
+		fatal("failed to check config", err, "path", path)
+	}
+	c, ok, err := storeMetadata(db, meta)
+	if err != nil {
+		fatal("failed to check metadata", err, "path", path)
+	}
+	if ok {
+		changes = append(changes, c)
+	}
+	for _, r := range revs {
+		data, err := r.rev.bytes()
+		if err != nil {
+			fatal("failed to read revision", err, "revision", r.id)
+		}
+		if len(pinned) != 0 {
+			l, err := parseLayout(data)
+			if err != nil {
+				fatal("failed to parse revision", err, "revision", r.id)
+			}
+			if pin, ok := pinned[l.HashID]; ok && pin != r.id {
+				slog.Warn("layout is pinned: not storing revision", "layout", l.HashID, "revision", r.id, "pinned", pin, "path", path)
+				continue
+			}
+		}
+		revChanges, err := revisionChanges(db, r.id, r.sum, data, force, r.prov)
+		if err != nil {
+			fatal("failed to check revision", err, "revision", r.id, "path", path)
+		}
+		changes = append(changes, revChanges...)
+		changes = append(changes, r.tour...)
+		stored = append(stored, r)
+	}
+	return stored, changes
+}
+
+// fetchedRevision is a revision to be stored by update.
+type fetchedRevision struct {
+	id   string
+	sum  string
+	rev  *spool   // revision data after filtering
+	tour []change // changes made by the tour filter
+	prov provenance
+}
+
+// prepareRevision checks and filters the revision data rev, exiting
+// on failure.
+func prepareRevision(id string, rev []byte, filters revisionFilters, verifyMD5, detect bool) fetchedRevision {
+	sum, err := checkMD5(rev)
+	if err != nil {
+		if verifyMD5 {
+			fatal("failed to verify revision", err, "revision", id)
+		}
+		slog.Warn("failed to verify revision", "error", err, "revision", id)
+	}
+	filtered, tour, err := filters.apply(id, rev)
+	if err != nil {
+		fatal("failed to filter revision", err, "revision", id)
+	}
+	if detect {
+		l, err := parseLayout(filtered)
+		if err != nil {
+			fatal("failed to parse revision", err, "revision", id)
+		}
+		warnUnattached(l.Geometry)
+	}
+	spooled, err := spoolBytes(filtered)
+	if err != nil {
+		fatal("failed to hold revision", err, "revision", id)
+	}
+	return fetchedRevision{id: id, sum: sum, rev: spooled, tour: tour}
+}
+
+// readLayoutURLs returns the layout links read from r, one per line.
+// Blank lines and lines starting with '#' are ignored.
+func readLayoutURLs(r io.Reader) ([]string, error) {
+	var urls []string
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		urls = append(urls, line)
+	}
+	return urls, sc.Err()
+}
@@ -0,0 +1,295 @@
+// Copyright ©2025 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// mainArgsEnv is the environment variable holding the JSON-encoded
+// command line arguments of fkm when the test binary is run as fkm.
+const mainArgsEnv = "GO_TEST_FKM_ARGS"
+
+func TestMain(m *testing.M) {
+	if env, ok := os.LookupEnv(mainArgsEnv); ok {
+		var args []string
+		err := json.Unmarshal([]byte(env), &args)
+		if err != nil {
+			fatal("invalid test arguments", err)
+		}
+		os.Args = append([]string{"fkm"}, args...)
+		main()
+		os.Exit(exitOK)
+	}
+	slog.SetDefault(slog.New(slog.DiscardHandler))
+	os.Exit(m.Run())
+}
+
+// runFKM runs fkm with the given arguments in a new process, returning
+// its exit status and combined output. The fkm configuration file and
+// environment are not used.
+func runFKM(t *testing.T, args ...string) (int, string) {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], "-test.run=^$")
+	for _, e := range os.Environ() {
+		if !strings.HasPrefix(e, "FKM_") {
+			cmd.Env = append(cmd.Env, e)
+		}
+	}
+	flags := []string{"-config="}
+	if len(args) != 0 {
+		if _, ok := commands[args[0]]; ok {
+			flags = []string{args[0], "-config="}
+			args = args[1:]
+		}
+	}
+	env, err := json.Marshal(append(flags, args...))
+	if err != nil {
+		t.Fatalf("failed to encode arguments: %v", err)
+	}
+	cmd.Env = append(cmd.Env, mainArgsEnv+"="+string(env))
+	out, err := cmd.CombinedOutput()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode(), string(out)
+	}
+	if err != nil {
+		t.Fatalf("failed to run fkm: %v", err)
+	}
+	return exitOK, string(out)
+}
+
+// replayFlags are the flags for running update against the exchanges
+// in testdata/replay, recorded from a local test server.
+var replayFlags = []string{
+	"-replay", filepath.Join("testdata", "replay"),
+	"-graphql-url", "http://127.0.0.1:8787/graphql",
+	"-metadata-url", "http://127.0.0.1:8787/metadata.json",
+	"-cache-dir=",
+	"-detect-keyboard=false",
+}
+
+func TestUpdateReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keymapp.sqlite3")
+	status, out := runFKM(t, append(replayFlags,
+		"-path", path,
+		"-layout", "https://configure.zsa.io/voyager/layouts/abc/latest",
+	)...)
+	if status != exitOK {
+		t.Fatalf("unexpected exit status: got %d want %d\n%s", status, exitOK, out)
+	}
+
+	db, err := openDBReadOnly(path, dbOptions{})
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	if db == nil {
+		t.Fatal("database was not created")
+	}
+	defer db.Close()
+	id, err := trackedRevision(db, "abc")
+	if err != nil {
+		t.Fatalf("failed to read tracked revision: %v", err)
+	}
+	if id == "" {
+		t.Fatal("layout is not tracked")
+	}
+	l, err := loadLayout(db, id)
+	if err != nil {
+		t.Fatalf("failed to load revision %s: %v", id, err)
+	}
+	if l.HashID != "abc" || l.Geometry != "voyager" {
+		t.Errorf("unexpected layout: got hashId=%s geometry=%s want hashId=abc geometry=voyager", l.HashID, l.Geometry)
+	}
+	meta, err := loadMetadata(db)
+	if err != nil {
+		t.Fatalf("failed to load metadata: %v", err)
+	}
+	if !strings.Contains(string(meta), `"voyager"`) {
+		t.Errorf("unexpected metadata: %s", meta)
+	}
+	data, err := loadRevision(db, id)
+	if err != nil {
+		t.Fatalf("failed to load revision %s: %v", id, err)
+	}
+	var sum sql.NullString
+	err = db.QueryRow(`SELECT sha256 FROM fkm_checksum WHERE revisionId=?`, id).Scan(&sum)
+	if err != nil {
+		t.Fatalf("failed to read checksum: %v", err)
+	}
+	err = verifyRevision(data, sum)
+	if err != nil {
+		t.Errorf("stored revision failed verification: %v", err)
+	}
+}
+
+func TestUpdateReplayNotRecorded(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keymapp.sqlite3")
+	status, out := runFKM(t, append(replayFlags,
+		"-path", path,
+		"-layout", "https://configure.zsa.io/voyager/layouts/unknown/latest",
+	)...)
+	if status != exitNetwork {
+		t.Errorf("unexpected exit status: got %d want %d\n%s", status, exitNetwork, out)
+	}
+	if _, err := os.Stat(path); err == nil {
+		db, err := openDBReadOnly(path, dbOptions{})
+		if err != nil {
+			t.Fatalf("failed to open db: %v", err)
+		}
+		defer db.Close()
+		n, err := rowCount(db, "revision", "")
+		if err != nil {
+			t.Fatalf("failed to count revisions: %v", err)
+		}
+		if n != 0 {
+			t.Errorf("unexpected stored revisions: got %d want 0", n)
+		}
+	}
+}
+
+func TestUpdateReplaySwappedExchange(t *testing.T) {
+	// Serve the metadata exchange under the name of the layout
+	// request's exchange.
+	dir := t.TempDir()
+	src := filepath.Join("testdata", "replay")
+	meta, err := os.ReadFile(filepath.Join(src, exchangeName("GET", "http://127.0.0.1:8787/metadata.json", nil)))
+	if err != nil {
+		t.Fatalf("failed to read recording: %v", err)
+	}
+	entries, err := os.ReadDir(src)
+	if err != nil {
+		t.Fatalf("failed to read recordings: %v", err)
+	}
+	for _, e := range entries {
+		err = os.WriteFile(filepath.Join(dir, e.Name()), meta, 0o600)
+		if err != nil {
+			t.Fatalf("failed to write recording: %v", err)
+		}
+	}
+
+	path := filepath.Join(t.TempDir(), "keymapp.sqlite3")
+	args := append([]string{}, replayFlags...)
+	args[1] = dir
+	status, out := runFKM(t, append(args,
+		"-path", path,
+		"-layout", "https://configure.zsa.io/voyager/layouts/abc/latest",
+	)...)
+	if status != exitVerify {
+		t.Errorf("unexpected exit status: got %d want %d\n%s", status, exitVerify, out)
+	}
+	if !strings.Contains(out, errExchangeMismatch.Error()) {
+		t.Errorf("missing mismatch error in output:\n%s", out)
+	}
+}
+
+func TestUpdateReplaySigned(t *testing.T) {
+	keys := t.TempDir()
+	secret, public := writeTestKeys(t, keys, "fkm")
+	key, err := loadSigningKey(secret)
+	if err != nil {
+		t.Fatalf("failed to load signing key: %v", err)
+	}
+	dir := t.TempDir()
+	src := filepath.Join("testdata", "replay")
+	entries, err := os.ReadDir(src)
+	if err != nil {
+		t.Fatalf("failed to read recordings: %v", err)
+	}
+	for _, e := range entries {
+		b, err := os.ReadFile(filepath.Join(src, e.Name()))
+		if err != nil {
+			t.Fatalf("failed to read recording: %v", err)
+		}
+		path := filepath.Join(dir, e.Name())
+		err = os.WriteFile(path, b, 0o600)
+		if err != nil {
+			t.Fatalf("failed to write recording: %v", err)
+		}
+		err = signFile(path, key)
+		if err != nil {
+			t.Fatalf("failed to sign recording: %v", err)
+		}
+	}
+	args := append([]string{}, replayFlags...)
+	args[1] = dir
+	args = append(args,
+		"-replay-verify-key", public,
+		"-layout", "https://configure.zsa.io/voyager/layouts/abc/latest",
+	)
+
+	status, out := runFKM(t, append(args, "-path", filepath.Join(t.TempDir(), "keymapp.sqlite3"))...)
+	if status != exitOK {
+		t.Fatalf("unexpected exit status: got %d want %d\n%s", status, exitOK, out)
+	}
+
+	// Change a recording without signing it again.
+	path := filepath.Join(dir, entries[0].Name())
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read recording: %v", err)
+	}
+	err = os.WriteFile(path, append(b, '\n'), 0o600)
+	if err != nil {
+		t.Fatalf("failed to write recording: %v", err)
+	}
+	status, out = runFKM(t, append(args, "-path", filepath.Join(t.TempDir(), "keymapp.sqlite3"))...)
+	if status != exitVerify {
+		t.Errorf("unexpected exit status for modified recording: got %d want %d\n%s", status, exitVerify, out)
+	}
+	if !strings.Contains(out, errBadSignature.Error()) {
+		t.Errorf("missing signature error in output:\n%s", out)
+	}
+}
+
+func TestUpdateReplayScrubHistory(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not available")
+	}
+	path := filepath.Join(t.TempDir(), "keymapp.sqlite3")
+	history := t.TempDir()
+	status, out := runFKM(t, append(replayFlags,
+		"-path", path,
+		"-layout", "https://configure.zsa.io/voyager/layouts/abc/latest",
+		"-scrub-pii",
+		"-tour", "strip",
+		"-history-dir", history,
+	)...)
+	if status != exitOK {
+		t.Fatalf("unexpected exit status: got %d want %d\n%s", status, exitOK, out)
+	}
+
+	committed, err := exec.Command("git", "-C", history, "show", "HEAD:abc.json").Output()
+	if err != nil {
+		t.Fatalf("failed to read committed layout: %v", err)
+	}
+	for _, leaked := range []string{"Some One", "https://example.com/p.png"} {
+		if strings.Contains(string(committed), leaked) {
+			t.Errorf("committed layout contains scrubbed author detail %q", leaked)
+		}
+	}
+	var l struct {
+		Layout struct {
+			Revision struct {
+				Tour json.RawMessage `json:"tour"`
+			} `json:"revision"`
+		} `json:"layout"`
+	}
+	err = json.Unmarshal(committed, &l)
+	if err != nil {
+		t.Fatalf("failed to parse committed layout: %v", err)
+	}
+	if tour := l.Layout.Revision.Tour; len(tour) != 0 && string(tour) != "null" {
+		t.Errorf("committed layout contains stripped tour: %s", tour)
+	}
+}
@@ -0,0 +1,72 @@
+// Copyright ©2025 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"log/slog"
+	"slices"
+)
+
+// usbDevice is the vendor and product ID of an attached USB device.
+type usbDevice struct {
+	vendor, product uint32
+}
+
+// attachedGeometries returns the sorted geometries of the ZSA keyboards
+// attached by USB.
+func attachedGeometries() ([]string, error) {
+	devs, err := usbDevices()
+	if err != nil {
+		return nil, err
+	}
+	var geoms []string
+	for _, d := range devs {
+		if d.vendor != zsaVendorID {
+			continue
+		}
+		for g, p := range zsaProductIDs {
+			if p == d.product && !slices.Contains(geoms, g) {
+				geoms = append(geoms, g)
+			}
+		}
+	}
+	slices.Sort(geoms)
+	return geoms, nil
+}
+
+// attachedGeometry returns the geometry of the attached ZSA keyboards,
+// or the empty string if they do not share exactly one geometry.
+func attachedGeometry() string {
+	geoms, err := attachedGeometries()
+	if err != nil {
+		slog.Debug("failed to detect keyboards", "error", err)
+		return ""
+	}
+	if len(geoms) != 1 {
+		slog.Debug("no single keyboard geometry attached", "attached", geoms)
+		return ""
+	}
+	slog.Info("detected keyboard", "geometry", geoms[0])
+	return geoms[0]
+}
+
+// warnUnattached logs a warning if ZSA keyboards are attached but none
+// of them has the geometry geom. If no keyboard is attached, the
+// layout may be for a keyboard that is not yet plugged in, so only an
+// info message is logged.
+func warnUnattached(geom string) {
+	geoms, err := attachedGeometries()
+	if err != nil {
+		slog.Debug("failed to detect keyboards", "error", err)
+		return
+	}
+	switch {
+	case slices.Contains(geoms, geom):
+	case len(geoms) == 0:
+		slog.Info("no ZSA keyboard is attached", "geometry", geom)
+	default:
+		slog.Warn("layout is not for an attached keyboard", "geometry", geom, "attached", geoms)
+	}
+}
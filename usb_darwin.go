@@ -0,0 +1,57 @@
+// Copyright ©2025 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// usbDevices returns the attached USB devices listed in the IOUSB
+// plane of the I/O Registry.
+func usbDevices() ([]usbDevice, error) {
+	out, err := exec.Command("ioreg", "-p", "IOUSB", "-l", "-w0").Output()
+	if err != nil {
+		return nil, err
+	}
+	var (
+		devs []usbDevice
+		dev  usbDevice
+	)
+	flush := func() {
+		if dev.vendor != 0 {
+			devs = append(devs, dev)
+		}
+		dev = usbDevice{}
+	}
+	sc := bufio.NewScanner(bytes.NewReader(out))
+	for sc.Scan() {
+		line := strings.TrimLeft(sc.Text(), " |")
+		if strings.HasPrefix(line, "+-o ") {
+			// Start of a new device.
+			flush()
+			continue
+		}
+		key, val, ok := strings.Cut(line, " = ")
+		if !ok {
+			continue
+		}
+		id, err := strconv.ParseUint(val, 10, 16)
+		if err != nil {
+			continue
+		}
+		switch key {
+		case `"idVendor"`:
+			dev.vendor = uint32(id)
+		case `"idProduct"`:
+			dev.product = uint32(id)
+		}
+	}
+	flush()
+	return devs, sc.Err()
+}
@@ -0,0 +1,44 @@
+// Copyright ©2025 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// usbDevices returns the attached USB devices listed in sysfs.
+func usbDevices() ([]usbDevice, error) {
+	paths, err := filepath.Glob("/sys/bus/usb/devices/*/idVendor")
+	if err != nil {
+		return nil, err
+	}
+	var devs []usbDevice
+	for _, p := range paths {
+		vendor, err := readHexID(p)
+		if err != nil {
+			// The device may have been removed.
+			continue
+		}
+		product, err := readHexID(filepath.Join(filepath.Dir(p), "idProduct"))
+		if err != nil {
+			continue
+		}
+		devs = append(devs, usbDevice{vendor: vendor, product: product})
+	}
+	return devs, nil
+}
+
+// readHexID reads a hexadecimal USB ID from a sysfs file.
+func readHexID(path string) (uint32, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	id, err := strconv.ParseUint(string(bytes.TrimSpace(b)), 16, 16)
+	return uint32(id), err
+}
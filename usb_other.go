@@ -0,0 +1,15 @@
+// Copyright ©2025 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !linux && !darwin && !windows
+
+package main
+
+import "errors"
+
+// usbDevices returns the attached USB devices. USB device detection is
+// not supported on this platform.
+func usbDevices() ([]usbDevice, error) {
+	return nil, errors.New("USB device detection is not supported on this platform")
+}
@@ -0,0 +1,32 @@
+// Copyright ©2025 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"os/exec"
+	"regexp"
+	"strconv"
+)
+
+// usbInstanceID matches the vendor and product IDs in a USB device
+// instance ID.
+var usbInstanceID = regexp.MustCompile(`(?i)\\VID_([0-9A-F]{4})&PID_([0-9A-F]{4})`)
+
+// usbDevices returns the attached USB devices known to the Plug and
+// Play manager.
+func usbDevices() ([]usbDevice, error) {
+	out, err := exec.Command("powershell", "-NoProfile", "-NonInteractive", "-Command",
+		"Get-PnpDevice -PresentOnly -Class USB,HIDClass,Keyboard | ForEach-Object InstanceId").Output()
+	if err != nil {
+		return nil, err
+	}
+	var devs []usbDevice
+	for _, m := range usbInstanceID.FindAllSubmatch(out, -1) {
+		vendor, _ := strconv.ParseUint(string(m[1]), 16, 16)
+		product, _ := strconv.ParseUint(string(m[2]), 16, 16)
+		devs = append(devs, usbDevice{vendor: uint32(vendor), product: uint32(product)})
+	}
+	return devs, nil
+}
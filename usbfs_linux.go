@@ -0,0 +1,181 @@
+// Copyright ©2025 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// usbfsDevice is a USB device opened through Linux usbfs.
+type usbfsDevice struct {
+	f     *os.File
+	iface int
+}
+
+// openUSB opens the first attached device with the vendor and product
+// IDs and claims the interface. If detach is true, any kernel driver
+// bound to the interface is detached first.
+func openUSB(vendor, product uint32, iface int, detach bool) (usbConn, error) {
+	path, err := usbfsPath(vendor, product)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		if errors.Is(err, os.ErrPermission) {
+			return nil, fmt.Errorf("%w: install ZSA's udev rules to allow access to the keyboard", err)
+		}
+		return nil, err
+	}
+	d := &usbfsDevice{f: f, iface: iface}
+	if detach {
+		cmd := usbdevfsIoctl{ifno: int32(iface), code: int32(usbdevfsDisconnect)}
+		err = d.ioctl(usbdevfsIoctlCmd, unsafe.Pointer(&cmd))
+		// ENODATA is returned when no driver is bound.
+		if err != nil && !errors.Is(err, syscall.ENODATA) {
+			f.Close()
+			return nil, fmt.Errorf("failed to detach kernel driver: %w", err)
+		}
+	}
+	n := uint32(iface)
+	err = d.ioctl(usbdevfsClaimInterface, unsafe.Pointer(&n))
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to claim interface %d: %w", iface, err)
+	}
+	return d, nil
+}
+
+// usbfsPath returns the usbfs device file of the first attached device
+// with the vendor and product IDs.
+func usbfsPath(vendor, product uint32) (string, error) {
+	paths, err := filepath.Glob("/sys/bus/usb/devices/*/idVendor")
+	if err != nil {
+		return "", err
+	}
+	for _, p := range paths {
+		dir := filepath.Dir(p)
+		v, err := readHexID(p)
+		if err != nil || v != vendor {
+			continue
+		}
+		pr, err := readHexID(filepath.Join(dir, "idProduct"))
+		if err != nil || pr != product {
+			continue
+		}
+		bus, err := readDecimal(filepath.Join(dir, "busnum"))
+		if err != nil {
+			continue
+		}
+		dev, err := readDecimal(filepath.Join(dir, "devnum"))
+		if err != nil {
+			continue
+		}
+		return fmt.Sprintf("/dev/bus/usb/%03d/%03d", bus, dev), nil
+	}
+	return "", fmt.Errorf("no device %04x:%04x attached", vendor, product)
+}
+
+// readDecimal reads a decimal integer from a sysfs file.
+func readDecimal(path string) (int, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(string(bytes.TrimSpace(b)))
+}
+
+// usbfs ioctl requests, using the generic ioctl encoding.
+var (
+	usbdevfsControl          = ioc(3, 0, unsafe.Sizeof(usbdevfsCtrlTransfer{}))
+	usbdevfsClaimInterface   = ioc(2, 15, unsafe.Sizeof(uint32(0)))
+	usbdevfsReleaseInterface = ioc(2, 16, unsafe.Sizeof(uint32(0)))
+	usbdevfsIoctlCmd         = ioc(3, 18, unsafe.Sizeof(usbdevfsIoctl{}))
+	usbdevfsDisconnect       = ioc(0, 22, 0)
+)
+
+// ioc returns the usbfs ioctl request number for the direction, number
+// and argument size.
+func ioc(dir, nr, size uintptr) uintptr {
+	return dir<<30 | size<<16 | 'U'<<8 | nr
+}
+
+// usbdevfsCtrlTransfer is struct usbdevfs_ctrltransfer.
+type usbdevfsCtrlTransfer struct {
+	reqType uint8
+	req     uint8
+	value   uint16
+	index   uint16
+	length  uint16
+	timeout uint32 // ms
+	data    unsafe.Pointer
+}
+
+// usbdevfsIoctl is struct usbdevfs_ioctl.
+type usbdevfsIoctl struct {
+	ifno int32
+	code int32
+	data unsafe.Pointer
+}
+
+func (d *usbfsDevice) control(reqType, req uint8, value, index uint16, data []byte, timeout time.Duration) (int, error) {
+	var pin runtime.Pinner
+	defer pin.Unpin()
+	t := usbdevfsCtrlTransfer{
+		reqType: reqType,
+		req:     req,
+		value:   value,
+		index:   index,
+		length:  uint16(len(data)),
+		timeout: uint32(timeout.Milliseconds()),
+	}
+	if len(data) != 0 {
+		pin.Pin(&data[0])
+		t.data = unsafe.Pointer(&data[0])
+	}
+	n, _, errno := syscall.Syscall(syscall.SYS_IOCTL, d.f.Fd(), usbdevfsControl, uintptr(unsafe.Pointer(&t)))
+	if errno != 0 {
+		return 0, errno
+	}
+	return int(n), nil
+}
+
+func (d *usbfsDevice) dfuTransferSize() (int, error) {
+	// Reading the device file returns the device descriptor
+	// followed by the configuration descriptors.
+	_, err := d.f.Seek(0, io.SeekStart)
+	if err != nil {
+		return 0, err
+	}
+	desc, err := io.ReadAll(d.f)
+	if err != nil {
+		return 0, err
+	}
+	return dfuTransferSize(desc)
+}
+
+func (d *usbfsDevice) ioctl(req uintptr, arg unsafe.Pointer) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, d.f.Fd(), req, uintptr(arg))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+func (d *usbfsDevice) Close() error {
+	n := uint32(d.iface)
+	d.ioctl(usbdevfsReleaseInterface, unsafe.Pointer(&n))
+	return d.f.Close()
+}
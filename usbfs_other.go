@@ -0,0 +1,16 @@
+// Copyright ©2025 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !linux
+
+package main
+
+import "errors"
+
+// openUSB opens the first attached device with the vendor and product
+// IDs and claims the interface. Direct USB access is not supported on
+// this platform.
+func openUSB(vendor, product uint32, iface int, detach bool) (usbConn, error) {
+	return nil, errors.New("USB device access is not supported on this platform")
+}
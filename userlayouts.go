@@ -0,0 +1,295 @@
+// Copyright ©2025 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+// userLayouts lists the public layouts of an Oryx user and optionally
+// stores the latest revision of each of them.
+func userLayouts(args []string) {
+	fs := flag.NewFlagSet("fkm user-layouts", flag.ExitOnError)
+	var (
+		common  commonFlags
+		net     netFlags
+		guard   writeGuard
+		filters revisionFilters
+		prov    provenanceFlags
+	)
+	common.register(fs)
+	net.register(fs)
+	guard.register(fs)
+	filters.register(fs)
+	prov.register(fs)
+	geometry := fs.String("geometry", "", "only list layouts for this keyboard geometry")
+	format := fs.String("format", "text", "output format (text or json)")
+	doImport := fs.Bool("import", false, "store the latest revision of each listed layout")
+	jobs := fs.Int("jobs", defaultJobs, "maximum number of layouts to fetch concurrently")
+	mkDir := fs.Bool("mkdir", true, "create config directory")
+	force := fs.Bool("force", false, "replace stored revisions and keymapp config values that differ from those being written")
+	dryRun := fs.Bool("dry-run", false, "print the changes that would be made to the database without making them")
+	verifyMD5 := fs.Bool("verify-md5", false, "fail if the revision config does not match its md5 checksum instead of warning")
+	cacheDir := fs.String("cache-dir", defaultCacheDir(), "directory for cached downloads (empty to disable caching)")
+	metadataMaxAge := fs.Duration("metadata-max-age", 24*time.Hour, "age below which cached metadata is used without checking for updates")
+	backupDir := fs.String("backup-dir", "", "directory to back up the database to before changing it")
+	historyDir := fs.String("history-dir", "", "git repository to commit imported revisions' JSON to after storing them")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: fkm user-layouts [flags] <username-or-hashId>\n\nFlags:\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	common.setup(fs)
+	if common.json {
+		*format = "json"
+	}
+	if fs.NArg() != 1 || *jobs < 1 {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+	switch *format {
+	case "text", "json":
+	default:
+		fmt.Fprintf(fs.Output(), "unknown format %q\n", *format)
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+	user := fs.Arg(0)
+
+	ctx := context.Background()
+	cli := net.client()
+	layouts, err := layoutsByUser(ctx, cli, net.graphqlURL, user, *geometry)
+	if err != nil {
+		fatal("failed to list layouts", err, "user", user)
+	}
+	if !*doImport {
+		err = writeLayoutSummaries(os.Stdout, layouts, *format)
+		if err != nil {
+			fatal("failed to write layouts", err)
+		}
+		return
+	}
+	if len(layouts) == 0 {
+		slog.Warn("no layouts to import", "user", user)
+		return
+	}
+
+	meta, err := metadataCache{
+		dir:     *cacheDir,
+		maxAge:  *metadataMaxAge,
+		offline: net.cannotFetch(),
+	}.get(ctx, cli, net.metadataURL)
+	if err != nil {
+		fatal("failed to collect metadata", err)
+	}
+
+	var db *sql.DB
+	if *dryRun {
+		db, err = openDBReadOnly(common.dbPath, common.db)
+	} else {
+		if *mkDir {
+			err = os.MkdirAll(filepath.Dir(common.dbPath), 0o750)
+			if err != nil {
+				fatal("unable to create config directory", err, "path", filepath.Dir(common.dbPath))
+			}
+		}
+		db, err = openDB(common.dbPath, common.db)
+	}
+	if err != nil {
+		fatal("failed to open db", err, "path", common.dbPath)
+	}
+	if db != nil {
+		defer db.Close()
+	}
+
+	changes, err := configChanges(db, common.keymappConfig, *force)
+	if err != nil {
+		fatal("failed to check config", err)
+	}
+	c, ok, err := storeMetadata(db, meta)
+	if err != nil {
+		fatal("failed to check metadata", err)
+	}
+	if ok {
+		changes = append(changes, c)
+	}
+	pinned, err := pinnedRevisions(db)
+	if err != nil {
+		fatal("failed to read pinned layouts", err, "path", common.dbPath)
+	}
+	var refs []layoutRef
+	for _, l := range layouts {
+		if id, ok := pinned[l.HashID]; ok {
+			slog.Info("layout is pinned", "layout", l.HashID, "revision", id)
+			continue
+		}
+		refs = append(refs, layoutRef{geometry: l.Geometry, hashID: l.HashID, revision: "latest"})
+	}
+	f := fetcher{
+		db:       db,
+		cli:      cli,
+		endpoint: net.graphqlURL,
+		cache:    objectCache{dir: *cacheDir},
+		jobs:     *jobs,
+		verify:   *verifyMD5,
+		filters:  filters,
+		prov:     prov,
+		force:    *force,
+		keep:     *historyDir != "",
+		progress: common.progress("fetching layouts", len(refs)),
+	}
+	cli.received = f.progress.received
+	revChanges, fetched, fetchErr := f.fetchAll(ctx, refs)
+	changes = append(changes, revChanges...)
+
+	if *dryRun {
+		printChanges(common.dbPath, changes)
+	} else {
+		err = guard.check(ctx, db)
+		if err != nil {
+			fatal("refusing to update db", err, "path", common.dbPath)
+		}
+		if *backupDir != "" && len(changes) != 0 {
+			err = autoBackup(db, *backupDir)
+			if err != nil {
+				fatal("failed to back up db", err, "path", common.dbPath)
+			}
+		}
+		err = apply(db, changes)
+		if err != nil {
+			fatal("failed to update db", err, "path", common.dbPath)
+		}
+		history := layoutHistory{dir: *historyDir}
+		for _, rev := range fetched {
+			if rev == nil {
+				continue
+			}
+			data, err := rev.bytes()
+			if err == nil {
+				err = history.add(data)
+			}
+			if err != nil {
+				fatal("failed to record layout history", err, "path", *historyDir)
+			}
+		}
+	}
+	if fetchErr != nil {
+		fatal("failed to import layouts", fetchErr, "user", user)
+	}
+}
+
+// layoutsByUser returns the public layouts of the Oryx user identified
+// by a user name or hash ID. If geometry is not empty, only layouts for
+// that geometry are returned.
+func layoutsByUser(ctx context.Context, cli *client, endpoint, user, geometry string) ([]layoutSummary, error) {
+	vars := map[string]any{"user": user, "geometry": nil}
+	if geometry != "" {
+		vars["geometry"] = geometry
+	}
+	data, err := graphql(ctx, cli, endpoint, "getUserLayouts", vars, userLayoutsQuery)
+	if err != nil {
+		return nil, err
+	}
+	var resp struct {
+		User *struct {
+			Layouts []layoutSummary `json:"layouts"`
+		} `json:"user"`
+	}
+	err = json.Unmarshal(data, &resp)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse user layouts: %w", err)
+	}
+	if resp.User == nil {
+		return nil, fmt.Errorf("user %s not found", user)
+	}
+	return resp.User.Layouts, nil
+}
+
+// fetcher fetches layout revisions for storage.
+type fetcher struct {
+	db       *sql.DB
+	cli      *client
+	endpoint string
+	cache    objectCache
+	jobs     int // maximum number of concurrent fetches
+	verify   bool
+	filters  revisionFilters
+	prov     provenanceFlags
+	force    bool // replace stored revisions that differ
+	keep     bool // return the filtered data for the layout history
+	progress *progress
+}
+
+// fetchAll fetches the layout revisions identified by refs, with at
+// most f.jobs requests in flight, and returns the changes needed to
+// store them in the order of refs, and the spooled filtered revision
+// data indexed by ref, nil for failed fetches or if f.keep is false. A
+// failure to fetch one revision does not prevent the others from being
+// fetched; all failures are returned in the error.
+func (f *fetcher) fetchAll(ctx context.Context, refs []layoutRef) ([]change, []*spool, error) {
+	results := make([][]change, len(refs))
+	fetched := make([]*spool, len(refs))
+	err := forEach(len(refs), f.jobs, func(i int) error {
+		defer f.progress.step()
+		c, rev, err := f.fetch(ctx, refs[i])
+		if err != nil {
+			return fmt.Errorf("layout %s: %w", refs[i].hashID, err)
+		}
+		if f.keep {
+			fetched[i], err = spoolBytes(rev)
+			if err != nil {
+				return fmt.Errorf("layout %s: %w", refs[i].hashID, err)
+			}
+		}
+		results[i] = c
+		return nil
+	})
+	f.progress.finish()
+	return slices.Concat(results...), fetched, err
+}
+
+// fetch fetches the layout revision identified by ref and returns the
+// changes needed to store it and the revision data after filtering.
+func (f *fetcher) fetch(ctx context.Context, ref layoutRef) ([]change, []byte, error) {
+	id, rev, err := f.cache.fetchRevision(ctx, f.cli, f.endpoint, ref)
+	if err != nil {
+		return nil, nil, err
+	}
+	sum, err := checkMD5(rev)
+	if err != nil {
+		if f.verify {
+			return nil, nil, fmt.Errorf("revision %s: %w", id, err)
+		}
+		slog.Warn("failed to verify revision", "error", err, "layout", ref.hashID, "revision", id)
+	}
+	rev, tourChanges, err := f.filters.apply(id, rev)
+	if err != nil {
+		return nil, nil, fmt.Errorf("revision %s: %w", id, err)
+	}
+	changes, err := revisionChanges(f.db, id, sum, rev, f.force, f.prov.provenance(refSource(f.endpoint, ref)))
+	if err != nil {
+		return nil, nil, fmt.Errorf("revision %s: %w", id, err)
+	}
+	slog.Info("fetched revision", "layout", ref.hashID, "revision", id)
+	return append(changes, tourChanges...), rev, nil
+}
+
+const userLayoutsQuery = `
+query getUserLayouts($user: String!, $geometry: String) {
+	user(hashIdOrName: $user) {
+		layouts(geometry: $geometry) {
+			...LayoutSummary
+		}
+	}
+}` + layoutSummaryFragment
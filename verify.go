@@ -0,0 +1,302 @@
+// Copyright ©2025 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+)
+
+// errChecksumMismatch is returned when data does not match its
+// recorded checksum.
+var errChecksumMismatch = errors.New("checksum mismatch")
+
+// checkMD5 checks the md5 checksum reported in the layout revision
+// data against the revision's config and returns the reported sum.
+// If the revision has no checksum, the empty string and a nil error
+// are returned. The sum is taken over the config bytes as received; it
+// is not known whether Oryx computes it over the same bytes, so callers
+// treat a mismatch as a warning unless asked to fail.
+func checkMD5(data []byte) (string, error) {
+	var layout struct {
+		Layout struct {
+			Revision struct {
+				Config json.RawMessage `json:"config"`
+				MD5    string          `json:"md5"`
+			} `json:"revision"`
+		} `json:"layout"`
+	}
+	err := json.Unmarshal(data, &layout)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse revision: %w", err)
+	}
+	rev := layout.Layout.Revision
+	if rev.MD5 == "" {
+		return "", nil
+	}
+	got := fmt.Sprintf("%x", md5.Sum(rev.Config))
+	if got != rev.MD5 {
+		return rev.MD5, fmt.Errorf("config md5 %w: got %s want %s", errChecksumMismatch, got, rev.MD5)
+	}
+	return rev.MD5, nil
+}
+
+// verify checks that each stored revision matches the checksums
+// recorded when it was written and, if requested, the revisions held in
+// a source bundle or by Oryx.
+func verify(args []string) {
+	fs := flag.NewFlagSet("fkm verify", flag.ExitOnError)
+	var (
+		common commonFlags
+		net    netFlags
+	)
+	common.register(fs)
+	net.register(fs)
+	against := fs.String("against", "", "database bundle the stored revisions were distributed from to compare revision data against")
+	verifyKeyPath := fs.String("verify-key", "", "minisign public key file that the -against bundle must be signed by")
+	upstream := fs.Bool("upstream", false, "compare the config of each stored revision against the revision held by Oryx")
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), `usage: fkm verify [-against bundle.fkm [-verify-key fkm.pub]] [-upstream] [flags]
+
+Check that each stored revision matches the checksums recorded when it
+was written. With -against, the data of each stored revision must also
+be identical to the data of the revision in the bundle, a keymapp
+database such as one written by fkm backup, and every revision in the
+bundle must be stored. With -verify-key, the bundle must have a valid
+minisign signature, as written by fkm backup -sign, made with the
+secret key of the given public key. With -upstream, the md5 sum of each
+stored revision's config must match that of the revision fetched from
+Oryx. Any difference is reported as a failure.
+
+Flags:
+`)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	common.setup(fs)
+	if fs.NArg() != 0 || (*upstream && net.cannotFetch()) || (*verifyKeyPath != "" && *against == "") {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+
+	db, err := openDBReadOnly(common.dbPath, common.db)
+	if err != nil {
+		fatal("failed to open db", err, "path", common.dbPath)
+	}
+	if db == nil {
+		fatal("failed to open db", dbError(errors.New("no database")), "path", common.dbPath)
+	}
+	defer db.Close()
+
+	ok, err := hasTable(db, "fkm_checksum")
+	if err != nil {
+		fatal("failed to check db", err, "path", common.dbPath)
+	}
+	if !ok {
+		fatal("failed to verify db", exitError{status: exitVerify, err: errors.New("no checksums recorded")}, "path", common.dbPath)
+	}
+	var bundle map[string]string
+	if *verifyKeyPath != "" {
+		key, err := loadVerifyKey(*verifyKeyPath)
+		if err != nil {
+			fatal("failed to load verification key", err, "path", *verifyKeyPath)
+		}
+		err = verifyFile(*against, key)
+		if err != nil {
+			fatal("failed to verify bundle", err, "path", *against)
+		}
+	}
+	if *against != "" {
+		bundle, err = bundleChecksums(*against, common.db)
+		if err != nil {
+			fatal("failed to read bundle", err, "path", *against)
+		}
+	}
+	var cli *client
+	if *upstream {
+		cli = net.client()
+	}
+	rows, err := db.Query(`SELECT r.revisionId, r.data, c.sha256 FROM revision r LEFT JOIN fkm_checksum c ON r.revisionId=c.revisionId ORDER BY r.revisionId`)
+	if err != nil {
+		fatal("failed to read revisions", err)
+	}
+	defer rows.Close()
+	var (
+		failed  bool
+		results = []verifyResult{}
+		seen    = make(map[string]bool)
+	)
+	for rows.Next() {
+		var (
+			id   string
+			data []byte
+			sum  sql.NullString
+		)
+		err = rows.Scan(&id, &data, &sum)
+		if err != nil {
+			fatal("failed to read revision", err)
+		}
+		seen[id] = true
+		err = verifyRevision(data, sum)
+		if err == nil && bundle != nil {
+			err = verifyAgainstBundle(bundle, id, data)
+		}
+		if err == nil && *upstream {
+			err = verifyUpstream(context.Background(), cli, net.graphqlURL, id, data)
+		}
+		r := verifyResult{Revision: id, OK: err == nil}
+		if err != nil {
+			failed = true
+			r.Error = err.Error()
+		}
+		results = append(results, r)
+	}
+	err = rows.Err()
+	if err != nil {
+		fatal("failed to read revisions", err)
+	}
+	for _, id := range slices.Sorted(maps.Keys(bundle)) {
+		if !seen[id] {
+			failed = true
+			results = append(results, verifyResult{Revision: id, Error: "in bundle but not stored"})
+		}
+	}
+	if common.json {
+		printJSON(results)
+	} else {
+		for _, r := range results {
+			if r.OK {
+				fmt.Printf("ok\t%s\n", r.Revision)
+			} else {
+				fmt.Printf("FAIL\t%s\t%s\n", r.Revision, r.Error)
+			}
+		}
+	}
+	if failed {
+		os.Exit(exitVerify)
+	}
+}
+
+// bundleChecksums returns the SHA-256 sums of the data of the revisions
+// held in the database bundle at path, keyed by revision ID.
+func bundleChecksums(path string, opts dbOptions) (map[string]string, error) {
+	db, err := openDBReadOnly(path, opts)
+	if err != nil {
+		return nil, err
+	}
+	if db == nil {
+		return nil, dbError(fmt.Errorf("%w: %s", os.ErrNotExist, path))
+	}
+	defer db.Close()
+	rows, err := db.Query(`SELECT revisionId, data FROM revision`)
+	if err != nil {
+		return nil, dbError(err)
+	}
+	defer rows.Close()
+	sums := make(map[string]string)
+	for rows.Next() {
+		var (
+			id   string
+			data []byte
+		)
+		err = rows.Scan(&id, &data)
+		if err != nil {
+			return nil, dbError(err)
+		}
+		sums[id] = fmt.Sprintf("%x", sha256.Sum256(data))
+	}
+	return sums, dbError(rows.Err())
+}
+
+// verifyAgainstBundle checks the stored revision data against the data
+// of the revision in the bundle.
+func verifyAgainstBundle(bundle map[string]string, id string, data []byte) error {
+	want, ok := bundle[id]
+	if !ok {
+		return errors.New("stored but not in bundle")
+	}
+	got := fmt.Sprintf("%x", sha256.Sum256(data))
+	if got != want {
+		return fmt.Errorf("sha256 differs from bundle: got %s want %s", got, want)
+	}
+	return nil
+}
+
+// verifyUpstream checks the config of the stored revision data against
+// the config of the revision fetched from Oryx. Only the config is
+// compared since the rest of the stored data may have been filtered
+// when it was stored. Network failures are fatal since they say nothing
+// about the revision.
+func verifyUpstream(ctx context.Context, cli *client, endpoint, id string, data []byte) error {
+	l, err := parseLayout(data)
+	if err != nil {
+		return err
+	}
+	_, fetched, err := fetchRevision(ctx, cli, endpoint, layoutRef{geometry: l.Geometry, hashID: l.HashID, revision: id})
+	if err != nil {
+		if exitStatus(err) == exitNetwork {
+			fatal("failed to fetch revision", err, "revision", id)
+		}
+		return fmt.Errorf("failed to fetch from upstream: %w", err)
+	}
+	got, err := configMD5(data)
+	if err != nil {
+		return err
+	}
+	want, err := configMD5(fetched)
+	if err != nil {
+		return fmt.Errorf("fetched revision: %w", err)
+	}
+	if got != want {
+		return fmt.Errorf("config md5 differs from upstream: got %s want %s", got, want)
+	}
+	return nil
+}
+
+// verifyResult is the JSON description of a revision's verification.
+type verifyResult struct {
+	Revision string `json:"revision"`
+	OK       bool   `json:"ok"`
+	Error    string `json:"error,omitempty"`
+}
+
+// verifyRevision checks the stored revision data against its recorded
+// SHA-256 sum and its embedded md5 checksum.
+func verifyRevision(data []byte, sum sql.NullString) error {
+	if !sum.Valid {
+		return errors.New("no recorded checksum")
+	}
+	got := fmt.Sprintf("%x", sha256.Sum256(data))
+	if got != sum.String {
+		return fmt.Errorf("sha256 %w: got %s want %s", errChecksumMismatch, got, sum.String)
+	}
+	_, err := checkMD5(data)
+	return err
+}
+
+// configMD5 returns the md5 sum of the config of the revision data.
+func configMD5(data []byte) (string, error) {
+	var layout struct {
+		Layout struct {
+			Revision struct {
+				Config json.RawMessage `json:"config"`
+			} `json:"revision"`
+		} `json:"layout"`
+	}
+	err := json.Unmarshal(data, &layout)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse revision: %w", err)
+	}
+	return fmt.Sprintf("%x", md5.Sum(layout.Layout.Revision.Config)), nil
+}
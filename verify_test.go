@@ -0,0 +1,71 @@
+// Copyright ©2025 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestVerifySignedBundle(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "keymapp.sqlite3")
+	db, err := openDB(path, dbOptions{})
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	storeTestRevision(t, db, "abc", "r1", "2025-01-01T00:00:00Z")
+	db.Close()
+
+	secret, public := writeTestKeys(t, dir, "fkm")
+	_, other := writeTestKeys(t, dir, "other")
+	bundle := filepath.Join(dir, "bundle.sqlite3")
+	status, out := runFKM(t, "backup", "-path", path, "-out", bundle, "-sign", secret)
+	if status != exitOK {
+		t.Fatalf("unexpected backup exit status: got %d want %d\n%s", status, exitOK, out)
+	}
+	if _, err := os.Stat(bundle + sigSuffix); err != nil {
+		t.Fatalf("backup not signed: %v", err)
+	}
+	unsigned := filepath.Join(dir, "unsigned.sqlite3")
+	status, out = runFKM(t, "backup", "-path", path, "-out", unsigned)
+	if status != exitOK {
+		t.Fatalf("unexpected backup exit status: got %d want %d\n%s", status, exitOK, out)
+	}
+
+	tests := []struct {
+		name string
+		args []string
+		want int
+	}{
+		{name: "verify signed", args: []string{"verify", "-path", path, "-against", bundle, "-verify-key", public}, want: exitOK},
+		{name: "verify other key", args: []string{"verify", "-path", path, "-against", bundle, "-verify-key", other}, want: exitVerify},
+		{name: "verify unsigned", args: []string{"verify", "-path", path, "-against", unsigned, "-verify-key", public}, want: exitVerify},
+		{name: "verify key without bundle", args: []string{"verify", "-path", path, "-verify-key", public}, want: exitUsage},
+		{name: "restore unsigned", args: []string{"restore", "-path", path, "-from", unsigned, "-verify-key", public}, want: exitVerify},
+		{name: "restore signed", args: []string{"restore", "-path", path, "-from", bundle, "-verify-key", public}, want: exitOK},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			status, out := runFKM(t, test.args...)
+			if status != test.want {
+				t.Errorf("unexpected exit status: got %d want %d\n%s", status, test.want, out)
+			}
+		})
+	}
+
+	// Modifying the bundle after signing must be detected.
+	f, err := os.OpenFile(bundle, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatalf("failed to open bundle: %v", err)
+	}
+	f.Write([]byte{0})
+	f.Close()
+	status, out = runFKM(t, "verify", "-path", path, "-against", bundle, "-verify-key", public)
+	if status != exitVerify {
+		t.Errorf("unexpected exit status for modified bundle: got %d want %d\n%s", status, exitVerify, out)
+	}
+}
@@ -0,0 +1,145 @@
+// Copyright ©2025 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"regexp"
+	"strings"
+)
+
+// zsaVendorID is the USB vendor ID of ZSA keyboards.
+const zsaVendorID = 0x3297
+
+// zsaProductIDs maps Oryx geometries to USB product IDs.
+var zsaProductIDs = map[string]uint32{
+	"voyager":    0x1977,
+	"moonlander": 0x1969,
+	"ergodox-ez": 0x4974,
+	"planck-ez":  0xc6ce,
+}
+
+// viaRows returns the layer's QMK keycodes grouped into rows by the
+// physical position of the keys in the layout's geometry. VIA and Vial
+// address keys by firmware matrix position, which Oryx does not
+// describe, so rows follow the physical layout instead.
+func viaRows(l *layout, codes []string) [][]string {
+	placements := keyPlacements(nil, l.Geometry, len(codes))
+	if len(placements) < len(codes) {
+		placements = keyPlacements(nil, "", len(codes))
+	}
+	var (
+		rows [][]string
+		y    = -1.0
+	)
+	for i, code := range codes {
+		if p := placements[i]; len(rows) == 0 || p.Y != y {
+			rows = append(rows, nil)
+			y = p.Y
+		}
+		rows[len(rows)-1] = append(rows[len(rows)-1], code)
+	}
+	return rows
+}
+
+// writeVIA writes a VIA saved layout file for the layout.
+func writeVIA(w io.Writer, l *layout) error {
+	if len(l.Revision.Combos) != 0 {
+		slog.Warn("combos are not exported to VIA layouts: use the vial format", "combos", len(l.Revision.Combos))
+	}
+	var layers [][]string
+	for _, codes := range qmkLayers(l) {
+		for i, c := range codes {
+			codes[i] = strings.ReplaceAll(c, " ", "")
+		}
+		layers = append(layers, codes)
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(struct {
+		Name            string     `json:"name"`
+		VendorProductID uint32     `json:"vendorProductId"`
+		Macros          []string   `json:"macros"`
+		Layers          [][]string `json:"layers"`
+	}{
+		Name:            l.Title,
+		VendorProductID: zsaVendorID<<16 | zsaProductIDs[l.Geometry],
+		Macros:          []string{},
+		Layers:          layers,
+	})
+}
+
+// vialLayerTap matches a QMK layer-tap keycode.
+var vialLayerTap = regexp.MustCompile(`^LT\((\d+), *(.*)\)$`)
+
+// vialKeycode returns the Vial form of a QMK keycode.
+func vialKeycode(code string) string {
+	return vialLayerTap.ReplaceAllString(code, "LT$1($2)")
+}
+
+// writeVial writes a Vial .vil layout file for the layout, including
+// combos of up to four keys.
+func writeVial(w io.Writer, l *layout) error {
+	qmk := qmkLayers(l)
+	var layers [][][]string
+	for _, codes := range qmk {
+		for i, c := range codes {
+			codes[i] = vialKeycode(c)
+		}
+		layers = append(layers, viaRows(l, codes))
+	}
+	combos := [][]string{}
+	for i, c := range l.Revision.Combos {
+		if c.LayerIdx < 0 || c.LayerIdx >= len(qmk) || len(c.KeyIndices) > 4 {
+			slog.Warn("combo cannot be exported to Vial", "combo", comboName(l, i))
+			continue
+		}
+		entry := []string{"KC_NO", "KC_NO", "KC_NO", "KC_NO", "KC_NO"}
+		ok := true
+		for j, k := range c.KeyIndices {
+			if k < 0 || k >= len(qmk[c.LayerIdx]) {
+				ok = false
+				break
+			}
+			entry[j] = qmk[c.LayerIdx][k]
+		}
+		trigger, err := qmkAction(&keyAction{Code: c.Trigger})
+		if !ok || err != nil {
+			slog.Warn("combo cannot be exported to Vial", "combo", comboName(l, i))
+			continue
+		}
+		entry[4] = vialKeycode(trigger)
+		combos = append(combos, entry)
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(struct {
+		Version       int            `json:"version"`
+		Layout        [][][]string   `json:"layout"`
+		EncoderLayout []any          `json:"encoder_layout"`
+		LayoutOptions int            `json:"layout_options"`
+		Macro         []any          `json:"macro"`
+		VialProtocol  int            `json:"vial_protocol"`
+		VIAProtocol   int            `json:"via_protocol"`
+		TapDance      []any          `json:"tap_dance"`
+		Combo         [][]string     `json:"combo"`
+		KeyOverride   []any          `json:"key_override"`
+		Settings      map[string]any `json:"settings"`
+	}{
+		Version:       1,
+		Layout:        layers,
+		EncoderLayout: []any{},
+		LayoutOptions: -1,
+		Macro:         []any{},
+		VialProtocol:  6,
+		VIAProtocol:   9,
+		TapDance:      []any{},
+		Combo:         combos,
+		KeyOverride:   []any{},
+		Settings:      map[string]any{},
+	})
+}
@@ -0,0 +1,339 @@
+// Copyright ©2025 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"time"
+)
+
+// watch periodically checks the layouts tracked in the database for
+// new revisions and stores them.
+func watch(args []string) {
+	fs := flag.NewFlagSet("fkm watch", flag.ExitOnError)
+	var (
+		common  commonFlags
+		net     netFlags
+		guard   writeGuard
+		filters revisionFilters
+		prov    provenanceFlags
+		dmn     daemonFlags
+	)
+	common.register(fs)
+	net.register(fs)
+	guard.register(fs)
+	filters.register(fs)
+	prov.register(fs)
+	dmn.register(fs)
+	interval := fs.Duration("interval", 6*time.Hour, "time between checks for new revisions")
+	once := fs.Bool("once", false, "check once and exit, with a non-zero status on failure")
+	notifyDesktop := fs.Bool("notify", false, "show a desktop notification when new revisions are stored")
+	verifyMD5 := fs.Bool("verify-md5", false, "fail if the revision config does not match its md5 checksum instead of warning")
+	force := fs.Bool("force", false, "replace stored revisions that differ from those being written, and track latest revisions that are already stored")
+	cacheDir := fs.String("cache-dir", defaultCacheDir(), "directory for cached downloads (empty to disable caching)")
+	metadataMaxAge := fs.Duration("metadata-max-age", 24*time.Hour, "age below which cached metadata is used without checking for updates")
+	backupDir := fs.String("backup-dir", "", "directory to back up the database to before changing it")
+	historyDir := fs.String("history-dir", "", "git repository to commit new revisions' JSON to after storing them")
+	jobs := fs.Int("jobs", defaultJobs, "maximum number of layouts to fetch concurrently")
+	fs.Parse(args)
+	common.setup(fs)
+	if fs.NArg() != 0 || *interval <= 0 || *jobs < 1 {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+
+	db := openExisting(common.dbPath, false, common.db)
+	defer db.Close()
+
+	w := watcher{
+		db:      db,
+		cli:     net.client(),
+		common:  &common,
+		net:     net,
+		guard:   guard,
+		filters: filters,
+		prov:    prov,
+		verify:  *verifyMD5,
+		force:   *force,
+		backup:  *backupDir,
+		jobs:    *jobs,
+		cache:   objectCache{dir: *cacheDir},
+		history: layoutHistory{dir: *historyDir},
+		meta: metadataCache{
+			dir:     *cacheDir,
+			maxAge:  *metadataMaxAge,
+			offline: net.cannotFetch(),
+		},
+	}
+	ctx, stop := shutdownContext()
+	defer stop()
+	d, err := dmn.start(ctx, "watch")
+	if err != nil {
+		fatal("failed to serve status", err, "addr", dmn.statusAddr)
+	}
+	d.setState("running")
+	for {
+		d.set("checking", true)
+		updates, err := w.check(ctx)
+		d.set("checking", nil)
+		d.set("last_check", time.Now())
+		d.set("last_updates", len(updates))
+		if err != nil {
+			d.set("last_error", err.Error())
+		} else {
+			d.set("last_error", nil)
+		}
+		if *notifyDesktop {
+			nerr := notifyUpdates(updates)
+			if nerr != nil {
+				slog.Warn("failed to show notification", "error", nerr)
+			}
+		}
+		if *once {
+			if err != nil {
+				fatal("failed to check layouts", err, "path", common.dbPath)
+			}
+			return
+		}
+		if err != nil {
+			slog.Error("failed to check layouts", "error", err, "path", common.dbPath)
+		}
+		next := time.Now().Add(*interval)
+		d.set("next_check", next)
+		slog.Info("waiting for next check", "after", *interval)
+		select {
+		case <-ctx.Done():
+			d.setState("stopping")
+			slog.Info("stopping watch")
+			return
+		case <-time.After(time.Until(next)):
+		}
+	}
+}
+
+// watcher checks tracked layouts for new revisions.
+type watcher struct {
+	db      *sql.DB
+	cli     *client
+	common  *commonFlags
+	net     netFlags
+	guard   writeGuard
+	filters revisionFilters
+	prov    provenanceFlags
+	meta    metadataCache
+	cache   objectCache
+	history layoutHistory
+	verify  bool
+	force   bool   // replace stored revisions that differ
+	backup  string // directory for backups before changes, if not empty
+	jobs    int    // maximum number of concurrent fetches
+}
+
+// layoutUpdate describes a new revision of a tracked layout.
+type layoutUpdate struct {
+	hashID string
+	title  string
+	from   string // previous revision ID
+	to     string // new revision ID
+	data   *spool // revision data after filtering, if kept for the history
+}
+
+// trackedLayout is a layout recorded in fkm_tracked.
+type trackedLayout struct {
+	hashID   string
+	geometry string
+	revision string
+}
+
+// trackedLayouts returns the layouts tracked in the database.
+func trackedLayouts(db *sql.DB) ([]trackedLayout, error) {
+	ok, err := hasTable(db, "fkm_tracked")
+	if err != nil || !ok {
+		return nil, err
+	}
+	rows, err := db.Query(`SELECT hashId, geometry, revisionId FROM fkm_tracked ORDER BY hashId`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var layouts []trackedLayout
+	for rows.Next() {
+		var l trackedLayout
+		err = rows.Scan(&l.hashID, &l.geometry, &l.revision)
+		if err != nil {
+			return nil, err
+		}
+		layouts = append(layouts, l)
+	}
+	return layouts, rows.Err()
+}
+
+// check fetches the latest revision of each tracked layout and stores
+// the revisions that are new. It returns the updates that were stored.
+// A failure to check one layout does not prevent the others from being
+// checked; all failures are returned in the error.
+func (w *watcher) check(ctx context.Context) ([]layoutUpdate, error) {
+	layouts, err := trackedLayouts(w.db)
+	if err != nil {
+		return nil, err
+	}
+	if len(layouts) == 0 {
+		slog.Warn("no tracked layouts: fetch a layout to track it")
+		return nil, nil
+	}
+	pinned, err := pinnedRevisions(w.db)
+	if err != nil {
+		return nil, err
+	}
+	layouts = slices.DeleteFunc(layouts, func(t trackedLayout) bool {
+		id, ok := pinned[t.hashID]
+		if ok {
+			slog.Info("layout is pinned", "layout", t.hashID, "revision", id)
+		}
+		return ok
+	})
+
+	var (
+		changes []change
+		updates []layoutUpdate
+		errs    []error
+	)
+	meta, err := w.meta.get(ctx, w.cli, w.net.metadataURL)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("metadata: %w", err))
+	} else {
+		c, ok, err := storeMetadata(w.db, meta)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			changes = append(changes, c)
+		}
+	}
+	type fetched struct {
+		id  string
+		rev *spool
+		err error
+	}
+	latest := make([]fetched, len(layouts))
+	p := w.common.progress("checking layouts", len(layouts))
+	w.cli.received = p.received
+	forEach(len(layouts), w.jobs, func(i int) error {
+		defer p.step()
+		t := layouts[i]
+		ref := layoutRef{geometry: t.geometry, hashID: t.hashID, revision: "latest"}
+		id, rev, err := w.cache.fetchRevision(ctx, w.cli, w.net.graphqlURL, ref)
+		if err == nil {
+			// Keep the fetched data out of memory until
+			// all the layouts have been checked.
+			latest[i].rev, err = spoolBytes(rev)
+		}
+		latest[i].id, latest[i].err = id, err
+		return nil
+	})
+	p.finish()
+	w.cli.received = nil
+	for i, t := range layouts {
+		id, err := latest[i].id, latest[i].err
+		if err != nil {
+			errs = append(errs, fmt.Errorf("layout %s: %w", t.hashID, err))
+			continue
+		}
+		rev, err := latest[i].rev.bytes()
+		latest[i].rev.close()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("layout %s: %w", t.hashID, err))
+			continue
+		}
+		if id == t.revision {
+			slog.Info("layout is up to date", "layout", t.hashID, "revision", id)
+			continue
+		}
+		if !w.force {
+			// The latest revision may have been rolled back
+			// from.
+			n, err := rowCount(w.db, "revision", "revisionId=?", id)
+			if err != nil {
+				return nil, err
+			}
+			if n != 0 {
+				slog.Info("latest revision is already stored", "layout", t.hashID, "revision", id, "tracked", t.revision)
+				continue
+			}
+		}
+		sum, err := checkMD5(rev)
+		if err != nil {
+			if w.verify {
+				errs = append(errs, fmt.Errorf("layout %s: revision %s: %w", t.hashID, id, err))
+				continue
+			}
+			slog.Warn("failed to verify revision", "error", err, "layout", t.hashID, "revision", id)
+		}
+		rev, tourChanges, err := w.filters.apply(id, rev)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("layout %s: revision %s: %w", t.hashID, id, err))
+			continue
+		}
+		ref := layoutRef{geometry: t.geometry, hashID: t.hashID, revision: "latest"}
+		c, err := revisionChanges(w.db, id, sum, rev, w.force, w.prov.provenance(refSource(w.net.graphqlURL, ref)))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("layout %s: revision %s: %w", t.hashID, id, err))
+			continue
+		}
+		changes = append(changes, c...)
+		changes = append(changes, tourChanges...)
+		u := layoutUpdate{hashID: t.hashID, from: t.revision, to: id}
+		if l, err := parseLayout(rev); err == nil {
+			u.title = l.Title
+		}
+		if w.history.dir != "" {
+			u.data, err = spoolBytes(rev)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("layout %s: revision %s: history: %w", t.hashID, id, err))
+			}
+		}
+		updates = append(updates, u)
+		slog.Info("found new revision", "layout", t.hashID, "from", t.revision, "to", id)
+	}
+	if len(changes) == 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	err = w.guard.check(ctx, w.db)
+	if err != nil {
+		return nil, errors.Join(append(errs, err)...)
+	}
+	if w.backup != "" {
+		err = autoBackup(w.db, w.backup)
+		if err != nil {
+			return nil, errors.Join(append(errs, fmt.Errorf("backup: %w", err))...)
+		}
+	}
+	err = apply(w.db, changes)
+	if err != nil {
+		return nil, errors.Join(append(errs, err)...)
+	}
+	for _, u := range updates {
+		if u.data == nil {
+			continue
+		}
+		data, err := u.data.bytes()
+		u.data.close()
+		if err == nil {
+			err = w.history.add(data)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("layout %s: revision %s: history: %w", u.hashID, u.to, err))
+		}
+	}
+	return updates, errors.Join(errs...)
+}
@@ -0,0 +1,58 @@
+// Copyright ©2025 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWatchKeepsRollback(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "keymapp.sqlite3")
+	status, out := runFKM(t, append(replayFlags,
+		"-path", path,
+		"-layout", "https://configure.zsa.io/voyager/layouts/abc/latest",
+	)...)
+	if status != exitOK {
+		t.Fatalf("unexpected update exit status: got %d want %d\n%s", status, exitOK, out)
+	}
+	older := filepath.Join(dir, "r1.json")
+	err := os.WriteFile(older, testRevision("abc", "r1", "2025-01-01T00:00:00Z"), 0o600)
+	if err != nil {
+		t.Fatalf("failed to write revision: %v", err)
+	}
+	status, out = runFKM(t, "-path", path, "-offline", "-cache-dir=", "-detect-keyboard=false", "-revision-file", older)
+	if status != exitOK {
+		t.Fatalf("unexpected update exit status: got %d want %d\n%s", status, exitOK, out)
+	}
+
+	for _, test := range []struct {
+		args []string
+		want string
+	}{
+		{args: nil, want: "r1"},
+		{args: []string{"-force"}, want: "rev2"},
+	} {
+		watch := append([]string{"watch", "-once"}, replayFlags[:len(replayFlags)-1]...)
+		status, out = runFKM(t, append(append(watch, test.args...), "-path", path)...)
+		if status != exitOK {
+			t.Fatalf("unexpected watch %q exit status: got %d want %d\n%s", test.args, status, exitOK, out)
+		}
+		db, err := openDBReadOnly(path, dbOptions{})
+		if err != nil {
+			t.Fatalf("failed to open db: %v", err)
+		}
+		got, err := trackedRevision(db, "abc")
+		db.Close()
+		if err != nil {
+			t.Fatalf("failed to read tracked revision: %v", err)
+		}
+		if got != test.want {
+			t.Errorf("unexpected tracked revision after watch %q: got %s want %s", test.args, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,407 @@
+// Copyright ©2025 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// web serves a read-only web interface for browsing the stored layouts.
+func web(args []string) {
+	fs := flag.NewFlagSet("fkm web", flag.ExitOnError)
+	var (
+		common commonFlags
+		legend legendFlags
+		dmn    daemonFlags
+	)
+	common.register(fs)
+	legend.register(fs)
+	dmn.register(fs)
+	addr := fs.String("addr", "localhost:8081", "address to listen on")
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), `usage: fkm web [flags]
+
+Serve a read-only web interface over the database showing the stored
+layouts and their revision history, with diagrams of each revision's
+layers, its combos, its heatmap data and the differences between
+revisions. The interface needs no network access beyond the listening
+address, so it can be used where configure.zsa.io is blocked. When socket
+activated, the server listens on the passed socket instead of -addr.
+
+Flags:
+`)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	common.setup(fs)
+	if fs.NArg() != 0 {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+
+	db := openExisting(common.dbPath, true, common.db)
+	defer db.Close()
+	ui := webUI{db: db, legends: legend.legends()}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", ui.index)
+	mux.HandleFunc("GET /revision/{id}", ui.revision)
+	mux.HandleFunc("GET /revision/{id}/heatmap", ui.heatmap)
+	mux.HandleFunc("GET /diff/{from}/{to}", ui.diff)
+	srv := &http.Server{
+		Handler:           logRequests(mux),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	ctx, stop := shutdownContext()
+	defer stop()
+	d, err := dmn.start(ctx, "web")
+	if err != nil {
+		fatal("failed to serve status", err, "addr", dmn.statusAddr)
+	}
+	ln, err := serviceListener(*addr)
+	if err != nil {
+		fatal("failed to listen", err, "addr", *addr)
+	}
+	go func() {
+		<-ctx.Done()
+		d.setState("stopping")
+		shutdown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdown)
+	}()
+	slog.Info("serving web interface", "url", "http://"+ln.Addr().String()+"/", "path", common.dbPath)
+	d.set("addr", ln.Addr().String())
+	d.setState("running")
+	err = srv.Serve(ln)
+	if !errors.Is(err, http.ErrServerClosed) {
+		fatal("server failed", err, "addr", *addr)
+	}
+}
+
+// webUI serves pages describing the revisions in a database.
+type webUI struct {
+	db      *sql.DB
+	legends *keyLegends // nil to show key codes
+}
+
+// webLayout is a layout on the index page with its stored revisions,
+// newest first.
+type webLayout struct {
+	HashID    string
+	Title     string
+	Geometry  string
+	Revisions []webRevision
+}
+
+// webRevision is a stored revision on the index page.
+type webRevision struct {
+	ID       string
+	Title    string
+	Created  string
+	Heatmap  bool
+	Previous string // ID of the next older revision of the layout
+}
+
+// index serves the list of stored layouts and their revisions.
+func (ui webUI) index(w http.ResponseWriter, r *http.Request) {
+	revs, err := storedRevisions(ui.db)
+	if err != nil {
+		ui.fail(w, err)
+		return
+	}
+	var (
+		layouts    []webLayout
+		unreadable []string
+	)
+	for _, rev := range revs {
+		if rev.layout == nil {
+			unreadable = append(unreadable, rev.id)
+			continue
+		}
+		l := rev.layout
+		if len(layouts) == 0 || layouts[len(layouts)-1].HashID != l.HashID {
+			layouts = append(layouts, webLayout{HashID: l.HashID, Title: l.Title, Geometry: l.Geometry})
+		}
+		cur := &layouts[len(layouts)-1]
+		if n := len(cur.Revisions); n != 0 {
+			cur.Revisions[n-1].Previous = rev.id
+		}
+		cur.Revisions = append(cur.Revisions, webRevision{
+			ID:      rev.id,
+			Title:   l.Revision.Title,
+			Created: l.Revision.CreatedAt,
+			Heatmap: rev.heatmap,
+		})
+	}
+	ui.render(w, "index", struct {
+		Layouts    []webLayout
+		Unreadable []string
+	}{layouts, unreadable})
+}
+
+// revision serves the diagrams, combos and tour of a stored revision.
+func (ui webUI) revision(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	l, err := loadLayout(ui.db, id)
+	if err != nil {
+		ui.fail(w, err)
+		return
+	}
+	meta, err := loadMetadata(ui.db)
+	if err != nil {
+		ui.fail(w, err)
+		return
+	}
+	h, err := loadHeatmap(ui.db, id)
+	if err != nil {
+		slog.Warn("failed to read heatmap", "revision", id, "error", err)
+	}
+	previous, err := ui.previous(l, id)
+	if err != nil {
+		ui.fail(w, err)
+		return
+	}
+	ui.render(w, "revision", struct {
+		ID       string
+		Page     cheatsheetPage
+		Previous string
+		Heatmap  bool
+	}{id, newCheatsheetPage(l, meta, nil, ui.legends), previous, h != nil})
+}
+
+// previous returns the ID of the stored revision of the layout that
+// is next older than the revision with the given ID, or the empty
+// string if there is none.
+func (ui webUI) previous(l *layout, id string) (string, error) {
+	revs, err := storedRevisions(ui.db)
+	if err != nil {
+		return "", err
+	}
+	i := slices.IndexFunc(revs, func(r storedRevision) bool { return r.id == id })
+	if i < 0 || i+1 == len(revs) {
+		return "", nil
+	}
+	next := revs[i+1]
+	if next.layout == nil || next.layout.HashID != l.HashID {
+		return "", nil
+	}
+	return next.id, nil
+}
+
+// webKeyCount is a key and its press count on the heatmap page.
+type webKeyCount struct {
+	Index int
+	Label string
+	Count int
+}
+
+// webHeatmapLayer is a layer on the heatmap page with its image shaded
+// by press count and its keys in order of decreasing press count.
+type webHeatmapLayer struct {
+	Position int
+	Name     string
+	Image    template.HTML
+	Total    int
+	Keys     []webKeyCount
+}
+
+// heatmap serves the key press counts recorded for a stored revision.
+func (ui webUI) heatmap(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	l, err := loadLayout(ui.db, id)
+	if err != nil {
+		ui.fail(w, err)
+		return
+	}
+	h, err := loadHeatmap(ui.db, id)
+	if err != nil {
+		ui.fail(w, err)
+		return
+	}
+	meta, err := loadMetadata(ui.db)
+	if err != nil {
+		ui.fail(w, err)
+		return
+	}
+	var layers []webHeatmapLayer
+	for i, ly := range l.Revision.Layers {
+		if i >= len(h) {
+			break
+		}
+		var img strings.Builder
+		renderSVG(&img, l, i, keyPlacements(meta, l.Geometry, len(ly.Keys)), svgOptions{heat: h[i], legends: ui.legends})
+		hl := webHeatmapLayer{
+			Position: ly.Position,
+			Name:     ly.Name(),
+			// The SVG is generated with all text escaped.
+			Image: template.HTML(img.String()),
+		}
+		for j, n := range h[i] {
+			if n == 0 || j >= len(ly.Keys) {
+				continue
+			}
+			hl.Total += n
+			hl.Keys = append(hl.Keys, webKeyCount{Index: j, Label: ui.legends.key(ly.Keys[j]), Count: n})
+		}
+		slices.SortStableFunc(hl.Keys, func(a, b webKeyCount) int { return cmp.Compare(b.Count, a.Count) })
+		layers = append(layers, hl)
+	}
+	ui.render(w, "heatmap", struct {
+		ID     string
+		Layout *layout
+		Layers []webHeatmapLayer
+	}{id, l, layers})
+}
+
+// diff serves the differences between two stored revisions.
+func (ui webUI) diff(w http.ResponseWriter, r *http.Request) {
+	from, to := r.PathValue("from"), r.PathValue("to")
+	a, err := loadLayout(ui.db, from)
+	if err != nil {
+		ui.fail(w, err)
+		return
+	}
+	b, err := loadLayout(ui.db, to)
+	if err != nil {
+		ui.fail(w, err)
+		return
+	}
+	ui.render(w, "diff", diffRevisions(a, b, ui.legends))
+}
+
+// render writes the named page to w.
+func (ui webUI) render(w http.ResponseWriter, name string, data any) {
+	var buf bytes.Buffer
+	err := webTemplates.ExecuteTemplate(&buf, name, data)
+	if err != nil {
+		ui.fail(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(buf.Bytes())
+}
+
+// fail reports err to the client, as not found if it is for a revision
+// that is not stored.
+func (ui webUI) fail(w http.ResponseWriter, err error) {
+	if errors.Is(err, errRevisionNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	slog.Error("failed to serve page", "error", err)
+	http.Error(w, "failed to read database", http.StatusInternalServerError)
+}
+
+var webTemplates = template.Must(template.New("web").Parse(`
+{{define "header"}}<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{.}} — fkm</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+nav { margin-bottom: 1em; }
+section { margin-bottom: 2em; }
+svg { max-width: 100%; height: auto; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #999; padding: 0.25em 0.75em; text-align: left; }
+.added { color: #227722; }
+.removed { color: #cc3333; }
+</style>
+</head>
+<body>
+<nav><a href="/">Layouts</a></nav>
+{{end}}
+
+{{define "footer"}}</body>
+</html>
+{{end}}
+
+{{define "index"}}{{template "header" "Layouts"}}<h1>Layouts</h1>
+{{range .Layouts}}<section>
+<h2>{{.Title}}</h2>
+<p>{{.Geometry}} layout {{.HashID}}</p>
+<table>
+<tr><th>Revision</th><th>Title</th><th>Created</th><th>Heatmap</th><th>Changes</th></tr>
+{{range .Revisions}}{{$id := .ID}}<tr><td><a href="/revision/{{.ID}}">{{.ID}}</a></td><td>{{.Title}}</td><td>{{.Created}}</td><td>{{if .Heatmap}}<a href="/revision/{{.ID}}/heatmap">recording</a>{{end}}</td><td>{{with .Previous}}<a href="/diff/{{.}}/{{$id}}">since {{.}}</a>{{end}}</td></tr>
+{{end}}</table>
+</section>
+{{else}}<p>No revisions are stored.</p>
+{{end}}{{with .Unreadable}}<section>
+<h2>Unreadable revisions</h2>
+<ul>
+{{range .}}<li>{{.}}</li>
+{{end}}</ul>
+</section>
+{{end}}{{template "footer"}}{{end}}
+
+{{define "revision"}}{{template "header" .Page.Layout.Title}}{{with .Page}}<h1>{{.Layout.Title}}</h1>
+<p>{{.Layout.Geometry}} revision {{.Layout.Revision.HashID}}{{with .Layout.Revision.Title}} “{{.}}”{{end}}{{with .Layout.Revision.CreatedAt}}, created {{.}}{{end}}</p>
+{{end}}<p>{{with .Previous}}<a href="/diff/{{.}}/{{$.ID}}">Changes since {{.}}</a> {{end}}{{if .Heatmap}}<a href="/revision/{{.ID}}/heatmap">Heatmap</a>{{end}}</p>
+{{with .Page}}{{range .Layers}}<section>
+<h2>{{.Position}}: {{.Name}}</h2>
+{{.Image}}</section>
+{{end}}{{with .Combos}}<section>
+<h2>Combos</h2>
+<table>
+<tr><th>Name</th><th>Layer</th><th>Keys</th><th>Trigger</th></tr>
+{{range .}}<tr><td>{{.Name}}</td><td>{{.Layer}}</td><td>{{.Keys}}</td><td>{{.Trigger}}</td></tr>
+{{end}}</table>
+</section>
+{{end}}{{with .Tour}}{{if .Steps}}<section>
+<h2>Tour</h2>
+<ol>
+{{range .Steps}}<li>{{with .Layer}}<em>Layer {{.Position}}</em>{{end}}{{with .KeyIndex}} <em>key {{.}}</em>{{end}}
+{{with .Intro}}<p>{{.}}</p>{{end}}{{with .Content}}<p>{{.}}</p>{{end}}{{with .Outro}}<p>{{.}}</p>{{end}}</li>
+{{end}}</ol>
+</section>
+{{end}}{{end}}{{end}}{{template "footer"}}{{end}}
+
+{{define "heatmap"}}{{template "header" "Heatmap"}}<h1>Heatmap of {{.Layout.Title}}</h1>
+<p>Revision <a href="/revision/{{.ID}}">{{.ID}}</a></p>
+{{range .Layers}}<section>
+<h2>{{.Position}}: {{.Name}}</h2>
+{{if .Keys}}{{.Image}}
+<p>{{.Total}} presses</p>
+<table>
+<tr><th>Key</th><th>Legend</th><th>Presses</th></tr>
+{{range .Keys}}<tr><td>{{.Index}}</td><td>{{.Label}}</td><td>{{.Count}}</td></tr>
+{{end}}</table>
+{{else}}<p>No presses recorded.</p>
+{{end}}</section>
+{{else}}<p>No heatmap data is stored for this revision.</p>
+{{end}}{{template "footer"}}{{end}}
+
+{{define "diff"}}{{template "header" "Changes"}}<h1>Changes from <a href="/revision/{{.From}}">{{.From}}</a> to <a href="/revision/{{.To}}">{{.To}}</a></h1>
+{{range .Layers}}<section>
+<h2>Layer {{.Position}} {{.Name}}: {{.Change}}</h2>
+{{with .Keys}}<table>
+<tr><th>Key</th><th>Change</th><th>From</th><th>To</th></tr>
+{{range .}}<tr><td>{{.Key}}</td><td class="{{.Change}}">{{.Change}}</td><td>{{.From}}</td><td>{{.To}}</td></tr>
+{{end}}</table>
+{{end}}</section>
+{{end}}{{with .Combos}}<section>
+<h2>Combos</h2>
+<table>
+<tr><th>Combo</th><th>Change</th><th>From</th><th>To</th></tr>
+{{range .}}<tr><td>{{.Key}}</td><td class="{{.Change}}">{{.Change}}</td><td>{{.From}}</td><td>{{.To}}</td></tr>
+{{end}}</table>
+</section>
+{{end}}{{if and (not .Layers) (not .Combos)}}<p>No differences.</p>
+{{end}}{{template "footer"}}{{end}}
+`))